@@ -0,0 +1,260 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capacity estimates whether a cluster can absorb the resource
+// requests added by injection.
+package capacity
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// resources are the compute resources considered by the estimate.
+var resources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+// quotaResources maps every compute resource to the ResourceQuota
+// entries that limit its requests.
+var quotaResources = map[v1.ResourceName][]v1.ResourceName{
+	v1.ResourceCPU:    {v1.ResourceRequestsCPU, v1.ResourceCPU},
+	v1.ResourceMemory: {v1.ResourceRequestsMemory, v1.ResourceMemory},
+}
+
+// NamespaceImpact describes the injection overhead of a namespace.
+type NamespaceImpact struct {
+	Namespace string
+	// Overhead includes the per-node requests of daemon sets for every
+	// schedulable node.
+	Overhead v1.ResourceList
+	// Violations describe the ResourceQuota entries the overhead would
+	// exceed.
+	Violations []string
+}
+
+// Impact estimates the effect of the injection overhead on a cluster.
+// The estimate compares cluster-wide totals and per-node daemon set
+// requests; it does not simulate scheduling.
+type Impact struct {
+	// Nodes is the number of schedulable nodes.
+	Nodes int
+	// Allocatable and Requested total the schedulable nodes.
+	Allocatable v1.ResourceList
+	Requested   v1.ResourceList
+	// Overhead totals the overhead of every namespace.
+	Overhead   v1.ResourceList
+	Namespaces []NamespaceImpact
+	// Violations describe the cluster-wide and per-node shortfalls.
+	Violations []string
+}
+
+// Fits reports whether the injected workloads are expected to fit in
+// the cluster and within the quotas of their namespaces.
+func (i *Impact) Fits() bool {
+	if len(i.Violations) > 0 {
+		return false
+	}
+	for _, ns := range i.Namespaces {
+		if len(ns.Violations) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func add(dst v1.ResourceList, src v1.ResourceList, count int64) {
+	for _, name := range resources {
+		q, ok := src[name]
+		if !ok {
+			continue
+		}
+		sum := dst[name]
+		sum.Add(*resource.NewMilliQuantity(q.MilliValue()*count, q.Format))
+		dst[name] = sum
+	}
+}
+
+func quantity(list v1.ResourceList, name v1.ResourceName) *resource.Quantity {
+	q := list[name]
+	return &q
+}
+
+// Estimate combines the injection report with the nodes, pods, and
+// resource quotas of the cluster. Resources without a namespace are
+// attributed to the default namespace.
+func Estimate(client kubernetes.Interface, report *inject.Report, defaultNamespace string) (*Impact, error) {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list nodes: %v", err)
+	}
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods: %v", err)
+	}
+
+	impact := &Impact{
+		Allocatable: make(v1.ResourceList),
+		Requested:   make(v1.ResourceList),
+		Overhead:    make(v1.ResourceList),
+	}
+	requestedByNode := make(map[string]v1.ResourceList)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		requested, ok := requestedByNode[pod.Spec.NodeName]
+		if !ok {
+			requested = make(v1.ResourceList)
+			requestedByNode[pod.Spec.NodeName] = requested
+		}
+		for _, c := range pod.Spec.Containers {
+			add(requested, c.Resources.Requests, 1)
+		}
+	}
+	var schedulable []v1.Node
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		schedulable = append(schedulable, node)
+		add(impact.Allocatable, node.Status.Allocatable, 1)
+		add(impact.Requested, requestedByNode[node.Name], 1)
+	}
+	impact.Nodes = len(schedulable)
+
+	overhead, _ := report.Overhead()
+	perNode := make(v1.ResourceList)
+	byNamespace := make(map[string]v1.ResourceList)
+	for ns, o := range overhead {
+		if ns == "" {
+			ns = defaultNamespace
+		}
+		list, ok := byNamespace[ns]
+		if !ok {
+			list = make(v1.ResourceList)
+			byNamespace[ns] = list
+		}
+		add(list, o.Requests, 1)
+		add(list, o.PerNode, int64(impact.Nodes))
+		add(perNode, o.PerNode, 1)
+	}
+
+	names := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	for _, ns := range names {
+		nsImpact := NamespaceImpact{Namespace: ns, Overhead: byNamespace[ns]}
+		add(impact.Overhead, nsImpact.Overhead, 1)
+		quotas, err := client.CoreV1().ResourceQuotas(ns).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot list resource quotas in namespace %s: %v", ns, err)
+		}
+		for _, quota := range quotas.Items {
+			nsImpact.Violations = append(nsImpact.Violations, checkQuota(quota, nsImpact.Overhead)...)
+		}
+		impact.Namespaces = append(impact.Namespaces, nsImpact)
+	}
+
+	for _, name := range resources {
+		need := quantity(impact.Requested, name)
+		need.Add(*quantity(impact.Overhead, name))
+		if need.Cmp(*quantity(impact.Allocatable, name)) > 0 {
+			impact.Violations = append(impact.Violations, fmt.Sprintf("cluster: %s requests would reach %s of %s allocatable",
+				name, need.String(), quantity(impact.Allocatable, name).String()))
+		}
+	}
+	for _, node := range schedulable {
+		for _, name := range resources {
+			extra := quantity(perNode, name)
+			if extra.IsZero() {
+				continue
+			}
+			need := quantity(requestedByNode[node.Name], name)
+			need.Add(*extra)
+			if need.Cmp(*quantity(node.Status.Allocatable, name)) > 0 {
+				impact.Violations = append(impact.Violations, fmt.Sprintf("node %s: %s requests would reach %s of %s allocatable",
+					node.Name, name, need.String(), quantity(node.Status.Allocatable, name).String()))
+			}
+		}
+	}
+	return impact, nil
+}
+
+// checkQuota returns the entries of the quota that the overhead would
+// exceed.
+func checkQuota(quota v1.ResourceQuota, overhead v1.ResourceList) []string {
+	var out []string
+	for _, name := range resources {
+		extra, ok := overhead[name]
+		if !ok || extra.IsZero() {
+			continue
+		}
+		for _, entry := range quotaResources[name] {
+			hard, ok := quota.Status.Hard[entry]
+			if !ok {
+				continue
+			}
+			need := quantity(quota.Status.Used, entry)
+			need.Add(extra)
+			if need.Cmp(hard) > 0 {
+				out = append(out, fmt.Sprintf("quota %s: %s would reach %s of %s", quota.Name, entry, need.String(), hard.String()))
+			}
+		}
+	}
+	return out
+}
+
+// Write prints the estimate as a table of the overhead per namespace
+// followed by every violation.
+func (i *Impact) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAMESPACE\tCPU\tMEMORY\tQUOTA\n")
+	for _, ns := range i.Namespaces {
+		status := "ok"
+		if len(ns.Violations) > 0 {
+			status = "exceeded"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ns.Namespace,
+			quantity(ns.Overhead, v1.ResourceCPU).String(), quantity(ns.Overhead, v1.ResourceMemory).String(), status)
+	}
+	fmt.Fprintf(tw, "Total\t%s\t%s\n",
+		quantity(i.Overhead, v1.ResourceCPU).String(), quantity(i.Overhead, v1.ResourceMemory).String())
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n%d schedulable nodes: %s of %s CPU and %s of %s memory requested before injection.\n", i.Nodes,
+		quantity(i.Requested, v1.ResourceCPU).String(), quantity(i.Allocatable, v1.ResourceCPU).String(),
+		quantity(i.Requested, v1.ResourceMemory).String(), quantity(i.Allocatable, v1.ResourceMemory).String())
+	for _, ns := range i.Namespaces {
+		for _, violation := range ns.Violations {
+			fmt.Fprintf(w, "namespace %s: %s\n", ns.Namespace, violation)
+		}
+	}
+	for _, violation := range i.Violations {
+		fmt.Fprintln(w, violation)
+	}
+	_, err := fmt.Fprintf(w, "Fits: %t\n", i.Fits())
+	return err
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capacity
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+func requests(cpu, memory string) v1.ResourceList {
+	return v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse(cpu),
+		v1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func node(name, cpu, memory string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Allocatable: requests(cpu, memory)},
+	}
+}
+
+func pod(name, nodeName, cpu, memory string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name:      "app",
+				Resources: v1.ResourceRequirements{Requests: requests(cpu, memory)},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func quota(namespace, hardCPU, usedCPU string) *v1.ResourceQuota {
+	return &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: namespace},
+		Status: v1.ResourceQuotaStatus{
+			Hard: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse(hardCPU)},
+			Used: v1.ResourceList{v1.ResourceRequestsCPU: resource.MustParse(usedCPU)},
+		},
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	report := &inject.Report{Resources: []inject.ResourceReport{{
+		Kind:      "Deployment",
+		Namespace: "web",
+		Injected:  true,
+		Replicas:  3,
+		Requests:  requests("100m", "128Mi"),
+	}, {
+		Kind:     "Deployment",
+		Injected: true,
+		Replicas: 1,
+		Requests: requests("100m", "128Mi"),
+	}, {
+		Kind:      "DaemonSet",
+		Namespace: "kube-system",
+		Injected:  true,
+		PerNode:   true,
+		Requests:  requests("100m", "64Mi"),
+	}}}
+
+	cases := []struct {
+		name       string
+		objects    []runtime.Object
+		namespaces map[string][]string
+		violations []string
+	}{{
+		name: "fits",
+		objects: []runtime.Object{
+			node("node-1", "2", "4Gi"),
+			node("node-2", "2", "4Gi"),
+			pod("a", "node-1", "1", "1Gi"),
+			quota("web", "2", "1"),
+		},
+		namespaces: map[string][]string{"default": nil, "kube-system": nil, "web": nil},
+	}, {
+		name: "quota exceeded",
+		objects: []runtime.Object{
+			node("node-1", "2", "4Gi"),
+			node("node-2", "2", "4Gi"),
+			quota("web", "2", "1800m"),
+		},
+		namespaces: map[string][]string{
+			"default":     nil,
+			"kube-system": nil,
+			"web":         {"quota compute: requests.cpu would reach 2100m of 2"},
+		},
+	}, {
+		name: "node full",
+		objects: []runtime.Object{
+			node("node-1", "1", "4Gi"),
+			node("node-2", "2", "4Gi"),
+			pod("a", "node-1", "950m", "1Gi"),
+		},
+		namespaces: map[string][]string{"default": nil, "kube-system": nil, "web": nil},
+		violations: []string{"node node-1: cpu requests would reach 1050m of 1 allocatable"},
+	}, {
+		name: "cluster full",
+		objects: []runtime.Object{
+			node("node-1", "1", "4Gi"),
+			pod("a", "node-1", "600m", "1Gi"),
+		},
+		namespaces: map[string][]string{"default": nil, "kube-system": nil, "web": nil},
+		violations: []string{"cluster: cpu requests would reach 1100m of 1 allocatable"},
+	}}
+	for _, c := range cases {
+		client := fake.NewSimpleClientset(c.objects...)
+		impact, err := Estimate(client, report, "default")
+		if err != nil {
+			t.Fatalf("%s: Estimate() returned an error: %v", c.name, err)
+		}
+		namespaces := make(map[string][]string)
+		for _, ns := range impact.Namespaces {
+			namespaces[ns.Namespace] = ns.Violations
+		}
+		if !reflect.DeepEqual(namespaces, c.namespaces) {
+			t.Errorf("%s: namespace violations => got %q, want %q", c.name, namespaces, c.namespaces)
+		}
+		if !reflect.DeepEqual(impact.Violations, c.violations) {
+			t.Errorf("%s: violations => got %q, want %q", c.name, impact.Violations, c.violations)
+		}
+		if fits := c.violations == nil && len(namespaces["web"]) == 0; impact.Fits() != fits {
+			t.Errorf("%s: Fits() => got %t, want %t", c.name, impact.Fits(), fits)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	impact := &Impact{
+		Nodes:       2,
+		Allocatable: requests("4", "8Gi"),
+		Requested:   requests("1", "1Gi"),
+		Overhead:    requests("500m", "640Mi"),
+		Namespaces: []NamespaceImpact{{
+			Namespace: "web",
+			Overhead:  requests("300m", "384Mi"),
+		}, {
+			Namespace:  "api",
+			Overhead:   requests("200m", "256Mi"),
+			Violations: []string{"quota compute: requests.cpu would reach 2100m of 2"},
+		}},
+	}
+	var out bytes.Buffer
+	if err := impact.Write(&out); err != nil {
+		t.Fatalf("Write() returned an error: %v", err)
+	}
+	want := `NAMESPACE  CPU   MEMORY  QUOTA
+web        300m  384Mi   ok
+api        200m  256Mi   exceeded
+Total      500m  640Mi
+
+2 schedulable nodes: 1 of 4 CPU and 1Gi of 8Gi memory requested before injection.
+namespace api: quota compute: requests.cpu would reach 2100m of 2
+Fits: false
+`
+	if out.String() != want {
+		t.Errorf("Write() => got\n%s\nwant\n%s", out.String(), want)
+	}
+}
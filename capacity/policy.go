@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capacity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// Policies are the LimitRanges and ResourceQuotas of namespaces, by
+// namespace.
+type Policies struct {
+	LimitRanges map[string][]v1.LimitRange
+	Quotas      map[string][]v1.ResourceQuota
+}
+
+func newPolicies() *Policies {
+	return &Policies{
+		LimitRanges: make(map[string][]v1.LimitRange),
+		Quotas:      make(map[string][]v1.ResourceQuota),
+	}
+}
+
+// LoadPolicies reads the LimitRanges and ResourceQuotas of a YAML file
+// and ignores other kinds. Objects without a namespace belong to the
+// default namespace. Quotas without a status are checked against their
+// spec with nothing used.
+func LoadPolicies(in io.Reader, defaultNamespace string) (*Policies, error) {
+	policies := newPolicies()
+	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var meta metav1.TypeMeta
+		if err = yaml.Unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+		switch meta.Kind {
+		case "LimitRange":
+			var limitRange v1.LimitRange
+			if err = yaml.Unmarshal(raw, &limitRange); err != nil {
+				return nil, fmt.Errorf("invalid LimitRange: %v", err)
+			}
+			ns := limitRange.Namespace
+			if ns == "" {
+				ns = defaultNamespace
+			}
+			policies.LimitRanges[ns] = append(policies.LimitRanges[ns], limitRange)
+		case "ResourceQuota":
+			var quota v1.ResourceQuota
+			if err = yaml.Unmarshal(raw, &quota); err != nil {
+				return nil, fmt.Errorf("invalid ResourceQuota: %v", err)
+			}
+			if quota.Status.Hard == nil {
+				quota.Status.Hard = quota.Spec.Hard
+			}
+			ns := quota.Namespace
+			if ns == "" {
+				ns = defaultNamespace
+			}
+			policies.Quotas[ns] = append(policies.Quotas[ns], quota)
+		}
+	}
+	return policies, nil
+}
+
+// FetchPolicies lists the LimitRanges and ResourceQuotas of every
+// namespace of the cluster.
+func FetchPolicies(client kubernetes.Interface) (*Policies, error) {
+	limitRanges, err := client.CoreV1().LimitRanges(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list limit ranges: %v", err)
+	}
+	quotas, err := client.CoreV1().ResourceQuotas(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list resource quotas: %v", err)
+	}
+	policies := newPolicies()
+	for _, limitRange := range limitRanges.Items {
+		policies.LimitRanges[limitRange.Namespace] = append(policies.LimitRanges[limitRange.Namespace], limitRange)
+	}
+	for _, quota := range quotas.Items {
+		policies.Quotas[quota.Namespace] = append(policies.Quotas[quota.Namespace], quota)
+	}
+	return policies, nil
+}
+
+// CheckQuotas returns the ResourceQuota entries that the injection
+// overhead of the report would exceed, by namespace. Resources without
+// a namespace are attributed to the default namespace. Daemon sets are
+// counted for a single node; see Estimate for the cluster-wide view.
+func (p *Policies) CheckQuotas(report *inject.Report, defaultNamespace string) []NamespaceImpact {
+	overhead, _ := report.Overhead()
+	byNamespace := make(map[string]v1.ResourceList)
+	for ns, o := range overhead {
+		if ns == "" {
+			ns = defaultNamespace
+		}
+		list, ok := byNamespace[ns]
+		if !ok {
+			list = make(v1.ResourceList)
+			byNamespace[ns] = list
+		}
+		add(list, o.Requests, 1)
+		add(list, o.PerNode, 1)
+	}
+	names := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	var out []NamespaceImpact
+	for _, ns := range names {
+		impact := NamespaceImpact{Namespace: ns, Overhead: byNamespace[ns]}
+		for _, quota := range p.Quotas[ns] {
+			impact.Violations = append(impact.Violations, checkQuota(quota, impact.Overhead)...)
+		}
+		if len(impact.Violations) > 0 {
+			out = append(out, impact)
+		}
+	}
+	return out
+}
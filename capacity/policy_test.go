@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capacity
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+func TestLoadPolicies(t *testing.T) {
+	in, err := os.Open("testdata/policies.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = in.Close() }()
+	policies, err := LoadPolicies(in, "default")
+	if err != nil {
+		t.Fatalf("LoadPolicies() returned an error: %v", err)
+	}
+	if got := policies.LimitRanges["web"]; len(got) != 1 || got[0].Name != "containers" {
+		t.Errorf("LoadPolicies() => got limit ranges %v, want containers in web", policies.LimitRanges)
+	}
+	quotas := policies.Quotas["default"]
+	if len(quotas) != 1 {
+		t.Fatalf("LoadPolicies() => got quotas %v, want compute in default", policies.Quotas)
+	}
+	if hard := quotas[0].Status.Hard[v1.ResourceRequestsCPU]; hard.String() != "1" {
+		t.Errorf("LoadPolicies() => got hard requests.cpu %s, want the spec", hard.String())
+	}
+}
+
+func TestFetchPolicies(t *testing.T) {
+	limitRange := &v1.LimitRange{}
+	limitRange.Name, limitRange.Namespace = "containers", "web"
+	client := fake.NewSimpleClientset([]runtime.Object{limitRange, quota("web", "1", "0")}...)
+	policies, err := FetchPolicies(client)
+	if err != nil {
+		t.Fatalf("FetchPolicies() returned an error: %v", err)
+	}
+	if len(policies.LimitRanges["web"]) != 1 || len(policies.Quotas["web"]) != 1 {
+		t.Errorf("FetchPolicies() => got %v and %v", policies.LimitRanges, policies.Quotas)
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	report := &inject.Report{Resources: []inject.ResourceReport{{
+		Kind:      "Deployment",
+		Namespace: "web",
+		Injected:  true,
+		Replicas:  3,
+		Requests:  requests("100m", "128Mi"),
+	}, {
+		Kind:     "DaemonSet",
+		Injected: true,
+		PerNode:  true,
+		Requests: requests("100m", "128Mi"),
+	}}}
+	policies := &Policies{Quotas: map[string][]v1.ResourceQuota{
+		"web":     {*quota("web", "2", "1800m")},
+		"default": {*quota("default", "1", "0")},
+	}}
+	got := policies.CheckQuotas(report, "default")
+	want := []NamespaceImpact{{
+		Namespace:  "web",
+		Overhead:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("300m"), v1.ResourceMemory: resource.MustParse("384Mi")},
+		Violations: []string{"quota compute: requests.cpu would reach 2100m of 2"},
+	}}
+	if len(got) != 1 || got[0].Namespace != "web" || !reflect.DeepEqual(got[0].Violations, want[0].Violations) {
+		t.Errorf("CheckQuotas() => got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client creates kubernetes clients for the features that
+// need cluster access.
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// DefaultTimeout bounds every request made to the API server, except
+// watches, unless overridden.
+const DefaultTimeout = 30 * time.Second
+
+// Config selects the cluster, namespace, and identity used by every
+// feature that needs cluster access, following the kubectl
+// conventions.
+type Config struct {
+	// Kubeconfig is the path to a kubeconfig file. When empty, the
+	// KUBECONFIG environment variable and ~/.kube/config are used,
+	// falling back to the in-cluster configuration.
+	Kubeconfig string
+	// Context overrides the current kubeconfig context.
+	Context string
+	// Namespace overrides the namespace of the kubeconfig context.
+	Namespace string
+	// Impersonate and ImpersonateGroups act as another user.
+	Impersonate       string
+	ImpersonateGroups []string
+	// Timeout bounds every request including its retries,
+	// DefaultTimeout if zero. Watches are not bounded, so that the
+	// informers of controllers keep watching.
+	Timeout time.Duration
+	// Retries of requests that fail transiently, DefaultRetries if
+	// zero and none if negative.
+	Retries int
+}
+
+func (c *Config) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: c.Context,
+		Context: clientcmdapi.Context{
+			Namespace: c.Namespace,
+		},
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       c.Impersonate,
+			ImpersonateGroups: c.ImpersonateGroups,
+		},
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// RESTConfig returns the client configuration for the selected
+// cluster.
+func (c *Config) RESTConfig() (*rest.Config, error) {
+	config, err := c.clientConfig().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	retries := c.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	// The timeout of the config would also bound watches.
+	config.Timeout = 0
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		if retries > 0 {
+			rt = newRetryTransport(rt, retries)
+		}
+		return newTimeoutTransport(rt, timeout)
+	}
+	return config, nil
+}
+
+// DefaultNamespace returns the namespace for resources that do not
+// specify one: the Namespace override, else the namespace of the
+// kubeconfig context, else the namespace of the in-cluster service
+// account.
+func (c *Config) DefaultNamespace() (string, error) {
+	namespace, _, err := c.clientConfig().Namespace()
+	return namespace, err
+}
+
+// CreateInterface creates a kubernetes client for the selected
+// cluster.
+func (c *Config) CreateInterface() (kubernetes.Interface, error) {
+	config, err := c.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
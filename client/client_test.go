@@ -0,0 +1,99 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	cases := []struct {
+		config        Config
+		wantHost      string
+		wantNamespace string
+		wantTimeout   time.Duration
+	}{
+		{
+			config:        Config{Kubeconfig: "testdata/kubeconfig"},
+			wantHost:      "https://staging.example.com",
+			wantNamespace: "staging",
+			wantTimeout:   DefaultTimeout,
+		},
+		{
+			config:        Config{Kubeconfig: "testdata/kubeconfig", Context: "prod"},
+			wantHost:      "https://prod.example.com",
+			wantNamespace: "default",
+			wantTimeout:   DefaultTimeout,
+		},
+		{
+			config: Config{
+				Kubeconfig: "testdata/kubeconfig",
+				Context:    "prod",
+				Namespace:  "web",
+				Timeout:    5 * time.Second,
+			},
+			wantHost:      "https://prod.example.com",
+			wantNamespace: "web",
+			wantTimeout:   5 * time.Second,
+		},
+	}
+	for _, c := range cases {
+		config, err := c.config.RESTConfig()
+		if err != nil {
+			t.Errorf("RESTConfig(%+v) returned an error: %v", c.config, err)
+			continue
+		}
+		if config.Host != c.wantHost {
+			t.Errorf("RESTConfig(%+v) host => got %q, want %q", c.config, config.Host, c.wantHost)
+		}
+		if config.Timeout != 0 {
+			t.Errorf("RESTConfig(%+v) timeout => got %v, want watches unbounded", c.config, config.Timeout)
+		}
+		if rt, ok := config.WrapTransport(http.DefaultTransport).(*timeoutTransport); !ok || rt.timeout != c.wantTimeout {
+			t.Errorf("RESTConfig(%+v) transport => got %#v, want a timeout of %v", c.config, rt, c.wantTimeout)
+		}
+		namespace, err := c.config.DefaultNamespace()
+		if err != nil {
+			t.Errorf("DefaultNamespace(%+v) returned an error: %v", c.config, err)
+		} else if namespace != c.wantNamespace {
+			t.Errorf("DefaultNamespace(%+v) => got %q, want %q", c.config, namespace, c.wantNamespace)
+		}
+	}
+}
+
+func TestConfigImpersonate(t *testing.T) {
+	c := Config{
+		Kubeconfig:        "testdata/kubeconfig",
+		Impersonate:       "deployer",
+		ImpersonateGroups: []string{"ci", "release"},
+	}
+	config, err := c.RESTConfig()
+	if err != nil {
+		t.Fatalf("RESTConfig() returned an error: %v", err)
+	}
+	if config.Impersonate.UserName != "deployer" || !reflect.DeepEqual(config.Impersonate.Groups, []string{"ci", "release"}) {
+		t.Errorf("RESTConfig() impersonation => got %+v", config.Impersonate)
+	}
+}
+
+func TestConfigMissingKubeconfig(t *testing.T) {
+	c := Config{Kubeconfig: "testdata/missing"}
+	if _, err := c.RESTConfig(); err == nil {
+		t.Error("RESTConfig() succeeded with a missing kubeconfig file")
+	}
+}
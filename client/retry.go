@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Defaults for retrying requests that fail transiently.
+const (
+	DefaultRetries        = 4
+	DefaultInitialBackoff = 250 * time.Millisecond
+	DefaultMaxBackoff     = 8 * time.Second
+)
+
+// retryTransport retries requests that fail transiently with
+// exponential backoff and jitter. Requests that were rejected before
+// being processed (429) are retried regardless of the method; server
+// errors and connection failures are only retried for idempotent
+// methods. A Retry-After from the server is respected.
+type retryTransport struct {
+	next       http.RoundTripper
+	retries    int
+	initial    time.Duration
+	max        time.Duration
+	sleep      func(time.Duration)
+	jitterFunc func(time.Duration) time.Duration
+}
+
+func newRetryTransport(next http.RoundTripper, retries int) *retryTransport {
+	return &retryTransport{
+		next:    next,
+		retries: retries,
+		initial: DefaultInitialBackoff,
+		max:     DefaultMaxBackoff,
+		sleep:   time.Sleep,
+		// Sleep between half and all of the backoff so that clients
+		// failing together do not retry together.
+		jitterFunc: func(d time.Duration) time.Duration {
+			return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		},
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable returns whether the outcome of an attempt is transient.
+func retryable(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return idempotent(method)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the server, if any.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RoundTrip implements http.RoundTripper. When every attempt fails to
+// reach the server, the returned error lists the error of each.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var errs error
+	backoff := t.initial
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			// The body of the previous attempt has been consumed.
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, multierror.Append(errs, err)
+			}
+			req.Body = body
+		}
+		resp, err := t.next.RoundTrip(req)
+		last := attempt >= t.retries || (req.Body != nil && req.GetBody == nil)
+		if last || !retryable(req.Method, resp, err) {
+			if err != nil {
+				if errs == nil {
+					return nil, err
+				}
+				return nil, multierror.Append(errs, err)
+			}
+			return resp, nil
+		}
+
+		delay := t.jitterFunc(backoff)
+		if after := retryAfter(resp); after > delay {
+			delay = after
+		}
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		} else {
+			_ = resp.Body.Close()
+		}
+		t.sleep(delay)
+		if backoff *= 2; backoff > t.max {
+			backoff = t.max
+		}
+	}
+}
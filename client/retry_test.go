@@ -0,0 +1,140 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTransport(retries int) (*retryTransport, *[]time.Duration) {
+	var slept []time.Duration
+	t := newRetryTransport(http.DefaultTransport, retries)
+	t.sleep = func(d time.Duration) { slept = append(slept, d) }
+	t.jitterFunc = func(d time.Duration) time.Duration { return d }
+	return t, &slept
+}
+
+func TestRetryTransport(t *testing.T) {
+	cases := []struct {
+		name       string
+		method     string
+		failures   int
+		status     int
+		retryAfter string
+		wantStatus int
+		wantCalls  int
+		wantSlept  []time.Duration
+	}{{
+		name:       "recovers",
+		method:     http.MethodGet,
+		failures:   2,
+		status:     http.StatusServiceUnavailable,
+		wantStatus: http.StatusOK,
+		wantCalls:  3,
+		wantSlept:  []time.Duration{DefaultInitialBackoff, 2 * DefaultInitialBackoff},
+	}, {
+		name:       "gives up",
+		method:     http.MethodPut,
+		failures:   10,
+		status:     http.StatusInternalServerError,
+		wantStatus: http.StatusInternalServerError,
+		wantCalls:  3,
+		wantSlept:  []time.Duration{DefaultInitialBackoff, 2 * DefaultInitialBackoff},
+	}, {
+		name:       "server error not retried for POST",
+		method:     http.MethodPost,
+		failures:   1,
+		status:     http.StatusServiceUnavailable,
+		wantStatus: http.StatusServiceUnavailable,
+		wantCalls:  1,
+	}, {
+		name:       "throttled POST",
+		method:     http.MethodPost,
+		failures:   1,
+		status:     http.StatusTooManyRequests,
+		retryAfter: "3",
+		wantStatus: http.StatusOK,
+		wantCalls:  2,
+		wantSlept:  []time.Duration{3 * time.Second},
+	}, {
+		name:       "not transient",
+		method:     http.MethodGet,
+		failures:   1,
+		status:     http.StatusNotFound,
+		wantStatus: http.StatusNotFound,
+		wantCalls:  1,
+	}}
+	for _, c := range cases {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+				t.Errorf("%s: attempt %d got body %q", c.name, calls, body)
+			}
+			if calls <= c.failures {
+				if c.retryAfter != "" {
+					w.Header().Set("Retry-After", c.retryAfter)
+				}
+				w.WriteHeader(c.status)
+			}
+		}))
+		transport, slept := newTestTransport(2)
+		req, err := http.NewRequest(c.method, server.URL, strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: RoundTrip() returned an error: %v", c.name, err)
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != c.wantStatus || calls != c.wantCalls {
+			t.Errorf("%s: got status %d after %d calls, want %d after %d", c.name, resp.StatusCode, calls, c.wantStatus, c.wantCalls)
+		}
+		if len(*slept) != len(c.wantSlept) {
+			t.Errorf("%s: slept %v, want %v", c.name, *slept, c.wantSlept)
+			continue
+		}
+		for i := range c.wantSlept {
+			if (*slept)[i] != c.wantSlept[i] {
+				t.Errorf("%s: slept %v, want %v", c.name, *slept, c.wantSlept)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryTransportAggregatesErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	transport, slept := newTestTransport(2)
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = transport.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "3 errors occurred") {
+		t.Errorf("RoundTrip() => got error %v, want the errors of 3 attempts", err)
+	}
+	if len(*slept) != 2 {
+		t.Errorf("RoundTrip() slept %v, want 2 backoffs", *slept)
+	}
+}
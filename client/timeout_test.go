@@ -0,0 +1,49 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: newTimeoutTransport(http.DefaultTransport, 10*time.Millisecond)}
+
+	if resp, err := client.Get(server.URL + "/api/v1/pods"); err == nil {
+		_ = resp.Body.Close()
+		t.Error("Get() of a slow list succeeded, want a timeout")
+	}
+	for _, path := range []string{"/api/v1/pods?watch=true", "/api/v1/watch/pods"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Errorf("Get(%s) => got %v, want the watch unbounded", path, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil || string(body) != "ok" {
+			t.Errorf("Get(%s) => got %q, %v", path, body, err)
+		}
+	}
+}
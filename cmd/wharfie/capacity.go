@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/capacity"
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	capacityFilename string
+
+	capacityCmd = &cobra.Command{
+		Use:   "capacity",
+		Short: "Estimate whether the cluster can absorb the proxy resource requests",
+		Long: `
+Injects the resources without writing them out and compares the CPU and
+memory requested by the added containers with the allocatable capacity
+of the schedulable nodes and the ResourceQuotas of every namespace.
+Fails when the injected workloads are not expected to fit.
+`,
+		Example: `
+wharfie capacity -f deployment.yaml --proxyCPU 100m --proxyMemory 128Mi
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if capacityFilename == "" {
+				return errors.New("filename not specified (see --filename or -f)")
+			}
+			var reader io.Reader
+			if capacityFilename == "-" {
+				reader = os.Stdin
+			} else {
+				in, err := os.Open(capacityFilename)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = in.Close() }()
+				reader = in
+			}
+
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			report, err := inject.IntoResourceFileWithReport(params, reader, ioutil.Discard)
+			if err != nil {
+				return err
+			}
+			client, err := clusterConfig.CreateInterface()
+			if err != nil {
+				return err
+			}
+			namespace, err := clusterConfig.DefaultNamespace()
+			if err != nil {
+				return err
+			}
+			impact, err := capacity.Estimate(client, report, namespace)
+			if err != nil {
+				return err
+			}
+			if err = impact.Write(os.Stdout); err != nil {
+				return err
+			}
+			if !impact.Fits() {
+				return errors.New("injected workloads are not expected to fit in the cluster")
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(capacityCmd)
+	capacityCmd.Flags().StringVarP(&capacityFilename, "filename", "f", "", "Input kubernetes resource filename")
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/debug"
+)
+
+var (
+	debugTarget string
+
+	debugCmd = &cobra.Command{
+		Use:   "debug POD",
+		Short: "Attach a proxy to a running pod as an ephemeral container",
+		Long: `
+Adds the proxy to a running pod as an ephemeral container, to diagnose
+the traffic of workloads that were not injected when they were
+deployed. The pod is not restarted. The traffic of the pod is not
+redirected to the proxy, and the proxy only mounts volumes the pod
+already has. The proxy uses the debug image unless --debugImage=false.
+`,
+		Example: `
+wharfie debug hello-2917434-x1k9z --target hello
+kubectl logs hello-2917434-x1k9z -c proxy-debug
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			client, err := clusterConfig.CreateInterface()
+			if err != nil {
+				return err
+			}
+			namespace, err := clusterConfig.DefaultNamespace()
+			if err != nil {
+				return err
+			}
+			name, err := debug.Attach(client, params, namespace, args[0], debugTarget)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Added ephemeral container %s to pod %s\n", name, args[0])
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+	debugCmd.Flags().StringVar(&debugTarget, "target", "",
+		"Container of the pod whose process namespace the proxy shares")
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/initializer"
+)
+
+var (
+	initializerName string
+	watchNamespace  string
+	resyncPeriod    time.Duration
+	initializerLock leaderElection
+	initializerHook failureWebhook
+
+	initializerMetricsPort int
+
+	initializerCmd = &cobra.Command{
+		Use:   "initializer",
+		Short: "Inject the proxy into uninitialized workloads as an alpha initializer",
+		Long: `
+Runs an initializer controller that injects the proxy into Deployments,
+DaemonSets, and ReplicaSets created while the initializer is pending.
+Requires the alpha Initializers feature and an InitializerConfiguration
+that lists the initializer name for those resources.
+
+With --leaderElect, replicas elect a leader through a lock ConfigMap
+and only the leader initializes workloads while the others stand by.
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			client, err := clusterConfig.CreateInterface()
+			if err != nil {
+				return err
+			}
+			notifier, err := initializerHook.notifier()
+			if err != nil {
+				return err
+			}
+			controller := initializer.NewController(client, params, initializerName, watchNamespace, resyncPeriod, notifier)
+			serveMetrics(initializerMetricsPort)
+			stop := make(chan struct{})
+			watchMeshConfig(stop)
+			go waitSignal(stop)
+			return initializerLock.run(client, stop, controller.Run)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(initializerCmd)
+	initializerCmd.Flags().StringVar(&initializerName, "initializerName", initializer.DefaultName,
+		"Name of the initializer as registered in the InitializerConfiguration")
+	initializerCmd.Flags().StringVar(&watchNamespace, "watchNamespace", "",
+		"Namespace to watch for uninitialized workloads, all namespaces if empty")
+	initializerCmd.Flags().DurationVar(&resyncPeriod, "resync", time.Minute,
+		"Period to resync pending workloads")
+	initializerHook.addFlags(initializerCmd, "every workload released without injection or that cannot be updated")
+	initializerCmd.Flags().IntVar(&initializerMetricsPort, "metricsPort", 0,
+		"Port to serve Prometheus metrics on at /metrics, disabled if 0")
+	initializerLock.addFlags(initializerCmd, "wharfie-initializer")
+}
@@ -0,0 +1,328 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	inFilename  string
+	outFilename string
+	check       bool
+	format      string
+	selector    string
+	nameRegex   string
+	namespaces  []string
+	customKinds []string
+	skipTekton  bool
+	dryRun      bool
+	onlyChanged bool
+	outputDir   string
+
+	injectCmd = &cobra.Command{
+		Use:   "inject",
+		Short: "Inject istio sidecar proxy into kubernetes resources",
+		Example: `
+# Update resources on the fly before applying.
+kubectl apply -f <(wharfie inject -f <resource.yaml>)
+
+# Create a persistent version of the deployment with Envoy sidecar
+# injected. This is particularly useful to understand what is
+# being injected before committing to Kubernetes API server.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml
+
+# Fail a CI step when a checked-in manifest is not injected.
+wharfie inject --check -f deployment-with-istio.yaml
+
+# Check that the cluster would admit the injected resources before
+# writing them out.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml --serverDryRun
+
+# Validate the injected resources against the cluster's API schema
+# without contacting the cluster.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml --schema swagger.json
+
+# Warn about workloads whose mutual TLS certificate secret is missing.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml --verifyCertSecrets
+
+# Onboard part of a bundle to the mesh, passing the other resources
+# through unchanged.
+wharfie inject -f bundle.yaml -o bundle-with-istio.yaml --selector tier=frontend --namespaces web,api
+
+# Inject JSON manifests, e.g. from kubectl get -o json, and write JSON.
+kubectl get deployment hello -o json | wharfie inject -f - --format json
+
+# Manage the injected workloads with Terraform.
+wharfie inject -f deployment.yaml -o deployment.tf --format terraform
+
+# Inject a deployed workload in place, keeping the fields set by the
+# server.
+kubectl get deployment hello -o yaml > hello.yaml
+wharfie inject -f hello.yaml --format jsonpatch | jq '.[0].patch' > patch.json
+kubectl patch deployment hello --type json --patch "$(cat patch.json)"
+
+# Layer injection onto manifests managed elsewhere with kustomize
+# patchesStrategicMerge.
+wharfie inject -f base/deployment.yaml -o overlay/istio-patch.yaml --format strategicmerge
+
+# Keep the proxies within the LimitRanges and ResourceQuotas of the
+# namespaces, adjusting proxy resources where needed.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml --limits policies.yaml --limitMode clamp
+
+# Inject the custom resources of an operator that embed a pod template.
+wharfie inject -f workload.yaml -o workload-with-istio.yaml --customKind example.com/v1/Workload=.spec.workload.template
+
+# Only inject the namespaces of a multi-namespace bundle that opted in
+# to the mesh.
+wharfie inject -f bundle.yaml -o bundle-with-istio.yaml --namespacePolicy namespaces.yaml
+
+# Extract the injected workloads of a large bundle for an overlay.
+wharfie inject -f bundle.yaml -o workloads-with-istio.yaml --onlyChanged
+
+# Keep a file per injected resource, e.g. deployment_hello.yaml, in a
+# GitOps repository.
+wharfie inject -f bundle.yaml --outputDir manifests/
+
+# Review what injection changes before committing the manifests.
+wharfie inject -f deployment.yaml --dryRun
+
+# Summarize the injection for a pull request.
+wharfie inject -f deployment.yaml -o deployment-with-istio.yaml --report report.md
+`,
+		RunE: func(cmd *cobra.Command, _ []string) (err error) {
+			if inFilename == "" {
+				return errors.New("filename not specified (see --filename or -f)")
+			}
+			var reader io.Reader
+			if inFilename == "-" {
+				reader = os.Stdin
+			} else {
+				var in *os.File
+				if in, err = os.Open(inFilename); err != nil {
+					return err
+				}
+				defer func() { _ = in.Close() }()
+				reader = in
+			}
+
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			if selector != "" || nameRegex != "" || len(namespaces) > 0 {
+				var namespace string
+				if namespace, err = resourceNamespace(false); err != nil {
+					return err
+				}
+				if params.Filter, err = inject.NewFilter(selector, nameRegex, namespaces, namespace); err != nil {
+					return err
+				}
+			}
+			// The flag overrides the --injectConfig file only if set.
+			if cmd.Flags().Changed("skipTekton") {
+				params.SkipTekton = skipTekton
+			}
+			for _, value := range customKinds {
+				var kind inject.CustomKind
+				if kind, err = inject.ParseCustomKind(value); err != nil {
+					return err
+				}
+				params.CustomKinds = append(params.CustomKinds, kind)
+			}
+			policies, namespace, err := applyLimits(params)
+			if err != nil {
+				return err
+			}
+			convert, ok := outputFormats[format]
+			if !ok {
+				return fmt.Errorf("unknown output format %q, expected yaml, json, terraform, tfvars, jsonpatch, or strategicmerge", format)
+			}
+			if dryRun && convert != nil {
+				return errors.New("--dryRun prints a diff of the injected YAML and cannot be combined with --format")
+			}
+			if onlyChanged && (check || dryRun || format == "jsonpatch" || format == "strategicmerge") {
+				return errors.New("--onlyChanged cannot be combined with --check, --dryRun, or patch output formats, " +
+					"which compare the output with every input resource")
+			}
+			params.OmitUnchanged = onlyChanged
+			if outputDir != "" && (outFilename != "" || check || dryRun || convert != nil) {
+				return errors.New("--outputDir writes a YAML file per resource and cannot be combined with " +
+					"--output, --check, --dryRun, or --format")
+			}
+
+			if check {
+				var report *inject.Report
+				if report, err = inject.IntoResourceFileWithReport(params, reader, ioutil.Discard); err != nil {
+					return err
+				}
+				if err = writeReport(report); err != nil {
+					return err
+				}
+				if err = checkQuotas(policies, namespace, report); err != nil {
+					return err
+				}
+				return changesNeeded(report)
+			}
+
+			// The original resources are kept for output formats that
+			// describe the changes of injection.
+			var original bytes.Buffer
+			var out bytes.Buffer
+			report, err := inject.IntoResourceFileWithReport(params, io.TeeReader(reader, &original), &out)
+			if err != nil {
+				return err
+			}
+			if err = writeReport(report); err != nil {
+				return err
+			}
+			if err = checkQuotas(policies, namespace, report); err != nil {
+				return err
+			}
+			if err = validateOutput(out.Bytes()); err != nil {
+				return err
+			}
+			if convert != nil {
+				var converted bytes.Buffer
+				if err = convert(original.Bytes(), &out, &converted); err != nil {
+					return err
+				}
+				out = converted
+			}
+			if dryRun {
+				var diff string
+				if diff, err = injectionDiff(inFilename, original.Bytes(), out.Bytes()); err != nil {
+					return err
+				}
+				out.Reset()
+				out.WriteString(diff)
+			}
+
+			if outputDir != "" {
+				_, err = inject.WriteResourceFiles(&out, outputDir)
+				return err
+			}
+
+			var writer io.Writer
+			if outFilename == "" {
+				writer = os.Stdout
+			} else {
+				var file *os.File
+				if file, err = os.Create(outFilename); err != nil {
+					return err
+				}
+				writer = file
+				defer func() {
+					if cerr := file.Close(); err == nil {
+						err = cerr
+					}
+				}()
+			}
+			if _, err = writer.Write(out.Bytes()); err != nil {
+				return err
+			}
+			if dryRun {
+				return changesNeeded(report)
+			}
+			return nil
+		},
+	}
+)
+
+// changesNeeded returns errChangesNeeded if any resource of the report
+// was injected. The output is not compared with the input, which would
+// also flag the document separators and new lines that injection
+// normalizes.
+func changesNeeded(report *inject.Report) error {
+	if injected, _ := report.Summary(); injected > 0 {
+		return errChangesNeeded
+	}
+	return nil
+}
+
+// injectionDiff returns the unified diff of the injected resources
+// against the input file. The input is compared as the injector writes
+// it, so that the document separators it adds are not reported.
+func injectionDiff(name string, original, injected []byte) (string, error) {
+	var written bytes.Buffer
+	if err := inject.WriteDocuments(bytes.NewReader(original), &written); err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(written.String()),
+		B:        difflib.SplitLines(string(injected)),
+		FromFile: name,
+		ToFile:   name + " (injected)",
+		Context:  3,
+	})
+}
+
+// outputFormats converts the injected YAML to the other output
+// formats, given the original YAML.
+var outputFormats = map[string]func(original []byte, injected io.Reader, out io.Writer) error{
+	"yaml": nil,
+	"json": func(_ []byte, injected io.Reader, out io.Writer) error {
+		return inject.WriteJSON(injected, out)
+	},
+	"terraform": func(_ []byte, injected io.Reader, out io.Writer) error {
+		return inject.WriteTerraform(injected, out)
+	},
+	"tfvars": func(_ []byte, injected io.Reader, out io.Writer) error {
+		return inject.WriteTFVars(injected, out)
+	},
+	"jsonpatch": func(original []byte, injected io.Reader, out io.Writer) error {
+		return inject.WriteJSONPatches(bytes.NewReader(original), injected, out)
+	},
+	"strategicmerge": func(original []byte, injected io.Reader, out io.Writer) error {
+		return inject.WriteStrategicMergePatches(bytes.NewReader(original), injected, out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(injectCmd)
+	injectCmd.Flags().StringVarP(&inFilename, "filename", "f", "", "Input kubernetes resource filename, in YAML or a stream of JSON objects")
+	injectCmd.Flags().StringVarP(&outFilename, "output", "o", "", "Modified output kubernetes resource filename")
+	injectCmd.Flags().BoolVar(&check, "check", false,
+		"Report whether injection would modify the input instead of writing the output")
+	injectCmd.Flags().StringVarP(&selector, "selector", "l", "",
+		"Only inject resources whose labels match this selector, e.g. tier=frontend,mesh!=off")
+	injectCmd.Flags().StringVar(&nameRegex, "name", "",
+		"Only inject resources whose whole name matches this regular expression")
+	injectCmd.Flags().StringSliceVar(&namespaces, "namespaces", nil,
+		"Only inject resources in these namespaces; resources without one are in the default namespace (see --namespace)")
+	injectCmd.Flags().StringArrayVar(&customKinds, "customKind", nil,
+		"Custom kind whose resources embed a pod template at a field path, e.g. example.com/v1/Workload=.spec.workload.template; can be repeated")
+	injectCmd.Flags().BoolVar(&skipTekton, "skipTekton", false,
+		"Pass Tekton TaskRuns and PipelineRuns through unchanged, e.g. when the sidecars of their tasks conflict with the proxy")
+	injectCmd.Flags().BoolVar(&onlyChanged, "onlyChanged", false,
+		"Only write the resources changed by injection, leaving out e.g. services and config maps")
+	injectCmd.Flags().StringVar(&outputDir, "outputDir", "",
+		"Write every resource to its own file, named kind_name.yaml or kind_namespace_name.yaml, in this directory instead of a single output")
+	injectCmd.Flags().BoolVar(&dryRun, "dryRun", false,
+		"Print a unified diff of the injected resources against the input instead of the injected resources")
+	injectCmd.Flags().StringVar(&format, "format", "yaml",
+		"Output format: yaml, json (a stream of objects), terraform (kubernetes_manifest resources), tfvars (JSON variables), "+
+			"jsonpatch (RFC 6902 patches of the injected changes), or strategicmerge (strategic merge patches of the injected changes)")
+}
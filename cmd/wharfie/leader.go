@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/pilot/platform/kube/leader"
+)
+
+// leaderElection holds the leader election flags of a controller
+// command.
+type leaderElection struct {
+	enabled       bool
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// addFlags registers the leader election flags of a command whose lock
+// is named name by default.
+func (l *leaderElection) addFlags(cmd *cobra.Command, name string) {
+	cmd.Flags().BoolVar(&l.enabled, "leaderElect", false,
+		"Elect a leader among the replicas so that only one acts at a time while the others stand by")
+	cmd.Flags().StringVar(&l.namespace, "leaderElectNamespace", "",
+		"Namespace of the leader election lock ConfigMap (defaults to the context namespace)")
+	cmd.Flags().StringVar(&l.name, "leaderElectName", name, "Name of the leader election lock ConfigMap")
+	cmd.Flags().DurationVar(&l.leaseDuration, "leaseDuration", leader.DefaultLeaseDuration,
+		"Time standby replicas wait after the last renewal of the leader before taking over")
+	cmd.Flags().DurationVar(&l.renewDeadline, "renewDeadline", leader.DefaultRenewDeadline,
+		"Time the leader retries renewing its lease before giving up leadership")
+	cmd.Flags().DurationVar(&l.retryPeriod, "retryPeriod", leader.DefaultRetryPeriod,
+		"Interval between attempts to acquire or renew the lease")
+}
+
+// run runs lead until stop is closed, only while this replica is the
+// leader if leader election is enabled. Losing the lease is an error so
+// that the replica exits and restarts as a candidate.
+func (l *leaderElection) run(client kubernetes.Interface, stop <-chan struct{}, lead func(stop <-chan struct{})) error {
+	if !l.enabled {
+		lead(stop)
+		return nil
+	}
+	namespace := l.namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = clusterConfig.DefaultNamespace(); err != nil {
+			return err
+		}
+	}
+	// The hostname of a pod is its name.
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	elector, err := leader.NewElector(leader.Config{
+		Client:        client,
+		Namespace:     namespace,
+		Name:          l.name,
+		Identity:      identity,
+		LeaseDuration: l.leaseDuration,
+		RenewDeadline: l.renewDeadline,
+		RetryPeriod:   l.retryPeriod,
+	})
+	if err != nil {
+		return err
+	}
+	return elector.Run(stop, lead)
+}
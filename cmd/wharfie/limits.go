@@ -0,0 +1,99 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"istio.io/pilot/platform/kube/capacity"
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	limitsFilename    string
+	limitsFromCluster bool
+	limitMode         string
+)
+
+// applyLimits loads the LimitRanges and ResourceQuotas selected on the
+// command line and makes injection comply with the LimitRanges. It
+// returns nil policies if none are selected.
+func applyLimits(params *inject.Params) (*capacity.Policies, string, error) {
+	if limitsFilename == "" && !limitsFromCluster {
+		return nil, "", nil
+	}
+	mode := inject.LimitMode(limitMode)
+	if mode != inject.LimitValidate && mode != inject.LimitClamp {
+		return nil, "", fmt.Errorf("unknown limit mode %q, expected validate or clamp", limitMode)
+	}
+	namespace, err := resourceNamespace(limitsFromCluster)
+	if err != nil {
+		return nil, "", err
+	}
+	var policies *capacity.Policies
+	if limitsFilename != "" {
+		in, err := os.Open(limitsFilename)
+		if err != nil {
+			return nil, "", err
+		}
+		defer func() { _ = in.Close() }()
+		if policies, err = capacity.LoadPolicies(in, namespace); err != nil {
+			return nil, "", err
+		}
+	} else {
+		client, err := clusterConfig.CreateInterface()
+		if err != nil {
+			return nil, "", err
+		}
+		if policies, err = capacity.FetchPolicies(client); err != nil {
+			return nil, "", err
+		}
+	}
+	params.Limits = &inject.LimitPolicy{
+		Mode:             mode,
+		LimitRanges:      policies.LimitRanges,
+		DefaultNamespace: namespace,
+	}
+	return policies, namespace, nil
+}
+
+// checkQuotas fails when the injection overhead would exceed the
+// ResourceQuotas of a namespace.
+func checkQuotas(policies *capacity.Policies, namespace string, report *inject.Report) error {
+	if policies == nil {
+		return nil
+	}
+	var violations []string
+	for _, impact := range policies.CheckQuotas(report, namespace) {
+		for _, violation := range impact.Violations {
+			violations = append(violations, fmt.Sprintf("namespace %s: %s", impact.Namespace, violation))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("injection would exceed resource quotas:\n%s", strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+func init() {
+	injectCmd.Flags().StringVar(&limitsFilename, "limits", "",
+		"Check the proxy resources against the LimitRanges and ResourceQuotas in this file")
+	injectCmd.Flags().BoolVar(&limitsFromCluster, "limitsFromCluster", false,
+		"Check the proxy resources against the LimitRanges and ResourceQuotas of the cluster")
+	injectCmd.Flags().StringVar(&limitMode, "limitMode", string(inject.LimitValidate),
+		"How to treat proxy resources outside a LimitRange: validate (fail) or clamp (adjust)")
+}
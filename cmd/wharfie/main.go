@@ -0,0 +1,918 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/client"
+	"istio.io/pilot/platform/kube/fleet"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/mesh"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/proxy"
+)
+
+var (
+	hub             string
+	tag             string
+	sidecarProxyUID int64
+	verbosity       int
+	versionStr      string
+	enableCoreDump  bool
+	coreDumpImage   string
+	coreDumpDir     string
+	coreDumpNode    bool
+	shareProcesses  bool
+	holdApplication bool
+	meshConfig      string
+	loadMeshConfig  bool
+	meshNamespace   string
+	meshConfigTTL   time.Duration
+	includeIPRanges string
+	excludeIfaces   string
+	excludeInbound  string
+	excludeRanges   string
+	includeOutbound string
+	excludeOutbound string
+	interception    string
+	sidecarMode     string
+	excludeUIDs     string
+	excludeGIDs     string
+	certSecret      string
+	trustDomain     string
+	profileName     string
+	debugImage      bool
+	sdsPath         string
+	proxyCPU        string
+	proxyMemory     string
+	proxyCPULimit   string
+	proxyMemLimit   string
+	initCPU         string
+	initMemory      string
+	pullSecrets     []string
+	proxyEnv        []string
+	extraProxyArgs  []string
+	proxyThreads    int
+	concurrencyCPU  bool
+	readOnlyRoot    bool
+	seccompProfile  string
+	appArmor        string
+	podSecurity     string
+	adaptSecurity   bool
+	openShift       bool
+	proxyGroup      int64
+	fsGroup         int64
+	proxyImage      string
+	proxyName       string
+	proxyPortName   string
+	adminPortName   string
+	statsPort       int32
+	statsPortName   string
+	healthPort      int32
+	healthPortName  string
+	initImage       string
+	sizingFraction  float64
+	sizingMin       []string
+	sizingMax       []string
+	maxGracePeriod  time.Duration
+	drainDuration   time.Duration
+	network         string
+	fleetFile       string
+	clusterName     string
+	injectConfig    string
+	injectPolicy    string
+	namespacePolicy string
+
+	clusterConfig client.Config
+	meshCache     *mesh.Cache
+
+	rootCmd = &cobra.Command{
+		Use:   "wharfie",
+		Short: "Istio proxy injection for kubernetes resources",
+		Long: `
+Istio proxy injection for kubernetes resources.
+
+Commands that check or diff resources exit with a status that scripts
+can branch on without parsing the output:
+
+  0  no changes needed
+  1  changes needed
+  2  error
+`,
+		SilenceErrors: true,
+		// Errors of the commands are not usage errors, e.g. changes
+		// needed, and would bury the message under the flags.
+		SilenceUsage: true,
+	}
+)
+
+// Exit codes shared by all commands.
+const (
+	exitUnchanged = 0
+	exitChanged   = 1
+	exitError     = 2
+)
+
+// errChangesNeeded is returned by check and diff modes when injection
+// would modify the input.
+var errChangesNeeded = errors.New("changes needed")
+
+func init() {
+	rootCmd.PersistentPreRunE = func(*cobra.Command, []string) error {
+		return applyCluster()
+	}
+	rootCmd.PersistentFlags().StringVarP(&clusterConfig.Kubeconfig, "kubeconfig", "c", "",
+		"Kubernetes configuration file (defaults to $KUBECONFIG, ~/.kube/config, then in-cluster configuration)")
+	rootCmd.PersistentFlags().StringVar(&clusterConfig.Context, "context", "",
+		"Kubernetes configuration context to use")
+	rootCmd.PersistentFlags().StringVarP(&clusterConfig.Namespace, "namespace", "n", "",
+		"Namespace for resources that do not specify one (defaults to the context namespace)")
+	rootCmd.PersistentFlags().StringVar(&clusterConfig.Impersonate, "as", "",
+		"Username to impersonate for cluster operations")
+	rootCmd.PersistentFlags().StringSliceVar(&clusterConfig.ImpersonateGroups, "as-group", nil,
+		"Group to impersonate for cluster operations, can be repeated")
+	rootCmd.PersistentFlags().DurationVar(&clusterConfig.Timeout, "requestTimeout", client.DefaultTimeout,
+		"Timeout for each request to the Kubernetes API server, except watches")
+	rootCmd.PersistentFlags().IntVar(&clusterConfig.Retries, "requestRetries", client.DefaultRetries,
+		"Retries with exponential backoff of requests to the cluster that fail transiently, none if negative")
+	rootCmd.PersistentFlags().StringVar(&hub, "hub", "docker.io/istio", "Docker hub")
+	rootCmd.PersistentFlags().StringVar(&tag, "tag", "0.1", "Docker tag")
+	rootCmd.PersistentFlags().StringVar(&proxyImage, "proxyImage", "",
+		"Complete image reference of the proxy, e.g. registry.example.com:5000/mesh/proxy@sha256:<digest>, "+
+			"instead of the proxy image in --hub with --tag")
+	rootCmd.PersistentFlags().StringVar(&initImage, "initImage", "",
+		"Complete image reference of the init container instead of the init image in --hub with --tag")
+	rootCmd.PersistentFlags().StringVar(&proxyName, "proxyContainerName", "",
+		"Name of the injected proxy container instead of proxy, e.g. for workloads with a container of that name")
+	rootCmd.PersistentFlags().StringVar(&proxyPortName, "proxyPortName", "",
+		"Name of the listener port declared on the injected proxy instead of proxy")
+	rootCmd.PersistentFlags().StringVar(&adminPortName, "proxyAdminPortName", "",
+		"Name of the admin port declared on the injected proxy instead of proxy-admin")
+	rootCmd.PersistentFlags().Int32Var(&statsPort, "proxyStatsPort", 0,
+		"Port of the Prometheus stats of the proxy to declare on the injected proxy, e.g. 15090 (0 does not declare it)")
+	rootCmd.PersistentFlags().StringVar(&statsPortName, "proxyStatsPortName", "",
+		"Name of the declared --proxyStatsPort instead of http-envoy-prom")
+	rootCmd.PersistentFlags().Int32Var(&healthPort, "proxyHealthPort", 0,
+		"Port of the health checks of the proxy to declare on the injected proxy, e.g. 15021 (0 does not declare it)")
+	rootCmd.PersistentFlags().StringVar(&healthPortName, "proxyHealthPortName", "",
+		"Name of the declared --proxyHealthPort instead of http-health")
+	rootCmd.PersistentFlags().IntVar(&verbosity, "verbosity", inject.DefaultVerbosity, "Runtime verbosity")
+	rootCmd.PersistentFlags().Int64Var(&sidecarProxyUID, "sidecarProxyUID", inject.DefaultSidecarProxyUID, "Sidecar proxy UID")
+	rootCmd.PersistentFlags().Int64Var(&proxyGroup, "proxyRunAsGroup", -1,
+		"Primary group ID of the injected proxy (negative leaves it to the image)")
+	rootCmd.PersistentFlags().Int64Var(&fsGroup, "fsGroup", -1,
+		"fsGroup of injected pods that do not set one, so that the mounted certificate secrets are readable "+
+			"by the group (negative leaves it unset)")
+	rootCmd.PersistentFlags().StringVar(&versionStr, "setVersionString", "", "Override version info injected into resource")
+	rootCmd.PersistentFlags().StringVar(&meshConfig, "meshConfig", "", "ConfigMap name for Istio mesh configuration passed to the proxy")
+	rootCmd.PersistentFlags().BoolVar(&loadMeshConfig, "loadMeshConfig", false,
+		"Inject with the mesh configuration of the --meshConfig ConfigMap in the cluster instead of the defaults")
+	rootCmd.PersistentFlags().StringVar(&meshNamespace, "meshConfigNamespace", "",
+		"Namespace of the --meshConfig ConfigMap (defaults to the context namespace)")
+	rootCmd.PersistentFlags().DurationVar(&meshConfigTTL, "meshConfigTTL", 5*time.Minute,
+		"Maximum age of the cached mesh configuration in long-running modes, which also watch the ConfigMap for changes")
+	rootCmd.PersistentFlags().BoolVar(&enableCoreDump, "coreDump", false,
+		"Enable/Disable core dumps in injected proxy (--coreDump=true affects all pods in a node and should only be used the cluster admin)")
+	rootCmd.PersistentFlags().StringVar(&coreDumpImage, "coreDumpImage", "",
+		"Image of the privileged init container that enables core dumps instead of alpine")
+	rootCmd.PersistentFlags().StringVar(&coreDumpDir, "coreDumpDirectory", "",
+		"Absolute directory of the proxy for core dumps, mounted from an emptyDir volume, instead of /tmp")
+	rootCmd.PersistentFlags().BoolVar(&coreDumpNode, "coreDumpNodeConfigured", false,
+		"Rely on the core pattern of the nodes, which must write to --coreDumpDirectory, "+
+			"instead of injecting a privileged init container that sets it")
+	rootCmd.PersistentFlags().BoolVar(&shareProcesses, "shareProcessNamespace", false,
+		"Share the process namespace of injected pods so that the proxy can observe the application processes. "+
+			"Pods can override it with the sidecar.wharfie.io/shareProcessNamespace annotation")
+	rootCmd.PersistentFlags().BoolVar(&holdApplication, "holdApplicationUntilProxyStarts", false,
+		"Place the proxy first in injected pods and hold the start of the application containers until the proxy is ready. "+
+			"Pods can override it with the sidecar.wharfie.io/holdApplicationUntilProxyStarts annotation")
+	rootCmd.PersistentFlags().StringVar(&includeIPRanges, "includeIPRanges", "",
+		"Comma separated list of IP ranges in CIDR form. If set, only redirect outbound traffic to Envoy for these IP ranges. "+
+			"Otherwise all outbound traffic is redirected to Envoy.")
+	rootCmd.PersistentFlags().StringVar(&excludeIfaces, "excludeInterfaces", "",
+		"Comma separated list of network interfaces whose traffic is not redirected to Envoy, e.g. secondary "+
+			"interfaces of multi-NIC pods. Pods can override it with the sidecar.wharfie.io/excludeInterfaces annotation")
+	rootCmd.PersistentFlags().StringVar(&excludeRanges, "excludeIPRanges", "",
+		"Comma separated list of IP ranges in CIDR form whose outbound traffic is never redirected to Envoy, "+
+			"e.g. 169.254.169.254/32. Pods can override it with the sidecar.wharfie.io/excludeIPRanges annotation")
+	rootCmd.PersistentFlags().StringVar(&excludeUIDs, "excludeUIDs", "",
+		"Comma separated list of user IDs whose traffic is not redirected to Envoy, like the traffic of the proxy. "+
+			"Pods can override it with the sidecar.wharfie.io/excludeUIDs annotation")
+	rootCmd.PersistentFlags().StringVar(&excludeGIDs, "excludeGIDs", "",
+		"Comma separated list of group IDs whose traffic is not redirected to Envoy. "+
+			"Pods can override it with the sidecar.wharfie.io/excludeGIDs annotation")
+	rootCmd.PersistentFlags().StringVar(&interception, "interceptionMode", string(inject.InterceptionIptables),
+		"How traffic is redirected to the proxy: iptables from a NET_ADMIN init container, or cni or ebpf to annotate pods "+
+			"for a CNI plugin or an eBPF redirection agent in clusters that forbid NET_ADMIN")
+	rootCmd.PersistentFlags().StringVar(&sidecarMode, "sidecarMode", string(inject.SidecarContainer),
+		"How the proxy is added to pods: container, native for an init container with restartPolicy Always "+
+			"on clusters with the SidecarContainers feature, or auto to select native if the API server of the cluster "+
+			"is 1.29 or later. Pods can override it with the sidecar.wharfie.io/nativeSidecar annotation")
+	rootCmd.PersistentFlags().StringVar(&includeOutbound, "includeOutboundPorts", "",
+		"Comma separated list of outbound ports. If set, only outbound traffic to these ports is redirected to Envoy, e.g. 80,443. "+
+			"Pods can override it with the sidecar.wharfie.io/includeOutboundPorts annotation")
+	rootCmd.PersistentFlags().StringVar(&excludeOutbound, "excludeOutboundPorts", "",
+		"Comma separated list of outbound ports whose traffic is never redirected to Envoy. "+
+			"Pods can override it with the sidecar.wharfie.io/excludeOutboundPorts annotation")
+	rootCmd.PersistentFlags().StringVar(&excludeInbound, "excludeInboundPorts", "",
+		"Comma separated list of inbound ports whose traffic is not redirected to Envoy, e.g. debugging or metrics ports. "+
+			"Pods can override it with the sidecar.wharfie.io/excludeInboundPorts annotation")
+	rootCmd.PersistentFlags().StringVar(&certSecret, "certSecretTemplate", inject.DefaultCertSecretTemplate,
+		"Template over .Namespace and .ServiceAccount that names the certificate secret mounted with mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&trustDomain, "trustDomain", "",
+		"Trust domain of the mesh, used to annotate pods with their SPIFFE identity")
+	rootCmd.PersistentFlags().StringVar(&sdsPath, "sdsPath", "",
+		"Node directory of the node agent socket. If set, proxies with mutual TLS fetch their certificates "+
+			"from the node agent instead of mounting a secret (see the node-agent command)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "",
+		"Environment preset for the proxy: "+profileUsage()+". Flags set explicitly override the preset")
+	rootCmd.PersistentFlags().BoolVar(&debugImage, "debugImage", true,
+		"Inject the proxy image with debug tooling")
+	rootCmd.PersistentFlags().StringVar(&proxyCPU, "proxyCPU", "",
+		"CPU requested by the injected proxy, e.g. 100m")
+	rootCmd.PersistentFlags().StringVar(&proxyMemory, "proxyMemory", "",
+		"Memory requested by the injected proxy, e.g. 128Mi")
+	rootCmd.PersistentFlags().StringVar(&proxyCPULimit, "proxyCPULimit", "",
+		"CPU limit of the injected proxy, e.g. 1")
+	rootCmd.PersistentFlags().StringVar(&proxyMemLimit, "proxyMemoryLimit", "",
+		"Memory limit of the injected proxy, e.g. 512Mi")
+	rootCmd.PersistentFlags().StringVar(&initCPU, "initCPU", "",
+		"CPU requested and limited for the injected init container, which keeps Guaranteed pods Guaranteed, e.g. 10m")
+	rootCmd.PersistentFlags().StringVar(&initMemory, "initMemory", "",
+		"Memory requested and limited for the injected init container, e.g. 10Mi")
+	rootCmd.PersistentFlags().StringSliceVar(&pullSecrets, "imagePullSecrets", nil,
+		"Secrets added to the image pull secrets of injected pods to pull the proxy and init images from a private registry")
+	rootCmd.PersistentFlags().StringArrayVar(&proxyEnv, "proxyEnv", nil,
+		"Extra environment variable of the injected proxy as name=value, e.g. a feature flag (repeatable). "+
+			"Pods can add or override variables with the sidecar.wharfie.io/proxyEnv annotation")
+	rootCmd.PersistentFlags().StringArrayVar(&extraProxyArgs, "extraProxyArgs", nil,
+		"Extra argument appended to the arguments of the injected proxy, e.g. a flag of a newer proxy (repeatable)")
+	rootCmd.PersistentFlags().IntVar(&proxyThreads, "proxyConcurrency", 0,
+		"Worker threads of the injected proxy, which otherwise runs a worker per core of the node (0 lets the proxy choose). "+
+			"Pods can override it with the sidecar.wharfie.io/concurrency annotation")
+	rootCmd.PersistentFlags().BoolVar(&concurrencyCPU, "concurrencyFromCPULimit", false,
+		"Derive the worker threads of the injected proxy from its CPU limit, rounded up to whole cores, "+
+			"unless --proxyConcurrency is set")
+	rootCmd.PersistentFlags().BoolVar(&readOnlyRoot, "readOnlyRootFilesystem", false,
+		"Run the injected proxy with a read-only root filesystem and emptyDir volumes for the paths it writes to, "+
+			"so that with the hardened profile injected pods pass the restricted pod security standard")
+	rootCmd.PersistentFlags().StringVar(&seccompProfile, "seccompProfile", "",
+		"Seccomp profile of the injected init and proxy containers, RuntimeDefault or localhost/<profile> "+
+			"relative to the seccomp directory of the kubelet (empty leaves it to the pod)")
+	rootCmd.PersistentFlags().StringVar(&appArmor, "appArmorProfile", "",
+		"AppArmor profile of the injected init and proxy containers, runtime/default, unconfined, or localhost/<profile>, "+
+			"set with the container.apparmor.security.beta.kubernetes.io annotations (empty leaves it to the nodes)")
+	rootCmd.PersistentFlags().StringVar(&podSecurity, "podSecurityLevel", string(inject.PodSecurityPrivileged),
+		"Pod security level the injected pods must meet, privileged, baseline, or restricted: "+
+			"injection fails with the violations instead of the API server rejecting the pods")
+	rootCmd.PersistentFlags().BoolVar(&adaptSecurity, "adaptPodSecurity", false,
+		"Adapt the injection to --podSecurityLevel where possible: the CNI interception mode, "+
+			"no privileged core dump container, and the RuntimeDefault seccomp profile for restricted")
+	rootCmd.PersistentFlags().BoolVar(&openShift, "openShift", false,
+		"Run the injected proxy as a UID of the range of the OpenShift project, read from its "+
+			"openshift.io/sa.scc.uid-range annotation in the cluster, instead of --sidecarProxyUID "+
+			"(see generate-openshift-scc for the init container)")
+	rootCmd.PersistentFlags().Float64Var(&sizingFraction, "proxySizingFraction", 0,
+		"Size the proxy requests and limits as this fraction of the application containers of every pod, "+
+			"falling back to --proxyCPU and --proxyMemory (0 disables)")
+	rootCmd.PersistentFlags().StringSliceVar(&sizingMin, "proxySizingMin", nil,
+		"Floors of the proportionally sized proxy resources, e.g. cpu=10m,memory=32Mi")
+	rootCmd.PersistentFlags().StringSliceVar(&sizingMax, "proxySizingMax", nil,
+		"Ceilings of the proportionally sized proxy resources, e.g. cpu=1,memory=512Mi")
+	rootCmd.PersistentFlags().DurationVar(&maxGracePeriod, "maxTerminationGracePeriod", 0,
+		"Cap on the termination grace period raised to the proxy drain duration, none if zero. "+
+			"Pods can opt out with the sidecar.wharfie.io/manageTerminationGracePeriod annotation")
+	rootCmd.PersistentFlags().DurationVar(&drainDuration, "terminationDrainDuration", 0,
+		"Delay the exit of the proxy with a preStop hook so that in-flight requests drain before pods terminate, "+
+			"none if zero. Pods can override it with the sidecar.wharfie.io/terminationDrainDuration annotation")
+	rootCmd.PersistentFlags().StringVar(&network, "network", "",
+		"Network of the mesh the workloads belong to, labeled on pod templates that do not set "+inject.NetworkLabel)
+	rootCmd.PersistentFlags().StringVar(&injectConfig, "injectConfig", "",
+		"YAML file of injection parameters, e.g. versioned with the manifests. Flags set explicitly override the file")
+	rootCmd.PersistentFlags().StringVar(&injectPolicy, "policy", string(inject.InjectionPolicyEnabled),
+		"Injection policy: enabled injects workloads unless annotated with sidecar.wharfie.io/inject=false, "+
+			"disabled only injects workloads annotated with sidecar.wharfie.io/inject=true")
+	rootCmd.PersistentFlags().StringVar(&namespacePolicy, "namespacePolicy", "",
+		"YAML file of the namespaces to inject: include and exclude lists, a label selector of the namespaces, "+
+			"and the labels of the namespaces of offline manifests")
+	rootCmd.PersistentFlags().StringVar(&fleetFile, "fleet", "",
+		"File defining the named clusters of a fleet, selected with --cluster")
+	rootCmd.PersistentFlags().StringVar(&clusterName, "cluster", "",
+		"Cluster of the fleet whose kube context, hub, mesh config, trust domain, network and profile to use")
+}
+
+func profileUsage() string {
+	var usage []string
+	for _, name := range inject.ProfileNames() {
+		usage = append(usage, fmt.Sprintf("%s (%s)", name, inject.Profiles[name].Description))
+	}
+	return strings.Join(usage, ", ")
+}
+
+// applyProfile overrides the parameters whose flags were not set
+// explicitly with the selected profile.
+func applyProfile(params *inject.Params) error {
+	if profileName == "" {
+		return nil
+	}
+	profile, ok := inject.Profiles[profileName]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected one of %s",
+			profileName, strings.Join(inject.ProfileNames(), ", "))
+	}
+	flags := rootCmd.PersistentFlags()
+	if !flags.Changed("debugImage") && !profile.DebugImage {
+		params.ProxyImage = inject.ProxyReleaseImageName(hub, tag)
+	}
+	if !flags.Changed("verbosity") {
+		params.Verbosity = profile.Verbosity
+	}
+	if !flags.Changed("coreDump") {
+		params.EnableCoreDump = profile.EnableCoreDump
+	}
+	params.HardenedSecurityContext = profile.HardenedSecurityContext
+	if profile.InterceptionMode != "" && !flags.Changed("interceptionMode") {
+		params.InterceptionMode = profile.InterceptionMode
+	}
+	if profile.CoreDump != nil && !flags.Changed("coreDumpImage") && !flags.Changed("coreDumpDirectory") &&
+		!flags.Changed("coreDumpNodeConfigured") {
+		params.CoreDump = profile.CoreDump
+	}
+	if profile.PodSecurityLevel != "" && !flags.Changed("podSecurityLevel") {
+		params.PodSecurityLevel = profile.PodSecurityLevel
+	}
+	resources := profile.ProxyResources
+	for _, r := range []struct {
+		flag  string
+		name  v1.ResourceName
+		value v1.ResourceList
+		dest  *v1.ResourceList
+	}{
+		{"proxyCPU", v1.ResourceCPU, params.ProxyResources.Requests, &resources.Requests},
+		{"proxyMemory", v1.ResourceMemory, params.ProxyResources.Requests, &resources.Requests},
+		{"proxyCPULimit", v1.ResourceCPU, params.ProxyResources.Limits, &resources.Limits},
+		{"proxyMemoryLimit", v1.ResourceMemory, params.ProxyResources.Limits, &resources.Limits},
+	} {
+		if q, ok := r.value[r.name]; ok && flags.Changed(r.flag) {
+			list := make(v1.ResourceList)
+			for name, q := range *r.dest {
+				list[name] = q
+			}
+			list[r.name] = q
+			*r.dest = list
+		}
+	}
+	params.ProxyResources = resources
+	return nil
+}
+
+// applyConfig overrides the parameters whose flags were not set
+// explicitly with the --injectConfig file.
+func applyConfig(params *inject.Params) error {
+	if injectConfig == "" {
+		return nil
+	}
+	in, err := os.Open(injectConfig)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	config, err := inject.LoadConfig(in)
+	if err != nil {
+		return fmt.Errorf("invalid injection config %s: %v", injectConfig, err)
+	}
+	flags := rootCmd.PersistentFlags()
+	for flag, clear := range map[string]func(){
+		"hub":                             func() { config.InitImage, config.ProxyImage = "", "" },
+		"proxyImage":                      func() { config.ProxyImage = "" },
+		"proxyContainerName":              func() { config.ProxyContainerName = "" },
+		"initImage":                       func() { config.InitImage = "" },
+		"tag":                             func() { config.InitImage, config.ProxyImage = "", "" },
+		"debugImage":                      func() { config.ProxyImage = "" },
+		"verbosity":                       func() { config.Verbosity = nil },
+		"sidecarProxyUID":                 func() { config.SidecarProxyUID = nil },
+		"proxyRunAsGroup":                 func() { config.ProxyRunAsGroup = nil },
+		"fsGroup":                         func() { config.FSGroup = nil },
+		"setVersionString":                func() { config.Version = "" },
+		"coreDump":                        func() { config.EnableCoreDump = nil },
+		"coreDumpImage":                   func() { config.CoreDump = nil },
+		"coreDumpDirectory":               func() { config.CoreDump = nil },
+		"coreDumpNodeConfigured":          func() { config.CoreDump = nil },
+		"readOnlyRootFilesystem":          func() { config.ReadOnlyRootFilesystem = nil },
+		"seccompProfile":                  func() { config.SeccompProfile = nil },
+		"appArmorProfile":                 func() { config.AppArmorProfile = "" },
+		"podSecurityLevel":                func() { config.PodSecurityLevel = "" },
+		"adaptPodSecurity":                func() { config.AdaptPodSecurity = nil },
+		"openShift":                       func() { config.OpenShift = nil },
+		"shareProcessNamespace":           func() { config.ShareProcessNamespace = nil },
+		"holdApplicationUntilProxyStarts": func() { config.HoldApplicationUntilProxyStarts = nil },
+		"meshConfig":                      func() { config.MeshConfigMapName = "" },
+		"includeIPRanges":                 func() { config.IncludeIPRanges = "" },
+		"excludeInterfaces":               func() { config.ExcludeInterfaces = "" },
+		"excludeInboundPorts":             func() { config.ExcludeInboundPorts = "" },
+		"excludeIPRanges":                 func() { config.ExcludeIPRanges = "" },
+		"includeOutboundPorts":            func() { config.IncludeOutboundPorts = "" },
+		"excludeOutboundPorts":            func() { config.ExcludeOutboundPorts = "" },
+		"interceptionMode":                func() { config.InterceptionMode = "" },
+		"sidecarMode":                     func() { config.SidecarMode = "" },
+		"excludeUIDs":                     func() { config.ExcludeUIDs = "" },
+		"excludeGIDs":                     func() { config.ExcludeGIDs = "" },
+		"certSecretTemplate":              func() { config.CertSecretTemplate = "" },
+		"trustDomain":                     func() { config.TrustDomain = "" },
+		"sdsPath":                         func() { config.SDSPath = "" },
+		"proxyCPU":                        func() { config.ProxyResources = nil },
+		"proxyMemory":                     func() { config.ProxyResources = nil },
+		"proxyCPULimit":                   func() { config.ProxyResources = nil },
+		"proxyMemoryLimit":                func() { config.ProxyResources = nil },
+		"initCPU":                         func() { config.InitResources = nil },
+		"initMemory":                      func() { config.InitResources = nil },
+		"imagePullSecrets":                func() { config.ImagePullSecrets = nil },
+		"proxyEnv":                        func() { config.ProxyEnv = nil },
+		"extraProxyArgs":                  func() { config.ExtraProxyArgs = nil },
+		"proxyConcurrency":                func() { config.Concurrency = nil },
+		"concurrencyFromCPULimit":         func() { config.ConcurrencyFromCPULimit = nil },
+		"maxTerminationGracePeriod":       func() { config.MaxTerminationGracePeriod = "" },
+		"terminationDrainDuration":        func() { config.TerminationDrainDuration = "" },
+		"network":                         func() { config.Network = "" },
+		"policy":                          func() { config.Policy = "" },
+		"proxyPortName":                   func() { config.ProxyPorts = nil },
+		"proxyAdminPortName":              func() { config.ProxyPorts = nil },
+		"proxyStatsPort":                  func() { config.ProxyPorts = nil },
+		"proxyStatsPortName":              func() { config.ProxyPorts = nil },
+		"proxyHealthPort":                 func() { config.ProxyPorts = nil },
+		"proxyHealthPortName":             func() { config.ProxyPorts = nil },
+	} {
+		if flags.Changed(flag) {
+			clear()
+		}
+	}
+	if err = config.Apply(params); err != nil {
+		return fmt.Errorf("invalid injection config %s: %v", injectConfig, err)
+	}
+	return nil
+}
+
+// applyNamespacePolicy restricts injection to the namespaces of the
+// --namespacePolicy file.
+func applyNamespacePolicy(params *inject.Params) error {
+	if namespacePolicy == "" {
+		return nil
+	}
+	in, err := os.Open(namespacePolicy)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	if params.Namespaces, err = inject.LoadNamespacePolicy(in); err != nil {
+		return fmt.Errorf("invalid namespace policy %s: %v", namespacePolicy, err)
+	}
+	params.Namespaces.DefaultNamespace, err = resourceNamespace(false)
+	return err
+}
+
+// applyOpenShift reads the UID ranges of the OpenShift projects from the
+// cluster if --openShift is set.
+func applyOpenShift(params *inject.Params) error {
+	if openShift {
+		client, err := clusterConfig.CreateInterface()
+		if err != nil {
+			return err
+		}
+		params.OpenShift = &inject.OpenShiftPolicy{Lookup: namespaceAnnotations(client)}
+	}
+	if params.OpenShift == nil {
+		return nil
+	}
+	var err error
+	params.OpenShift.DefaultNamespace, err = resourceNamespace(false)
+	return err
+}
+
+// resolveSidecarMode selects native sidecars in auto mode if the API
+// server of the cluster supports them.
+func resolveSidecarMode(params *inject.Params) error {
+	if params.SidecarMode != inject.SidecarAuto {
+		return nil
+	}
+	client, err := clusterConfig.CreateInterface()
+	if err != nil {
+		return err
+	}
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("cannot detect native sidecar support (see --sidecarMode): %v", err)
+	}
+	params.SidecarMode = inject.SidecarContainer
+	if inject.SupportsNativeSidecars(version.Major, version.Minor) {
+		params.SidecarMode = inject.SidecarNative
+	}
+	glog.V(2).Infof("Selected the %s sidecar mode for kubernetes %s", params.SidecarMode, version.GitVersion)
+	return nil
+}
+
+// applyCluster overrides the flags that were not set explicitly with the
+// settings of the selected cluster of the fleet.
+func applyCluster() error {
+	if clusterName == "" {
+		return nil
+	}
+	if fleetFile == "" {
+		return errors.New("--cluster requires --fleet")
+	}
+	in, err := os.Open(fleetFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	f, err := fleet.Load(in)
+	if err != nil {
+		return fmt.Errorf("invalid fleet %s: %v", fleetFile, err)
+	}
+	cluster, err := f.Cluster(clusterName)
+	if err != nil {
+		return err
+	}
+	flags := rootCmd.PersistentFlags()
+	for _, setting := range []struct {
+		flag  string
+		value string
+		dest  *string
+	}{
+		{"kubeconfig", cluster.Kubeconfig, &clusterConfig.Kubeconfig},
+		{"context", cluster.Context, &clusterConfig.Context},
+		{"hub", cluster.Hub, &hub},
+		{"tag", cluster.Tag, &tag},
+		{"profile", cluster.Profile, &profileName},
+		{"meshConfig", cluster.MeshConfig, &meshConfig},
+		{"meshConfigNamespace", cluster.MeshConfigNamespace, &meshNamespace},
+		{"trustDomain", cluster.TrustDomain, &trustDomain},
+		{"network", cluster.Network, &network},
+	} {
+		if setting.value != "" && !flags.Changed(setting.flag) {
+			*setting.dest = setting.value
+		}
+	}
+	// The mesh config of a cluster is read from the cluster itself.
+	if cluster.MeshConfig != "" && !flags.Changed("loadMeshConfig") {
+		loadMeshConfig = true
+	}
+	return nil
+}
+
+// parseResourceList parses name=quantity pairs.
+func parseResourceList(values []string) (v1.ResourceList, error) {
+	list := make(v1.ResourceList)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid resource %q, expected name=quantity", value)
+		}
+		q, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for resource %s: %v", parts[0], err)
+		}
+		list[v1.ResourceName(parts[0])] = q
+	}
+	return list, nil
+}
+
+// parseEnv parses environment variables in the name=value form, nil if
+// there are none.
+func parseEnv(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid environment variable %q, expected name=value", value)
+		}
+		if err := inject.ValidateEnvName(parts[0]); err != nil {
+			return nil, err
+		}
+		env[parts[0]] = parts[1]
+	}
+	return env, nil
+}
+
+// resourceNamespace returns the namespace of resources that do not
+// specify one. Offline commands do not need a cluster, in which case
+// it falls back to the default namespace.
+func resourceNamespace(needCluster bool) (string, error) {
+	if clusterConfig.Namespace != "" {
+		return clusterConfig.Namespace, nil
+	}
+	namespace, err := clusterConfig.DefaultNamespace()
+	if err != nil && !needCluster {
+		return v1.NamespaceDefault, nil
+	}
+	return namespace, err
+}
+
+// getParams assembles the injection parameters from the command line
+// flags.
+func getParams() (*inject.Params, error) {
+	mesh := proxy.DefaultMeshConfig()
+	var resources, initResources v1.ResourceRequirements
+	for _, r := range []struct {
+		kind   string
+		cpu    string
+		memory string
+		dest   *v1.ResourceList
+	}{
+		{"proxy %s request", proxyCPU, proxyMemory, &resources.Requests},
+		{"proxy %s limit", proxyCPULimit, proxyMemLimit, &resources.Limits},
+		{"init container %s", initCPU, initMemory, &initResources.Requests},
+	} {
+		list := v1.ResourceList{}
+		for name, value := range map[v1.ResourceName]string{
+			v1.ResourceCPU:    r.cpu,
+			v1.ResourceMemory: r.memory,
+		} {
+			if value == "" {
+				continue
+			}
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", fmt.Sprintf(r.kind, name), value, err)
+			}
+			list[name] = q
+		}
+		if len(list) > 0 {
+			*r.dest = list
+		}
+	}
+	var sizing *inject.SizingPolicy
+	if sizingFraction < 0 {
+		return nil, fmt.Errorf("invalid proxy sizing fraction %v", sizingFraction)
+	} else if sizingFraction > 0 {
+		sizing = &inject.SizingPolicy{Fraction: sizingFraction}
+		var err error
+		if sizing.Min, err = parseResourceList(sizingMin); err != nil {
+			return nil, err
+		}
+		if sizing.Max, err = parseResourceList(sizingMax); err != nil {
+			return nil, err
+		}
+	}
+	params := &inject.Params{
+		InitImage:          inject.InitImageName(hub, tag),
+		ProxyImage:         inject.ProxyImageName(hub, tag),
+		Verbosity:          verbosity,
+		SidecarProxyUID:    sidecarProxyUID,
+		Version:            versionStr,
+		EnableCoreDump:     enableCoreDump,
+		Mesh:               &mesh,
+		MeshConfigMapName:  meshConfig,
+		IncludeIPRanges:    includeIPRanges,
+		ProxyResources:     resources,
+		CertSecretTemplate: certSecret,
+		TrustDomain:        trustDomain,
+		SDSPath:            sdsPath,
+		Sizing:             sizing,
+	}
+	params.ShareProcessNamespace = shareProcesses
+	params.HoldApplicationUntilProxyStarts = holdApplication
+	params.ExcludeInterfaces = excludeIfaces
+	params.ExcludeInboundPorts = excludeInbound
+	params.ExcludeIPRanges = excludeRanges
+	params.IncludeOutboundPorts = includeOutbound
+	params.ExcludeOutboundPorts = excludeOutbound
+	params.ExcludeUIDs = excludeUIDs
+	params.ExcludeGIDs = excludeGIDs
+	params.MaxTerminationGracePeriod = maxGracePeriod
+	params.TerminationDrainDuration = drainDuration
+	params.Network = network
+	// Equal requests and limits keep the QoS class of Guaranteed pods.
+	initResources.Limits = initResources.Requests
+	params.InitResources = initResources
+	params.ImagePullSecrets = pullSecrets
+	params.ExtraProxyArgs = extraProxyArgs
+	params.ProxyContainerName = proxyName
+	if coreDumpImage != "" || coreDumpDir != "" || coreDumpNode {
+		params.CoreDump = &inject.CoreDumpPolicy{
+			Image:          coreDumpImage,
+			Directory:      coreDumpDir,
+			NodeConfigured: coreDumpNode,
+		}
+		if err := params.CoreDump.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if proxyPortName != "" || adminPortName != "" || statsPort != 0 || statsPortName != "" ||
+		healthPort != 0 || healthPortName != "" {
+		params.ProxyPorts = &inject.ProxyPorts{
+			ProxyName:  proxyPortName,
+			AdminName:  adminPortName,
+			StatsName:  statsPortName,
+			StatsPort:  statsPort,
+			HealthName: healthPortName,
+			HealthPort: healthPort,
+		}
+		if err := params.ProxyPorts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if proxyThreads < 0 {
+		return nil, fmt.Errorf("invalid --proxyConcurrency %d, expected a non-negative integer", proxyThreads)
+	}
+	params.Concurrency = proxyThreads
+	params.ConcurrencyFromCPULimit = concurrencyCPU
+	params.ReadOnlyRootFilesystem = readOnlyRoot
+	if proxyGroup >= 0 {
+		gid := proxyGroup
+		params.ProxyRunAsGroup = &gid
+	}
+	if fsGroup >= 0 {
+		gid := fsGroup
+		params.FSGroup = &gid
+	}
+	if appArmor != "" {
+		if err := inject.ValidateAppArmorProfile(appArmor); err != nil {
+			return nil, err
+		}
+	}
+	params.AppArmorProfile = appArmor
+	policy, err := inject.ParseInjectionPolicy(injectPolicy)
+	if err != nil {
+		return nil, err
+	}
+	params.Policy = policy
+	if params.ProxyEnv, err = parseEnv(proxyEnv); err != nil {
+		return nil, err
+	}
+	if params.SeccompProfile, err = inject.ParseSeccompProfile(seccompProfile); err != nil {
+		return nil, err
+	}
+	if params.InterceptionMode, err = inject.ParseInterceptionMode(interception); err != nil {
+		return nil, err
+	}
+	if params.SidecarMode, err = inject.ParseSidecarMode(sidecarMode); err != nil {
+		return nil, err
+	}
+	if params.PodSecurityLevel, err = inject.ParsePodSecurityLevel(podSecurity); err != nil {
+		return nil, err
+	}
+	params.AdaptPodSecurity = adaptSecurity
+	if !debugImage {
+		params.ProxyImage = inject.ProxyReleaseImageName(hub, tag)
+	}
+	if err := applyProfile(params); err != nil {
+		return nil, err
+	}
+	// Complete image references replace the images of the hub and the
+	// profile.
+	if proxyImage != "" {
+		params.ProxyImage = proxyImage
+	}
+	if initImage != "" {
+		params.InitImage = initImage
+	}
+	if err := applyConfig(params); err != nil {
+		return nil, err
+	}
+	for _, image := range []string{params.ProxyImage, params.InitImage} {
+		if err := inject.ValidateImage(image); err != nil {
+			return nil, err
+		}
+	}
+	if params.ProxyContainerName != "" {
+		if err := inject.ValidateContainerName(params.ProxyContainerName); err != nil {
+			return nil, err
+		}
+	}
+	if err := inject.CheckResources(params.ProxyResources); err != nil {
+		return nil, err
+	}
+	if err := applyNamespacePolicy(params); err != nil {
+		return nil, err
+	}
+	if err := resolveSidecarMode(params); err != nil {
+		return nil, err
+	}
+	if err := applyOpenShift(params); err != nil {
+		return nil, err
+	}
+	if loadMeshConfig {
+		if err := setMeshSource(params); err != nil {
+			return nil, err
+		}
+	}
+	// Report template errors before any resource is processed.
+	if _, err := params.CertSecretName("", "default"); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// setMeshSource reads the mesh configuration of the injection from the
+// cluster through meshCache.
+func setMeshSource(params *inject.Params) error {
+	if meshConfig == "" {
+		return errors.New("mesh config map not specified (see --meshConfig)")
+	}
+	client, err := clusterConfig.CreateInterface()
+	if err != nil {
+		return err
+	}
+	namespace := meshNamespace
+	if namespace == "" {
+		if namespace, err = clusterConfig.DefaultNamespace(); err != nil {
+			return err
+		}
+	}
+	meshCache = mesh.NewCache(client, namespace, meshConfig, meshConfigTTL)
+	// Report a missing or invalid config map before any resource is
+	// processed.
+	if _, err = meshCache.Mesh(); err != nil {
+		return err
+	}
+	params.MeshSource = meshCache
+	return nil
+}
+
+// watchMeshConfig keeps the mesh configuration read from the cluster,
+// if any, up to date until the stop channel is closed.
+func watchMeshConfig(stop <-chan struct{}) {
+	if meshCache != nil {
+		go meshCache.Run(stop)
+	}
+}
+
+// exitCode maps the error returned by a command to the process exit
+// status.
+func exitCode(err error) int {
+	if err == nil {
+		return exitUnchanged
+	}
+	if merr, ok := err.(*multierror.Error); ok {
+		for _, err := range merr.Errors {
+			if exitCode(err) != exitChanged {
+				return exitError
+			}
+		}
+		return exitChanged
+	}
+	if _, ok := err.(*inject.SnapshotMismatchError); ok || err == errChangesNeeded {
+		return exitChanged
+	}
+	return exitError
+}
+
+// serveMetrics serves the Prometheus metrics of the process on the
+// port in the background, unless the port is 0.
+func serveMetrics(port int) {
+	if port == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metrics.DefaultPath, metrics.Handler())
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+			glog.Warningf("Cannot serve metrics: %v", err)
+		}
+	}()
+}
+
+// waitSignal blocks until the process is asked to terminate and then
+// closes the stop channel.
+func waitSignal(stop chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	close(stop)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
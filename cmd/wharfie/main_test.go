@@ -0,0 +1,366 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+func TestExitCode(t *testing.T) {
+	mismatch := &inject.SnapshotMismatchError{Path: "a.yaml.injected"}
+	failure := errors.New("failure")
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitUnchanged},
+		{errChangesNeeded, exitChanged},
+		{mismatch, exitChanged},
+		{multierror.Append(nil, mismatch, mismatch), exitChanged},
+		{multierror.Append(nil, mismatch, failure), exitError},
+		{failure, exitError},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) => got %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestChangesNeeded(t *testing.T) {
+	report := &inject.Report{Resources: []inject.ResourceReport{{Kind: "Deployment"}, {Kind: "Service"}}}
+	if err := changesNeeded(report); err != nil {
+		t.Errorf("changesNeeded() without injected resources => got %v", err)
+	}
+	report.Resources[0].Injected = true
+	if err := changesNeeded(report); err != errChangesNeeded {
+		t.Errorf("changesNeeded() with an injected resource => got %v, want %v", err, errChangesNeeded)
+	}
+}
+
+func TestParseResourceList(t *testing.T) {
+	list, err := parseResourceList([]string{"cpu=10m", "memory=32Mi"})
+	if err != nil {
+		t.Fatalf("parseResourceList() returned an error: %v", err)
+	}
+	if cpu := list.Cpu().String(); cpu != "10m" {
+		t.Errorf("parseResourceList() cpu => got %s, want 10m", cpu)
+	}
+	if memory := list.Memory().String(); memory != "32Mi" {
+		t.Errorf("parseResourceList() memory => got %s, want 32Mi", memory)
+	}
+	for _, value := range []string{"cpu", "cpu=lots"} {
+		if _, err := parseResourceList([]string{value}); err == nil {
+			t.Errorf("parseResourceList(%q) => got no error", value)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := parseEnv([]string{"TRACE_SAMPLING=10", "ZIPKIN=zipkin:9411,zipkin-2:9411", "EMPTY="})
+	if err != nil {
+		t.Fatalf("parseEnv() returned an error: %v", err)
+	}
+	if env["TRACE_SAMPLING"] != "10" || env["ZIPKIN"] != "zipkin:9411,zipkin-2:9411" || len(env) != 3 {
+		t.Errorf("parseEnv() => got %v", env)
+	}
+	for _, value := range []string{"TRACE_SAMPLING", "TRACE SAMPLING=10"} {
+		if _, err := parseEnv([]string{value}); err == nil {
+			t.Errorf("parseEnv(%q) => got no error", value)
+		}
+	}
+}
+
+func TestInjectionDiff(t *testing.T) {
+	original := "kind: Deployment\nspec:\n  replicas: 1\n"
+	injected := "kind: Deployment\nspec:\n  replicas: 1\n  paused: true\n---\n"
+	diff, err := injectionDiff("app.yaml", []byte(original), []byte(injected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- app.yaml\n", "+++ app.yaml (injected)\n", "+  paused: true\n"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("injectionDiff() => got %q, want %q", diff, want)
+		}
+	}
+	// The separator that the injector writes after every document is
+	// not a change.
+	if diff, err = injectionDiff("app.yaml", []byte(original), []byte(original+"---\n")); err != nil || diff != "" {
+		t.Errorf("injectionDiff() of unchanged resources => got %q, %v", diff, err)
+	}
+}
+
+func TestPrintWarnings(t *testing.T) {
+	report := &inject.Report{Resources: []inject.ResourceReport{
+		{Kind: "Deployment", Name: "hello", Namespace: "web", Warnings: []string{"anchors were expanded"}},
+		{Kind: "Service", Name: "hello"},
+	}}
+	var out bytes.Buffer
+	if err := printWarnings(report, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Warning: Deployment web/hello: anchors were expanded\n"; out.String() != want {
+		t.Errorf("printWarnings() => got %q, want %q", out.String(), want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wharfie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	defer func() { inFilename, outFilename, dryRun = "", "", false }()
+	cases := []struct {
+		in       string
+		wantDiff bool
+		wantErr  error
+	}{
+		{in: "../../inject/testdata/hello.yaml", wantDiff: true, wantErr: errChangesNeeded},
+		{in: "../../inject/testdata/hello-service.yaml"},
+	}
+	for _, c := range cases {
+		out := filepath.Join(dir, "diff")
+		rootCmd.SetArgs([]string{"inject", "-f", c.in, "-o", out, "--dryRun"})
+		if err = rootCmd.Execute(); err != c.wantErr {
+			t.Errorf("inject --dryRun -f %s => got error %v, want %v", c.in, err, c.wantErr)
+		}
+		diff, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(diff) > 0) != c.wantDiff {
+			t.Errorf("inject --dryRun -f %s => got diff %q, want a diff: %v", c.in, diff, c.wantDiff)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	defer func() { profileName = "" }()
+	flags := rootCmd.PersistentFlags()
+	if err := flags.Set("proxyCPU", "250m"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = flags.Set("proxyCPU", "") }()
+	if err := flags.Set("proxyMemoryLimit", "1Gi"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = flags.Set("proxyMemoryLimit", "") }()
+
+	profileName = "prod"
+	params, err := getParams()
+	if err != nil {
+		t.Fatalf("getParams() returned an error: %v", err)
+	}
+	if want := inject.ProxyReleaseImageName(hub, tag); params.ProxyImage != want {
+		t.Errorf("prod profile image => got %q, want %q", params.ProxyImage, want)
+	}
+	if params.Verbosity != 0 || !params.HardenedSecurityContext {
+		t.Errorf("prod profile => got verbosity %d and hardened %t, want 0 and true",
+			params.Verbosity, params.HardenedSecurityContext)
+	}
+	requests := params.ProxyResources.Requests
+	if cpu, memory := requests.Cpu().String(), requests.Memory().String(); cpu != "250m" || memory != "128Mi" {
+		t.Errorf("prod profile requests => got cpu %s, memory %s, want 250m (flag) and 128Mi (profile)", cpu, memory)
+	}
+	limits := params.ProxyResources.Limits
+	if cpu, memory := limits.Cpu().String(), limits.Memory().String(); cpu != "1" || memory != "1Gi" {
+		t.Errorf("prod profile limits => got cpu %s, memory %s, want 1 (profile) and 1Gi (flag)", cpu, memory)
+	}
+
+	if err = flags.Set("proxyCPU", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = getParams(); err == nil {
+		t.Error("getParams() accepted a CPU request above the CPU limit")
+	}
+
+	if err = flags.Set("proxyCPU", ""); err != nil {
+		t.Fatal(err)
+	}
+	profileName = "autopilot"
+	if params, err = getParams(); err != nil {
+		t.Fatalf("getParams() returned an error: %v", err)
+	}
+	if params.InterceptionMode != inject.InterceptionCNI || params.CoreDump == nil || !params.CoreDump.NodeConfigured ||
+		params.PodSecurityLevel != inject.PodSecurityBaseline {
+		t.Errorf("autopilot profile => got interception %s, core dump %+v, pod security %s",
+			params.InterceptionMode, params.CoreDump, params.PodSecurityLevel)
+	}
+
+	profileName = "qa"
+	if _, err = getParams(); err == nil {
+		t.Error("getParams() accepted an unknown profile")
+	}
+}
+
+func TestInitResources(t *testing.T) {
+	flags := rootCmd.PersistentFlags()
+	if err := flags.Set("initCPU", "10m"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = flags.Set("initCPU", "") }()
+	params, err := getParams()
+	if err != nil {
+		t.Fatalf("getParams() returned an error: %v", err)
+	}
+	resources := params.InitResources
+	if request, limit := resources.Requests.Cpu().String(), resources.Limits.Cpu().String(); request != "10m" || limit != "10m" {
+		t.Errorf("getParams() => got init CPU request %s and limit %s, want 10m", request, limit)
+	}
+	if _, ok := resources.Requests[v1.ResourceMemory]; ok {
+		t.Errorf("getParams() => got init memory request %v, want none", resources.Requests)
+	}
+}
+
+func TestImageFlags(t *testing.T) {
+	defer func() { proxyImage, initImage, profileName = "", "", "" }()
+	proxyImage = "registry.example.com:5000/mesh/envoy@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	initImage = "registry.example.com:5000/mesh/iptables:v2"
+	profileName = "prod"
+	params, err := getParams()
+	if err != nil {
+		t.Fatalf("getParams() returned an error: %v", err)
+	}
+	if params.ProxyImage != proxyImage || params.InitImage != initImage {
+		t.Errorf("getParams() => got images %q and %q, want %q and %q", params.ProxyImage, params.InitImage, proxyImage, initImage)
+	}
+
+	proxyImage = "registry.example.com/Mesh/proxy"
+	if _, err = getParams(); err == nil {
+		t.Error("getParams() accepted an invalid image reference")
+	}
+}
+
+func TestApplyCluster(t *testing.T) {
+	saved := []*string{&fleetFile, &clusterName, &clusterConfig.Context, &hub, &profileName, &meshConfig, &meshNamespace, &network, &trustDomain}
+	values := make([]string, len(saved))
+	for i, v := range saved {
+		values[i] = *v
+	}
+	defer func() {
+		for i, v := range saved {
+			*v = values[i]
+		}
+		loadMeshConfig = false
+	}()
+	flags := rootCmd.PersistentFlags()
+	if err := flags.Set("trustDomain", "mesh.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = flags.Set("trustDomain", values[len(values)-1]) }()
+
+	fleetFile, clusterName = "../../fleet/testdata/fleet.yaml", "us-east"
+	if err := applyCluster(); err != nil {
+		t.Fatalf("applyCluster() returned an error: %v", err)
+	}
+	if clusterConfig.Context != "gke_prod_us-east1_mesh" || hub != "us-east1-docker.example.com/istio" || profileName != "prod" {
+		t.Errorf("applyCluster() => got context %q, hub %q, profile %q", clusterConfig.Context, hub, profileName)
+	}
+	if meshConfig != "istio" || meshNamespace != "istio-system" || !loadMeshConfig {
+		t.Errorf("applyCluster() => got mesh config %s/%s, load %t", meshNamespace, meshConfig, loadMeshConfig)
+	}
+	if network != "vpc-east" {
+		t.Errorf("applyCluster() => got network %q", network)
+	}
+	if trustDomain != "mesh.example.com" {
+		t.Errorf("applyCluster() => got trust domain %q, want the explicit flag", trustDomain)
+	}
+
+	clusterName = "ap-south"
+	if err := applyCluster(); err == nil {
+		t.Error("applyCluster() accepted an unknown cluster")
+	}
+	fleetFile = ""
+	if err := applyCluster(); err == nil {
+		t.Error("applyCluster() accepted a cluster without a fleet")
+	}
+}
+
+func TestServerArgs(t *testing.T) {
+	defer func() {
+		profileName, clusterConfig.Kubeconfig, debugImage, injectConfig = "", "", true, ""
+		pullSecrets, proxyEnv = nil, nil
+	}()
+	profileName, clusterConfig.Kubeconfig, debugImage = "prod", "/tmp/kubeconfig", false
+	pullSecrets, proxyEnv = []string{"registry", "mirror"}, []string{"A=1,2"}
+	got, err := serverArgs()
+	if err != nil {
+		t.Fatalf("serverArgs() returned an error: %v", err)
+	}
+	want := []string{"--hub", hub, "--tag", tag, "--debugImage=false", "--imagePullSecrets=registry",
+		"--imagePullSecrets=mirror", "--profile=prod", "--proxyEnv=A=1,2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("serverArgs() => got %q, want %q", got, want)
+	}
+
+	injectConfig = "inject.yaml"
+	if _, err = serverArgs(); err == nil {
+		t.Error("serverArgs() forwarded a local file")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "inject-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if _, err = file.WriteString("verbosity: 4\nnetwork: vpc-west\nproxyImage: registry.example.com/proxy:1.0\npolicy: disabled\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { injectConfig = "" }()
+	flags := rootCmd.PersistentFlags()
+	if err = flags.Set("verbosity", "3"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = flags.Set("verbosity", strconv.Itoa(inject.DefaultVerbosity)) }()
+
+	injectConfig = file.Name()
+	params, err := getParams()
+	if err != nil {
+		t.Fatalf("getParams() returned an error: %v", err)
+	}
+	if params.Verbosity != 3 {
+		t.Errorf("getParams() => got verbosity %d, want 3 (flag)", params.Verbosity)
+	}
+	if params.Network != "vpc-west" || params.ProxyImage != "registry.example.com/proxy:1.0" {
+		t.Errorf("getParams() => got network %q and proxy image %q from the file", params.Network, params.ProxyImage)
+	}
+	if params.Policy != inject.InjectionPolicyDisabled {
+		t.Errorf("getParams() => got policy %q, want %q from the file", params.Policy, inject.InjectionPolicyDisabled)
+	}
+
+	injectConfig = file.Name() + ".missing"
+	if _, err = getParams(); err == nil {
+		t.Error("getParams() accepted a missing injection config")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/nodeagent"
+)
+
+var (
+	nodeAgentNamespace string
+
+	nodeAgentCmd = &cobra.Command{
+		Use:   "node-agent",
+		Short: "Generate the node agent DaemonSet and RBAC for the SDS identity mode",
+		Long: `
+Proxies injected with --sdsPath fetch their certificates from a node
+agent listening on a socket in that node directory. This command
+generates the node agent for the same directory, so that both halves
+of the SDS identity mode are configured from the same flags.
+`,
+		Example: `
+wharfie node-agent --sdsPath /var/run/sds | kubectl apply -f -
+kubectl apply -f <(wharfie inject --sdsPath /var/run/sds -f deployment.yaml)
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			options := nodeagent.DefaultOptions(nodeAgentNamespace, hub, tag)
+			if sdsPath != "" {
+				options.SDSPath = sdsPath
+			}
+			return nodeagent.WriteManifests(options, os.Stdout)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(nodeAgentCmd)
+	nodeAgentCmd.Flags().StringVar(&nodeAgentNamespace, "nodeAgentNamespace", "istio-system",
+		"Namespace of the node agent")
+}
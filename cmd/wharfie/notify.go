@@ -0,0 +1,48 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/notify"
+)
+
+// failureWebhook holds the failure webhook flags of a command.
+type failureWebhook struct {
+	url    string
+	format string
+}
+
+// addFlags registers the failure webhook flags of a command, which
+// notifies the webhook of the failures described by usage.
+func (f *failureWebhook) addFlags(cmd *cobra.Command, usage string) {
+	cmd.Flags().StringVar(&f.url, "failureWebhook", "", "URL notified of "+usage)
+	cmd.Flags().StringVar(&f.format, "failureWebhookFormat", notify.FormatGeneric,
+		"Payload of the failure webhook: "+notify.FormatGeneric+" JSON or a "+notify.FormatSlack+" message")
+}
+
+// notifier returns a notifier of the webhook that does not block its
+// callers, or nil if no webhook is set.
+func (f *failureWebhook) notifier() (notify.Notifier, error) {
+	if f.url == "" {
+		return nil, nil
+	}
+	webhook, err := notify.NewWebhook(f.url, f.format)
+	if err != nil {
+		return nil, err
+	}
+	return notify.Async(webhook, notify.DefaultQueueSize), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	sccName       string
+	sccNamespaces []string
+
+	generateOpenShiftSCCCmd = &cobra.Command{
+		Use:   "generate-openshift-scc",
+		Short: "Generate the security context constraints that admit injected pods on OpenShift",
+		Long: `
+Writes the SecurityContextConstraints that admit the init container of
+injected pods, which runs as root with the NET_ADMIN and NET_RAW
+capabilities to redirect traffic, the ClusterRole that uses them, and a
+RoleBinding to the service accounts of every --namespaces project.
+Inject with --openShift so that the proxy runs as a UID of the range
+of the project.
+`,
+		Example: `
+wharfie generate-openshift-scc --namespaces web,api | oc apply -f -
+`,
+		RunE: func(_ *cobra.Command, _ []string) (err error) {
+			var writer io.Writer = os.Stdout
+			if outFilename != "" {
+				var file *os.File
+				if file, err = os.Create(outFilename); err != nil {
+					return err
+				}
+				writer = file
+				defer func() {
+					if cerr := file.Close(); err == nil {
+						err = cerr
+					}
+				}()
+			}
+			return inject.WriteOpenShiftSCC(sccName, sccNamespaces, writer)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(generateOpenShiftSCCCmd)
+	flags := generateOpenShiftSCCCmd.Flags()
+	flags.StringVarP(&outFilename, "output", "o", "", "Output filename, standard output if empty")
+	flags.StringVar(&sccName, "name", "wharfie-proxy", "Name of the SecurityContextConstraints and its ClusterRole and RoleBindings")
+	flags.StringSliceVar(&sccNamespaces, "namespaces", nil, "Projects whose service accounts use the SecurityContextConstraints")
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	reportFilename string
+	reportFormat   string
+)
+
+// writeReport prints the warnings of the injection report to standard
+// error, e.g. that YAML anchors were expanded, and renders the report
+// into the file selected on the command line, if any.
+func writeReport(report *inject.Report) (err error) {
+	if err = printWarnings(report, os.Stderr); err != nil {
+		return err
+	}
+	if reportFilename == "" {
+		return nil
+	}
+	var render func(io.Writer) error
+	switch reportFormat {
+	case "markdown":
+		render = report.WriteMarkdown
+	case "html":
+		render = report.WriteHTML
+	case "json":
+		render = report.WriteJSON
+	default:
+		return fmt.Errorf("unknown report format %q", reportFormat)
+	}
+
+	file, err := os.Create(reportFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return render(file)
+}
+
+// printWarnings prints the warnings of every resource of the report.
+func printWarnings(report *inject.Report, out io.Writer) error {
+	for _, r := range report.Resources {
+		name := r.Name
+		if r.Namespace != "" {
+			name = r.Namespace + "/" + r.Name
+		}
+		for _, warning := range r.Warnings {
+			if _, err := fmt.Fprintf(out, "Warning: %s %s: %s\n", r.Kind, name, warning); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	injectCmd.Flags().StringVar(&reportFilename, "report", "", "Write a summary of the injected changes to this file")
+	injectCmd.Flags().StringVar(&reportFormat, "reportFormat", "markdown", "Format of the injection report: markdown, html, or json")
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/events"
+	"istio.io/pilot/platform/kube/retrofit"
+)
+
+var (
+	retrofitNamespaceSelector string
+	retrofitResyncPeriod      time.Duration
+	retrofitMetricsPort       int
+	retrofitLock              leaderElection
+	retrofitHook              failureWebhook
+
+	retrofitCmd = &cobra.Command{
+		Use:   "retrofit",
+		Short: "Inject the proxy into the existing workloads of labeled namespaces",
+		Long: `
+Runs a controller that watches the Deployments, StatefulSets, DaemonSets,
+and Jobs of the namespaces matching --namespaceSelector, and patches
+those whose pod template lacks the sidecar annotation with the injected
+proxy, which rolls out their pods. Only the fields changed by injection
+are patched, so that fields unknown to wharfie are kept. Workloads
+annotated to be ignored are left as is. Jobs cannot be updated and are
+only reported to be re-created.
+Every injected or skipped workload gets a SidecarInjected or
+SidecarInjectionSkipped Event, see kubectl describe.
+
+With --leaderElect, replicas elect a leader through a lease recorded on
+a lock ConfigMap and only the leader updates workloads, so that replicas
+do not race to update the same workload, while the others stand by.
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			selector, err := retrofit.ParseSelector(retrofitNamespaceSelector)
+			if err != nil {
+				return err
+			}
+			client, err := clusterConfig.CreateInterface()
+			if err != nil {
+				return err
+			}
+			notifier, err := retrofitHook.notifier()
+			if err != nil {
+				return err
+			}
+			recorder := events.NewRecorder(client, "wharfie-retrofit")
+			controller := retrofit.NewController(client, params, selector, retrofitResyncPeriod, recorder, notifier)
+			serveMetrics(retrofitMetricsPort)
+			stop := make(chan struct{})
+			watchMeshConfig(stop)
+			go waitSignal(stop)
+			return retrofitLock.run(client, stop, controller.Run)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(retrofitCmd)
+	retrofitCmd.Flags().StringVar(&retrofitNamespaceSelector, "namespaceSelector", retrofit.DefaultNamespaceSelector,
+		"Label selector of the namespaces whose workloads are injected")
+	retrofitCmd.Flags().DurationVar(&retrofitResyncPeriod, "resync", time.Minute,
+		"Period to resync workloads")
+	retrofitCmd.Flags().IntVar(&retrofitMetricsPort, "metricsPort", 0,
+		"Port to serve Prometheus metrics on at /metrics, disabled if 0")
+	retrofitHook.addFlags(retrofitCmd, "every workload that cannot be injected or patched")
+	retrofitLock.addFlags(retrofitCmd, "wharfie-retrofit")
+}
@@ -0,0 +1,309 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/platform/kube/events"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/preview"
+	"istio.io/pilot/platform/kube/webhook"
+)
+
+var (
+	serverPort     int
+	tlsCertFile    string
+	tlsKeyFile     string
+	validationMode string
+	tlsCSRService  string
+	tlsSecret      string
+	recordEvents   bool
+	serverHook     failureWebhook
+	failurePolicy  string
+
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownDelay   time.Duration
+	shutdownTimeout time.Duration
+
+	serverCmd = &cobra.Command{
+		Use:   "server",
+		Short: "Serve injection over HTTP",
+		Long: `
+Runs an HTTP server for injection. The server exposes a preview page at
+/preview where a manifest can be pasted to see the injected result, the
+diff, and the effective injection configuration. Nothing is sent to the
+cluster. It also serves a mutating admission webhook at /inject that
+injects the proxy into the pods selected by its
+MutatingWebhookConfiguration, see generate-webhook-config. With
+--recordEvents, it records a SidecarInjected or SidecarInjectionSkipped
+Event on the controller of every admitted pod, e.g. its ReplicaSet.
+Pods that cannot be injected, e.g. because of an invalid annotation,
+are admitted without the proxy with a warning, or rejected with
+--failurePolicy Fail, which must match the failure policy of the
+MutatingWebhookConfiguration.
+
+With --validation, the server also serves a validating admission
+webhook at /validate that rejects, or warns about, workloads created
+without the proxy in the namespaces selected by its
+ValidatingWebhookConfiguration, by default those labeled
+wharfie.io/require-injection=true. The API server requires webhooks to be
+served over TLS, see --tlsCert and --tlsKey. Alternatively, with
+--tlsCSRService the server requests its certificate from the cluster
+through a CertificateSigningRequest, which must be approved, e.g. with
+kubectl certificate approve, and renews it before it expires. With
+--tlsSecret the server serves the certificate of a kubernetes.io/tls
+Secret, e.g. one issued by cert-manager, and reloads it whenever the
+Secret is renewed.
+
+The server reports its liveness at /healthz and its readiness at
+/readyz, and serves Prometheus metrics of its injections at /metrics.
+On SIGTERM it stops being ready, keeps serving for --shutdownDelay
+while the Service stops routing reviews to it, and then drains open
+connections for up to --shutdownTimeout, so that rolling updates of
+the injector do not fail pod admissions.
+`,
+		Example: `
+wharfie server --port 8080 --profile staging
+wharfie server --port 443 --tlsCert cert.pem --tlsKey key.pem --validation reject
+wharfie server --port 443 --tlsCSRService wharfie --namespace istio-system --validation warn
+wharfie server --port 443 --tlsSecret wharfie-tls --namespace istio-system --validation reject
+`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			params, err := getParams()
+			if err != nil {
+				return err
+			}
+			if failurePolicy != webhook.FailurePolicyIgnore && failurePolicy != webhook.FailurePolicyFail {
+				return fmt.Errorf("unknown failure policy %q, expected %s or %s",
+					failurePolicy, webhook.FailurePolicyIgnore, webhook.FailurePolicyFail)
+			}
+			health := &webhook.Health{}
+			mux := http.NewServeMux()
+			mux.Handle(webhook.DefaultHealthPath, health.LivenessHandler())
+			mux.Handle(webhook.DefaultReadyPath, health.ReadinessHandler())
+			mux.Handle(metrics.DefaultPath, metrics.Handler())
+			mux.Handle(preview.DefaultPath, preview.NewHandler(params))
+			var client kubernetes.Interface
+			lookupNamespaces := params.Namespaces != nil && params.Namespaces.Selector != ""
+			if recordEvents || lookupNamespaces {
+				if client, err = clusterConfig.CreateInterface(); err != nil {
+					return err
+				}
+			}
+			var recorder record.EventRecorder
+			if recordEvents {
+				recorder = events.NewRecorder(client, "wharfie-injector")
+			}
+			if lookupNamespaces {
+				params.Namespaces.Lookup = namespaceLabels(client)
+			}
+			notifier, err := serverHook.notifier()
+			if err != nil {
+				return err
+			}
+			mux.Handle(webhook.DefaultPath, webhook.NewInjector(params, recorder, notifier, failurePolicy))
+			if validationMode != "" {
+				var validator http.Handler
+				if validator, err = webhook.NewValidator(validationMode); err != nil {
+					return err
+				}
+				mux.Handle(webhook.DefaultValidatePath, validator)
+			}
+			server := &http.Server{
+				Addr:         fmt.Sprintf(":%d", serverPort),
+				Handler:      mux,
+				ReadTimeout:  readTimeout,
+				WriteTimeout: writeTimeout,
+				IdleTimeout:  idleTimeout,
+			}
+
+			stop := make(chan struct{})
+			drained := make(chan struct{})
+			go func() {
+				<-stop
+				shutdown(server, health)
+				close(drained)
+			}()
+			if tlsCSRService != "" || tlsSecret != "" {
+				if tlsCertFile != "" || tlsKeyFile != "" || (tlsCSRService != "" && tlsSecret != "") {
+					return errors.New("only one of --tlsCert and --tlsKey, --tlsCSRService, or --tlsSecret can provide the serving certificate")
+				}
+				if tlsSecret != "" {
+					err = secretCertificate(server, stop)
+				} else {
+					err = bootstrapCertificate(server, stop)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			watchMeshConfig(stop)
+			go waitSignal(stop)
+			glog.Infof("Serving injection on %s", server.Addr)
+			health.SetReady(true)
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else if tlsCertFile != "" || tlsKeyFile != "" {
+				err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				return err
+			}
+			<-drained
+			return nil
+		},
+	}
+)
+
+// namespaceLabels reads the labels of namespaces for the namespace
+// policy, as admission requests only carry the name of the namespace.
+func namespaceLabels(client kubernetes.Interface) func(string) (map[string]string, error) {
+	return func(namespace string) (map[string]string, error) {
+		ns, err := client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ns.Labels, nil
+	}
+}
+
+// namespaceAnnotations reads the annotations of namespaces, e.g. the UID
+// ranges of OpenShift projects.
+func namespaceAnnotations(client kubernetes.Interface) func(string) (map[string]string, error) {
+	return func(namespace string) (map[string]string, error) {
+		ns, err := client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return ns.Annotations, nil
+	}
+}
+
+// shutdown stops the readiness of the server, waits for --shutdownDelay
+// while the endpoints of the server are removed, and then shuts the
+// server down once its open connections are drained, or closes them
+// after --shutdownTimeout.
+func shutdown(server *http.Server, health *webhook.Health) {
+	health.SetReady(false)
+	glog.Infof("Shutting down in %v", shutdownDelay)
+	time.Sleep(shutdownDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		glog.Warningf("Closing connections that did not drain: %v", err)
+		_ = server.Close()
+	}
+}
+
+// bootstrapCertificate requests the serving certificate of the server
+// through the CertificateSigningRequest API and rotates it until stop
+// is closed.
+func bootstrapCertificate(server *http.Server, stop <-chan struct{}) error {
+	client, err := clusterConfig.CreateInterface()
+	if err != nil {
+		return err
+	}
+	namespace, err := clusterConfig.DefaultNamespace()
+	if err != nil {
+		return err
+	}
+	// The host name is the pod name, which tells the requests of the
+	// replicas apart.
+	pod, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	config := webhook.CSRConfig{
+		Client:    client,
+		Name:      tlsCSRService + "." + namespace + "." + pod,
+		Service:   tlsCSRService,
+		Namespace: namespace,
+	}
+	cert, err := webhook.RequestCertificate(config)
+	if err != nil {
+		return err
+	}
+	keypair := &webhook.Keypair{}
+	keypair.Set(cert)
+	go webhook.RotateCertificate(config, keypair, stop)
+	server.TLSConfig = &tls.Config{GetCertificate: keypair.GetCertificate}
+	return nil
+}
+
+// secretCertificate serves the certificate of the --tlsSecret Secret
+// and reloads it on every change until stop is closed.
+func secretCertificate(server *http.Server, stop <-chan struct{}) error {
+	client, err := clusterConfig.CreateInterface()
+	if err != nil {
+		return err
+	}
+	namespace, err := clusterConfig.DefaultNamespace()
+	if err != nil {
+		return err
+	}
+	cert, err := webhook.LoadSecret(client, namespace, tlsSecret)
+	if err != nil {
+		return err
+	}
+	keypair := &webhook.Keypair{}
+	keypair.Set(cert)
+	go webhook.WatchSecret(client, namespace, tlsSecret, keypair, stop)
+	server.TLSConfig = &tls.Config{GetCertificate: keypair.GetCertificate}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+	serverCmd.Flags().IntVar(&serverPort, "port", 8080, "Port to serve on")
+	serverCmd.Flags().StringVar(&tlsCertFile, "tlsCert", "", "PEM encoded certificate to serve TLS with")
+	serverCmd.Flags().StringVar(&tlsKeyFile, "tlsKey", "", "PEM encoded private key of the TLS certificate")
+	serverCmd.Flags().StringVar(&tlsCSRService, "tlsCSRService", "",
+		"Request the serving certificate for this webhook service in --namespace through a CertificateSigningRequest, and renew it before it expires")
+	serverCmd.Flags().StringVar(&tlsSecret, "tlsSecret", "",
+		"Serve the certificate of this kubernetes.io/tls Secret in --namespace, e.g. issued by cert-manager, reloading it when it is renewed")
+	serverCmd.Flags().BoolVar(&recordEvents, "recordEvents", false,
+		"Record an Event on the controller of every pod injected or skipped by the webhook")
+	serverHook.addFlags(serverCmd, "every pod admitted by the webhook without injection because of an error")
+	serverCmd.Flags().StringVar(&failurePolicy, "failurePolicy", webhook.FailurePolicyIgnore,
+		"Failure policy of the webhook configuration: pods that cannot be injected are admitted without the proxy "+
+			"with a warning under "+webhook.FailurePolicyIgnore+", and rejected under "+webhook.FailurePolicyFail)
+	serverCmd.Flags().StringVar(&validationMode, "validation", "",
+		"Serve a validating webhook for workloads created without the proxy: "+
+			webhook.ValidationReject+" or "+webhook.ValidationWarn+" (disabled if empty)")
+	serverCmd.Flags().DurationVar(&readTimeout, "readTimeout", 10*time.Second, "Timeout to read a request, unbounded if 0")
+	serverCmd.Flags().DurationVar(&writeTimeout, "writeTimeout", 10*time.Second, "Timeout to write a response, unbounded if 0")
+	serverCmd.Flags().DurationVar(&idleTimeout, "idleTimeout", 2*time.Minute, "Timeout of idle keep-alive connections")
+	serverCmd.Flags().DurationVar(&shutdownDelay, "shutdownDelay", 5*time.Second,
+		"Time to keep serving after SIGTERM while not ready, for the Service to stop routing to the server")
+	serverCmd.Flags().DurationVar(&shutdownTimeout, "shutdownTimeout", 20*time.Second,
+		"Timeout to drain open connections on shutdown before closing them")
+}
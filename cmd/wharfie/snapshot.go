@@ -0,0 +1,122 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var (
+	snapshotDir string
+	concurrency int
+
+	snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Record and verify golden snapshots of injected resources",
+		Long: `
+Snapshots capture the injected output for a set of input files or
+directories. Record them once, check them in, and verify them after
+every upgrade to catch changes in injection behavior.
+`,
+	}
+
+	snapshotRecordCmd = &cobra.Command{
+		Use:   "record <file or directory>...",
+		Short: "Record the injected output of the inputs into the snapshot directory",
+		RunE: func(_ *cobra.Command, args []string) error {
+			inputs, params, err := snapshotArgs(args)
+			if err != nil {
+				return err
+			}
+			return inject.RecordSnapshots(params, inputs, snapshotDir, concurrency)
+		},
+	}
+
+	snapshotVerifyCmd = &cobra.Command{
+		Use:   "verify <file or directory>...",
+		Short: "Verify the injected output of the inputs matches the snapshot directory",
+		RunE: func(_ *cobra.Command, args []string) error {
+			inputs, params, err := snapshotArgs(args)
+			if err != nil {
+				return err
+			}
+			return inject.VerifySnapshots(params, inputs, snapshotDir, concurrency)
+		},
+	}
+)
+
+func snapshotArgs(args []string) ([]string, *inject.Params, error) {
+	if len(args) == 0 {
+		return nil, nil, errors.New("no input files or directories specified")
+	}
+	inputs, err := expandInputs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := getParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	return inputs, params, nil
+}
+
+// expandInputs replaces every directory in args with the YAML files
+// it contains.
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+		files, err := ioutil.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, file := range files {
+			switch filepath.Ext(file.Name()) {
+			case ".yaml", ".yml":
+				if !file.IsDir() {
+					names = append(names, filepath.Join(arg, file.Name()))
+				}
+			}
+		}
+		sort.Strings(names)
+		inputs = append(inputs, names...)
+	}
+	return inputs, nil
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotRecordCmd, snapshotVerifyCmd)
+	snapshotCmd.PersistentFlags().StringVar(&snapshotDir, "dir", "snapshots", "Directory holding the recorded snapshots")
+	snapshotCmd.PersistentFlags().IntVar(&concurrency, "concurrency", runtime.NumCPU(),
+		"Number of input files processed at once")
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+var uninjectCmd = &cobra.Command{
+	Use:   "uninject",
+	Short: "Remove the injected istio sidecar proxy from kubernetes resources",
+	Example: `
+# Inspect a workload as it was before injection.
+wharfie uninject -f deployment-with-istio.yaml
+
+# Move workloads out of the mesh.
+kubectl get deployment -o yaml | wharfie uninject -f - | kubectl apply -f -
+`,
+	RunE: func(_ *cobra.Command, _ []string) (err error) {
+		if inFilename == "" {
+			return errors.New("filename not specified (see --filename or -f)")
+		}
+		var reader io.Reader
+		if inFilename == "-" {
+			reader = os.Stdin
+		} else {
+			var in *os.File
+			if in, err = os.Open(inFilename); err != nil {
+				return err
+			}
+			defer func() { _ = in.Close() }()
+			reader = in
+		}
+
+		var writer io.Writer
+		if outFilename == "" {
+			writer = os.Stdout
+		} else {
+			var file *os.File
+			if file, err = os.Create(outFilename); err != nil {
+				return err
+			}
+			writer = file
+			defer func() {
+				if cerr := file.Close(); err == nil {
+					err = cerr
+				}
+			}()
+		}
+		return inject.FromResourceFile(reader, writer)
+	},
+}
+
+func init() {
+	uninjectCmd.Flags().StringVarP(&inFilename, "filename", "f", "", "Input kubernetes resource filename")
+	uninjectCmd.Flags().StringVarP(&outFilename, "output", "o", "", "Modified output kubernetes resource filename")
+	rootCmd.AddCommand(uninjectCmd)
+}
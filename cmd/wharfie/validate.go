@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"istio.io/pilot/platform/kube/validate"
+)
+
+var (
+	serverDryRun      bool
+	schemaFilename    string
+	verifyCertSecrets bool
+)
+
+// validateOutput runs the validations selected on the command line
+// against the injected output.
+func validateOutput(out []byte) error {
+	if schemaFilename != "" {
+		file, err := os.Open(schemaFilename)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		schema, err := validate.LoadSchema(file)
+		if err != nil {
+			return err
+		}
+		if err = schema.Validate(bytes.NewReader(out)); err != nil {
+			return err
+		}
+	}
+	if !serverDryRun && !verifyCertSecrets {
+		return nil
+	}
+	client, err := clusterConfig.CreateInterface()
+	if err != nil {
+		return err
+	}
+	namespace, err := clusterConfig.DefaultNamespace()
+	if err != nil {
+		return err
+	}
+	if serverDryRun {
+		if err = validate.DryRun(client, namespace, bytes.NewReader(out)); err != nil {
+			return err
+		}
+	}
+	if verifyCertSecrets {
+		warnings, err := validate.CertSecrets(client, namespace, bytes.NewReader(out))
+		if err != nil {
+			return err
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	return nil
+}
+
+func init() {
+	injectCmd.Flags().BoolVar(&serverDryRun, "serverDryRun", false,
+		"Submit the injected resources to the cluster as a server-side dry-run and fail if any would be rejected")
+	injectCmd.Flags().StringVar(&schemaFilename, "schema", "",
+		"Validate the injected resources offline against this OpenAPI schema (e.g. the cluster's /swagger.json)")
+	injectCmd.Flags().BoolVar(&verifyCertSecrets, "verifyCertSecrets", false,
+		"Warn about injected workloads whose mutual TLS certificate secret does not exist in the cluster and will not be created")
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/platform/kube/webhook"
+)
+
+// defaultWebhookNamespace is the namespace of the injector unless
+// --namespace is set.
+const defaultWebhookNamespace = "istio-system"
+
+var (
+	webhookOptions = webhook.DefaultInstallOptions("", "")
+
+	webhookNamespaceSelector string
+	webhookObjectSelector    string
+	webhookCABundle          string
+
+	generateWebhookConfigCmd = &cobra.Command{
+		Use:   "generate-webhook-config",
+		Short: "Generate the manifests that run the injection webhook in the cluster",
+		Long: `
+Writes the ServiceAccount, Role, RoleBinding, Service, Deployment, and
+MutatingWebhookConfiguration that run "wharfie server" as a mutating
+admission webhook in --namespace (istio-system by default). The server
+injects with the injection flags of this command, e.g. --hub, --tag, and
+--profile, and serves the certificate of the --tlsSecret Secret, e.g.
+issued by cert-manager, whose CA is passed with --caBundle. Files such
+as --injectConfig cannot be read by the server, set their values with
+flags instead.
+`,
+		Example: `
+wharfie generate-webhook-config --namespace istio-system --caBundle ca.pem | kubectl apply -f -
+
+# Fail closed, only for namespaces that opt in and pods that are not
+# explicitly excluded.
+wharfie generate-webhook-config --failurePolicy Fail --objectSelector 'mesh notin (off)' -o injector.yaml
+`,
+		RunE: func(_ *cobra.Command, _ []string) (err error) {
+			if _, err = getParams(); err != nil {
+				return err
+			}
+			o := webhookOptions
+			o.Config.ServiceNamespace = clusterConfig.Namespace
+			if o.Config.ServiceNamespace == "" {
+				o.Config.ServiceNamespace = defaultWebhookNamespace
+			}
+			if o.Image == "" {
+				o.Image = hub + "/wharfie:" + tag
+			}
+			if o.Args, err = serverArgs(); err != nil {
+				return err
+			}
+			if o.Config.NamespaceSelector, err = labelSelector(webhookNamespaceSelector); err != nil {
+				return err
+			}
+			if o.Config.ObjectSelector, err = labelSelector(webhookObjectSelector); err != nil {
+				return err
+			}
+			if webhookCABundle != "" {
+				if o.Config.CABundle, err = ioutil.ReadFile(webhookCABundle); err != nil {
+					return err
+				}
+			}
+
+			var writer io.Writer = os.Stdout
+			if outFilename != "" {
+				var file *os.File
+				if file, err = os.Create(outFilename); err != nil {
+					return err
+				}
+				writer = file
+				defer func() {
+					if cerr := file.Close(); err == nil {
+						err = cerr
+					}
+				}()
+			}
+			return webhook.WriteInstall(o, writer)
+		},
+	}
+)
+
+// clusterFlags are the root flags of the connection to the cluster,
+// which the server makes with its own service account, or that select
+// it, e.g. with the fleet.
+var clusterFlags = map[string]bool{
+	"kubeconfig":     true,
+	"context":        true,
+	"namespace":      true,
+	"as":             true,
+	"as-group":       true,
+	"requestTimeout": true,
+	"requestRetries": true,
+	"fleet":          true,
+	"cluster":        true,
+}
+
+// fileFlags are the root flags that name local files, which the server
+// cannot read.
+var fileFlags = map[string]bool{
+	"injectConfig":    true,
+	"namespacePolicy": true,
+}
+
+// serverArgs returns the arguments that make the server inject as this
+// command does: --hub, --tag, and every other root flag whose value is
+// not the default, e.g. set by the fleet, except those of the cluster.
+func serverArgs() ([]string, error) {
+	args := []string{"--hub", hub, "--tag", tag}
+	var err error
+	flags := rootCmd.PersistentFlags()
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || clusterFlags[f.Name] || f.Name == "hub" || f.Name == "tag" ||
+			f.Value.String() == f.DefValue {
+			return
+		}
+		if fileFlags[f.Name] {
+			err = fmt.Errorf("--%s cannot be read by the injector, set its values with flags instead", f.Name)
+			return
+		}
+		var values []string
+		switch f.Value.Type() {
+		case "stringSlice":
+			values, err = flags.GetStringSlice(f.Name)
+		case "stringArray":
+			values, err = flags.GetStringArray(f.Name)
+		default:
+			values = []string{f.Value.String()}
+		}
+		for _, value := range values {
+			args = append(args, "--"+f.Name+"="+value)
+		}
+	})
+	return args, err
+}
+
+// labelSelector parses a label selector, which selects everything if
+// empty.
+func labelSelector(selector string) (*metav1.LabelSelector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	return metav1.ParseToLabelSelector(selector)
+}
+
+func init() {
+	rootCmd.AddCommand(generateWebhookConfigCmd)
+	flags := generateWebhookConfigCmd.Flags()
+	flags.StringVarP(&outFilename, "output", "o", "", "Output filename, standard output if empty")
+	flags.StringVar(&webhookOptions.Config.Name, "webhookName", webhook.DefaultName,
+		"Name of the MutatingWebhookConfiguration and its webhook")
+	flags.StringVar(&webhookOptions.Config.ServiceName, "serviceName", webhook.DefaultServiceName,
+		"Name of the Service, Deployment, ServiceAccount, and RBAC of the injector")
+	flags.StringVar(&webhookOptions.Config.FailurePolicy, "failurePolicy", webhook.FailurePolicyIgnore,
+		"Failure policy of the webhook and of the injector: "+webhook.FailurePolicyIgnore+
+			" admits pods uninjected while the injector is unavailable or cannot inject them, "+
+			webhook.FailurePolicyFail+" rejects them")
+	flags.StringVar(&webhookOptions.Config.ReinvocationPolicy, "reinvocationPolicy", webhook.ReinvocationPolicyNever,
+		"Reinvocation policy of the webhook: "+webhook.ReinvocationPolicyNever+" or "+webhook.ReinvocationPolicyIfNeeded)
+	flags.Int32Var(&webhookOptions.Config.TimeoutSeconds, "timeoutSeconds", webhook.DefaultTimeoutSeconds,
+		"Timeout of every call to the webhook, between 1 and 30 seconds")
+	flags.StringVar(&webhookNamespaceSelector, "namespaceSelector", "istio-injection=enabled",
+		"Label selector of the namespaces whose pods are injected, all namespaces if empty")
+	flags.StringVar(&webhookObjectSelector, "objectSelector", "",
+		"Label selector of the pods that are injected, all pods if empty")
+	flags.StringVar(&webhookCABundle, "caBundle", "", "PEM encoded CA that signed the serving certificate of the injector")
+	flags.StringVar(&webhookOptions.Image, "image", "", "Image of the injector (defaults to <hub>/wharfie:<tag>)")
+	flags.Int32Var(&webhookOptions.Replicas, "replicas", webhook.DefaultReplicas, "Replicas of the injector")
+	flags.StringVar(&webhookOptions.TLSSecret, "tlsSecret", webhook.DefaultTLSSecret,
+		"kubernetes.io/tls Secret with the serving certificate of the injector")
+}
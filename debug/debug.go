@@ -0,0 +1,162 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package debug attaches a proxy to running pods as an ephemeral
+// container, to diagnose traffic of workloads that were not injected
+// when they were deployed.
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// ContainerName is the name of the ephemeral proxy container. A suffix
+// is added when a pod already has an ephemeral container of that name.
+const ContainerName = "proxy-debug"
+
+// ephemeralPod is the subset of a pod needed to name a new ephemeral
+// container, which the pod types of the client do not model.
+type ephemeralPod struct {
+	Spec struct {
+		EphemeralContainers []struct {
+			Name string `json:"name"`
+		} `json:"ephemeralContainers"`
+	} `json:"spec"`
+}
+
+// EphemeralContainer returns the proxy container built by the flavor
+// of the parameters for the pod, in the form of an ephemeral container.
+// Ephemeral containers cannot add volumes, ports, probes or resources
+// to a pod, so mounts of volumes that the pod does not already have
+// are dropped. The init container is not run either: the proxy does
+// not intercept the traffic of the pod but can observe it and reach
+// its endpoints. If target is set, the proxy shares the process
+// namespace of that container.
+func EphemeralContainer(p *inject.Params, pod *v1.Pod, name, target string) (map[string]interface{}, error) {
+	flavor := p.Flavor
+	if flavor == nil {
+		flavor = inject.IstioProxy{}
+	}
+	t := &v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+	container, _, err := flavor.Container(p, pod.Namespace, t)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	var mounts []v1.VolumeMount
+	for _, mount := range container.VolumeMounts {
+		if volumes[mount.Name] {
+			mounts = append(mounts, mount)
+		}
+	}
+	container.Name = name
+	container.VolumeMounts = mounts
+	container.Ports = nil
+	container.Resources = v1.ResourceRequirements{}
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.Lifecycle = nil
+
+	// Round trip through JSON to add the fields only ephemeral
+	// containers have.
+	data, err := json.Marshal(container)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	if target != "" {
+		found := false
+		for _, c := range pod.Spec.Containers {
+			found = found || c.Name == target
+		}
+		if !found {
+			return nil, fmt.Errorf("pod %s has no container %s", pod.Name, target)
+		}
+		out["targetContainerName"] = target
+	}
+	return out, nil
+}
+
+// Attach adds the proxy as an ephemeral container to the pod through
+// the ephemeralcontainers subresource and returns the name of the
+// added container.
+func Attach(client kubernetes.Interface, p *inject.Params, namespace, name, target string) (string, error) {
+	raw, err := client.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("pods").
+		Name(name).
+		Do().
+		Raw()
+	if err != nil {
+		return "", fmt.Errorf("cannot get pod %s in namespace %s: %v", name, namespace, err)
+	}
+	var pod v1.Pod
+	if err = json.Unmarshal(raw, &pod); err != nil {
+		return "", err
+	}
+	var existing ephemeralPod
+	if err = json.Unmarshal(raw, &existing); err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	for _, c := range pod.Spec.Containers {
+		used[c.Name] = true
+	}
+	for _, c := range existing.Spec.EphemeralContainers {
+		used[c.Name] = true
+	}
+	containerName := ContainerName
+	for i := 1; used[containerName]; i++ {
+		containerName = fmt.Sprintf("%s-%d", ContainerName, i)
+	}
+
+	container, err := EphemeralContainer(p, &pod, containerName, target)
+	if err != nil {
+		return "", err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []interface{}{container},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	err = client.CoreV1().RESTClient().Patch(types.StrategicMergePatchType).
+		Namespace(namespace).
+		Resource("pods").
+		Name(name).
+		SubResource("ephemeralcontainers").
+		Body(patch).
+		Do().
+		Error()
+	if err != nil {
+		return "", fmt.Errorf("cannot add ephemeral container to pod %s in namespace %s: %v", name, namespace, err)
+	}
+	return containerName, nil
+}
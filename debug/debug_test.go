@@ -0,0 +1,129 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/proxy"
+)
+
+const runningPod = `{
+  "apiVersion": "v1",
+  "kind": "Pod",
+  "metadata": {"name": "hello", "namespace": "web"},
+  "spec": {
+    "containers": [{"name": "hello", "image": "hello"}],
+    "ephemeralContainers": [{"name": "proxy-debug", "image": "proxy"}],
+    "volumes": [{"name": "istio-certs", "secret": {"secretName": "istio.default"}}]
+  }
+}`
+
+func newParams(authPolicy proxyconfig.ProxyMeshConfig_AuthPolicy) *inject.Params {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = authPolicy
+	return &inject.Params{
+		ProxyImage:      inject.ProxyImageName("docker.io/istio", "unittest"),
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Mesh:            &mesh,
+	}
+}
+
+func TestEphemeralContainer(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "web"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:           "hello",
+				ReadinessProbe: &v1.Probe{},
+			}},
+		},
+	}
+	got, err := EphemeralContainer(newParams(proxyconfig.ProxyMeshConfig_MUTUAL_TLS), pod, ContainerName, "hello")
+	if err != nil {
+		t.Fatalf("EphemeralContainer() returned an error: %v", err)
+	}
+	if got["name"] != ContainerName || got["targetContainerName"] != "hello" {
+		t.Errorf("EphemeralContainer() => got name %v and target %v", got["name"], got["targetContainerName"])
+	}
+	// The pod has no certificate volume and ephemeral containers cannot
+	// add one.
+	if mounts, ok := got["volumeMounts"]; ok {
+		t.Errorf("EphemeralContainer() => got volume mounts %v, want none", mounts)
+	}
+
+	if _, err = EphemeralContainer(newParams(proxyconfig.ProxyMeshConfig_NONE), pod, ContainerName, "missing"); err == nil {
+		t.Error("EphemeralContainer() succeeded for a missing target container")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	var patch map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/web/pods/hello":
+			_, _ = w.Write([]byte(runningPod))
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/namespaces/web/pods/hello/ephemeralcontainers":
+			if got := r.Header.Get("Content-Type"); got != "application/strategic-merge-patch+json" {
+				t.Errorf("PATCH with content type %q", got)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &patch); err != nil {
+				t.Errorf("PATCH with invalid body: %v", err)
+			}
+			_, _ = w.Write([]byte(runningPod))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := Attach(client, newParams(proxyconfig.ProxyMeshConfig_MUTUAL_TLS), "web", "hello", "")
+	if err != nil {
+		t.Fatalf("Attach() returned an error: %v", err)
+	}
+	if name != ContainerName+"-1" {
+		t.Errorf("Attach() => got container %q, want %q", name, ContainerName+"-1")
+	}
+	containers := patch["spec"].(map[string]interface{})["ephemeralContainers"].([]interface{})
+	container := containers[0].(map[string]interface{})
+	if container["name"] != name {
+		t.Errorf("Attach() patched container %v, want %q", container["name"], name)
+	}
+	mounts, _ := container["volumeMounts"].([]interface{})
+	if len(mounts) != 1 || mounts[0].(map[string]interface{})["name"] != inject.CertVolumeName {
+		t.Errorf("Attach() patched volume mounts %v, want the existing certificate volume", mounts)
+	}
+
+	if _, err = Attach(client, newParams(proxyconfig.ProxyMeshConfig_NONE), "web", "missing", ""); err == nil {
+		t.Error("Attach() succeeded for a missing pod")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events records kubernetes Events on the workloads that the
+// webhook and the controllers inject or skip, so that users can see
+// why a workload did or did not get the proxy with kubectl describe.
+package events
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the recorded events.
+const (
+	ReasonInjected = "SidecarInjected"
+	ReasonSkipped  = "SidecarInjectionSkipped"
+	ReasonFailed   = "SidecarInjectionFailed"
+)
+
+// NewRecorder returns a recorder that sends events to the cluster on
+// behalf of the component.
+func NewRecorder(client kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
+
+// SkipMessage explains why a workload with a sidecar annotation status
+// is skipped.
+func SkipMessage(status string) string {
+	if status == "injected" {
+		return "the istio proxy is already injected"
+	}
+	return fmt.Sprintf("annotation opt-out: alpha.istio.io/sidecar is %q", status)
+}
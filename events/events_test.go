@@ -0,0 +1,28 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import "testing"
+
+func TestSkipMessage(t *testing.T) {
+	for status, want := range map[string]string{
+		"injected": "the istio proxy is already injected",
+		"ignore":   `annotation opt-out: alpha.istio.io/sidecar is "ignore"`,
+	} {
+		if got := SkipMessage(status); got != want {
+			t.Errorf("SkipMessage(%q) => got %q, want %q", status, got, want)
+		}
+	}
+}
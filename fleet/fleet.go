@@ -0,0 +1,111 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fleet describes the clusters of a fleet and the settings to
+// inject workloads for each of them, so that one configuration drives
+// injection consistently across clusters.
+package fleet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// Cluster holds the settings of one cluster. Empty fields keep the
+// defaults of the command line.
+type Cluster struct {
+	// Kubeconfig and Context select the cluster.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	// Hub is the docker hub of the proxy images, e.g. a registry mirror
+	// reachable from the cluster, and Tag their tag.
+	Hub string `json:"hub,omitempty"`
+	Tag string `json:"tag,omitempty"`
+	// Profile is the name of a built-in injection profile.
+	Profile string `json:"profile,omitempty"`
+	// MeshConfig names the ConfigMap in the cluster that holds the mesh
+	// configuration, in MeshConfigNamespace.
+	MeshConfig          string `json:"meshConfig,omitempty"`
+	MeshConfigNamespace string `json:"meshConfigNamespace,omitempty"`
+	TrustDomain         string `json:"trustDomain,omitempty"`
+	// Network of the cluster in a mesh that spans several networks.
+	Network string `json:"network,omitempty"`
+}
+
+// Fleet is a set of named clusters.
+type Fleet struct {
+	Clusters map[string]Cluster `json:"clusters"`
+}
+
+// Load reads and validates a fleet in YAML.
+func Load(in io.Reader) (*Fleet, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var f Fleet
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if err = f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Validate checks that the fleet has clusters with known profiles.
+func (f *Fleet) Validate() error {
+	if len(f.Clusters) == 0 {
+		return errors.New("fleet has no clusters")
+	}
+	var errs error
+	for _, name := range f.Names() {
+		cluster := f.Clusters[name]
+		if _, ok := inject.Profiles[cluster.Profile]; cluster.Profile != "" && !ok {
+			errs = multierror.Append(errs, fmt.Errorf("cluster %s has unknown profile %q", name, cluster.Profile))
+		}
+		if cluster.MeshConfigNamespace != "" && cluster.MeshConfig == "" {
+			errs = multierror.Append(errs, fmt.Errorf("cluster %s sets meshConfigNamespace without meshConfig", name))
+		}
+	}
+	return errs
+}
+
+// Names returns the names of the clusters in order.
+func (f *Fleet) Names() []string {
+	names := make([]string, 0, len(f.Clusters))
+	for name := range f.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Cluster returns the cluster with the given name.
+func (f *Fleet) Cluster(name string) (Cluster, error) {
+	cluster, ok := f.Clusters[name]
+	if !ok {
+		return Cluster{}, fmt.Errorf("unknown cluster %q, expected one of %s", name, strings.Join(f.Names(), ", "))
+	}
+	return cluster, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fleet
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	in, err := os.Open("testdata/fleet.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = in.Close() }()
+	f, err := Load(in)
+	if err != nil {
+		t.Fatalf("Load() returned an error: %v", err)
+	}
+	if names := f.Names(); !reflect.DeepEqual(names, []string{"dev", "eu-west", "us-east"}) {
+		t.Errorf("Names() => got %v", names)
+	}
+	got, err := f.Cluster("us-east")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Cluster{
+		Context:             "gke_prod_us-east1_mesh",
+		Hub:                 "us-east1-docker.example.com/istio",
+		Profile:             "prod",
+		MeshConfig:          "istio",
+		MeshConfigNamespace: "istio-system",
+		TrustDomain:         "us-east.example.com",
+		Network:             "vpc-east",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Cluster(us-east) => got %+v, want %+v", got, want)
+	}
+	if _, err = f.Cluster("ap-south"); err == nil || !strings.Contains(err.Error(), "dev, eu-west, us-east") {
+		t.Errorf("Cluster(ap-south) => got error %v, want the known clusters", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{in: "clusters: {}", want: []string{"no clusters"}},
+		{
+			in: `clusters:
+  a:
+    profile: qa
+  b:
+    meshConfigNamespace: istio-system
+`,
+			want: []string{`cluster a has unknown profile "qa"`, "cluster b sets meshConfigNamespace without meshConfig"},
+		},
+	}
+	for _, c := range cases {
+		_, err := Load(strings.NewReader(c.in))
+		if err == nil {
+			t.Errorf("Load(%q) succeeded", c.in)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Load(%q) => got error %v, want %q", c.in, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package initializer injects the istio proxy into workloads using the
+// alpha kubernetes initializers feature, for clusters where mutating
+// admission webhooks are not available.
+package initializer
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/notify"
+)
+
+// DefaultName is the initializer name to register in the
+// InitializerConfiguration.
+const DefaultName = "sidecar.initializer.istio.io"
+
+// kind describes how to watch, inject, and update one workload type.
+type kind struct {
+	resource  string
+	prototype runtime.Object
+	template  func(obj runtime.Object) *v1.PodTemplateSpec
+	update    func(client kubernetes.Interface, obj runtime.Object) error
+}
+
+// kind returns the name of the kind, e.g. Deployment.
+func (k kind) kind() string {
+	return reflect.TypeOf(k.prototype).Elem().Name()
+}
+
+var kinds = []kind{
+	{
+		resource:  "deployments",
+		prototype: &v1beta1.Deployment{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*v1beta1.Deployment).Spec.Template
+		},
+		update: func(client kubernetes.Interface, obj runtime.Object) error {
+			d := obj.(*v1beta1.Deployment)
+			_, err := client.ExtensionsV1beta1().Deployments(d.Namespace).Update(d)
+			return err
+		},
+	},
+	{
+		resource:  "daemonsets",
+		prototype: &v1beta1.DaemonSet{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*v1beta1.DaemonSet).Spec.Template
+		},
+		update: func(client kubernetes.Interface, obj runtime.Object) error {
+			d := obj.(*v1beta1.DaemonSet)
+			_, err := client.ExtensionsV1beta1().DaemonSets(d.Namespace).Update(d)
+			return err
+		},
+	},
+	{
+		resource:  "replicasets",
+		prototype: &v1beta1.ReplicaSet{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*v1beta1.ReplicaSet).Spec.Template
+		},
+		update: func(client kubernetes.Interface, obj runtime.Object) error {
+			r := obj.(*v1beta1.ReplicaSet)
+			_, err := client.ExtensionsV1beta1().ReplicaSets(r.Namespace).Update(r)
+			return err
+		},
+	},
+}
+
+// Controller watches uninitialized workloads and injects the proxy
+// into those for which it is the next pending initializer.
+type Controller struct {
+	client    kubernetes.Interface
+	params    *inject.Params
+	name      string
+	notifier  notify.Notifier
+	informers []cache.Controller
+}
+
+// NewController creates an initializer controller named name that
+// watches the namespace, or all namespaces if empty. Injection and
+// update failures are reported to the notifier, if not nil, which is
+// called from the event handlers and should not block, see
+// notify.Async.
+func NewController(client kubernetes.Interface, params *inject.Params, name, namespace string,
+	resyncPeriod time.Duration, notifier notify.Notifier) *Controller {
+	c := &Controller{
+		client:   client,
+		params:   params,
+		name:     name,
+		notifier: notifier,
+	}
+	for _, k := range kinds {
+		k := k
+		watchlist := cache.NewListWatchFromClient(client.ExtensionsV1beta1().RESTClient(),
+			k.resource, namespace, fields.Everything())
+		// Uninitialized objects are only returned when asked for
+		// explicitly.
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.IncludeUninitialized = true
+				return watchlist.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.IncludeUninitialized = true
+				return watchlist.Watch(options)
+			},
+		}
+		handle := func(obj interface{}) {
+			if err := c.initialize(k, obj.(runtime.Object)); err != nil {
+				glog.Warningf("Failed to initialize %s: %v", k.resource, err)
+			}
+		}
+		// Updates and resyncs retry the objects whose update failed,
+		// which are still pending on this controller.
+		_, informer := cache.NewInformer(lw, k.prototype, resyncPeriod, cache.ResourceEventHandlerFuncs{
+			AddFunc:    handle,
+			UpdateFunc: func(_, obj interface{}) { handle(obj) },
+		})
+		c.informers = append(c.informers, informer)
+	}
+	return c
+}
+
+// Run watches workloads until the stop channel is closed.
+func (c *Controller) Run(stop <-chan struct{}) {
+	for _, informer := range c.informers {
+		go informer.Run(stop)
+	}
+	<-stop
+}
+
+// initialize injects the proxy into the object if this controller is
+// its first pending initializer, and removes the controller from the
+// pending list. Objects that fail injection are still released so
+// that they do not stay uninitialized forever.
+func (c *Controller) initialize(k kind, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	initializers := accessor.GetInitializers()
+	if initializers == nil || len(initializers.Pending) == 0 || initializers.Pending[0].Name != c.name {
+		return nil
+	}
+
+	released, err := scheme.Scheme.Copy(obj)
+	if err != nil {
+		return err
+	}
+	if accessor, err = meta.Accessor(released); err != nil {
+		return err
+	}
+	if len(initializers.Pending) == 1 {
+		accessor.SetInitializers(nil)
+	} else {
+		accessor.SetInitializers(&metav1.Initializers{
+			Pending: append([]metav1.Initializer(nil), initializers.Pending[1:]...),
+		})
+	}
+
+	start := time.Now()
+	injected, err := scheme.Scheme.Copy(released)
+	if err != nil {
+		return err
+	}
+	if err = inject.IntoPodTemplateSpec(c.params, accessor.GetNamespace(), k.template(injected)); err != nil {
+		metrics.Errors.Inc(metrics.SourceInitializer, k.kind(), accessor.GetNamespace())
+		glog.Warningf("Releasing %s %s/%s without injection: %v",
+			k.resource, accessor.GetNamespace(), accessor.GetName(), err)
+		c.notify(k, accessor, err)
+		if err = k.update(c.client, released); err != nil {
+			c.notify(k, accessor, fmt.Errorf("cannot release the workload: %v", err))
+		}
+		return err
+	}
+	glog.V(2).Infof("Injected %s %s/%s", k.resource, accessor.GetNamespace(), accessor.GetName())
+	if err = k.update(c.client, injected); err != nil {
+		metrics.Errors.Inc(metrics.SourceInitializer, k.kind(), accessor.GetNamespace())
+		c.notify(k, accessor, fmt.Errorf("cannot update the injected workload: %v", err))
+		return err
+	}
+	metrics.Injected.Inc(metrics.SourceInitializer, k.kind(), accessor.GetNamespace())
+	metrics.ObserveSince(metrics.SourceInitializer, k.kind(), start)
+	return nil
+}
+
+// notify reports a failure of the workload to the notifier, if any.
+func (c *Controller) notify(k kind, accessor metav1.Object, err error) {
+	if c.notifier == nil {
+		return
+	}
+	failure := notify.Failure{
+		Resource:  k.resource,
+		Namespace: accessor.GetNamespace(),
+		Name:      accessor.GetName(),
+		Error:     err.Error(),
+	}
+	if nerr := c.notifier.Notify(failure); nerr != nil {
+		glog.Warningf("Failed to notify %v: %v", failure, nerr)
+	}
+}
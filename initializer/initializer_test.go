@@ -0,0 +1,172 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initializer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/notify"
+	"istio.io/pilot/proxy"
+)
+
+func newDeployment(name string, pending ...string) *v1beta1.Deployment {
+	d := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1beta1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "hello", Image: "hello"}},
+				},
+			},
+		},
+	}
+	if len(pending) > 0 {
+		d.Initializers = &metav1.Initializers{}
+		for _, name := range pending {
+			d.Initializers.Pending = append(d.Initializers.Pending, metav1.Initializer{Name: name})
+		}
+	}
+	return d
+}
+
+func containerNames(d *v1beta1.Deployment) []string {
+	var names []string
+	for _, c := range d.Spec.Template.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestInitialize(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:       inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:      inject.ProxyImageName("docker.io/istio", "unittest"),
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Version:         "12345678",
+		Mesh:            &mesh,
+	}
+
+	cases := []struct {
+		in             *v1beta1.Deployment
+		wantContainers []string
+		wantPending    *metav1.Initializers
+	}{
+		{
+			in:             newDeployment("last", DefaultName),
+			wantContainers: []string{"hello", "proxy"},
+		},
+		{
+			in:             newDeployment("first", DefaultName, "other"),
+			wantContainers: []string{"hello", "proxy"},
+			wantPending:    &metav1.Initializers{Pending: []metav1.Initializer{{Name: "other"}}},
+		},
+		{
+			in:             newDeployment("waiting", "other", DefaultName),
+			wantContainers: []string{"hello"},
+			wantPending: &metav1.Initializers{Pending: []metav1.Initializer{
+				{Name: "other"}, {Name: DefaultName},
+			}},
+		},
+		{
+			in:             newDeployment("initialized"),
+			wantContainers: []string{"hello"},
+		},
+	}
+
+	for _, c := range cases {
+		client := fake.NewSimpleClientset(c.in)
+		controller := &Controller{client: client, params: params, name: DefaultName}
+		if err := controller.initialize(kinds[0], c.in); err != nil {
+			t.Errorf("initialize(%s) returned an error: %v", c.in.Name, err)
+			continue
+		}
+		got, err := client.ExtensionsV1beta1().Deployments("default").Get(c.in.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := containerNames(got); !reflect.DeepEqual(names, c.wantContainers) {
+			t.Errorf("initialize(%s) containers => got %v, want %v", c.in.Name, names, c.wantContainers)
+		}
+		if !reflect.DeepEqual(got.Initializers, c.wantPending) {
+			t.Errorf("initialize(%s) initializers => got %v, want %v", c.in.Name, got.Initializers, c.wantPending)
+		}
+		if len(c.in.Spec.Template.Spec.Containers) != 1 {
+			t.Errorf("initialize(%s) modified the cached object", c.in.Name)
+		}
+	}
+}
+
+type recorder []notify.Failure
+
+func (r *recorder) Notify(f notify.Failure) error {
+	*r = append(*r, f)
+	return nil
+}
+
+func TestInitializeNotifiesFailures(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+	}
+	in := newDeployment("broken", DefaultName)
+	in.Spec.Template.Annotations = map[string]string{"sidecar.wharfie.io/authPolicy": "SOMETIMES"}
+	client := fake.NewSimpleClientset(in)
+	var failures recorder
+	controller := &Controller{client: client, params: params, name: DefaultName, notifier: &failures}
+	if err := controller.initialize(kinds[0], in); err != nil {
+		t.Fatalf("initialize(%s) returned an error: %v", in.Name, err)
+	}
+	if len(failures) != 1 || failures[0].Resource != "deployments" || failures[0].Namespace != "default" ||
+		failures[0].Name != "broken" || failures[0].Error == "" {
+		t.Errorf("initialize(%s) notified %v, want one failure", in.Name, failures)
+	}
+	got, err := client.ExtensionsV1beta1().Deployments("default").Get(in.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Initializers != nil {
+		t.Errorf("initialize(%s) did not release the deployment: %v", in.Name, got.Initializers)
+	}
+}
+
+func TestInitializeNotifiesUpdateFailures(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:  inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+	}
+	// The deployment was deleted before it could be updated.
+	in := newDeployment("deleted", DefaultName)
+	var failures recorder
+	controller := &Controller{client: fake.NewSimpleClientset(), params: params, name: DefaultName, notifier: &failures}
+	if err := controller.initialize(kinds[0], in); err == nil {
+		t.Errorf("initialize(%s) succeeded without the deployment", in.Name)
+	}
+	if len(failures) != 1 || failures[0].Name != "deleted" ||
+		!strings.Contains(failures[0].Error, "cannot update the injected workload") {
+		t.Errorf("initialize(%s) notified %v, want the update failure", in.Name, failures)
+	}
+}
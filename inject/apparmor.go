@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// appArmorAnnotationPrefix prefixes the annotations of the AppArmor
+// profiles of the containers, by container name.
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+const (
+	// AppArmorRuntimeDefault selects the default AppArmor profile of the
+	// container runtime.
+	AppArmorRuntimeDefault = "runtime/default"
+	// AppArmorUnconfined runs the containers without an AppArmor profile.
+	AppArmorUnconfined = "unconfined"
+)
+
+// appArmorLocalhostPrefix prefixes the names of the AppArmor profiles
+// loaded on the nodes.
+const appArmorLocalhostPrefix = "localhost/"
+
+// ValidateAppArmorProfile checks that a profile is runtime/default,
+// unconfined, or localhost/<profile>.
+func ValidateAppArmorProfile(profile string) error {
+	switch {
+	case profile == AppArmorRuntimeDefault, profile == AppArmorUnconfined:
+		return nil
+	case strings.HasPrefix(profile, appArmorLocalhostPrefix) && len(profile) > len(appArmorLocalhostPrefix):
+		return nil
+	default:
+		return fmt.Errorf("invalid AppArmor profile %q, expected %s, %s, or %s<profile>",
+			profile, AppArmorRuntimeDefault, AppArmorUnconfined, appArmorLocalhostPrefix)
+	}
+}
+
+// appArmorAnnotationKey returns the annotation of the AppArmor profile
+// of a container.
+func appArmorAnnotationKey(container string) string {
+	return appArmorAnnotationPrefix + container
+}
+
+// annotateAppArmor sets the AppArmor profile of the parameters, if
+// any, on the named injected containers.
+func annotateAppArmor(p *Params, t *v1.PodTemplateSpec, containers ...string) {
+	if p.AppArmorProfile == "" {
+		return
+	}
+	if t.Annotations == nil {
+		t.Annotations = make(map[string]string)
+	}
+	for _, name := range containers {
+		t.Annotations[appArmorAnnotationKey(name)] = p.AppArmorProfile
+	}
+}
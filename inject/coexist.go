@@ -0,0 +1,78 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// secretInjector describes another admission webhook that injects an
+// init container fetching secrets over the network. Such webhooks
+// mutate pods after this tool has rendered their templates, so the
+// order of the init containers is controlled through their
+// annotations.
+//
+// The proxy init container redirects all outbound traffic to the
+// proxy, which only starts after every init container has completed.
+// An init container running after it can not reach its secret store,
+// so the secret injector has to run its init container first.
+type secretInjector struct {
+	name string
+	// enabledKey requests injection with the value "true".
+	enabledKey string
+	// initFirstKey runs the init container before all others with the
+	// value "true".
+	initFirstKey string
+}
+
+var secretInjectors = []secretInjector{{
+	name:         "Vault Agent",
+	enabledKey:   "vault.hashicorp.com/agent-inject",
+	initFirstKey: "vault.hashicorp.com/agent-init-first",
+}}
+
+// orderSecretInjectors asks every secret injector enabled on the
+// template to run its init container first, unless the template
+// already chose an order.
+func orderSecretInjectors(t *v1.PodTemplateSpec) {
+	for _, injector := range secretInjectors {
+		if t.Annotations[injector.enabledKey] != "true" {
+			continue
+		}
+		if _, ok := t.Annotations[injector.initFirstKey]; !ok {
+			t.Annotations[injector.initFirstKey] = "true"
+		}
+	}
+}
+
+// secretInjectorWarnings returns a warning for every secret injector
+// whose init container is explicitly ordered after the proxy init
+// container.
+func secretInjectorWarnings(t *v1.PodTemplateSpec) []string {
+	var warnings []string
+	for _, injector := range secretInjectors {
+		if t.Annotations[injector.enabledKey] != "true" {
+			continue
+		}
+		if value, ok := t.Annotations[injector.initFirstKey]; ok && value != "true" {
+			warnings = append(warnings, fmt.Sprintf(
+				"annotation %s is %q: the %s init container runs after traffic is redirected to the proxy and cannot fetch secrets",
+				injector.initFirstKey, value, injector.name))
+		}
+	}
+	return warnings
+}
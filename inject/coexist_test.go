@@ -0,0 +1,39 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecretInjectors(t *testing.T) {
+	report := injectWithReport(t, "testdata/vault.yaml")
+	want := map[string][]string{
+		"vault": nil,
+		"vault-init-last": {`annotation vault.hashicorp.com/agent-init-first is "false": ` +
+			`the Vault Agent init container runs after traffic is redirected to the proxy and cannot fetch secrets`},
+	}
+	got := make(map[string][]string)
+	for _, resource := range report.Resources {
+		got[resource.Name] = resource.Warnings
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IntoResourceFileWithReport() warnings => got %q, want %q", got, want)
+	}
+	if added := report.Resources[0].Annotations; !contains(added, "vault.hashicorp.com/agent-init-first") {
+		t.Errorf("IntoResourceFileWithReport() added annotations %v, want vault.hashicorp.com/agent-init-first", added)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// concurrencyAnnotationKey overrides the number of worker threads of
+// the proxy of the pods, or derives it from the CPU limit of the proxy
+// if set to auto.
+const concurrencyAnnotationKey = "sidecar.wharfie.io/concurrency"
+
+// concurrencyAuto is the value of the concurrency annotation that
+// derives the worker threads from the CPU limit.
+const concurrencyAuto = "auto"
+
+// proxyConcurrency returns the number of worker threads of the proxy of
+// the pods of the template with the given resources, or zero to let
+// the proxy run a worker per core of the node. Derived from the CPU
+// limit, it is the limit rounded up to whole cores.
+func proxyConcurrency(p *Params, t *v1.PodTemplateSpec, resources v1.ResourceRequirements) (int, error) {
+	concurrency, auto := p.Concurrency, p.ConcurrencyFromCPULimit
+	if value, ok := t.Annotations[concurrencyAnnotationKey]; ok {
+		if value == concurrencyAuto {
+			concurrency, auto = 0, true
+		} else {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("annotation %s must be a positive integer or %s, got %q",
+					concurrencyAnnotationKey, concurrencyAuto, value)
+			}
+			concurrency = n
+		}
+	}
+	if concurrency > 0 || !auto {
+		return concurrency, nil
+	}
+	limit, ok := resources.Limits[v1.ResourceCPU]
+	if !ok {
+		return 0, nil
+	}
+	cores := int((limit.MilliValue() + 999) / 1000)
+	if cores < 1 {
+		cores = 1
+	}
+	return cores, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestProxyConcurrency(t *testing.T) {
+	limits := func(cpu string) v1.ResourceRequirements {
+		return v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+	cases := []struct {
+		name       string
+		params     Params
+		annotation string
+		resources  v1.ResourceRequirements
+		want       int
+		wantErr    bool
+	}{
+		{name: "unset", resources: limits("2")},
+		{name: "parameter", params: Params{Concurrency: 4}, resources: limits("2"), want: 4},
+		{name: "cpu limit", params: Params{ConcurrencyFromCPULimit: true}, resources: limits("1500m"), want: 2},
+		{name: "small cpu limit", params: Params{ConcurrencyFromCPULimit: true}, resources: limits("100m"), want: 1},
+		{name: "no cpu limit", params: Params{ConcurrencyFromCPULimit: true}},
+		{name: "parameter over cpu limit", params: Params{Concurrency: 1, ConcurrencyFromCPULimit: true}, resources: limits("4"), want: 1},
+		{name: "annotation", params: Params{Concurrency: 4}, annotation: "2", want: 2},
+		{name: "auto annotation", params: Params{Concurrency: 4}, annotation: "auto", resources: limits("3"), want: 3},
+		{name: "invalid annotation", annotation: "0", wantErr: true},
+	}
+	for _, c := range cases {
+		template := &v1.PodTemplateSpec{}
+		if c.annotation != "" {
+			template.Annotations = map[string]string{concurrencyAnnotationKey: c.annotation}
+		}
+		got, err := proxyConcurrency(&c.params, template, c.resources)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: proxyConcurrency() => got error %v, want error %t", c.name, err, c.wantErr)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: proxyConcurrency() => got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
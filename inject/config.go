@@ -0,0 +1,312 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/jsonpb"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// Config holds injection parameters in a YAML file, so that teams can
+// version their injection configuration with their manifests. Unset
+// fields keep the value of the Params the file is applied to.
+type Config struct {
+	InitImage       string `json:"initImage,omitempty"`
+	ProxyImage      string `json:"proxyImage,omitempty"`
+	Verbosity       *int   `json:"verbosity,omitempty"`
+	SidecarProxyUID *int64 `json:"sidecarProxyUID,omitempty"`
+	Version         string `json:"version,omitempty"`
+	EnableCoreDump  *bool  `json:"enableCoreDump,omitempty"`
+	// Mesh overrides fields of the mesh configuration, in the format of
+	// the mesh ConfigMap.
+	Mesh                            json.RawMessage          `json:"mesh,omitempty"`
+	MeshConfigMapName               string                   `json:"meshConfigMapName,omitempty"`
+	IncludeIPRanges                 string                   `json:"includeIPRanges,omitempty"`
+	ExcludeInterfaces               string                   `json:"excludeInterfaces,omitempty"`
+	ProxyResources                  *v1.ResourceRequirements `json:"proxyResources,omitempty"`
+	CertSecretTemplate              string                   `json:"certSecretTemplate,omitempty"`
+	TrustDomain                     string                   `json:"trustDomain,omitempty"`
+	SDSPath                         string                   `json:"sdsPath,omitempty"`
+	HardenedSecurityContext         *bool                    `json:"hardenedSecurityContext,omitempty"`
+	ShareProcessNamespace           *bool                    `json:"shareProcessNamespace,omitempty"`
+	HoldApplicationUntilProxyStarts *bool                    `json:"holdApplicationUntilProxyStarts,omitempty"`
+	// MaxTerminationGracePeriod is a duration, e.g. 2m.
+	MaxTerminationGracePeriod string `json:"maxTerminationGracePeriod,omitempty"`
+	// TerminationDrainDuration is a duration, e.g. 20s.
+	TerminationDrainDuration string `json:"terminationDrainDuration,omitempty"`
+	Network                  string `json:"network,omitempty"`
+	SkipTekton               *bool  `json:"skipTekton,omitempty"`
+	// Policy is enabled or disabled.
+	Policy string `json:"policy,omitempty"`
+	// InitResources are the resources of the init container.
+	InitResources        *v1.ResourceRequirements `json:"initResources,omitempty"`
+	ImagePullSecrets     []string                 `json:"imagePullSecrets,omitempty"`
+	ProxyEnv             map[string]string        `json:"proxyEnv,omitempty"`
+	ProxyVolumes         []v1.Volume              `json:"proxyVolumes,omitempty"`
+	ProxyVolumeMounts    []v1.VolumeMount         `json:"proxyVolumeMounts,omitempty"`
+	ExtraProxyArgs       []string                 `json:"extraProxyArgs,omitempty"`
+	ExcludeInboundPorts  string                   `json:"excludeInboundPorts,omitempty"`
+	ExcludeIPRanges      string                   `json:"excludeIPRanges,omitempty"`
+	IncludeOutboundPorts string                   `json:"includeOutboundPorts,omitempty"`
+	ExcludeOutboundPorts string                   `json:"excludeOutboundPorts,omitempty"`
+	// InterceptionMode is iptables, cni, or ebpf.
+	InterceptionMode string `json:"interceptionMode,omitempty"`
+	// SidecarMode is container, native, or auto.
+	SidecarMode string `json:"sidecarMode,omitempty"`
+	ExcludeUIDs string `json:"excludeUIDs,omitempty"`
+	ExcludeGIDs string `json:"excludeGIDs,omitempty"`
+	// Concurrency is the number of worker threads of the proxy.
+	Concurrency *int `json:"concurrency,omitempty"`
+	// ConcurrencyFromCPULimit derives the worker threads of the proxy
+	// from its CPU limit.
+	ConcurrencyFromCPULimit *bool `json:"concurrencyFromCPULimit,omitempty"`
+	// ProxyContainerName is the name of the proxy container.
+	ProxyContainerName string `json:"proxyContainerName,omitempty"`
+	// CoreDump configures how core dumps are enabled.
+	CoreDump *CoreDumpPolicy `json:"coreDump,omitempty"`
+	// ReadOnlyRootFilesystem makes the root filesystem of the proxy
+	// read-only.
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+	// SeccompProfile is the seccomp profile of the injected init and
+	// proxy containers.
+	SeccompProfile *SeccompProfile `json:"seccompProfile,omitempty"`
+	// AppArmorProfile is the AppArmor profile of the injected init and
+	// proxy containers.
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+	// PodSecurityLevel is privileged, baseline, or restricted.
+	PodSecurityLevel string `json:"podSecurityLevel,omitempty"`
+	// AdaptPodSecurity adapts the injection to the pod security level
+	// where possible.
+	AdaptPodSecurity *bool `json:"adaptPodSecurity,omitempty"`
+	// OpenShift runs the proxy as a UID of the range of the OpenShift
+	// project of the pods.
+	OpenShift *OpenShiftPolicy `json:"openShift,omitempty"`
+	// ProxyRunAsGroup is the primary group of the proxy.
+	ProxyRunAsGroup *int64 `json:"proxyRunAsGroup,omitempty"`
+	// FSGroup is the fsGroup of the pods that do not set one.
+	FSGroup *int64 `json:"fsGroup,omitempty"`
+	// ProxyPorts configures the container ports of the proxy.
+	ProxyPorts *ProxyPorts `json:"proxyPorts,omitempty"`
+}
+
+// LoadConfig reads injection parameters in YAML.
+func LoadConfig(in io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err = yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Apply overrides the parameters with the fields set in the file.
+func (c *Config) Apply(p *Params) error {
+	for _, s := range []struct {
+		value string
+		dest  *string
+	}{
+		{c.InitImage, &p.InitImage},
+		{c.ProxyImage, &p.ProxyImage},
+		{c.Version, &p.Version},
+		{c.MeshConfigMapName, &p.MeshConfigMapName},
+		{c.IncludeIPRanges, &p.IncludeIPRanges},
+		{c.ExcludeInterfaces, &p.ExcludeInterfaces},
+		{c.ExcludeInboundPorts, &p.ExcludeInboundPorts},
+		{c.ExcludeIPRanges, &p.ExcludeIPRanges},
+		{c.IncludeOutboundPorts, &p.IncludeOutboundPorts},
+		{c.ExcludeOutboundPorts, &p.ExcludeOutboundPorts},
+		{c.ExcludeUIDs, &p.ExcludeUIDs},
+		{c.ExcludeGIDs, &p.ExcludeGIDs},
+		{c.CertSecretTemplate, &p.CertSecretTemplate},
+		{c.TrustDomain, &p.TrustDomain},
+		{c.SDSPath, &p.SDSPath},
+		{c.Network, &p.Network},
+		{c.ProxyContainerName, &p.ProxyContainerName},
+	} {
+		if s.value != "" {
+			*s.dest = s.value
+		}
+	}
+	for _, s := range []struct {
+		value *bool
+		dest  *bool
+	}{
+		{c.EnableCoreDump, &p.EnableCoreDump},
+		{c.HardenedSecurityContext, &p.HardenedSecurityContext},
+		{c.ShareProcessNamespace, &p.ShareProcessNamespace},
+		{c.HoldApplicationUntilProxyStarts, &p.HoldApplicationUntilProxyStarts},
+		{c.SkipTekton, &p.SkipTekton},
+		{c.ConcurrencyFromCPULimit, &p.ConcurrencyFromCPULimit},
+		{c.ReadOnlyRootFilesystem, &p.ReadOnlyRootFilesystem},
+		{c.AdaptPodSecurity, &p.AdaptPodSecurity},
+	} {
+		if s.value != nil {
+			*s.dest = *s.value
+		}
+	}
+	if c.Verbosity != nil {
+		p.Verbosity = *c.Verbosity
+	}
+	if c.SidecarProxyUID != nil {
+		p.SidecarProxyUID = *c.SidecarProxyUID
+	}
+	if c.CoreDump != nil {
+		if err := c.CoreDump.Validate(); err != nil {
+			return err
+		}
+		p.CoreDump = c.CoreDump
+	}
+	if c.AppArmorProfile != "" {
+		if err := ValidateAppArmorProfile(c.AppArmorProfile); err != nil {
+			return err
+		}
+		p.AppArmorProfile = c.AppArmorProfile
+	}
+	if c.SeccompProfile != nil {
+		if err := c.SeccompProfile.Validate(); err != nil {
+			return err
+		}
+		p.SeccompProfile = c.SeccompProfile
+	}
+	if c.ProxyPorts != nil {
+		if err := c.ProxyPorts.Validate(); err != nil {
+			return err
+		}
+		p.ProxyPorts = c.ProxyPorts
+	}
+	if c.Concurrency != nil {
+		if *c.Concurrency < 0 {
+			return fmt.Errorf("invalid concurrency %d, expected a non-negative integer", *c.Concurrency)
+		}
+		p.Concurrency = *c.Concurrency
+	}
+	if c.ProxyResources != nil {
+		p.ProxyResources = *c.ProxyResources
+	}
+	if c.InitResources != nil {
+		p.InitResources = *c.InitResources
+	}
+	if len(c.ImagePullSecrets) > 0 {
+		p.ImagePullSecrets = c.ImagePullSecrets
+	}
+	if len(c.ProxyEnv) > 0 {
+		for name := range c.ProxyEnv {
+			if err := ValidateEnvName(name); err != nil {
+				return fmt.Errorf("invalid proxyEnv: %v", err)
+			}
+		}
+		p.ProxyEnv = c.ProxyEnv
+	}
+	if len(c.ProxyVolumes) > 0 {
+		p.ProxyVolumes = c.ProxyVolumes
+	}
+	if len(c.ProxyVolumeMounts) > 0 {
+		p.ProxyVolumeMounts = c.ProxyVolumeMounts
+	}
+	if len(c.ExtraProxyArgs) > 0 {
+		p.ExtraProxyArgs = c.ExtraProxyArgs
+	}
+	if c.MaxTerminationGracePeriod != "" {
+		period, err := time.ParseDuration(c.MaxTerminationGracePeriod)
+		if err != nil {
+			return fmt.Errorf("invalid maxTerminationGracePeriod: %v", err)
+		}
+		p.MaxTerminationGracePeriod = period
+	}
+	if c.TerminationDrainDuration != "" {
+		drain, err := time.ParseDuration(c.TerminationDrainDuration)
+		if err != nil {
+			return fmt.Errorf("invalid terminationDrainDuration: %v", err)
+		}
+		p.TerminationDrainDuration = drain
+	}
+	if c.InterceptionMode != "" {
+		mode, err := ParseInterceptionMode(c.InterceptionMode)
+		if err != nil {
+			return err
+		}
+		p.InterceptionMode = mode
+	}
+	if c.SidecarMode != "" {
+		mode, err := ParseSidecarMode(c.SidecarMode)
+		if err != nil {
+			return err
+		}
+		p.SidecarMode = mode
+	}
+	for _, group := range []struct {
+		name  string
+		value *int64
+		dest  **int64
+	}{
+		{"proxyRunAsGroup", c.ProxyRunAsGroup, &p.ProxyRunAsGroup},
+		{"fsGroup", c.FSGroup, &p.FSGroup},
+	} {
+		if group.value == nil {
+			continue
+		}
+		if *group.value < 0 {
+			return fmt.Errorf("invalid %s %d, expected a non-negative group ID", group.name, *group.value)
+		}
+		*group.dest = group.value
+	}
+	if c.OpenShift != nil {
+		for namespace, uidRange := range c.OpenShift.UIDRanges {
+			if _, _, err := ParseUIDRange(uidRange); err != nil {
+				return fmt.Errorf("namespace %s: %v", namespace, err)
+			}
+		}
+		p.OpenShift = c.OpenShift
+	}
+	if c.PodSecurityLevel != "" {
+		level, err := ParsePodSecurityLevel(c.PodSecurityLevel)
+		if err != nil {
+			return err
+		}
+		p.PodSecurityLevel = level
+	}
+	if c.Policy != "" {
+		policy, err := ParseInjectionPolicy(c.Policy)
+		if err != nil {
+			return err
+		}
+		p.Policy = policy
+	}
+	if len(c.Mesh) > 0 {
+		// The overrides apply to a copy, as the mesh configuration may
+		// be shared with other parameters.
+		var mesh proxyconfig.ProxyMeshConfig
+		if p.Mesh != nil {
+			mesh = *p.Mesh
+		}
+		if err := jsonpb.UnmarshalString(string(c.Mesh), &mesh); err != nil {
+			return fmt.Errorf("invalid mesh: %v", err)
+		}
+		p.Mesh = &mesh
+	}
+	return nil
+}
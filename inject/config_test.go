@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestConfigApply(t *testing.T) {
+	in := `
+proxyImage: registry.example.com/istio/proxy:1.0
+verbosity: 4
+sidecarProxyUID: 1337
+enableCoreDump: false
+includeIPRanges: 10.0.0.0/8
+excludeInboundPorts: "9090"
+excludeIPRanges: 169.254.169.254/32
+includeOutboundPorts: 80,443
+excludeUIDs: "1000"
+proxyResources:
+  requests:
+    cpu: 100m
+    memory: 128Mi
+hardenedSecurityContext: true
+readOnlyRootFilesystem: true
+seccompProfile:
+  type: RuntimeDefault
+appArmorProfile: runtime/default
+podSecurityLevel: restricted
+adaptPodSecurity: true
+proxyRunAsGroup: 1337
+fsGroup: 1337
+openShift:
+  uidRanges:
+    web: 1000620000/10000
+holdApplicationUntilProxyStarts: true
+imagePullSecrets: [registry-credentials]
+proxyEnv:
+  TRACE_SAMPLING: "10"
+extraProxyArgs: [--drainDuration, 20s]
+concurrency: 2
+proxyVolumes:
+- name: ca-bundle
+  configMap:
+    name: corporate-ca
+proxyVolumeMounts:
+- name: ca-bundle
+  mountPath: /etc/ssl/corporate
+initResources:
+  limits:
+    cpu: 10m
+maxTerminationGracePeriod: 2m
+terminationDrainDuration: 20s
+policy: disabled
+interceptionMode: cni
+sidecarMode: native
+proxyContainerName: mesh-proxy
+coreDump:
+  image: registry.example.com/busybox
+proxyPorts:
+  statsPort: 15090
+mesh:
+  discoveryAddress: pilot.example.com:8080
+`
+	config, err := LoadConfig(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{
+		InitImage:      InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:     ProxyImageName("docker.io/istio", "unittest"),
+		Verbosity:      DefaultVerbosity,
+		EnableCoreDump: true,
+		Mesh:           &mesh,
+	}
+	if err = config.Apply(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.InitImage != InitImageName("docker.io/istio", "unittest") {
+		t.Errorf("Apply() changed the unset init image to %q", p.InitImage)
+	}
+	if p.ProxyImage != "registry.example.com/istio/proxy:1.0" || p.Verbosity != 4 || p.SidecarProxyUID != 1337 ||
+		p.EnableCoreDump || p.IncludeIPRanges != "10.0.0.0/8" || p.ExcludeInboundPorts != "9090" ||
+		p.ExcludeIPRanges != "169.254.169.254/32" || p.IncludeOutboundPorts != "80,443" ||
+		p.ExcludeUIDs != "1000" || !p.HardenedSecurityContext || !p.ReadOnlyRootFilesystem ||
+		p.SeccompProfile == nil || p.SeccompProfile.Type != SeccompRuntimeDefault || p.AppArmorProfile != AppArmorRuntimeDefault ||
+		p.PodSecurityLevel != PodSecurityRestricted || !p.AdaptPodSecurity ||
+		p.ProxyRunAsGroup == nil || *p.ProxyRunAsGroup != 1337 || p.FSGroup == nil || *p.FSGroup != 1337 ||
+		p.OpenShift == nil || p.OpenShift.UIDRanges["web"] != "1000620000/10000" || !p.HoldApplicationUntilProxyStarts ||
+		p.MaxTerminationGracePeriod != 2*time.Minute || p.TerminationDrainDuration != 20*time.Second || p.Policy != InjectionPolicyDisabled || p.InterceptionMode != InterceptionCNI ||
+		p.SidecarMode != SidecarNative || p.ProxyContainerName != "mesh-proxy" ||
+		p.CoreDump == nil || p.CoreDump.Image != "registry.example.com/busybox" ||
+		len(p.ImagePullSecrets) != 1 || p.ImagePullSecrets[0] != "registry-credentials" || p.ProxyEnv["TRACE_SAMPLING"] != "10" ||
+		len(p.ProxyVolumes) != 1 || p.ProxyVolumes[0].ConfigMap == nil || len(p.ProxyVolumeMounts) != 1 ||
+		len(p.ExtraProxyArgs) != 2 || p.Concurrency != 2 || p.ProxyPorts == nil || p.ProxyPorts.StatsPort != 15090 {
+		t.Errorf("Apply() => got %+v", p)
+	}
+	if q := p.ProxyResources.Requests[v1.ResourceCPU]; q.String() != "100m" {
+		t.Errorf("Apply() => got proxy CPU request %s, want 100m", q.String())
+	}
+	if q := p.InitResources.Limits[v1.ResourceCPU]; q.String() != "10m" {
+		t.Errorf("Apply() => got init CPU limit %s, want 10m", q.String())
+	}
+	if p.Mesh.DiscoveryAddress != "pilot.example.com:8080" || p.Mesh.ProxyListenPort != mesh.ProxyListenPort {
+		t.Errorf("Apply() => got mesh %v, want the overridden discovery address over the defaults", p.Mesh)
+	}
+	if mesh.DiscoveryAddress == "pilot.example.com:8080" {
+		t.Error("Apply() modified the shared mesh configuration")
+	}
+}
+
+func TestConfigApplyErrors(t *testing.T) {
+	for _, in := range []string{
+		"maxTerminationGracePeriod: forever",
+		"terminationDrainDuration: 20",
+		"policy: sometimes",
+		"interceptionMode: ipvs",
+		"sidecarMode: sidecar",
+		"concurrency: -1",
+		"coreDump:\n  directory: cores",
+		"proxyPorts:\n  statsPort: 70000",
+		"proxyPorts:\n  statsName: proxy\n  statsPort: 15090",
+		"seccompProfile:\n  type: Unconfined",
+		"appArmorProfile: localhost",
+		"podSecurityLevel: strict",
+		"fsGroup: -1",
+		"openShift:\n  uidRanges:\n    web: 1000620000",
+		"proxyEnv:\n  TRACE SAMPLING: 10",
+		"mesh:\n  unknownField: true",
+	} {
+		config, err := LoadConfig(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mesh := proxy.DefaultMeshConfig()
+		if err = config.Apply(&Params{Mesh: &mesh}); err == nil {
+			t.Errorf("Apply(%q) succeeded, want an error", in)
+		}
+	}
+}
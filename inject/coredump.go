@@ -0,0 +1,114 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"path"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// coreDumpVolumeName is the name of the volume the proxy writes its
+// core dumps to, if the core dump directory is set.
+const coreDumpVolumeName = "istio-cores"
+
+// defaultCoreDumpDirectory is where the proxy writes its core dumps by
+// default.
+const defaultCoreDumpDirectory = "/tmp"
+
+// CoreDumpPolicy configures how the core dumps of the proxy are
+// enabled. The kernel.core_pattern sysctl is not namespaced, so pods
+// cannot set it through their security context: either a privileged
+// init container sets it on the node of every injected pod, or the
+// nodes are configured out of band, e.g. by a DaemonSet, which passes
+// security policies that ban privileged containers.
+type CoreDumpPolicy struct {
+	// Image of the init container, alpine if empty.
+	Image string `json:"image,omitempty"`
+	// Script run by the init container with /bin/sh -c, which sets the
+	// core pattern to the directory by default.
+	Script string `json:"script,omitempty"`
+	// Directory the proxy writes its core dumps to, /tmp if empty. If
+	// set, an emptyDir volume is mounted at the directory of the proxy,
+	// so that the core dumps outlive restarts of the proxy.
+	Directory string `json:"directory,omitempty"`
+	// NodeConfigured leaves the core pattern of the nodes as is instead
+	// of injecting the privileged init container. The pattern of the
+	// nodes must write to the directory.
+	NodeConfigured bool `json:"nodeConfigured,omitempty"`
+}
+
+// directory returns the directory of the core dumps of the proxy.
+func (c *CoreDumpPolicy) directory() string {
+	if c == nil || c.Directory == "" {
+		return defaultCoreDumpDirectory
+	}
+	return c.Directory
+}
+
+// Validate checks that the directory of the core dumps is absolute.
+func (c *CoreDumpPolicy) Validate() error {
+	if c != nil && c.Directory != "" && !path.IsAbs(c.Directory) {
+		return fmt.Errorf("core dump directory %q must be an absolute path", c.Directory)
+	}
+	return nil
+}
+
+// initContainer returns the init container that enables core dumps in
+// the form written to the init containers annotation, or nil if the
+// nodes are configured.
+func (c *CoreDumpPolicy) initContainer() map[string]interface{} {
+	image, script := enableCoreDumpImage, ""
+	if c != nil {
+		if c.NodeConfigured {
+			return nil
+		}
+		if c.Image != "" {
+			image = c.Image
+		}
+		script = c.Script
+	}
+	if script == "" {
+		script = fmt.Sprintf("sysctl -w kernel.core_pattern=%s && ulimit -c unlimited",
+			path.Join(c.directory(), "core.%e.%p.%t"))
+	}
+	return map[string]interface{}{
+		"name":            enableCoreDumpContainerName,
+		"image":           image,
+		"command":         []string{"/bin/sh"},
+		"args":            []string{"-c", script},
+		"imagePullPolicy": "Always",
+		"securityContext": map[string]interface{}{
+			"privileged": true,
+		},
+	}
+}
+
+// addVolume mounts an emptyDir volume at the directory of the core
+// dumps of the proxy, if set.
+func (c *CoreDumpPolicy) addVolume(t *v1.PodTemplateSpec, sidecar *v1.Container) {
+	if c == nil || c.Directory == "" {
+		return
+	}
+	t.Spec.Volumes = append(t.Spec.Volumes, v1.Volume{
+		Name:         coreDumpVolumeName,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, v1.VolumeMount{
+		Name:      coreDumpVolumeName,
+		MountPath: c.Directory,
+	})
+}
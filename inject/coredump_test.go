@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoreDumpInitContainer(t *testing.T) {
+	cases := []struct {
+		name       string
+		policy     *CoreDumpPolicy
+		wantImage  string
+		wantScript string
+	}{
+		{
+			name:       "default",
+			wantImage:  "alpine",
+			wantScript: "sysctl -w kernel.core_pattern=/tmp/core.%e.%p.%t && ulimit -c unlimited",
+		},
+		{
+			name:       "directory",
+			policy:     &CoreDumpPolicy{Image: "registry.example.com/busybox", Directory: "/var/lib/cores"},
+			wantImage:  "registry.example.com/busybox",
+			wantScript: "sysctl -w kernel.core_pattern=/var/lib/cores/core.%e.%p.%t && ulimit -c unlimited",
+		},
+		{
+			name:       "script",
+			policy:     &CoreDumpPolicy{Script: "sysctl -w kernel.core_pattern=|/usr/bin/collect"},
+			wantImage:  "alpine",
+			wantScript: "sysctl -w kernel.core_pattern=|/usr/bin/collect",
+		},
+		{name: "node configured", policy: &CoreDumpPolicy{NodeConfigured: true}},
+	}
+	for _, c := range cases {
+		container := c.policy.initContainer()
+		if c.wantImage == "" {
+			if container != nil {
+				t.Errorf("%s: initContainer() => got %v, want none", c.name, container)
+			}
+			continue
+		}
+		if container["image"] != c.wantImage || !reflect.DeepEqual(container["args"], []string{"-c", c.wantScript}) {
+			t.Errorf("%s: initContainer() => got image %v and args %v, want %s and %q",
+				c.name, container["image"], container["args"], c.wantImage, c.wantScript)
+		}
+	}
+}
+
+func TestCoreDumpValidate(t *testing.T) {
+	var unset *CoreDumpPolicy
+	for _, policy := range []*CoreDumpPolicy{unset, {}, {Directory: "/var/lib/cores"}} {
+		if err := policy.Validate(); err != nil {
+			t.Errorf("Validate(%+v) => got error %v", policy, err)
+		}
+	}
+	if err := (&CoreDumpPolicy{Directory: "cores"}).Validate(); err == nil {
+		t.Error("Validate() accepted a relative directory")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// CustomKind locates the pod template embedded in the resources of a
+// custom kind, e.g. the custom resources of an operator.
+type CustomKind struct {
+	schema.GroupVersionKind
+	// Path lists the fields from the root of the resource to the pod
+	// template.
+	Path []string
+}
+
+// ParseCustomKind parses a custom kind of the form
+// group/version/Kind=.path.to.template, e.g.
+// example.com/v1/Workload=.spec.workload.template. Kinds of the core
+// group omit the group.
+func ParseCustomKind(s string) (CustomKind, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return CustomKind{}, fmt.Errorf("invalid custom kind %q, expected group/version/Kind=.path.to.template", s)
+	}
+	slash := strings.LastIndex(parts[0], "/")
+	if slash < 0 || slash == len(parts[0])-1 {
+		return CustomKind{}, fmt.Errorf("custom kind %q does not have an API version and a kind", s)
+	}
+	gv, err := schema.ParseGroupVersion(parts[0][:slash])
+	if err != nil {
+		return CustomKind{}, fmt.Errorf("invalid API version of custom kind %q: %v", s, err)
+	}
+	if !strings.HasPrefix(parts[1], ".") || parts[1] == "." {
+		return CustomKind{}, fmt.Errorf("pod template path of custom kind %q must start with a period, e.g. .spec.template", s)
+	}
+	path := strings.Split(parts[1][1:], ".")
+	for _, field := range path {
+		if field == "" {
+			return CustomKind{}, fmt.Errorf("pod template path of custom kind %q has an empty field", s)
+		}
+	}
+	return CustomKind{GroupVersionKind: gv.WithKind(parts[0][slash+1:]), Path: path}, nil
+}
+
+// String returns the custom kind in the form parsed by ParseCustomKind.
+func (c CustomKind) String() string {
+	return c.GroupVersion().String() + "/" + c.Kind + "=." + strings.Join(c.Path, ".")
+}
+
+// customKind returns the custom kind of the parameters with the API
+// version and kind.
+func customKind(p *Params, gvk schema.GroupVersionKind) (CustomKind, bool) {
+	for _, c := range p.CustomKinds {
+		if c.GroupVersionKind == gvk {
+			return c, true
+		}
+	}
+	return CustomKind{}, false
+}
+
+// intoCustomResource injects the istio proxy into the pod template of
+// a resource of a custom kind. The resource is edited as a map so that
+// the fields outside of the pod template are kept, and the number of
+// replicas is read from a replicas field next to the template.
+func intoCustomResource(p *Params, kind CustomKind, namespace string, raw []byte, entry *ResourceReport) ([]byte, error) {
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	parent := obj
+	last := len(kind.Path) - 1
+	for _, field := range kind.Path[:last] {
+		if parent, _ = parent[field].(map[string]interface{}); parent == nil {
+			break
+		}
+	}
+	value, ok := parent[kind.Path[last]].(map[string]interface{})
+	if !ok {
+		entry.Reason = fmt.Sprintf("no pod template at .%s", strings.Join(kind.Path, "."))
+		return raw, nil
+	}
+
+	var t v1.PodTemplateSpec
+	if err := convert(value, &t); err != nil {
+		return nil, fmt.Errorf("invalid pod template at .%s: %v", strings.Join(kind.Path, "."), err)
+	}
+	before := summarizeTemplate(&t)
+	if err := IntoPodTemplateSpec(p, namespace, &t); err != nil {
+		return nil, err
+	}
+	entry.compare(p, before, &t)
+	if !entry.Injected {
+		return raw, nil
+	}
+	var replicas *int32
+	if r, ok := parent["replicas"].(float64); ok {
+		n := int32(r)
+		replicas = &n
+	}
+	entry.Replicas = defaultReplicas(replicas)
+
+	var template map[string]interface{}
+	if err := convert(&t, &template); err != nil {
+		return nil, err
+	}
+	spec, _ := template["spec"].(map[string]interface{})
+	for _, patch := range PodSpecPatches(p, entry.Containers, &t) {
+		if err := patch(spec); err != nil {
+			return nil, err
+		}
+	}
+	parent[kind.Path[last]] = template
+	updated, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if hasAnchors(raw) {
+		entry.Warnings = append(entry.Warnings,
+			"YAML anchors and aliases were expanded in the injected output")
+	}
+	return updated, nil
+}
+
+// convert copies a value into another type through its JSON encoding.
+func convert(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestParseCustomKind(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    CustomKind
+		wantErr bool
+	}{
+		{
+			in: "example.com/v1/Workload=.spec.workload.template",
+			want: CustomKind{
+				GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Workload"},
+				Path:             []string{"spec", "workload", "template"},
+			},
+		},
+		{
+			in: "v1/PodTemplate=.template",
+			want: CustomKind{
+				GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "PodTemplate"},
+				Path:             []string{"template"},
+			},
+		},
+		{in: "example.com/v1/Workload", wantErr: true},
+		{in: "Workload=.spec.template", wantErr: true},
+		{in: "example.com/v1/=.spec.template", wantErr: true},
+		{in: "a/b/c/Workload=.spec.template", wantErr: true},
+		{in: "example.com/v1/Workload=spec.template", wantErr: true},
+		{in: "example.com/v1/Workload=.", wantErr: true},
+		{in: "example.com/v1/Workload=.spec..template", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseCustomKind(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseCustomKind(%q) => got error %v, want error %t", c.in, err, c.wantErr)
+			continue
+		}
+		if c.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseCustomKind(%q) => got %#v, want %#v", c.in, got, c.want)
+		}
+		if got.String() != c.in {
+			t.Errorf("ParseCustomKind(%q).String() => got %q", c.in, got.String())
+		}
+	}
+}
+
+func TestCustomKindReport(t *testing.T) {
+	kind, err := ParseCustomKind("example.com/v1/Workload=.spec.workload.template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{
+		InitImage:   InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:  ProxyImageName(unitTestHub, unitTestTag),
+		Verbosity:   DefaultVerbosity,
+		Mesh:        &mesh,
+		CustomKinds: []CustomKind{kind},
+	}
+	in := "apiVersion: example.com/v1\nkind: Workload\nmetadata:\n  name: hello\n" +
+		"spec:\n  workload:\n    replicas: 3\n    template:\n      spec:\n        containers:\n        - name: hello\n" +
+		"---\napiVersion: example.com/v1\nkind: Workload\nmetadata:\n  name: empty\nspec: {}\n"
+	var out bytes.Buffer
+	report, err := IntoResourceFileWithReport(p, strings.NewReader(in), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Resources) != 2 {
+		t.Fatalf("got %d resources in the report, want 2", len(report.Resources))
+	}
+	if r := report.Resources[0]; !r.Injected || r.Replicas != 3 {
+		t.Errorf("got report %+v, want 3 injected replicas", r)
+	}
+	if r := report.Resources[1]; r.Injected || r.Reason != "no pod template at .spec.workload.template" {
+		t.Errorf("got report %+v, want a missing pod template", r)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// proxyEnvAnnotationKey adds environment variables to the proxy of the
+// pods, as a JSON object of names to values, e.g. {"TRACE_SAMPLING":"10"}.
+// They take precedence over the variables of the parameters.
+const proxyEnvAnnotationKey = "sidecar.wharfie.io/proxyEnv"
+
+// envNamePattern matches the environment variable names accepted by the
+// API server.
+var envNamePattern = regexp.MustCompile(`^[-._a-zA-Z][-._a-zA-Z0-9]*$`)
+
+// ValidateEnvName checks that an environment variable name is accepted
+// by the API server.
+func ValidateEnvName(name string) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	return nil
+}
+
+// proxyEnv returns the extra environment variables of the proxy of the
+// pods of the template, sorted by name.
+func proxyEnv(p *Params, t *v1.PodTemplateSpec) ([]v1.EnvVar, error) {
+	env := make(map[string]string, len(p.ProxyEnv))
+	for name, value := range p.ProxyEnv {
+		env[name] = value
+	}
+	if value, ok := t.Annotations[proxyEnvAnnotationKey]; ok {
+		var overrides map[string]string
+		if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+			return nil, fmt.Errorf("annotation %s must be a JSON object of names to values: %v", proxyEnvAnnotationKey, err)
+		}
+		for name, value := range overrides {
+			env[name] = value
+		}
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		if err := ValidateEnvName(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]v1.EnvVar, 0, len(names))
+	for _, name := range names {
+		vars = append(vars, v1.EnvVar{Name: name, Value: env[name]})
+	}
+	return vars, nil
+}
+
+// addProxyEnv adds the extra environment variables to the proxy. The
+// variables set by the flavor cannot be overridden, as injection
+// depends on them.
+func addProxyEnv(p *Params, t *v1.PodTemplateSpec, c *v1.Container) error {
+	vars, err := proxyEnv(p, t)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		for _, existing := range c.Env {
+			if existing.Name == v.Name {
+				return fmt.Errorf("cannot override the proxy environment variable %s set by injection", v.Name)
+			}
+		}
+	}
+	c.Env = append(c.Env, vars...)
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestAddProxyEnv(t *testing.T) {
+	p := &Params{ProxyEnv: map[string]string{"TRACE_SAMPLING": "1", "FEATURE_X": "on"}}
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        []v1.EnvVar
+		wantErr     bool
+	}{
+		{
+			name: "parameters",
+			want: []v1.EnvVar{{Name: "POD_NAME"}, {Name: "FEATURE_X", Value: "on"}, {Name: "TRACE_SAMPLING", Value: "1"}},
+		},
+		{
+			name:        "annotation",
+			annotations: map[string]string{proxyEnvAnnotationKey: `{"TRACE_SAMPLING":"10","ZIPKIN":"zipkin:9411,zipkin-2:9411"}`},
+			want: []v1.EnvVar{{Name: "POD_NAME"}, {Name: "FEATURE_X", Value: "on"}, {Name: "TRACE_SAMPLING", Value: "10"},
+				{Name: "ZIPKIN", Value: "zipkin:9411,zipkin-2:9411"}},
+		},
+		{
+			name:        "override of injection",
+			annotations: map[string]string{proxyEnvAnnotationKey: `{"POD_NAME":"hello"}`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid name",
+			annotations: map[string]string{proxyEnvAnnotationKey: `{"TRACE SAMPLING":"10"}`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{proxyEnvAnnotationKey: "TRACE_SAMPLING=10"},
+			wantErr:     true,
+		},
+	}
+	for _, c := range cases {
+		template := &v1.PodTemplateSpec{}
+		template.Annotations = c.annotations
+		container := v1.Container{Env: []v1.EnvVar{{Name: "POD_NAME"}}}
+		err := addProxyEnv(p, template, &container)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: addProxyEnv() => got error %v, want error %t", c.name, err, c.wantErr)
+			continue
+		}
+		if !c.wantErr && !reflect.DeepEqual(container.Env, c.want) {
+			t.Errorf("%s: addProxyEnv() => got %v, want %v", c.name, container.Env, c.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"regexp"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Filter selects resources by labels, name, and namespace, so that a
+// bundle of manifests can be onboarded to the mesh in parts. A
+// resource must match every criterion that is set.
+type Filter struct {
+	// Selector matches the labels of the resource.
+	Selector labels.Selector
+	// Name matches the whole name of the resource.
+	Name *regexp.Regexp
+	// Namespaces lists the namespaces of the resources to inject.
+	Namespaces []string
+	// DefaultNamespace is the namespace of resources without one.
+	DefaultNamespace string
+}
+
+// NewFilter parses a label selector and a name regular expression.
+// Empty arguments match every resource.
+func NewFilter(selector, name string, namespaces []string, defaultNamespace string) (*Filter, error) {
+	f := &Filter{Namespaces: namespaces, DefaultNamespace: defaultNamespace}
+	if selector != "" {
+		var err error
+		if f.Selector, err = labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %v", selector, err)
+		}
+	}
+	if name != "" {
+		var err error
+		if f.Name, err = regexp.Compile("^(?:" + name + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid name regular expression %q: %v", name, err)
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether a resource is selected. A nil filter
+// selects every resource.
+func (f *Filter) matches(meta metav1.ObjectMeta) bool {
+	if f == nil {
+		return true
+	}
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(meta.Labels)) {
+		return false
+	}
+	if f.Name != nil && !f.Name.MatchString(meta.Name) {
+		return false
+	}
+	if len(f.Namespaces) > 0 {
+		namespace := meta.Namespace
+		if namespace == "" {
+			namespace = f.DefaultNamespace
+		}
+		for _, ns := range f.Namespaces {
+			if ns == namespace {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilter(t *testing.T) {
+	onboard := map[string]string{"mesh": "onboard"}
+	cases := []struct {
+		filter *Filter
+		meta   metav1.ObjectMeta
+		want   bool
+	}{
+		{filter: nil, meta: metav1.ObjectMeta{Name: "a"}, want: true},
+		{filter: mustFilter(t, "", "", nil), meta: metav1.ObjectMeta{Name: "a"}, want: true},
+		{filter: mustFilter(t, "mesh=onboard", "", nil), meta: metav1.ObjectMeta{Name: "a", Labels: onboard}, want: true},
+		{filter: mustFilter(t, "mesh=onboard", "", nil), meta: metav1.ObjectMeta{Name: "a"}, want: false},
+		{filter: mustFilter(t, "!mesh", "", nil), meta: metav1.ObjectMeta{Name: "a"}, want: true},
+		{filter: mustFilter(t, "", "front.*", nil), meta: metav1.ObjectMeta{Name: "frontend"}, want: true},
+		{filter: mustFilter(t, "", "front", nil), meta: metav1.ObjectMeta{Name: "frontend"}, want: false},
+		{filter: mustFilter(t, "", "", []string{"web"}), meta: metav1.ObjectMeta{Name: "a", Namespace: "web"}, want: true},
+		{filter: mustFilter(t, "", "", []string{"web"}), meta: metav1.ObjectMeta{Name: "a", Namespace: "batch"}, want: false},
+		{filter: mustFilter(t, "", "", []string{"default"}), meta: metav1.ObjectMeta{Name: "a"}, want: true},
+	}
+	for _, c := range cases {
+		if got := c.filter.matches(c.meta); got != c.want {
+			t.Errorf("%+v matches %+v => got %t, want %t", c.filter, c.meta, got, c.want)
+		}
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	if _, err := NewFilter("mesh in (", "", nil, ""); err == nil {
+		t.Error("NewFilter() accepted an invalid selector")
+	}
+	if _, err := NewFilter("", "front(", nil, ""); err == nil {
+		t.Error("NewFilter() accepted an invalid regular expression")
+	}
+}
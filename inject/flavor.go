@@ -0,0 +1,272 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// ProxyFlavor builds the data plane injected into pod templates. The
+// injection machinery shared by all flavors handles the annotations,
+// the init containers annotation, core dumps, resources, identity, and
+// security context hardening.
+type ProxyFlavor interface {
+	// InitContainer returns the init container that redirects the
+	// traffic of the pod to the proxy, in the form written to the init
+	// containers annotation, or nil if none is needed.
+	InitContainer(p *Params, t *v1.PodTemplateSpec) (map[string]interface{}, error)
+	// Container returns the proxy container for a pod template of a
+	// resource in the given namespace, and the volumes it mounts.
+	Container(p *Params, namespace string, t *v1.PodTemplateSpec) (v1.Container, []v1.Volume, error)
+}
+
+// IstioProxy is the default flavor that injects the istio init
+// container and proxy agent.
+type IstioProxy struct{}
+
+// InitContainer implements ProxyFlavor.
+func (IstioProxy) InitContainer(p *Params, t *v1.PodTemplateSpec) (map[string]interface{}, error) {
+	settings, err := redirection(p, t)
+	if err != nil {
+		return nil, err
+	}
+	var initArgs []string
+	for _, setting := range settings {
+		initArgs = append(initArgs, setting.flag, setting.value)
+	}
+	return map[string]interface{}{
+		"name":            initContainerName,
+		"image":           p.InitImage,
+		"args":            initArgs,
+		"imagePullPolicy": "Always",
+		// The init container runs as root to program iptables and is
+		// granted exactly the capabilities it needs.
+		"securityContext": map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"add":  []string{"NET_ADMIN", "NET_RAW"},
+				"drop": []string{"ALL"},
+			},
+			"privileged":               false,
+			"allowPrivilegeEscalation": false,
+		},
+	}, nil
+}
+
+// validateInterfaces checks a comma separated list of network interface
+// names.
+func validateInterfaces(list string) error {
+	for _, name := range strings.Split(list, ",") {
+		if name == "" || len(name) > 15 || strings.ContainsAny(name, "/: \t") {
+			return fmt.Errorf("invalid network interface name %q in %q", name, list)
+		}
+	}
+	return nil
+}
+
+// validatePorts checks a comma separated list of port numbers.
+func validatePorts(list string) error {
+	for _, port := range strings.Split(list, ",") {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q in %q", port, list)
+		}
+	}
+	return nil
+}
+
+// SDSSocket returns the address of the node agent socket in the SDS
+// directory.
+func SDSSocket(dir string) string {
+	return "unix:" + path.Join(dir, "uds_path")
+}
+
+// serviceAccount returns the service account the pods of the template
+// run as.
+func serviceAccount(t *v1.PodTemplateSpec) string {
+	if t.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return t.Spec.ServiceAccountName
+}
+
+// proxySecurityContext returns the minimal security context of a proxy
+// running as the given user: unprivileged, without capabilities, and
+// non-root unless the user is root. allowPrivilegeEscalation is added
+// when the resource is written out, see disallowPrivilegeEscalation.
+func proxySecurityContext(uid int64) *v1.SecurityContext {
+	nonRoot, privileged := uid != 0, false
+	return &v1.SecurityContext{
+		RunAsUser:    &uid,
+		RunAsNonRoot: &nonRoot,
+		Privileged:   &privileged,
+		Capabilities: &v1.Capabilities{
+			Drop: []v1.Capability{"ALL"},
+		},
+	}
+}
+
+// Container implements ProxyFlavor.
+func (IstioProxy) Container(p *Params, namespace string, t *v1.PodTemplateSpec) (v1.Container, []v1.Volume, error) {
+	args := []string{
+		"proxy",
+		"sidecar",
+	}
+
+	if p.Verbosity > 0 {
+		args = append(args, "-v", strconv.Itoa(p.Verbosity))
+	}
+	if p.MeshConfigMapName != "" {
+		args = append(args, "--meshConfig", p.MeshConfigMapName)
+	}
+
+	ports, err := healthPorts(t)
+	if err != nil {
+		return v1.Container{}, nil, err
+	}
+	for _, port := range ports {
+		args = append(args, "--passthrough", strconv.Itoa(port))
+	}
+
+	// Workloads can override the control plane and telemetry addresses
+	// of the mesh, e.g. to talk to a staging control plane.
+	for _, override := range []struct{ key, flag string }{
+		{discoveryAddressAnnotationKey, "--discoveryAddress"},
+		{zipkinAddressAnnotationKey, "--zipkinAddress"},
+		{statsdUDPAddressAnnotationKey, "--statsdUdpAddress"},
+	} {
+		if value, ok := t.Annotations[override.key]; ok {
+			if value == "" {
+				return v1.Container{}, nil, fmt.Errorf("annotation %s must not be empty", override.key)
+			}
+			args = append(args, override.flag, value)
+		}
+	}
+
+	// The auth policy of the mesh can be overridden for a workload.
+	authPolicy := p.Mesh.AuthPolicy
+	if value, ok := t.Annotations[authPolicyAnnotationKey]; ok {
+		policy, ok := proxyconfig.ProxyMeshConfig_AuthPolicy_value[value]
+		if !ok {
+			return v1.Container{}, nil, fmt.Errorf("annotation %s has unknown auth policy %q", authPolicyAnnotationKey, value)
+		}
+		authPolicy = proxyconfig.ProxyMeshConfig_AuthPolicy(policy)
+		args = append(args, "--authPolicy", authPolicy.String())
+	}
+
+	var volumeMounts []v1.VolumeMount
+	var volumes []v1.Volume
+	if authPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS && p.SDSPath != "" {
+		// The node agent identifies the proxy by its service account
+		// token. The kubernetes types used for injection cannot
+		// project a token for the proxy alone.
+		if automount := t.Spec.AutomountServiceAccountToken; automount != nil && !*automount {
+			return v1.Container{}, nil, fmt.Errorf("the proxy needs the service account token to fetch its certificates "+
+				"from the node agent, but the pod sets automountServiceAccountToken: false; enable token automounting "+
+				"or set annotation %s to NONE", authPolicyAnnotationKey)
+		}
+		args = append(args, "--sdsUdsPath", SDSSocket(p.SDSPath))
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      sdsVolumeName,
+			MountPath: p.SDSPath,
+		})
+		volumes = append(volumes, v1.Volume{
+			Name: sdsVolumeName,
+			VolumeSource: v1.VolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: p.SDSPath,
+				},
+			},
+		})
+	} else if authPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      CertVolumeName,
+			ReadOnly:  true,
+			MountPath: p.Mesh.AuthCertsPath,
+		})
+
+		secretName, err := p.CertSecretName(namespace, serviceAccount(t))
+		if err != nil {
+			return v1.Container{}, nil, err
+		}
+		volumes = append(volumes, v1.Volume{
+			Name: CertVolumeName,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: secretName,
+				},
+			},
+		})
+	}
+
+	sidecar := v1.Container{
+		Name:  p.proxyName(),
+		Image: p.ProxyImage,
+		Args:  args,
+		Env: []v1.EnvVar{{
+			Name: "POD_NAME",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "metadata.name",
+				},
+			},
+		}, {
+			Name: "POD_NAMESPACE",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "metadata.namespace",
+				},
+			},
+		}, {
+			Name: "POD_IP",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "status.podIP",
+				},
+			},
+		}},
+		// The ports are declared for tooling that discovers them from
+		// the pod spec, e.g. network policies and metrics scrapers.
+		Ports:           p.ProxyPorts.containerPorts(p.Mesh),
+		ImagePullPolicy: v1.PullAlways,
+		SecurityContext: proxySecurityContext(p.SidecarProxyUID),
+		VolumeMounts:    volumeMounts,
+	}
+	if network := t.Labels[NetworkLabel]; network != "" {
+		sidecar.Env = append(sidecar.Env, v1.EnvVar{
+			Name:  "ISTIO_META_NETWORK",
+			Value: network,
+		})
+	}
+	if p.TrustDomain != "" {
+		sidecar.Env = append(sidecar.Env, v1.EnvVar{
+			Name:  "ISTIO_TRUST_DOMAIN",
+			Value: p.TrustDomain,
+		}, v1.EnvVar{
+			Name: "POD_SERVICE_ACCOUNT",
+			ValueFrom: &v1.EnvVarSource{
+				FieldRef: &v1.ObjectFieldSelector{
+					FieldPath: "spec.serviceAccountName",
+				},
+			},
+		})
+	}
+	return sidecar, volumes, nil
+}
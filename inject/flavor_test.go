@@ -0,0 +1,163 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// envoyFlavor injects a plain Envoy without traffic redirection.
+type envoyFlavor struct{}
+
+func (envoyFlavor) InitContainer(*Params, *v1.PodTemplateSpec) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (envoyFlavor) Container(p *Params, _ string, _ *v1.PodTemplateSpec) (v1.Container, []v1.Volume, error) {
+	return v1.Container{
+		Name:         "envoy",
+		Image:        "envoyproxy/envoy",
+		VolumeMounts: []v1.VolumeMount{{Name: "envoy-config", MountPath: "/etc/envoy"}},
+	}, []v1.Volume{{
+		Name: "envoy-config",
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "envoy"},
+			},
+		},
+	}}, nil
+}
+
+func TestProxyFlavor(t *testing.T) {
+	params := Params{
+		Version: "12345678",
+		Flavor:  envoyFlavor{},
+		ProxyResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")},
+		},
+		HardenedSecurityContext: true,
+	}
+	template := v1.PodTemplateSpec{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+	if err := IntoPodTemplateSpec(&params, "default", &template); err != nil {
+		t.Fatalf("IntoPodTemplateSpec() returned an error: %v", err)
+	}
+
+	if got, ok := template.Annotations[initContainersAnnotationKey]; ok {
+		t.Errorf("init containers annotation => got %q, want none", got)
+	}
+	var names []string
+	for _, c := range template.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if want := []string{"app", "envoy"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("containers => got %v, want %v", names, want)
+	}
+	if len(template.Spec.Volumes) != 1 || template.Spec.Volumes[0].Name != "envoy-config" {
+		t.Errorf("volumes => got %v, want envoy-config", template.Spec.Volumes)
+	}
+	envoy := template.Spec.Containers[1]
+	if cpu := envoy.Resources.Requests.Cpu().String(); cpu != "100m" {
+		t.Errorf("envoy cpu request => got %s, want 100m", cpu)
+	}
+	if envoy.SecurityContext == nil || envoy.SecurityContext.RunAsNonRoot == nil || !*envoy.SecurityContext.RunAsNonRoot {
+		t.Errorf("envoy security context => got %v, want runAsNonRoot", envoy.SecurityContext)
+	}
+}
+
+func TestIstioProxySDSServiceAccountToken(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	automount := false
+	cases := []struct {
+		name    string
+		sdsPath string
+		wantErr bool
+	}{
+		{name: "SDS", sdsPath: DefaultSDSPath, wantErr: true},
+		{name: "secret"},
+	}
+	for _, c := range cases {
+		params := &Params{ProxyImage: ProxyImageName(unitTestHub, unitTestTag), Mesh: &mesh, SDSPath: c.sdsPath}
+		template := &v1.PodTemplateSpec{Spec: v1.PodSpec{AutomountServiceAccountToken: &automount}}
+		_, _, err := IstioProxy{}.Container(params, "default", template)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: Container() => got error %v, want error %t", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestValidateInterfaces(t *testing.T) {
+	cases := []struct {
+		list    string
+		wantErr bool
+	}{
+		{list: "net1"},
+		{list: "net1,eth1.100"},
+		{list: "net1,", wantErr: true},
+		{list: "net 1", wantErr: true},
+		{list: "averyveryverylongname", wantErr: true},
+	}
+	for _, c := range cases {
+		if err := validateInterfaces(c.list); (err != nil) != c.wantErr {
+			t.Errorf("validateInterfaces(%q) => got error %v, want error %t", c.list, err, c.wantErr)
+		}
+	}
+}
+
+func TestValidatePorts(t *testing.T) {
+	cases := []struct {
+		list    string
+		wantErr bool
+	}{
+		{list: "8080"},
+		{list: "15000,9090"},
+		{list: "8080,", wantErr: true},
+		{list: "0", wantErr: true},
+		{list: "65536", wantErr: true},
+		{list: "http", wantErr: true},
+	}
+	for _, c := range cases {
+		if err := validatePorts(c.list); (err != nil) != c.wantErr {
+			t.Errorf("validatePorts(%q) => got error %v, want error %t", c.list, err, c.wantErr)
+		}
+	}
+}
+
+func TestValidateIDs(t *testing.T) {
+	cases := []struct {
+		list    string
+		wantErr bool
+	}{
+		{list: "0"},
+		{list: "1000,1001"},
+		{list: "1000,", wantErr: true},
+		{list: "-1", wantErr: true},
+		{list: "nobody", wantErr: true},
+	}
+	for _, c := range cases {
+		if err := validateIDs(c.list); (err != nil) != c.wantErr {
+			t.Errorf("validateIDs(%q) => got error %v, want error %t", c.list, err, c.wantErr)
+		}
+	}
+}
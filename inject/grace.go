@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// manageTerminationGracePeriodAnnotationKey set to false keeps the
+// termination grace period of the pods as is.
+const manageTerminationGracePeriodAnnotationKey = "sidecar.wharfie.io/manageTerminationGracePeriod"
+
+// terminationDrainDurationAnnotationKey overrides the termination drain
+// duration of the pods, e.g. 20s.
+const terminationDrainDurationAnnotationKey = "sidecar.wharfie.io/terminationDrainDuration"
+
+// defaultTerminationGracePeriod is the grace period of pods that do
+// not set one.
+const defaultTerminationGracePeriod = 30 * time.Second
+
+// proxyDrainDuration returns how long the proxy drains connections
+// when the pod terminates: the termination drain duration of its
+// preStop hook followed by the drain duration of the mesh.
+func proxyDrainDuration(p *Params) (time.Duration, error) {
+	if p.Mesh == nil || p.Mesh.DrainDuration == nil {
+		return p.TerminationDrainDuration, nil
+	}
+	drain, err := ptypes.Duration(p.Mesh.DrainDuration)
+	if err != nil {
+		return 0, err
+	}
+	return p.TerminationDrainDuration + drain, nil
+}
+
+// addDrainHook delays the exit of the proxy by the termination drain
+// duration with a preStop hook, so that the proxy keeps serving
+// in-flight requests while the endpoints of the terminating pod are
+// removed. A hook set by the flavor is kept.
+func addDrainHook(p *Params, c *v1.Container) {
+	if p.TerminationDrainDuration <= 0 || c.Lifecycle != nil && c.Lifecycle.PreStop != nil {
+		return
+	}
+	if c.Lifecycle == nil {
+		c.Lifecycle = &v1.Lifecycle{}
+	}
+	seconds := int64((p.TerminationDrainDuration + time.Second - 1) / time.Second)
+	c.Lifecycle.PreStop = &v1.Handler{
+		Exec: &v1.ExecAction{Command: []string{"sleep", strconv.FormatInt(seconds, 10)}},
+	}
+}
+
+// terminationGracePeriod returns the termination grace period of the
+// pods of the template.
+func terminationGracePeriod(t *v1.PodTemplateSpec) time.Duration {
+	if t.Spec.TerminationGracePeriodSeconds == nil {
+		return defaultTerminationGracePeriod
+	}
+	return time.Duration(*t.Spec.TerminationGracePeriodSeconds) * time.Second
+}
+
+// raiseTerminationGracePeriod makes the termination grace period of the
+// pods at least the drain duration of the proxy, up to
+// MaxTerminationGracePeriod if set, so that the kubelet does not kill
+// the proxy while it drains in-flight requests. Pods can opt out with
+// an annotation.
+func raiseTerminationGracePeriod(p *Params, t *v1.PodTemplateSpec) error {
+	if value, ok := t.Annotations[manageTerminationGracePeriodAnnotationKey]; ok {
+		manage, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("annotation %s must be true or false, got %q", manageTerminationGracePeriodAnnotationKey, value)
+		}
+		if !manage {
+			return nil
+		}
+	}
+	drain, err := proxyDrainDuration(p)
+	if err != nil {
+		return err
+	}
+	if p.MaxTerminationGracePeriod > 0 && drain > p.MaxTerminationGracePeriod {
+		drain = p.MaxTerminationGracePeriod
+	}
+	if drain <= terminationGracePeriod(t) {
+		return nil
+	}
+	seconds := int64((drain + time.Second - 1) / time.Second)
+	t.Spec.TerminationGracePeriodSeconds = &seconds
+	return nil
+}
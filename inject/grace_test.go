@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestRaiseTerminationGracePeriod(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	cases := []struct {
+		name        string
+		drain       time.Duration
+		max         time.Duration
+		grace       *int64
+		annotations map[string]string
+		want        *int64
+		wantErr     bool
+	}{
+		{name: "default grace period", drain: 5 * time.Second},
+		{name: "longer grace period", drain: 5 * time.Second, grace: seconds(10), want: seconds(10)},
+		{name: "raised", drain: 45 * time.Second, want: seconds(45)},
+		{name: "rounded up", drain: 1500 * time.Millisecond, grace: seconds(1), want: seconds(2)},
+		{name: "capped", drain: 90 * time.Second, max: time.Minute, want: seconds(60)},
+		{name: "cap below grace period", drain: 90 * time.Second, max: 10 * time.Second, grace: seconds(20), want: seconds(20)},
+		{
+			name:        "opted out",
+			drain:       45 * time.Second,
+			annotations: map[string]string{manageTerminationGracePeriodAnnotationKey: "false"},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{manageTerminationGracePeriodAnnotationKey: "sometimes"},
+			wantErr:     true,
+		},
+	}
+	for _, c := range cases {
+		mesh := proxy.DefaultMeshConfig()
+		mesh.DrainDuration = ptypes.DurationProto(c.drain)
+		p := &Params{Mesh: &mesh, MaxTerminationGracePeriod: c.max}
+		template := &v1.PodTemplateSpec{}
+		template.Annotations = c.annotations
+		template.Spec.TerminationGracePeriodSeconds = c.grace
+		err := raiseTerminationGracePeriod(p, template)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: raiseTerminationGracePeriod() => got error %v, want error %t", c.name, err, c.wantErr)
+			continue
+		}
+		got := template.Spec.TerminationGracePeriodSeconds
+		if (got == nil) != (c.want == nil) || got != nil && *got != *c.want {
+			t.Errorf("%s: raiseTerminationGracePeriod() => got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAddDrainHook(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.DrainDuration = ptypes.DurationProto(5 * time.Second)
+	p := &Params{Mesh: &mesh, TerminationDrainDuration: 1500 * time.Millisecond}
+	if drain, err := proxyDrainDuration(p); err != nil || drain != 6500*time.Millisecond {
+		t.Errorf("proxyDrainDuration() => got %v, %v, want the hook and mesh drain durations", drain, err)
+	}
+
+	var c v1.Container
+	addDrainHook(p, &c)
+	if c.Lifecycle == nil || c.Lifecycle.PreStop == nil || c.Lifecycle.PreStop.Exec == nil {
+		t.Fatalf("addDrainHook() => got lifecycle %v, want a preStop command", c.Lifecycle)
+	}
+	if got := strings.Join(c.Lifecycle.PreStop.Exec.Command, " "); got != "sleep 2" {
+		t.Errorf("addDrainHook() => got command %q, want %q", got, "sleep 2")
+	}
+
+	own := &v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/drain"}}
+	c = v1.Container{Lifecycle: &v1.Lifecycle{PreStop: own}}
+	addDrainHook(p, &c)
+	if c.Lifecycle.PreStop != own {
+		t.Errorf("addDrainHook() replaced the preStop hook of the flavor with %v", c.Lifecycle.PreStop)
+	}
+
+	c = v1.Container{}
+	addDrainHook(&Params{Mesh: &mesh}, &c)
+	if c.Lifecycle != nil {
+		t.Errorf("addDrainHook() without a termination drain duration => got lifecycle %v", c.Lifecycle)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// runAsGroup returns a patch that sets the primary group of the named
+// container of the pod spec. The field is newer than the kubernetes
+// types used for injection.
+func runAsGroup(gid int64, name string) PodSpecPatch {
+	return func(spec map[string]interface{}) error {
+		list, _ := spec["containers"].([]interface{})
+		for _, item := range list {
+			container, ok := item.(map[string]interface{})
+			if !ok || fmt.Sprint(container["name"]) != name {
+				continue
+			}
+			securityContext, ok := container["securityContext"].(map[string]interface{})
+			if !ok {
+				securityContext = make(map[string]interface{})
+				container["securityContext"] = securityContext
+			}
+			securityContext["runAsGroup"] = gid
+			return nil
+		}
+		return fmt.Errorf("cannot find injected container %s in the pod template", name)
+	}
+}
+
+// PatchRunAsGroup sets the primary group of the proxy of the
+// parameters, if any, on the proxy of an injected pod spec marshaled
+// from the pod template. It must be applied before PatchNativeSidecar.
+func PatchRunAsGroup(p *Params, t *v1.PodTemplateSpec, spec map[string]interface{}) error {
+	if p.ProxyRunAsGroup == nil {
+		return nil
+	}
+	return runAsGroup(*p.ProxyRunAsGroup, injectedProxyName(t))(spec)
+}
+
+// addFSGroup sets the fsGroup of the parameters, if any, on pods that
+// do not set one, so that the volumes of the proxy, e.g. the mounted
+// certificate secrets, are readable by the group.
+func addFSGroup(p *Params, t *v1.PodTemplateSpec) {
+	if p.FSGroup == nil {
+		return
+	}
+	if t.Spec.SecurityContext == nil {
+		t.Spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	if t.Spec.SecurityContext.FSGroup == nil {
+		fsGroup := *p.FSGroup
+		t.Spec.SecurityContext.FSGroup = &fsGroup
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestRunAsGroup(t *testing.T) {
+	got, err := patchPodSpec([]byte(securityDeployment), runAsGroup(1337, "proxy"))
+	if err != nil {
+		t.Fatalf("runAsGroup() returned an error: %v", err)
+	}
+	want := `        securityContext:
+          runAsGroup: 1337
+          runAsUser: 1337
+`
+	if !strings.Contains(string(got), want) || strings.Count(string(got), "runAsGroup") != 1 {
+		t.Errorf("runAsGroup() => got\n%s\nwant only the proxy to contain\n%s", got, want)
+	}
+
+	if _, err = patchPodSpec([]byte(securityDeployment), runAsGroup(1337, "missing")); err == nil {
+		t.Error("runAsGroup() succeeded for a missing container")
+	}
+}
+
+func TestAddFSGroup(t *testing.T) {
+	gid, podGID := int64(1337), int64(2000)
+	p := &Params{FSGroup: &gid}
+	template := &v1.PodTemplateSpec{}
+	addFSGroup(p, template)
+	if sc := template.Spec.SecurityContext; sc == nil || sc.FSGroup == nil || *sc.FSGroup != gid {
+		t.Errorf("addFSGroup() => got pod security context %v, want fsGroup %d", sc, gid)
+	}
+
+	template.Spec.SecurityContext.FSGroup = &podGID
+	addFSGroup(p, template)
+	if *template.Spec.SecurityContext.FSGroup != podGID {
+		t.Errorf("addFSGroup() => got fsGroup %d, want the fsGroup %d of the pod", *template.Spec.SecurityContext.FSGroup, podGID)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import "testing"
+
+func TestValidateImage(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	cases := []struct {
+		image string
+		valid bool
+	}{
+		{image: "proxy", valid: true},
+		{image: "docker.io/istio/proxy_debug:0.1", valid: true},
+		{image: "registry.example.com:5000/platform/mesh/envoy-proxy:v1.2.3", valid: true},
+		{image: "localhost:5000/proxy", valid: true},
+		{image: "docker.io/istio/proxy@" + digest, valid: true},
+		{image: "docker.io/istio/proxy:1.0@" + digest, valid: true},
+		{image: ""},
+		{image: "docker.io/Istio/proxy:1.0"},
+		{image: "docker.io/istio/proxy:"},
+		{image: "docker.io/istio/proxy@sha256:short"},
+		{image: "docker.io//proxy"},
+		{image: "https://docker.io/istio/proxy"},
+	}
+	for _, c := range cases {
+		if err := ValidateImage(c.image); (err == nil) != c.valid {
+			t.Errorf("ValidateImage(%q) => got error %v, want valid %v", c.image, err, c.valid)
+		}
+	}
+}
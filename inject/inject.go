@@ -20,20 +20,25 @@ package inject
 // admission controller is written for istio.
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"regexp"
 	"sort"
 	"strconv"
+	"text/template"
+	"time"
 
 	"github.com/ghodss/yaml"
 	multierror "github.com/hashicorp/go-multierror"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
 	batch "k8s.io/client-go/pkg/apis/batch/v1"
+	"k8s.io/client-go/pkg/apis/batch/v2alpha1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
@@ -46,17 +51,61 @@ const (
 	DefaultVerbosity       = 2
 )
 
+// DefaultCertSecretTemplate names the certificate secret created by the
+// Istio CA for every service account.
+const DefaultCertSecretTemplate = "istio.{{.ServiceAccount}}"
+
+// DefaultSDSPath is the node directory that holds the socket of the
+// node agent serving secrets to the proxies.
+const DefaultSDSPath = "/var/run/sds"
+
+// sdsVolumeName is the name of the volume that mounts the node agent
+// socket directory into the proxy.
+const sdsVolumeName = "sds-uds-path"
+
+// CertVolumeName is the name of the volume that mounts the workload
+// certificate secret into the proxy when mutual TLS is enabled.
+const CertVolumeName = "istio-certs"
+
+// NetworkLabel identifies the network of a workload in a mesh that
+// spans several networks, e.g. clusters in different VPCs.
+const NetworkLabel = "topology.istio.io/network"
+
 const (
 	istioSidecarAnnotationSidecarKey   = "alpha.istio.io/sidecar"
 	istioSidecarAnnotationSidecarValue = "injected"
 	istioSidecarAnnotationVersionKey   = "alpha.istio.io/version"
+	istioIdentityAnnotationKey         = "alpha.istio.io/identity"
+	authPolicyAnnotationKey            = "sidecar.wharfie.io/authPolicy"
+	discoveryAddressAnnotationKey      = "sidecar.wharfie.io/discoveryAddress"
+	zipkinAddressAnnotationKey         = "sidecar.wharfie.io/zipkinAddress"
+	statsdUDPAddressAnnotationKey      = "sidecar.wharfie.io/statsdUdpAddress"
+	enableCoreDumpAnnotationKey        = "sidecar.wharfie.io/enableCoreDump"
+	shareProcessNamespaceAnnotationKey = "sidecar.wharfie.io/shareProcessNamespace"
+	excludeInterfacesAnnotationKey     = "sidecar.wharfie.io/excludeInterfaces"
+	excludeInboundPortsAnnotationKey   = "sidecar.wharfie.io/excludeInboundPorts"
+	excludeIPRangesAnnotationKey       = "sidecar.wharfie.io/excludeIPRanges"
+	includeOutboundPortsAnnotationKey  = "sidecar.wharfie.io/includeOutboundPorts"
+	excludeOutboundPortsAnnotationKey  = "sidecar.wharfie.io/excludeOutboundPorts"
+	interceptionModeAnnotationKey      = "sidecar.wharfie.io/interceptionMode"
+	proxyPortAnnotationKey             = "sidecar.wharfie.io/proxyPort"
+	proxyUIDAnnotationKey              = "sidecar.wharfie.io/proxyUID"
+	excludeUIDsAnnotationKey           = "sidecar.wharfie.io/excludeUIDs"
+	excludeGIDsAnnotationKey           = "sidecar.wharfie.io/excludeGIDs"
+	proxyImageAnnotationKey            = "sidecar.wharfie.io/proxyImage"
+	initImageAnnotationKey             = "sidecar.wharfie.io/initImage"
+	proxyCPUAnnotationKey              = "sidecar.wharfie.io/proxyCPU"
+	proxyMemoryAnnotationKey           = "sidecar.wharfie.io/proxyMemory"
+	proxyCPULimitAnnotationKey         = "sidecar.wharfie.io/proxyCPULimit"
+	proxyMemoryLimitAnnotationKey      = "sidecar.wharfie.io/proxyMemoryLimit"
+	includeIPRangesAnnotationKey       = "sidecar.wharfie.io/includeIPRanges"
+	verbosityAnnotationKey             = "sidecar.wharfie.io/verbosity"
+	injectAnnotationKey                = "sidecar.wharfie.io/inject"
+	initContainersAnnotationKey        = "pod.beta.kubernetes.io/init-containers"
 	initContainerName                  = "init"
 	proxyContainerName                 = "proxy"
 	enableCoreDumpContainerName        = "enable-core-dump"
 	enableCoreDumpImage                = "alpine"
-
-	istioCertVolumeName   = "istio-certs"
-	istioCertSecretPrefix = "istio."
 )
 
 // InitImageName returns the fully qualified image name for the istio
@@ -67,6 +116,16 @@ func InitImageName(hub, tag string) string { return hub + "/init:" + tag }
 // proxy image given a docker hub and tag.
 func ProxyImageName(hub, tag string) string { return hub + "/proxy_debug:" + tag }
 
+// ProxyReleaseImageName returns the fully qualified image name for the
+// istio proxy image without debug tooling.
+func ProxyReleaseImageName(hub, tag string) string { return hub + "/proxy:" + tag }
+
+// MeshSource provides the current mesh configuration, e.g. from a
+// ConfigMap in the cluster.
+type MeshSource interface {
+	Mesh() (*proxyconfig.ProxyMeshConfig, error)
+}
+
 // Params describes configurable parameters for injecting istio proxy
 // into kubernetes resource.
 type Params struct {
@@ -82,146 +141,428 @@ type Params struct {
 	// redirect outbound traffic to Envoy for these IP
 	// ranges. Otherwise all outbound traffic is redirected to Envoy.
 	IncludeIPRanges string
+	// Comma separated list of network interfaces whose traffic is not
+	// redirected to Envoy, e.g. secondary interfaces for storage.
+	ExcludeInterfaces string
+	// ProxyResources are the compute resources of the injected proxy
+	// container.
+	ProxyResources v1.ResourceRequirements
+	// CertSecretTemplate is a text/template over the Namespace and
+	// ServiceAccount of the pod that names the certificate secret
+	// mounted with mutual TLS, DefaultCertSecretTemplate if empty.
+	CertSecretTemplate string
+	// TrustDomain of the mesh. If set, the proxy is told its trust
+	// domain and service account, and the pod is annotated with its
+	// SPIFFE identity when the namespace of the resource is known.
+	TrustDomain string
+	// SDSPath selects the SDS identity mode: with mutual TLS the proxy
+	// fetches its certificates from the node agent listening on a
+	// socket in this node directory instead of mounting a secret.
+	SDSPath string
+	// HardenedSecurityContext requires the proxy to run as non-root and
+	// unprivileged, even with a root SidecarProxyUID or a flavor that
+	// does not set a security context.
+	HardenedSecurityContext bool
+	// Sizing, if set, sizes the proxy relative to the application
+	// containers of every pod, falling back to ProxyResources.
+	Sizing *SizingPolicy
+	// Flavor builds the injected data plane, IstioProxy if nil.
+	Flavor ProxyFlavor
+	// MeshSource, if set, replaces Mesh at every injection.
+	MeshSource MeshSource
+	// ShareProcessNamespace lets the proxy observe the processes of
+	// the application, e.g. to exit with the application of a Job. The
+	// field is only written to resource files, as the kubernetes types
+	// used for injection predate it.
+	ShareProcessNamespace bool
+	// Limits, if set, checks the proxy resources against the
+	// LimitRanges of the namespace of every resource.
+	Limits *LimitPolicy
+	// MaxTerminationGracePeriod caps the termination grace period that
+	// injection raises to the proxy drain duration, uncapped if zero.
+	MaxTerminationGracePeriod time.Duration
+	// Filter, if set, restricts injection to the matching resources of
+	// a file. Other resources are written out unchanged.
+	Filter *Filter
+	// Network of the mesh the workloads belong to. If set, pod
+	// templates are labeled with it unless they set their own.
+	Network string
+	// CustomKinds locate the pod templates of the resources of custom
+	// kinds to inject.
+	CustomKinds []CustomKind
+	// SkipTekton passes Tekton TaskRuns and PipelineRuns through
+	// unchanged, e.g. when their own sidecars conflict with the proxy.
+	SkipTekton bool
+	// OmitUnchanged leaves out the resources that injection did not
+	// change from the output, including the unchanged items of Lists.
+	OmitUnchanged bool
+	// Policy injects every workload by default if enabled or empty, or
+	// only those that opt in if disabled.
+	Policy InjectionPolicy
+	// Namespaces, if set, restricts injection to the namespaces that
+	// opted in to the mesh.
+	Namespaces *NamespacePolicy
+	// InitResources are the compute resources of the injected init
+	// container, e.g. equal requests and limits to keep Guaranteed
+	// pods Guaranteed.
+	InitResources v1.ResourceRequirements
+	// ImagePullSecrets are added to the pod templates, so that the
+	// proxy and init images can be pulled from a private registry
+	// without configuring every service account.
+	ImagePullSecrets []string
+	// Comma separated list of inbound ports whose traffic is not
+	// redirected to Envoy, e.g. debugging ports or protocols Envoy
+	// cannot proxy.
+	ExcludeInboundPorts string
+	// Comma separated list of IP ranges in CIDR form whose outbound
+	// traffic is never redirected to Envoy, even if IncludeIPRanges
+	// covers them, e.g. cloud metadata endpoints.
+	ExcludeIPRanges string
+	// Comma separated list of outbound ports. If set, only outbound
+	// traffic to these ports is redirected to Envoy, e.g. 80,443.
+	IncludeOutboundPorts string
+	// Comma separated list of outbound ports whose traffic is never
+	// redirected to Envoy.
+	ExcludeOutboundPorts string
+	// InterceptionMode selects how traffic is redirected to the proxy,
+	// InterceptionIptables if empty.
+	InterceptionMode InterceptionMode
+	// Comma separated list of user IDs whose traffic is not redirected
+	// to Envoy, like the traffic of SidecarProxyUID, e.g. for pods that
+	// run several processes under different users.
+	ExcludeUIDs string
+	// Comma separated list of group IDs whose traffic is not
+	// redirected to Envoy.
+	ExcludeGIDs string
+	// TerminationDrainDuration, if set, delays the exit of the proxy
+	// with a preStop hook, so that in-flight requests drain during
+	// rollouts instead of failing with 503s.
+	TerminationDrainDuration time.Duration
+	// HoldApplicationUntilProxyStarts places the proxy first in the
+	// pods and holds the start of the application containers until the
+	// proxy is ready, so that their first outbound calls do not fail.
+	HoldApplicationUntilProxyStarts bool
+	// SidecarMode selects how the proxy is added to the pods,
+	// SidecarContainer if empty. SidecarAuto must be resolved first.
+	SidecarMode SidecarMode
+	// ProxyEnv are extra environment variables of the proxy, e.g.
+	// feature flags or tracing endpoints.
+	ProxyEnv map[string]string
+	// ProxyVolumes are extra volumes added to the pods alongside the
+	// proxy, e.g. a custom CA bundle.
+	ProxyVolumes []v1.Volume
+	// ProxyVolumeMounts are extra mounts of the proxy, of ProxyVolumes
+	// or of the volumes of the pods.
+	ProxyVolumeMounts []v1.VolumeMount
+	// ExtraProxyArgs are appended to the arguments of the proxy, e.g.
+	// flags of newer proxies that injection does not know about.
+	ExtraProxyArgs []string
+	// Concurrency is the number of worker threads of the proxy, which
+	// otherwise runs a worker per core of the node.
+	Concurrency int
+	// ConcurrencyFromCPULimit derives the worker threads of the proxy
+	// from its CPU limit if Concurrency is not set.
+	ConcurrencyFromCPULimit bool
+	// ProxyContainerName is the name of the proxy container, "proxy"
+	// if empty, e.g. for workloads with a container of that name.
+	ProxyContainerName string
+	// CoreDump configures how core dumps are enabled, with a privileged
+	// init container setting the core pattern to /tmp if nil.
+	CoreDump *CoreDumpPolicy
+	// ReadOnlyRootFilesystem makes the root filesystem of the proxy
+	// read-only, with emptyDir volumes for the paths it writes to, so
+	// that injected pods pass the restricted pod security standard
+	// along with HardenedSecurityContext.
+	ReadOnlyRootFilesystem bool
+	// SeccompProfile is the seccomp profile of the injected init and
+	// proxy containers, or nil to leave it to the pod.
+	SeccompProfile *SeccompProfile
+	// AppArmorProfile is the AppArmor profile of the injected init and
+	// proxy containers: runtime/default, unconfined, or
+	// localhost/<profile>, or empty to leave it to the nodes.
+	AppArmorProfile string
+	// PodSecurityLevel is the pod security level the injected pods must
+	// meet, checked before injection so that it fails with an
+	// explanation instead of the API server rejecting the pods.
+	PodSecurityLevel PodSecurityLevel
+	// AdaptPodSecurity adapts the injection to the pod security level
+	// where possible, e.g. with the CNI interception mode, see
+	// adaptPodSecurity.
+	AdaptPodSecurity bool
+	// OpenShift, if set, runs the proxy as a UID of the range of the
+	// OpenShift project of the pods instead of SidecarProxyUID.
+	OpenShift *OpenShiftPolicy
+	// ProxyRunAsGroup, if set, is the primary group of the proxy.
+	ProxyRunAsGroup *int64
+	// FSGroup, if set, is the fsGroup of the pods that do not set one,
+	// so that the mounted certificate secrets are readable when the
+	// cluster enforces group-based file permissions.
+	FSGroup *int64
+	// ProxyPorts names the container ports declared on the proxy and
+	// adds its stats and health ports, see ProxyPorts.
+	ProxyPorts *ProxyPorts
+}
+
+// CertSecretName returns the name of the certificate secret of the
+// service account in the namespace.
+func (p *Params) CertSecretName(namespace, serviceAccount string) (string, error) {
+	text := p.CertSecretTemplate
+	if text == "" {
+		text = DefaultCertSecretTemplate
+	}
+	tmpl, err := template.New("certSecret").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate secret template: %v", err)
+	}
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, struct {
+		Namespace      string
+		ServiceAccount string
+	}{namespace, serviceAccount})
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate secret template: %v", err)
+	}
+	return name.String(), nil
 }
 
-var enableCoreDumpContainer = map[string]interface{}{
-	"name":    enableCoreDumpContainerName,
-	"image":   enableCoreDumpImage,
-	"command": []string{"/bin/sh"},
-	"args": []string{
-		"-c",
-		"sysctl -w kernel.core_pattern=/tmp/core.%e.%p.%t && ulimit -c unlimited",
-	},
-	"imagePullPolicy": "Always",
-	"securityContext": map[string]interface{}{
-		"privileged": true,
-	},
+// spiffeIdentity returns the SPIFFE ID of a service account.
+func spiffeIdentity(trustDomain, namespace, serviceAccount string) string {
+	return fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", trustDomain, namespace, serviceAccount)
 }
 
-func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
+// IntoPodTemplateSpec injects the istio proxy into the pod template of
+// a resource in the given namespace, which may be empty if the
+// resource does not set one. Templates that already carry the sidecar
+// annotation are left unmodified.
+func IntoPodTemplateSpec(p *Params, namespace string, t *v1.PodTemplateSpec) error {
 	if t.Annotations == nil {
 		t.Annotations = make(map[string]string)
 	} else if _, ok := t.Annotations[istioSidecarAnnotationSidecarKey]; ok {
 		// Return unmodified resource if sidecar is already present or ignored.
 		return nil
 	}
+	if reason, err := PolicySkip(p, t); err != nil || reason != "" {
+		return err
+	}
+	if p.MeshSource != nil {
+		mesh, err := p.MeshSource.Mesh()
+		if err != nil {
+			return err
+		}
+		current := *p
+		current.Mesh = mesh
+		p = &current
+	}
+	p, err := overrideParams(p, t)
+	if err != nil {
+		return err
+	}
+	if p, err = openShiftParams(p, namespace); err != nil {
+		return err
+	}
+	p = adaptPodSecurity(p, t)
+	volumeCount := len(t.Spec.Volumes)
 	t.Annotations[istioSidecarAnnotationSidecarKey] = istioSidecarAnnotationSidecarValue
 	t.Annotations[istioSidecarAnnotationVersionKey] = p.Version
+	orderSecretInjectors(t)
+
+	flavor := p.Flavor
+	if flavor == nil {
+		flavor = IstioProxy{}
+	}
 
 	// init-container
 	var annotations []interface{}
-	if initContainer, ok := t.Annotations["pod.beta.kubernetes.io/init-containers"]; ok {
+	if initContainer, ok := t.Annotations[initContainersAnnotationKey]; ok {
 		if err := json.Unmarshal([]byte(initContainer), &annotations); err != nil {
 			return err
 		}
 	}
-	initArgs := []string{
-		"-p", fmt.Sprintf("%d", p.Mesh.ProxyListenPort),
-		"-u", strconv.FormatInt(p.SidecarProxyUID, 10),
+	var initContainer map[string]interface{}
+	var injected []map[string]interface{}
+	if p.InterceptionMode.annotated() {
+		if err := annotateRedirection(p, t); err != nil {
+			return err
+		}
+	} else if initContainer, err = flavor.InitContainer(p, t); err != nil {
+		return err
 	}
-	if p.IncludeIPRanges != "" {
-		initArgs = append(initArgs, "-i", p.IncludeIPRanges)
+	if initContainer != nil {
+		if err = checkContainerName(t, annotations, fmt.Sprint(initContainer["name"])); err != nil {
+			return err
+		}
+		if len(p.InitResources.Requests) > 0 || len(p.InitResources.Limits) > 0 {
+			initContainer["resources"] = p.InitResources
+		}
+		if p.SeccompProfile != nil {
+			p.SeccompProfile.apply(initContainer)
+		}
+		annotations = append(annotations, initContainer)
+		injected = append(injected, initContainer)
 	}
-	annotations = append(annotations, map[string]interface{}{
-		"name":            initContainerName,
-		"image":           p.InitImage,
-		"args":            initArgs,
-		"imagePullPolicy": "Always",
-		"securityContext": map[string]interface{}{
-			"capabilities": map[string]interface{}{
-				"add": []string{"NET_ADMIN"},
-			},
-		},
-	})
 
-	if p.EnableCoreDump {
-		annotations = append(annotations, enableCoreDumpContainer)
+	// A crashing workload can turn core dumps on or off without
+	// changing the parameters of every other workload.
+	enableCoreDump := p.EnableCoreDump
+	if value, ok := t.Annotations[enableCoreDumpAnnotationKey]; ok {
+		if enableCoreDump, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("annotation %s must be true or false, got %q", enableCoreDumpAnnotationKey, value)
+		}
 	}
-
-	initAnnotationValue, err := json.Marshal(&annotations)
-	if err != nil {
+	if coreDumpContainer := p.CoreDump.initContainer(); enableCoreDump && coreDumpContainer != nil {
+		if err = checkContainerName(t, annotations, enableCoreDumpContainerName); err != nil {
+			return err
+		}
+		annotations = append(annotations, coreDumpContainer)
+		injected = append(injected, coreDumpContainer)
+	}
+	if _, err = shareProcessNamespace(p, t); err != nil {
+		return err
+	}
+	if _, err = nativeSidecar(p, t); err != nil {
+		return err
+	}
+	if err = raiseTerminationGracePeriod(p, t); err != nil {
 		return err
 	}
-	t.Annotations["pod.beta.kubernetes.io/init-containers"] = string(initAnnotationValue)
 
-	// sidecar proxy container
-	args := []string{
-		"proxy",
-		"sidecar",
+	if len(annotations) > 0 {
+		initAnnotationValue, err := json.Marshal(&annotations)
+		if err != nil {
+			return err
+		}
+		t.Annotations[initContainersAnnotationKey] = string(initAnnotationValue)
+	}
+
+	if p.Network != "" {
+		if t.Labels == nil {
+			t.Labels = make(map[string]string)
+		}
+		if _, ok := t.Labels[NetworkLabel]; !ok {
+			t.Labels[NetworkLabel] = p.Network
+		}
 	}
 
-	if p.Verbosity > 0 {
-		args = append(args, "-v", strconv.Itoa(p.Verbosity))
+	// sidecar proxy container
+	sidecar, volumes, err := flavor.Container(p, namespace, t)
+	if err != nil {
+		return err
+	}
+	if err = checkContainerName(t, annotations, sidecar.Name); err != nil {
+		return err
 	}
-	if p.MeshConfigMapName != "" {
-		args = append(args, "--meshConfig", p.MeshConfigMapName)
+	if sidecar.Name != proxyContainerName {
+		t.Annotations[proxyContainerNameAnnotationKey] = sidecar.Name
 	}
+	t.Spec.Volumes = append(t.Spec.Volumes, volumes...)
+	if enableCoreDump {
+		p.CoreDump.addVolume(t, &sidecar)
+	}
+	sidecar.Args = append(sidecar.Args, p.ExtraProxyArgs...)
 
-	ports, err := healthPorts(t)
+	sidecar.Resources = p.ProxyResources
+	if p.Sizing != nil {
+		sidecar.Resources = p.Sizing.Resources(t.Spec.Containers, p.ProxyResources)
+	}
+	if sidecar.Resources, err = overrideResources(t, sidecar.Resources); err != nil {
+		return err
+	}
+	if p.Limits != nil {
+		if sidecar.Resources, err = p.Limits.Apply(namespace, sidecar.Resources); err != nil {
+			return err
+		}
+	}
+	concurrency, err := proxyConcurrency(p, t, sidecar.Resources)
 	if err != nil {
 		return err
 	}
-	for _, port := range ports {
-		args = append(args, "--passthrough", strconv.Itoa(port))
+	if concurrency > 0 {
+		sidecar.Args = append(sidecar.Args, "--concurrency", strconv.Itoa(concurrency))
+	}
+	if p.TrustDomain != "" && namespace != "" {
+		t.Annotations[istioIdentityAnnotationKey] = spiffeIdentity(p.TrustDomain, namespace, serviceAccount(t))
+	}
+	if p.HardenedSecurityContext {
+		if sidecar.SecurityContext == nil {
+			sidecar.SecurityContext = &v1.SecurityContext{}
+		}
+		nonRoot, privileged := true, false
+		sidecar.SecurityContext.RunAsNonRoot = &nonRoot
+		sidecar.SecurityContext.Privileged = &privileged
+	}
+	if err = addProxyEnv(p, t, &sidecar); err != nil {
+		return err
+	}
+	if err = addProxyVolumes(p, t, &sidecar); err != nil {
+		return err
+	}
+	if p.ReadOnlyRootFilesystem {
+		readOnlyRootFilesystem(t, &sidecar)
+	}
+	addDrainHook(p, &sidecar)
+	hold, err := holdApplication(p, t)
+	if err != nil {
+		return err
+	}
+	if err = auditPodSecurity(p, t, injected, &sidecar, t.Spec.Volumes[volumeCount:]); err != nil {
+		return err
+	}
+	if hold {
+		addStartHook(p, &sidecar)
+		t.Spec.Containers = append([]v1.Container{sidecar}, t.Spec.Containers...)
+	} else {
+		t.Spec.Containers = append(t.Spec.Containers, sidecar)
 	}
+	if initContainer != nil {
+		annotateAppArmor(p, t, fmt.Sprint(initContainer["name"]))
+	}
+	annotateAppArmor(p, t, sidecar.Name)
+	addFSGroup(p, t)
+	addImagePullSecrets(t, p.ImagePullSecrets)
 
-	var volumeMounts []v1.VolumeMount
-	if p.Mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
-		volumeMounts = append(volumeMounts, v1.VolumeMount{
-			Name:      istioCertVolumeName,
-			ReadOnly:  true,
-			MountPath: p.Mesh.AuthCertsPath,
-		})
+	return nil
+}
 
-		sa := t.Spec.ServiceAccountName
-		if sa == "" {
-			sa = "default"
+// addImagePullSecrets adds the secrets the pod template does not
+// reference yet.
+func addImagePullSecrets(t *v1.PodTemplateSpec, secrets []string) {
+	for _, name := range secrets {
+		found := false
+		for _, ref := range t.Spec.ImagePullSecrets {
+			if ref.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Spec.ImagePullSecrets = append(t.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: name})
 		}
-		t.Spec.Volumes = append(t.Spec.Volumes, v1.Volume{
-			Name: istioCertVolumeName,
-			VolumeSource: v1.VolumeSource{
-				Secret: &v1.SecretVolumeSource{
-					SecretName: istioCertSecretPrefix + sa,
-				},
-			},
-		})
 	}
+}
 
-	sidecar := v1.Container{
-		Name:  proxyContainerName,
-		Image: p.ProxyImage,
-		Args:  args,
-		Env: []v1.EnvVar{{
-			Name: "POD_NAME",
-			ValueFrom: &v1.EnvVarSource{
-				FieldRef: &v1.ObjectFieldSelector{
-					FieldPath: "metadata.name",
-				},
-			},
-		}, {
-			Name: "POD_NAMESPACE",
-			ValueFrom: &v1.EnvVarSource{
-				FieldRef: &v1.ObjectFieldSelector{
-					FieldPath: "metadata.namespace",
-				},
-			},
-		}, {
-			Name: "POD_IP",
-			ValueFrom: &v1.EnvVarSource{
-				FieldRef: &v1.ObjectFieldSelector{
-					FieldPath: "status.podIP",
-				},
-			},
-		}},
-		ImagePullPolicy: v1.PullAlways,
-		SecurityContext: &v1.SecurityContext{
-			RunAsUser: &p.SidecarProxyUID,
-		},
-		VolumeMounts: volumeMounts,
-	}
-	t.Spec.Containers = append(t.Spec.Containers, sidecar)
+// SidecarStatus returns the value of the sidecar annotation of the
+// pod template: "injected", "ignore" for templates excluded from
+// injection, or empty if injection has not run.
+func SidecarStatus(t *v1.PodTemplateSpec) string {
+	return t.Annotations[istioSidecarAnnotationSidecarKey]
+}
 
-	return nil
+// shareProcessNamespace returns whether the pods of the template share
+// their process namespace, as set by the parameters or overridden by
+// an annotation. Pods in the host process namespace cannot share it.
+func shareProcessNamespace(p *Params, t *v1.PodTemplateSpec) (bool, error) {
+	share := p.ShareProcessNamespace
+	if value, ok := t.Annotations[shareProcessNamespaceAnnotationKey]; ok {
+		var err error
+		if share, err = strconv.ParseBool(value); err != nil {
+			return false, fmt.Errorf("annotation %s must be true or false, got %q", shareProcessNamespaceAnnotationKey, value)
+		}
+	}
+	if share && t.Spec.HostPID {
+		return false, fmt.Errorf("cannot share the process namespace of pods with hostPID (see annotation %s)",
+			shareProcessNamespaceAnnotationKey)
+	}
+	return share, nil
 }
 
 func resolvePort(c v1.Container, port intstr.IntOrString) (int, error) {
@@ -272,77 +613,411 @@ func healthPorts(t *v1.PodTemplateSpec) ([]int, error) {
 }
 
 // IntoResourceFile injects the istio proxy into the specified
-// kubernetes YAML file.
+// kubernetes YAML file. Documents that are not injected are copied
+// verbatim. Injected documents keep their comments and formatting,
+// only YAML anchors and aliases are expanded, see
+// IntoResourceFileWithReport.
 func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
-	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
+	_, err := IntoResourceFileWithReport(p, in, out)
+	return err
+}
+
+// yamlAnchorPattern matches an anchor or alias node property at the
+// start of a value, sequence entry, or flow collection entry.
+var yamlAnchorPattern = regexp.MustCompile(`(?m)(?:^\s*|:\s+|-\s+|[\[{,]\s*)[&*][\w-]+`)
+
+// hasAnchors reports whether a YAML document uses anchors or aliases,
+// which do not survive decoding into typed resources.
+func hasAnchors(raw []byte) bool {
+	return yamlAnchorPattern.Match(raw)
+}
+
+// resourceMeta is the subset of a kubernetes resource needed to
+// dispatch and report on it.
+type resourceMeta struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+// defaultReplicas returns the replica count of a workload, which
+// kubernetes defaults to one when unset.
+func defaultReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// workloadKind describes how to decode a workload kind and reach its
+// pod template.
+type workloadKind struct {
+	typ      interface{}
+	template func(typ interface{}) *v1.PodTemplateSpec
+	// replicas returns the number of pods created from the
+	// template unless the kind runs one pod per node.
+	replicas func(typ interface{}) int32
+	perNode  bool
+}
+
+// cronJob describes CronJobs, whose pod template is nested in the
+// template of the jobs they create.
+func cronJob() workloadKind {
+	return workloadKind{
+		typ: &v2alpha1.CronJob{},
+		template: func(typ interface{}) *v1.PodTemplateSpec {
+			return &((typ.(*v2alpha1.CronJob)).Spec.JobTemplate.Spec.Template)
+		},
+		replicas: func(typ interface{}) int32 {
+			return defaultReplicas((typ.(*v2alpha1.CronJob)).Spec.JobTemplate.Spec.Parallelism)
+		},
+	}
+}
+
+func daemonSet() workloadKind {
+	return workloadKind{
+		typ: &v1beta1.DaemonSet{},
+		template: func(typ interface{}) *v1.PodTemplateSpec {
+			return &((typ.(*v1beta1.DaemonSet)).Spec.Template)
+		},
+		perNode: true,
+	}
+}
+
+func replicaSet() workloadKind {
+	return workloadKind{
+		typ: &v1beta1.ReplicaSet{},
+		template: func(typ interface{}) *v1.PodTemplateSpec {
+			return &((typ.(*v1beta1.ReplicaSet)).Spec.Template)
+		},
+		replicas: func(typ interface{}) int32 {
+			return defaultReplicas((typ.(*v1beta1.ReplicaSet)).Spec.Replicas)
+		},
+	}
+}
+
+// isWorkload reports whether the kind is supported in some group.
+func isWorkload(kinds map[schema.GroupKind]workloadKind, kind string) bool {
+	for gk := range kinds {
+		if gk.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// IntoResourceFileWithReport injects the istio proxy into the
+// specified kubernetes YAML file and reports what was changed in
+// every resource. The changes are applied to the lines of each input
+// document to keep its comments and formatting, and fields that only
+// marshaling added, e.g. "creationTimestamp: null", are left out.
+// Injected documents that used YAML anchors or aliases are flagged
+// with a warning since the output expands them.
+func IntoResourceFileWithReport(p *Params, in io.Reader, out io.Writer) (*Report, error) {
+	report := &Report{}
+	reader := newDocumentReader(in)
 	for {
 		raw, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		updated, entries, err := intoResource(p, raw)
+		if err != nil {
+			return nil, err
+		}
+		report.Resources = append(report.Resources, entries...)
+		if bytes.Equal(updated, raw) {
+			if p.OmitUnchanged {
+				continue
+			}
+		} else {
+			updated = preserveFormatting(raw, scrubOutput(raw, updated))
+		}
+
+		if _, err = out.Write(updated); err != nil {
+			return nil, err
+		}
+		if _, err = fmt.Fprint(out, "---\n"); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// WriteDocuments writes the documents of a YAML file as
+// IntoResourceFile writes those it does not change, each followed by a
+// document separator, so that its output can be compared with the
+// injected file.
+func WriteDocuments(in io.Reader, out io.Writer) error {
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		kinds := map[string]struct {
-			typ    interface{}
-			inject func(typ interface{}) error
-		}{
-			"Job": {
-				typ: &batch.Job{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*batch.Job)).Spec.Template))
-				},
+		if _, err = out.Write(raw); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprint(out, "---\n"); err != nil {
+			return err
+		}
+	}
+}
+
+// workloadKinds returns the kinds with a pod template by their group.
+func workloadKinds() map[schema.GroupKind]workloadKind {
+	return map[schema.GroupKind]workloadKind{
+		{Group: "batch", Kind: "Job"}: {
+			typ: &batch.Job{},
+			template: func(typ interface{}) *v1.PodTemplateSpec {
+				return &((typ.(*batch.Job)).Spec.Template)
+			},
+			replicas: func(typ interface{}) int32 {
+				return defaultReplicas((typ.(*batch.Job)).Spec.Parallelism)
+			},
+		},
+		// ScheduledJob is the former name of CronJob.
+		{Group: "batch", Kind: "CronJob"}:      cronJob(),
+		{Group: "batch", Kind: "ScheduledJob"}: cronJob(),
+		// The apps group versions of daemon sets and replica sets
+		// share the schema of the extensions group.
+		{Group: "extensions", Kind: "DaemonSet"}:  daemonSet(),
+		{Group: "apps", Kind: "DaemonSet"}:        daemonSet(),
+		{Group: "extensions", Kind: "ReplicaSet"}: replicaSet(),
+		{Group: "apps", Kind: "ReplicaSet"}:       replicaSet(),
+		{Group: "extensions", Kind: "Deployment"}: {
+			typ: &v1beta1.Deployment{},
+			template: func(typ interface{}) *v1.PodTemplateSpec {
+				return &((typ.(*v1beta1.Deployment)).Spec.Template)
+			},
+			replicas: func(typ interface{}) int32 {
+				return defaultReplicas((typ.(*v1beta1.Deployment)).Spec.Replicas)
+			},
+		},
+		{Group: "apps", Kind: "Deployment"}: {
+			typ: &appsv1beta1.Deployment{},
+			template: func(typ interface{}) *v1.PodTemplateSpec {
+				return &((typ.(*appsv1beta1.Deployment)).Spec.Template)
 			},
-			"DaemonSet": {
-				typ: &v1beta1.DaemonSet{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.DaemonSet)).Spec.Template))
-				},
+			replicas: func(typ interface{}) int32 {
+				return defaultReplicas((typ.(*appsv1beta1.Deployment)).Spec.Replicas)
 			},
-			"ReplicaSet": {
-				typ: &v1beta1.ReplicaSet{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.ReplicaSet)).Spec.Template))
-				},
+		},
+		{Group: "apps", Kind: "StatefulSet"}: {
+			typ: &appsv1beta1.StatefulSet{},
+			template: func(typ interface{}) *v1.PodTemplateSpec {
+				return &((typ.(*appsv1beta1.StatefulSet)).Spec.Template)
+			},
+			replicas: func(typ interface{}) int32 {
+				return defaultReplicas((typ.(*appsv1beta1.StatefulSet)).Spec.Replicas)
 			},
-			"Deployment": {
-				typ: &v1beta1.Deployment{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.Deployment)).Spec.Template))
-				},
+		},
+		{Group: "", Kind: "ReplicationController"}: {
+			typ: &v1.ReplicationController{},
+			template: func(typ interface{}) *v1.PodTemplateSpec {
+				return (typ.(*v1.ReplicationController)).Spec.Template
 			},
-			"ReplicationController": {
-				typ: &v1.ReplicationController{},
-				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, ((typ.(*v1.ReplicationController)).Spec.Template))
-				},
+			replicas: func(typ interface{}) int32 {
+				return defaultReplicas((typ.(*v1.ReplicationController)).Spec.Replicas)
 			},
+		},
+	}
+}
+
+// PodSpecPatches returns the patches of the pod spec marshaled from an
+// injected pod template, with the injected containers, for the fields
+// that are newer than the kubernetes types. Every writer of injected
+// pod specs applies them in order.
+func PodSpecPatches(p *Params, containers []string, t *v1.PodTemplateSpec) []PodSpecPatch {
+	patches := []PodSpecPatch{disallowPrivilegeEscalation(containers)}
+	if profile := adaptPodSecurity(p, t).SeccompProfile; profile != nil {
+		patches = append(patches, seccompProfile(profile, injectedProxyName(t)))
+	}
+	if p.ProxyRunAsGroup != nil {
+		patches = append(patches, runAsGroup(*p.ProxyRunAsGroup, injectedProxyName(t)))
+	}
+	if share, _ := shareProcessNamespace(p, t); share {
+		patches = append(patches, func(spec map[string]interface{}) error {
+			spec["shareProcessNamespace"] = true
+			return nil
+		})
+	}
+	// Privilege escalation is disallowed while the proxy is still a
+	// regular container.
+	if native, _ := nativeSidecar(p, t); native {
+		patches = append(patches, moveToInitContainers(injectedProxyName(t)))
+	}
+	return patches
+}
+
+// intoResource injects the istio proxy into a single YAML document and
+// reports what was changed in the resources it holds.
+func intoResource(p *Params, raw []byte) ([]byte, []ResourceReport, error) {
+	kinds := workloadKinds()
+	var updated []byte
+	var meta resourceMeta
+	if err := yaml.Unmarshal(raw, &meta); err != nil {
+		return nil, nil, err
+	}
+	entry := ResourceReport{
+		Kind:      meta.Kind,
+		Name:      meta.Metadata.Name,
+		Namespace: meta.Metadata.Namespace,
+	}
+	gv, err := schema.ParseGroupVersion(meta.APIVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	if gv.Group == "" && meta.Kind == "List" {
+		return intoList(p, raw)
+	}
+	gk := gv.WithKind(meta.Kind).GroupKind()
+	kind, ok := kinds[gk]
+	custom, isCustom := customKind(p, gv.WithKind(meta.Kind))
+	injectable := ok || isCustom || isTektonRun(gk) || gk == knativeServiceKind
+	var namespaceReason string
+	if injectable {
+		if namespaceReason, err = NamespaceSkip(p, meta.Metadata.Namespace); err != nil {
+			return nil, nil, err
 		}
-		var updated []byte
-		var meta metav1.TypeMeta
-		if err = yaml.Unmarshal(raw, &meta); err != nil {
-			return err
+	}
+	if injectable && !p.Filter.matches(meta.Metadata) {
+		entry.Reason = "excluded by the selection filter"
+		updated = raw // unchanged
+	} else if namespaceReason != "" {
+		entry.Reason = namespaceReason
+		updated = raw // unchanged
+	} else if isCustom {
+		if updated, err = intoCustomResource(p, custom, meta.Metadata.Namespace, raw, &entry); err != nil {
+			return nil, nil, err
 		}
-		if kind, ok := kinds[meta.Kind]; ok {
-			if err = yaml.Unmarshal(raw, kind.typ); err != nil {
-				return err
-			}
-			if err = kind.inject(kind.typ); err != nil {
-				return err
+	} else if isTektonRun(gk) {
+		if updated, err = intoTektonRun(p, gv, meta.Kind, raw, &entry); err != nil {
+			return nil, nil, err
+		}
+	} else if gk == knativeServiceKind {
+		// Knative Services are annotated rather than injected.
+		if updated, entry.Reason, err = annotateKnativeService(raw); err != nil {
+			return nil, nil, err
+		}
+		if entry.Reason == "" {
+			entry.Reason = knativeReason
+			entry.Annotations = []string{knativeInjectAnnotationKey}
+		}
+	} else if !ok && isWorkload(kinds, meta.Kind) {
+		// Resources of other groups may reuse the name of a
+		// workload kind, e.g. custom resources.
+		entry.Reason = fmt.Sprintf("apiVersion %q of kind %q is not supported", meta.APIVersion, meta.Kind)
+		updated = raw // unchanged
+	} else if ok {
+		if err = yaml.Unmarshal(raw, kind.typ); err != nil {
+			return nil, nil, err
+		}
+		t := kind.template(kind.typ)
+		before := summarizeTemplate(t)
+		if err = IntoPodTemplateSpec(p, meta.Metadata.Namespace, t); err != nil {
+			return nil, nil, err
+		}
+		entry.compare(p, before, t)
+		if entry.Injected {
+			if kind.perNode {
+				entry.PerNode = true
+			} else {
+				entry.Replicas = kind.replicas(kind.typ)
 			}
 			if updated, err = yaml.Marshal(kind.typ); err != nil {
-				return err
+				return nil, nil, err
+			}
+			if updated, err = patchPodSpec(updated, PodSpecPatches(p, entry.Containers, t)...); err != nil {
+				return nil, nil, err
+			}
+			if hasAnchors(raw) {
+				entry.Warnings = append(entry.Warnings,
+					"YAML anchors and aliases were expanded in the injected output")
 			}
 		} else {
-			updated = raw // unchanged
+			// Skipped resources are emitted verbatim to keep their
+			// formatting, comments, and anchors.
+			updated = raw
 		}
+	} else {
+		entry.Reason = fmt.Sprintf("kind %q does not have a pod template", meta.Kind)
+		updated = raw // unchanged
+	}
+	if meta.Kind == "" {
+		return updated, nil, nil
+	}
+	return updated, []ResourceReport{entry}, nil
+}
 
-		if _, err = out.Write(updated); err != nil {
-			return err
+// intoList injects the items of a List, as printed by kubectl get, and
+// wraps them again in their original order.
+func intoList(p *Params, raw []byte) ([]byte, []ResourceReport, error) {
+	updated, entries, err := mapList(raw, p.OmitUnchanged, func(item []byte) ([]byte, []ResourceReport, error) {
+		return intoResource(p, item)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if hasAnchors(raw) && !bytes.Equal(updated, raw) {
+		for i := range entries {
+			if entries[i].Injected {
+				entries[i].Warnings = append(entries[i].Warnings,
+					"YAML anchors and aliases were expanded in the injected output")
+			}
 		}
-		if _, err = fmt.Fprint(out, "---\n"); err != nil {
-			return err
+	}
+	return updated, entries, nil
+}
+
+// mapList updates every item of a List with a function, which also
+// reports on the resources of the item. The list is returned verbatim
+// unless one of its items changed, and unchanged items are dropped if
+// requested.
+func mapList(raw []byte, omitUnchanged bool, update func(item []byte) ([]byte, []ResourceReport, error)) ([]byte, []ResourceReport, error) {
+	var list map[string]interface{}
+	if err := yaml.Unmarshal(raw, &list); err != nil {
+		return nil, nil, err
+	}
+	items, _ := list["items"].([]interface{})
+	var entries []ResourceReport
+	var kept []interface{}
+	changed := false
+	for _, item := range items {
+		itemRaw, err := yaml.Marshal(item)
+		if err != nil {
+			return nil, nil, err
 		}
+		updated, itemEntries, err := update(itemRaw)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, itemEntries...)
+		if bytes.Equal(updated, itemRaw) {
+			if !omitUnchanged {
+				kept = append(kept, item)
+			}
+			continue
+		}
+		var value interface{}
+		if err = yaml.Unmarshal(updated, &value); err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, value)
+		changed = true
 	}
-	return nil
+	if !changed {
+		return raw, entries, nil
+	}
+	list["items"] = kept
+	updated, err := yaml.Marshal(list)
+	if err != nil {
+		return nil, nil, err
+	}
+	return updated, entries, nil
 }
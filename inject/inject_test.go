@@ -16,9 +16,15 @@ package inject
 
 import (
 	"bytes"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/pkg/api/v1"
+
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/test/util"
@@ -33,6 +39,10 @@ func TestImageName(t *testing.T) {
 	if got := ProxyImageName("docker.io/istio", "latest"); got != want {
 		t.Errorf("ProxyImageName() failed: got %q want %q", got, want)
 	}
+	want = "docker.io/istio/proxy:latest"
+	if got := ProxyReleaseImageName("docker.io/istio", "latest"); got != want {
+		t.Errorf("ProxyReleaseImageName() failed: got %q want %q", got, want)
+	}
 }
 
 // Tag name should be kept in sync with value in platform/kube/inject/refresh.sh
@@ -42,14 +52,60 @@ const unitTestTag = "unittest"
 // and the other .injected "want" YAMLs
 const unitTestHub = "docker.io/istio"
 
+func TestCertSecretName(t *testing.T) {
+	cases := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "", want: "istio.hello"},
+		{template: "{{.Namespace}}.{{.ServiceAccount}}", want: "web.hello"},
+		{template: "{{.ServiceAccount", wantErr: true},
+		{template: "{{.Cluster}}", wantErr: true},
+	}
+	for _, c := range cases {
+		p := Params{CertSecretTemplate: c.template}
+		got, err := p.CertSecretName("web", "hello")
+		if (err != nil) != c.wantErr {
+			t.Errorf("CertSecretName(%q) => got error %v, want error %t", c.template, err, c.wantErr)
+		} else if got != c.want {
+			t.Errorf("CertSecretName(%q) => got %q, want %q", c.template, got, c.want)
+		}
+	}
+}
+
 func TestIntoResourceFile(t *testing.T) {
 	cases := []struct {
 		authConfigPath string
+		certSecret     string
 		configMapName  string
 		enableAuth     bool
 		in             string
 		want           string
 		enableCoreDump bool
+		trustDomain    string
+		hardened       bool
+		sdsPath        string
+		sizing         *SizingPolicy
+		filter         *Filter
+		network        string
+		customKinds    []CustomKind
+		omitUnchanged  bool
+		namespaces     *NamespacePolicy
+		initResources  v1.ResourceRequirements
+		pullSecrets    []string
+		excludeRanges  string
+		includePorts   string
+		interception   InterceptionMode
+		excludeUIDs    string
+		extraArgs      []string
+		coreDump       *CoreDumpPolicy
+		readOnly       bool
+		seccomp        *SeccompProfile
+		appArmor       string
+		podSecurity    PodSecurityLevel
+		group          int64
+		ports          *ProxyPorts
 	}{
 		{
 			in:   "testdata/hello.yaml",
@@ -84,15 +140,236 @@ func TestIntoResourceFile(t *testing.T) {
 			in:   "testdata/hello-ignore.yaml",
 			want: "testdata/hello-ignore.yaml.injected",
 		},
+		{
+			in:   "testdata/anchors.yaml",
+			want: "testdata/anchors.yaml.injected",
+		},
 		{
 			in:   "testdata/multi-init.yaml",
 			want: "testdata/multi-init.yaml.injected",
 		},
+		{
+			in:          "testdata/hello-identity.yaml",
+			want:        "testdata/hello-identity.yaml.injected",
+			trustDomain: "cluster.local",
+		},
+		{
+			in:       "testdata/hello.yaml",
+			want:     "testdata/hello-hardened.yaml.injected",
+			hardened: true,
+		},
+		{
+			in:   "testdata/hello-share-process-namespace.yaml",
+			want: "testdata/hello-share-process-namespace.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-exclude-interfaces.yaml",
+			want: "testdata/hello-exclude-interfaces.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-exclude-inbound-ports.yaml",
+			want: "testdata/hello-exclude-inbound-ports.yaml.injected",
+		},
+		{
+			in:            "testdata/hello-exclude-ip-ranges.yaml",
+			want:          "testdata/hello-exclude-ip-ranges.yaml.injected",
+			excludeRanges: "169.254.169.254/32",
+		},
+		{
+			in:           "testdata/hello-outbound-ports.yaml",
+			want:         "testdata/hello-outbound-ports.yaml.injected",
+			includePorts: "80,443",
+		},
+		{
+			in:           "testdata/hello-exclude-inbound-ports.yaml",
+			want:         "testdata/hello-cni.yaml.injected",
+			interception: InterceptionCNI,
+		},
+		{
+			in:          "testdata/hello-exclude-ids.yaml",
+			want:        "testdata/hello-exclude-ids.yaml.injected",
+			excludeUIDs: "1000,1001",
+		},
+		{
+			in:   "testdata/hello-grace-period.yaml",
+			want: "testdata/hello-grace-period.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-drain.yaml",
+			want: "testdata/hello-drain.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-hold.yaml",
+			want: "testdata/hello-hold.yaml.injected",
+		},
+		{
+			in:             "testdata/hello.yaml",
+			want:           "testdata/hello-core-dump-node.yaml.injected",
+			enableCoreDump: true,
+			coreDump:       &CoreDumpPolicy{Directory: "/var/lib/cores", NodeConfigured: true},
+		},
+		{
+			in:   "testdata/hello.yaml",
+			want: "testdata/hello-ports.yaml.injected",
+			ports: &ProxyPorts{
+				AdminName:  "http-admin",
+				StatsPort:  15090,
+				HealthPort: 15021,
+				HealthName: "status-port",
+			},
+		},
+		{
+			in:       "testdata/hello.yaml",
+			want:     "testdata/hello-read-only.yaml.injected",
+			hardened: true,
+			readOnly: true,
+		},
+		{
+			in:      "testdata/hello.yaml",
+			want:    "testdata/hello-seccomp.yaml.injected",
+			seccomp: &SeccompProfile{Type: SeccompLocalhost, LocalhostProfile: "profiles/istio-proxy.json"},
+		},
+		{
+			in:       "testdata/hello.yaml",
+			want:     "testdata/hello-apparmor.yaml.injected",
+			appArmor: AppArmorRuntimeDefault,
+		},
+		{
+			in:          "testdata/hello.yaml",
+			want:        "testdata/hello-restricted.yaml.injected",
+			podSecurity: PodSecurityRestricted,
+		},
+		{
+			in:    "testdata/hello.yaml",
+			want:  "testdata/hello-groups.yaml.injected",
+			group: 1337,
+		},
+		{
+			in:        "testdata/hello.yaml",
+			want:      "testdata/hello-extra-args.yaml.injected",
+			extraArgs: []string{"--concurrency", "2"},
+		},
+		{
+			in:   "testdata/hello-volumes.yaml",
+			want: "testdata/hello-volumes.yaml.injected",
+		},
+		{
+			in:   "testdata/job-native.yaml",
+			want: "testdata/job-native.yaml.injected",
+		},
+		{
+			in:   "testdata/hello.yaml",
+			want: "testdata/hello-init-resources.yaml.injected",
+			initResources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10m"), v1.ResourceMemory: resource.MustParse("10Mi")},
+				Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("10m"), v1.ResourceMemory: resource.MustParse("10Mi")},
+			},
+		},
+		{
+			in:          "testdata/hello-pull-secrets.yaml",
+			want:        "testdata/hello-pull-secrets.yaml.injected",
+			pullSecrets: []string{"registry-credentials", "mirror-credentials"},
+		},
+		{
+			in:   "testdata/hello-overrides.yaml",
+			want: "testdata/hello-overrides.yaml.injected",
+		},
+		{
+			in:     "testdata/hello-filter.yaml",
+			want:   "testdata/hello-filter.yaml.injected",
+			filter: mustFilter(t, "mesh=onboard", "frontend", []string{"web"}),
+		},
+		{
+			in:   "testdata/hello-namespaces.yaml",
+			want: "testdata/hello-namespaces.yaml.injected",
+			namespaces: &NamespacePolicy{
+				Exclude:  []string{"kube-system"},
+				Selector: "istio-injection=enabled",
+				Labels: map[string]map[string]string{
+					"web":         {"istio-injection": "enabled"},
+					"kube-system": {"istio-injection": "enabled"},
+					"default":     {"istio-injection": "enabled"},
+				},
+				DefaultNamespace: "default",
+			},
+		},
+		{
+			in:      "testdata/hello-network.yaml",
+			want:    "testdata/hello-network.yaml.injected",
+			network: "vpc-east",
+		},
+		{
+			in:   "testdata/apps.yaml",
+			want: "testdata/apps.yaml.injected",
+		},
+		{
+			in:   "testdata/cronjob.yaml",
+			want: "testdata/cronjob.yaml.injected",
+		},
+		{
+			in:   "testdata/knative.yaml",
+			want: "testdata/knative.yaml.injected",
+		},
+		{
+			in:   "testdata/list.yaml",
+			want: "testdata/list.yaml.injected",
+		},
+		{
+			in:            "testdata/frontend.yaml",
+			want:          "testdata/frontend.yaml.modified",
+			omitUnchanged: true,
+		},
+		{
+			in:            "testdata/list.yaml",
+			want:          "testdata/list.yaml.modified",
+			omitUnchanged: true,
+		},
+		{
+			in:   "testdata/hello-stream.json",
+			want: "testdata/hello-stream.json.injected",
+		},
+		{
+			in:   "testdata/tekton.yaml",
+			want: "testdata/tekton.yaml.injected",
+		},
+		{
+			in:   "testdata/custom.yaml",
+			want: "testdata/custom.yaml.injected",
+			customKinds: []CustomKind{{
+				GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Workload"},
+				Path:             []string{"spec", "workload", "template"},
+			}},
+		},
+		{
+			in:   "testdata/vault.yaml",
+			want: "testdata/vault.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-addresses.yaml",
+			want: "testdata/hello-addresses.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-sizing.yaml",
+			want: "testdata/hello-sizing.yaml.injected",
+			sizing: &SizingPolicy{
+				Fraction: 0.1,
+				Min:      parseResources(map[v1.ResourceName]string{"cpu": "10m", "memory": "64Mi"}),
+			},
+		},
 		{
 			in:             "testdata/enable-core-dump.yaml",
 			want:           "testdata/enable-core-dump.yaml.injected",
 			enableCoreDump: true,
 		},
+		{
+			in:   "testdata/hello-core-dump.yaml",
+			want: "testdata/hello-core-dump.yaml.injected",
+		},
+		{
+			in:             "testdata/hello-no-core-dump.yaml",
+			want:           "testdata/hello-no-core-dump.yaml.injected",
+			enableCoreDump: true,
+		},
 		{
 			enableAuth:     true,
 			authConfigPath: "/etc/certs/",
@@ -105,6 +382,30 @@ func TestIntoResourceFile(t *testing.T) {
 			in:             "testdata/auth.non-default-service-account.yaml",
 			want:           "testdata/auth.non-default-service-account.yaml.injected",
 		},
+		{
+			enableAuth:     true,
+			authConfigPath: "/etc/certs/",
+			certSecret:     "{{.Namespace}}-{{.ServiceAccount}}-certs",
+			in:             "testdata/auth.cert-secret-template.yaml",
+			want:           "testdata/auth.cert-secret-template.yaml.injected",
+		},
+		{
+			enableAuth:     true,
+			authConfigPath: "/etc/certs/",
+			in:             "testdata/hello-auth-none.yaml",
+			want:           "testdata/hello-auth-none.yaml.injected",
+		},
+		{
+			authConfigPath: "/etc/certs/",
+			in:             "testdata/hello-auth-mutual-tls.yaml",
+			want:           "testdata/hello-auth-mutual-tls.yaml.injected",
+		},
+		{
+			enableAuth: true,
+			sdsPath:    DefaultSDSPath,
+			in:         "testdata/auth.yaml",
+			want:       "testdata/auth.sds.yaml.injected",
+		},
 		{
 			enableAuth:     true,
 			authConfigPath: "/etc/non-default-dir/",
@@ -117,21 +418,50 @@ func TestIntoResourceFile(t *testing.T) {
 		mesh := proxy.DefaultMeshConfig()
 		if c.enableAuth {
 			mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+		}
+		if c.authConfigPath != "" {
 			mesh.AuthCertsPath = c.authConfigPath
 		}
 
 		params := Params{
-			InitImage:       InitImageName(unitTestHub, unitTestTag),
-			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag),
-			Verbosity:       DefaultVerbosity,
-			SidecarProxyUID: DefaultSidecarProxyUID,
-			Version:         "12345678",
-			EnableCoreDump:  c.enableCoreDump,
-			Mesh:            &mesh,
+			InitImage:               InitImageName(unitTestHub, unitTestTag),
+			ProxyImage:              ProxyImageName(unitTestHub, unitTestTag),
+			Verbosity:               DefaultVerbosity,
+			SidecarProxyUID:         DefaultSidecarProxyUID,
+			Version:                 "12345678",
+			EnableCoreDump:          c.enableCoreDump,
+			Mesh:                    &mesh,
+			CertSecretTemplate:      c.certSecret,
+			TrustDomain:             c.trustDomain,
+			HardenedSecurityContext: c.hardened,
+			SDSPath:                 c.sdsPath,
+			Sizing:                  c.sizing,
+			Filter:                  c.filter,
+			Network:                 c.network,
+			CustomKinds:             c.customKinds,
+			OmitUnchanged:           c.omitUnchanged,
+			Namespaces:              c.namespaces,
+			InitResources:           c.initResources,
+			ImagePullSecrets:        c.pullSecrets,
+			ExcludeIPRanges:         c.excludeRanges,
+			IncludeOutboundPorts:    c.includePorts,
+			InterceptionMode:        c.interception,
+			ExcludeUIDs:             c.excludeUIDs,
+			ExtraProxyArgs:          c.extraArgs,
+			CoreDump:                c.coreDump,
+			ReadOnlyRootFilesystem:  c.readOnly,
+			SeccompProfile:          c.seccomp,
+			AppArmorProfile:         c.appArmor,
+			PodSecurityLevel:        c.podSecurity,
+			AdaptPodSecurity:        c.podSecurity != "",
+			ProxyPorts:              c.ports,
 		}
 		if c.configMapName != "" {
 			params.MeshConfigMapName = c.configMapName
 		}
+		if c.group != 0 {
+			params.ProxyRunAsGroup, params.FSGroup = &c.group, &c.group
+		}
 
 		in, err := os.Open(c.in)
 		if err != nil {
@@ -150,3 +480,93 @@ func TestIntoResourceFile(t *testing.T) {
 	// file with existing annotation
 	// file with another init-container
 }
+
+func mustFilter(t *testing.T, selector, name string, namespaces []string) *Filter {
+	f, err := NewFilter(selector, name, namespaces, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestIntoResourceFileInvalidAuthPolicy(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := Params{
+		InitImage:  InitImageName(unitTestHub, unitTestTag),
+		ProxyImage: ProxyImageName(unitTestHub, unitTestTag),
+		Mesh:       &mesh,
+	}
+	in := `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    metadata:
+      annotations:
+        sidecar.wharfie.io/authPolicy: TLS
+    spec:
+      containers:
+      - name: hello
+        image: hello
+`
+	var out bytes.Buffer
+	err := IntoResourceFile(&params, strings.NewReader(in), &out)
+	want := `annotation sidecar.wharfie.io/authPolicy has unknown auth policy "TLS"`
+	if err == nil || err.Error() != want {
+		t.Errorf("IntoResourceFile() => got error %v, want %q", err, want)
+	}
+}
+
+type staticMesh struct {
+	mesh *proxyconfig.ProxyMeshConfig
+	err  error
+}
+
+func (s staticMesh) Mesh() (*proxyconfig.ProxyMeshConfig, error) { return s.mesh, s.err }
+
+func TestIntoPodTemplateSpecMeshSource(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	current := proxy.DefaultMeshConfig()
+	current.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	params := Params{
+		InitImage:  InitImageName(unitTestHub, unitTestTag),
+		ProxyImage: ProxyImageName(unitTestHub, unitTestTag),
+		Mesh:       &mesh,
+		MeshSource: staticMesh{mesh: &current},
+	}
+	var template v1.PodTemplateSpec
+	if err := IntoPodTemplateSpec(&params, "default", &template); err != nil {
+		t.Fatalf("IntoPodTemplateSpec() returned an error: %v", err)
+	}
+	if len(template.Spec.Volumes) != 1 || template.Spec.Volumes[0].Name != CertVolumeName {
+		t.Errorf("IntoPodTemplateSpec() => got volumes %v, want the certificates of the current mesh config", template.Spec.Volumes)
+	}
+	if params.Mesh != &mesh {
+		t.Error("IntoPodTemplateSpec() modified the parameters")
+	}
+
+	params.MeshSource = staticMesh{err: errors.New("config map not found")}
+	if err := IntoPodTemplateSpec(&params, "default", &v1.PodTemplateSpec{}); err == nil {
+		t.Error("IntoPodTemplateSpec() succeeded without a mesh config")
+	}
+}
+
+func TestShareProcessNamespaceHostPID(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := Params{
+		InitImage:             InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:            ProxyImageName(unitTestHub, unitTestTag),
+		Mesh:                  &mesh,
+		ShareProcessNamespace: true,
+	}
+	template := v1.PodTemplateSpec{Spec: v1.PodSpec{HostPID: true}}
+	if err := IntoPodTemplateSpec(&params, "default", &template); err == nil {
+		t.Error("IntoPodTemplateSpec() succeeded for a pod with hostPID")
+	}
+	template = v1.PodTemplateSpec{Spec: v1.PodSpec{HostPID: true}}
+	template.Annotations = map[string]string{shareProcessNamespaceAnnotationKey: "false"}
+	if err := IntoPodTemplateSpec(&params, "default", &template); err != nil {
+		t.Errorf("IntoPodTemplateSpec() returned an error for a pod that opted out: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// injectedRedirectionAnnotationKey records the redirection annotations
+// added by injection in the CNI and eBPF modes, whose keys are those of
+// the overrides of the workloads, so that they can be removed.
+const injectedRedirectionAnnotationKey = "sidecar.wharfie.io/injectedRedirection"
+
+// InterceptionMode selects how the traffic of a pod is redirected to
+// the proxy.
+type InterceptionMode string
+
+const (
+	// InterceptionIptables programs iptables from an init container
+	// with the NET_ADMIN capability.
+	InterceptionIptables InterceptionMode = "iptables"
+	// InterceptionCNI annotates the pod with the redirection settings
+	// for a CNI plugin, for clusters that forbid NET_ADMIN in pods.
+	InterceptionCNI InterceptionMode = "cni"
+	// InterceptionEBPF annotates the pod with the redirection settings
+	// for an eBPF redirection agent on the node, which needs neither
+	// NET_ADMIN nor an init container.
+	InterceptionEBPF InterceptionMode = "ebpf"
+)
+
+// ParseInterceptionMode parses an interception mode, iptables if empty.
+func ParseInterceptionMode(value string) (InterceptionMode, error) {
+	switch mode := InterceptionMode(value); mode {
+	case "":
+		return InterceptionIptables, nil
+	case InterceptionIptables, InterceptionCNI, InterceptionEBPF:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown interception mode %q, expected %s, %s, or %s",
+			value, InterceptionIptables, InterceptionCNI, InterceptionEBPF)
+	}
+}
+
+// annotated reports whether the mode redirects traffic from outside of
+// the pod, configured by the annotations of the pod rather than by an
+// init container.
+func (m InterceptionMode) annotated() bool {
+	return m == InterceptionCNI || m == InterceptionEBPF
+}
+
+// redirectSetting is a setting of the traffic redirection of a pod,
+// passed to the init container as a flag, or to a CNI plugin or an
+// eBPF agent as an annotation.
+type redirectSetting struct {
+	key   string
+	flag  string
+	value string
+}
+
+// redirection returns the traffic redirection settings of a pod
+// template. Workloads can override the traffic that bypasses the
+// proxy with annotations.
+func redirection(p *Params, t *v1.PodTemplateSpec) ([]redirectSetting, error) {
+	// The traffic of the proxy and of the excluded users bypasses the
+	// proxy.
+	uids := strconv.FormatInt(p.SidecarProxyUID, 10)
+	excludeUIDs := p.ExcludeUIDs
+	if override, ok := t.Annotations[excludeUIDsAnnotationKey]; ok {
+		excludeUIDs = override
+	}
+	if excludeUIDs != "" {
+		if err := validateIDs(excludeUIDs); err != nil {
+			return nil, err
+		}
+		uids += "," + excludeUIDs
+	}
+	settings := []redirectSetting{
+		{proxyPortAnnotationKey, "-p", fmt.Sprintf("%d", p.Mesh.ProxyListenPort)},
+		{proxyUIDAnnotationKey, "-u", uids},
+	}
+	if p.IncludeIPRanges != "" {
+		if err := validateIPRanges(p.IncludeIPRanges); err != nil {
+			return nil, err
+		}
+		settings = append(settings, redirectSetting{includeIPRangesAnnotationKey, "-i", normalizeList(p.IncludeIPRanges)})
+	}
+	for _, setting := range []struct {
+		redirectSetting
+		validate func(string) error
+	}{
+		{redirectSetting{excludeInterfacesAnnotationKey, "-c", p.ExcludeInterfaces}, validateInterfaces},
+		{redirectSetting{excludeInboundPortsAnnotationKey, "-d", p.ExcludeInboundPorts}, validatePorts},
+		{redirectSetting{excludeIPRangesAnnotationKey, "-x", p.ExcludeIPRanges}, validateIPRanges},
+		{redirectSetting{includeOutboundPortsAnnotationKey, "-q", p.IncludeOutboundPorts}, validatePorts},
+		{redirectSetting{excludeOutboundPortsAnnotationKey, "-o", p.ExcludeOutboundPorts}, validatePorts},
+		{redirectSetting{excludeGIDsAnnotationKey, "-g", p.ExcludeGIDs}, validateIDs},
+	} {
+		if override, ok := t.Annotations[setting.key]; ok {
+			setting.value = override
+		}
+		if setting.value != "" {
+			if err := setting.validate(setting.value); err != nil {
+				return nil, err
+			}
+			if setting.key == excludeIPRangesAnnotationKey {
+				setting.value = normalizeList(setting.value)
+			}
+			settings = append(settings, setting.redirectSetting)
+		}
+	}
+	return settings, nil
+}
+
+// validateIDs checks a comma separated list of user or group IDs.
+func validateIDs(list string) error {
+	for _, id := range strings.Split(list, ",") {
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return fmt.Errorf("invalid ID %q in %q", id, list)
+		}
+	}
+	return nil
+}
+
+// annotateRedirection annotates a pod template with its redirection
+// settings for the CNI plugin or the eBPF agent, in place of the init
+// container.
+func annotateRedirection(p *Params, t *v1.PodTemplateSpec) error {
+	settings, err := redirection(p, t)
+	if err != nil {
+		return err
+	}
+	t.Annotations[interceptionModeAnnotationKey] = string(p.InterceptionMode)
+	// The proxy port and UID are removed with the other annotations of
+	// injection.
+	var added []string
+	for _, setting := range settings {
+		_, ok := t.Annotations[setting.key]
+		if !ok && setting.key != proxyPortAnnotationKey && setting.key != proxyUIDAnnotationKey {
+			added = append(added, setting.key)
+		}
+		t.Annotations[setting.key] = setting.value
+	}
+	if len(added) > 0 {
+		t.Annotations[injectedRedirectionAnnotationKey] = strings.Join(added, ",")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestParseInterceptionMode(t *testing.T) {
+	for value, want := range map[string]InterceptionMode{
+		"":         InterceptionIptables,
+		"iptables": InterceptionIptables,
+		"cni":      InterceptionCNI,
+		"ebpf":     InterceptionEBPF,
+	} {
+		if got, err := ParseInterceptionMode(value); err != nil || got != want {
+			t.Errorf("ParseInterceptionMode(%q) => got %q, %v, want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseInterceptionMode("tproxy"); err == nil {
+		t.Error("ParseInterceptionMode() accepted an unknown mode")
+	}
+}
+
+func TestAnnotateRedirection(t *testing.T) {
+	for _, mode := range []InterceptionMode{InterceptionCNI, InterceptionEBPF} {
+		mesh := proxy.DefaultMeshConfig()
+		p := &Params{
+			InitImage:        InitImageName(unitTestHub, unitTestTag),
+			ProxyImage:       ProxyImageName(unitTestHub, unitTestTag),
+			SidecarProxyUID:  DefaultSidecarProxyUID,
+			Mesh:             &mesh,
+			IncludeIPRanges:  "10.0.0.0/8, 172.16.0.0/12",
+			InterceptionMode: mode,
+		}
+		tmpl := &v1.PodTemplateSpec{}
+		tmpl.Annotations = map[string]string{excludeOutboundPortsAnnotationKey: "5432"}
+		tmpl.Spec.Containers = []v1.Container{{Name: "app", Image: "app"}}
+		if err := IntoPodTemplateSpec(p, "default", tmpl); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := tmpl.Annotations[initContainersAnnotationKey]; ok {
+			t.Errorf("IntoPodTemplateSpec() in %s mode injected init containers: %v", mode, tmpl.Annotations)
+		}
+		for key, want := range map[string]string{
+			interceptionModeAnnotationKey:     string(mode),
+			proxyUIDAnnotationKey:             "1337",
+			includeIPRangesAnnotationKey:      "10.0.0.0/8,172.16.0.0/12",
+			excludeOutboundPortsAnnotationKey: "5432",
+		} {
+			if got := tmpl.Annotations[key]; got != want {
+				t.Errorf("IntoPodTemplateSpec() in %s mode => got annotation %s=%q, want %q", mode, key, got, want)
+			}
+		}
+
+		// The settings of the parameters are not taken for overrides
+		// once uninjected, the overrides of the workload are kept.
+		if err := FromPodTemplateSpec(tmpl); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{excludeOutboundPortsAnnotationKey: "5432"}; !reflect.DeepEqual(tmpl.Annotations, want) {
+			t.Errorf("FromPodTemplateSpec() in %s mode => got annotations %v, want %v", mode, tmpl.Annotations, want)
+		}
+
+		tmpl = &v1.PodTemplateSpec{}
+		tmpl.Annotations = map[string]string{excludeInboundPortsAnnotationKey: "http"}
+		if err := IntoPodTemplateSpec(p, "default", tmpl); err == nil {
+			t.Errorf("IntoPodTemplateSpec() in %s mode accepted an invalid port", mode)
+		}
+
+		// The ranges of the parameters are checked at injection rather
+		// than when the pod starts.
+		p.IncludeIPRanges = "10.0.0.0/8,192.168.0.0"
+		if err := IntoPodTemplateSpec(p, "default", &v1.PodTemplateSpec{}); err == nil || !strings.Contains(err.Error(), `"192.168.0.0"`) {
+			t.Errorf("IntoPodTemplateSpec() in %s mode => got error %v, want the invalid IP range", mode, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// documentReader reads the documents of a YAML file, in which streams
+// of JSON objects are split into one document for every object.
+type documentReader struct {
+	reader  *yamlDecoder.YAMLReader
+	pending [][]byte
+}
+
+func newDocumentReader(in io.Reader) *documentReader {
+	return &documentReader{reader: yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))}
+}
+
+func (r *documentReader) Read() ([]byte, error) {
+	for len(r.pending) == 0 {
+		doc, err := r.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.pending = splitJSON(doc)
+	}
+	doc := r.pending[0]
+	r.pending = r.pending[1:]
+	return doc, nil
+}
+
+// splitJSON splits a document holding several JSON objects, which are
+// valid YAML documents on their own. Every object is terminated by a
+// new line so that it can be written verbatim before a document
+// separator. Other documents are returned whole.
+func splitJSON(doc []byte) [][]byte {
+	trimmed := bytes.TrimSpace(doc)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return [][]byte{doc}
+	}
+	var docs [][]byte
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// e.g. a YAML flow mapping
+			return [][]byte{doc}
+		}
+		docs = append(docs, append(raw, '\n'))
+	}
+	if len(docs) < 2 {
+		return [][]byte{doc}
+	}
+	return docs
+}
+
+// WriteJSON converts the kubernetes resources of a YAML file, e.g. the
+// output of IntoResourceFile, to a stream of indented JSON objects.
+func WriteJSON(in io.Reader, out io.Writer) error {
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, []byte("null")) {
+			continue
+		}
+		var indented bytes.Buffer
+		if err = json.Indent(&indented, data, "", "  "); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(out, "%s\n", indented.Bytes()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestNewDocumentReader(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{in: "kind: a\n---\nkind: b\n", want: []string{"kind: a\n", "kind: b\n"}},
+		{in: "\n  {\"kind\": \"a\"}\n{\"kind\": \"b\"}", want: []string{"{\"kind\": \"a\"}\n", "{\"kind\": \"b\"}\n"}},
+		{in: "{\"kind\": \"a\"}\n---\nkind: b\n", want: []string{"{\"kind\": \"a\"}\n", "kind: b\n"}},
+		{in: "{kind: a}\n", want: []string{"{kind: a}\n"}},
+		{in: "", want: nil},
+	}
+	for _, c := range cases {
+		reader := newDocumentReader(strings.NewReader(c.in))
+		var got []string
+		for {
+			doc, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading %q returned an error: %v", c.in, err)
+			}
+			got = append(got, string(doc))
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("reading %q => got %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	in, err := os.Open("testdata/frontend.yaml.injected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = in.Close() }()
+	var out bytes.Buffer
+	if err = WriteJSON(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	util.CompareContent(out.Bytes(), "testdata/frontend.json.injected", t)
+}
@@ -0,0 +1,343 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// PatchOperation is an operation of a JSON Patch (RFC 6902).
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ResourcePatch is the JSON Patch that injects a resource, e.g. for
+// kubectl patch --type json.
+type ResourcePatch struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Name       string           `json:"name"`
+	Patch      []PatchOperation `json:"patch"`
+}
+
+// WriteJSONPatches writes a JSON array with the patch of every
+// resource changed between the original YAML file and its injected
+// output, which must hold the same documents in the same order. The
+// items of Lists are patched one by one.
+func WriteJSONPatches(original, injected io.Reader, out io.Writer) error {
+	before, err := readObjects(original)
+	if err != nil {
+		return err
+	}
+	after, err := readObjects(injected)
+	if err != nil {
+		return err
+	}
+	if len(before) != len(after) {
+		return fmt.Errorf("injected output has %d resources, want %d", len(after), len(before))
+	}
+	patches := []ResourcePatch{}
+	for i := range before {
+		var meta resourceMeta
+		if err = convert(before[i], &meta); err != nil {
+			return err
+		}
+		ops := diffJSON("", before[i], after[i])
+		if len(ops) == 0 {
+			continue
+		}
+		patches = append(patches, ResourcePatch{
+			APIVersion: meta.APIVersion,
+			Kind:       meta.Kind,
+			Namespace:  meta.Metadata.Namespace,
+			Name:       meta.Metadata.Name,
+			Patch:      ops,
+		})
+	}
+	data, err := json.MarshalIndent(patches, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+// PodSpecJSONPatch returns the operations at the path that change the
+// original JSON pod spec, e.g. of an admitted pod, into the spec of the
+// pod template injected from it. Only the fields changed by injection
+// are patched, so the fields of the original spec that the kubernetes
+// types do not know are kept.
+func PodSpecJSONPatch(p *Params, path string, original []byte, t *v1.PodTemplateSpec) ([]PatchOperation, error) {
+	var before map[string]interface{}
+	if err := decodeJSON(original, &before); err != nil {
+		return nil, err
+	}
+	prune(before)
+	var names []string
+	containers, _ := before["containers"].([]interface{})
+	for _, c := range t.Spec.Containers {
+		if !hasNamedElement(containers, c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+	data, err := json.Marshal(t.Spec)
+	if err != nil {
+		return nil, err
+	}
+	var after map[string]interface{}
+	if err = decodeJSON(data, &after); err != nil {
+		return nil, err
+	}
+	prune(after)
+	for _, patch := range PodSpecPatches(p, names, t) {
+		if err = patch(after); err != nil {
+			return nil, err
+		}
+	}
+	return diffJSON(path, before, after), nil
+}
+
+// PodTemplateJSONPatch returns the operations at the path that change
+// the original JSON pod template, or pod, into the pod template
+// injected from it: the annotations and labels are replaced and the
+// spec is patched as by PodSpecJSONPatch.
+func PodTemplateJSONPatch(p *Params, path string, original []byte, t *v1.PodTemplateSpec) ([]PatchOperation, error) {
+	var raw struct {
+		Metadata json.RawMessage `json:"metadata"`
+		Spec     json.RawMessage `json:"spec"`
+	}
+	if err := json.Unmarshal(original, &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Spec) == 0 || string(raw.Spec) == "null" {
+		raw.Spec = []byte("{}")
+	}
+	specOps, err := PodSpecJSONPatch(p, path+"/spec", raw.Spec, t)
+	if err != nil {
+		return nil, err
+	}
+	var ops []PatchOperation
+	if len(raw.Metadata) == 0 || string(raw.Metadata) == "null" {
+		ops = append(ops, PatchOperation{Op: "add", Path: path + "/metadata", Value: metav1.ObjectMeta{
+			Annotations: t.Annotations,
+			Labels:      t.Labels,
+		}})
+	} else {
+		// The add operation replaces existing members.
+		ops = append(ops, PatchOperation{Op: "add", Path: path + "/metadata/annotations", Value: t.Annotations})
+		if len(t.Labels) > 0 {
+			ops = append(ops, PatchOperation{Op: "add", Path: path + "/metadata/labels", Value: t.Labels})
+		}
+	}
+	return append(ops, specOps...), nil
+}
+
+// decodeJSON decodes JSON keeping numbers as written, so that they
+// compare equal after a round trip.
+func decodeJSON(data []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
+
+// readObjects decodes the resources of a YAML file without their null
+// fields, replacing Lists with their items.
+func readObjects(in io.Reader) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		var object map[string]interface{}
+		if err = decodeJSON(data, &object); err != nil {
+			return nil, err
+		}
+		prune(object)
+		objects = appendObject(objects, object)
+	}
+	return objects, nil
+}
+
+func appendObject(objects []map[string]interface{}, object map[string]interface{}) []map[string]interface{} {
+	if len(object) == 0 {
+		return objects
+	}
+	if object["kind"] != "List" || object["apiVersion"] != "v1" {
+		return append(objects, object)
+	}
+	items, _ := object["items"].([]interface{})
+	for _, item := range items {
+		item, _ := item.(map[string]interface{})
+		objects = appendObject(objects, item)
+	}
+	return objects
+}
+
+// diffJSON returns the operations that change a decoded JSON value at
+// the path into another. Injection marshals resources with the
+// vendored kubernetes types, which drop the fields they do not know
+// and add unset fields as null, empty strings, or empty objects. Such changes are left
+// out so that the patch does not reset fields of the server. Elements
+// inserted into arrays of named objects, e.g. a proxy container put
+// before the application, are added at their index. Other arrays that
+// grew are appended to, and arrays that shrank are replaced.
+func diffJSON(path string, before, after interface{}) []PatchOperation {
+	switch after := after.(type) {
+	case map[string]interface{}:
+		before, ok := before.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var ops []PatchOperation
+		for _, key := range sortedKeys(after) {
+			value := after[key]
+			old, ok := before[key]
+			if !ok {
+				if !isEmptyJSON(value) {
+					ops = append(ops, PatchOperation{Op: "add", Path: path + "/" + escapePointer(key), Value: value})
+				}
+				continue
+			}
+			ops = append(ops, diffJSON(path+"/"+escapePointer(key), old, value)...)
+		}
+		return ops
+	case []interface{}:
+		before, ok := before.([]interface{})
+		if !ok {
+			break
+		}
+		if ops, ok := diffNamed(path, before, after); ok {
+			return ops
+		}
+		if len(after) >= len(before) {
+			var ops []PatchOperation
+			for i := range before {
+				ops = append(ops, diffJSON(path+"/"+strconv.Itoa(i), before[i], after[i])...)
+			}
+			for _, value := range after[len(before):] {
+				ops = append(ops, PatchOperation{Op: "add", Path: path + "/-", Value: value})
+			}
+			return ops
+		}
+	default:
+		if reflect.DeepEqual(before, after) {
+			return nil
+		}
+	}
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	return []PatchOperation{{Op: "replace", Path: path, Value: after}}
+}
+
+// diffNamed diffs arrays of objects with a name, e.g. containers, by
+// matching their elements by name. It fails unless the elements of the
+// array before are kept in the same order and only new names are
+// inserted.
+func diffNamed(path string, before, after []interface{}) ([]PatchOperation, bool) {
+	names := make(map[string]bool, len(before))
+	for _, value := range before {
+		name := elementName(value)
+		if name == "" || names[name] {
+			return nil, false
+		}
+		names[name] = true
+	}
+	var ops []PatchOperation
+	next := 0
+	for i, value := range after {
+		name := elementName(value)
+		switch {
+		case name == "":
+			return nil, false
+		case next < len(before) && elementName(before[next]) == name:
+			ops = append(ops, diffJSON(path+"/"+strconv.Itoa(i), before[next], value)...)
+			next++
+		case names[name]:
+			// Moved or duplicated.
+			return nil, false
+		case next == len(before):
+			ops = append(ops, PatchOperation{Op: "add", Path: path + "/-", Value: value})
+		default:
+			ops = append(ops, PatchOperation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: value})
+		}
+	}
+	return ops, next == len(before)
+}
+
+// elementName returns the name of an array element, or an empty string
+// if it is not an object with a name.
+func elementName(value interface{}) string {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := object["name"].(string)
+	return name
+}
+
+func hasNamedElement(list []interface{}, name string) bool {
+	for _, value := range list {
+		if elementName(value) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyJSON(value interface{}) bool {
+	if value == nil || value == "" {
+		return true
+	}
+	object, ok := value.(map[string]interface{})
+	return ok && len(object) == 0
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a key for a JSON Pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
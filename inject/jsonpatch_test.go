@@ -0,0 +1,161 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteJSONPatches(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want string
+	}{
+		{in: "testdata/hello-multi.yaml", want: "testdata/hello-multi.jsonpatch.json"},
+		{in: "testdata/list.yaml", want: "testdata/list.jsonpatch.json"},
+		{in: "testdata/hello-hold.yaml", want: "testdata/hello-hold.jsonpatch.json"},
+	} {
+		original, err := os.Open(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		injected, err := os.Open(c.in + ".injected")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = WriteJSONPatches(original, injected, &out)
+		_, _ = original.Close(), injected.Close()
+		if err != nil {
+			t.Fatalf("WriteJSONPatches(%s) returned an error: %v", c.in, err)
+		}
+		util.CompareContent(out.Bytes(), c.want, t)
+	}
+}
+
+func TestWriteJSONPatchesMismatch(t *testing.T) {
+	original := "kind: ConfigMap\n---\nkind: Secret\n"
+	var out bytes.Buffer
+	if err := WriteJSONPatches(strings.NewReader(original), strings.NewReader("kind: ConfigMap\n"), &out); err == nil {
+		t.Error("WriteJSONPatches() succeeded for outputs with another number of resources")
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	cases := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   []PatchOperation
+	}{
+		{
+			name:   "escaped key",
+			before: map[string]interface{}{"annotations": map[string]interface{}{}},
+			after:  map[string]interface{}{"annotations": map[string]interface{}{"alpha.istio.io/sidecar": "injected", "a~b": "c"}},
+			want: []PatchOperation{
+				{Op: "add", Path: "/annotations/alpha.istio.io~1sidecar", Value: "injected"},
+				{Op: "add", Path: "/annotations/a~0b", Value: "c"},
+			},
+		},
+		{
+			name:   "marshaled unset fields",
+			before: map[string]interface{}{"name": "hello", "dropped": true},
+			after:  map[string]interface{}{"name": "hello", "creationTimestamp": nil, "status": map[string]interface{}{}},
+		},
+		{
+			name:   "appended",
+			before: map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "hello"}}},
+			after: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "resources": map[string]interface{}{}},
+				map[string]interface{}{"name": "proxy"},
+			}},
+			want: []PatchOperation{{Op: "add", Path: "/containers/-", Value: map[string]interface{}{"name": "proxy"}}},
+		},
+		{
+			name:   "prepended",
+			before: map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "hello", "image": "hello"}}},
+			after: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "proxy", "image": "proxy"},
+				map[string]interface{}{"name": "hello", "image": "hello"},
+			}},
+			want: []PatchOperation{{Op: "add", Path: "/containers/0", Value: map[string]interface{}{"name": "proxy", "image": "proxy"}}},
+		},
+		{
+			name:   "shrunk",
+			before: map[string]interface{}{"args": []interface{}{"a", "b"}},
+			after:  map[string]interface{}{"args": []interface{}{"a"}},
+			want:   []PatchOperation{{Op: "replace", Path: "/args", Value: []interface{}{"a"}}},
+		},
+		{
+			name:   "replaced",
+			before: map[string]interface{}{"terminationGracePeriodSeconds": 30},
+			after:  map[string]interface{}{"terminationGracePeriodSeconds": 45},
+			want:   []PatchOperation{{Op: "replace", Path: "/terminationGracePeriodSeconds", Value: 45}},
+		},
+	}
+	for _, c := range cases {
+		if got := diffJSON("", c.before, c.after); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPodTemplateJSONPatch(t *testing.T) {
+	template := &v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"alpha.istio.io/sidecar": "injected"},
+			Labels:      map[string]string{"app": "hello"},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "hello"}}},
+	}
+	cases := []struct {
+		name     string
+		original string
+		want     []string
+	}{
+		{
+			name:     "metadata",
+			original: `{"metadata":{"labels":{"app":"hello"}},"spec":{"containers":[{"name":"hello"}]}}`,
+			want:     []string{"add /template/metadata/annotations", "add /template/metadata/labels"},
+		},
+		{
+			name:     "no metadata",
+			original: `{"spec":{"containers":[{"name":"hello"}]}}`,
+			want:     []string{"add /template/metadata"},
+		},
+	}
+	for _, c := range cases {
+		ops, err := PodTemplateJSONPatch(&Params{}, "/template", []byte(c.original), template)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got []string
+		for _, op := range ops {
+			got = append(got, op.Op+" "+op.Path)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got operations %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// knativeServiceKind is the kind of Knative Services, which keep the
+// pod template of their revisions under spec.template.
+var knativeServiceKind = schema.GroupKind{Group: "serving.knative.dev", Kind: "Service"}
+
+// knativeInjectAnnotationKey requests the admission webhook of the mesh
+// to inject the pods created for a revision.
+const knativeInjectAnnotationKey = "sidecar.istio.io/inject"
+
+// knativeReason explains why Knative Services are only annotated.
+const knativeReason = "Knative does not allow init containers to redirect traffic, " +
+	"annotated " + knativeInjectAnnotationKey + " for injection at admission"
+
+// annotateKnativeService annotates the revision template of a Knative
+// Service for injection by the admission webhook of the mesh. Knative
+// rejects init containers in revisions, so the proxy cannot be added
+// offline without losing traffic redirection. Fields of the service
+// that the vendored types do not know are kept by editing the document
+// as a map. The returned reason is empty if the template was annotated.
+func annotateKnativeService(raw []byte) ([]byte, string, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, "", err
+	}
+	spec, _ := doc["spec"].(map[string]interface{})
+	template, ok := spec["template"].(map[string]interface{})
+	if !ok {
+		return raw, "Knative Service does not have spec.template", nil
+	}
+	metadata, _ := template["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+		template["metadata"] = metadata
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	if status, ok := annotations[istioSidecarAnnotationSidecarKey]; ok {
+		return raw, fmt.Sprintf("annotation %s is already set to %q", istioSidecarAnnotationSidecarKey, status), nil
+	}
+	if value, ok := annotations[knativeInjectAnnotationKey]; ok {
+		return raw, fmt.Sprintf("annotation %s is already set to %q", knativeInjectAnnotationKey, value), nil
+	}
+	annotations[knativeInjectAnnotationKey] = "true"
+	updated, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	return updated, "", nil
+}
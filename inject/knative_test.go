@@ -0,0 +1,66 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotateKnativeService(t *testing.T) {
+	cases := []struct {
+		name       string
+		in         string
+		wantReason string
+		wantAdded  bool
+	}{
+		{
+			name:      "annotated",
+			in:        "spec:\n  template:\n    spec:\n      containers:\n      - image: hello\n",
+			wantAdded: true,
+		},
+		{
+			name:       "opted out",
+			in:         "spec:\n  template:\n    metadata:\n      annotations:\n        sidecar.istio.io/inject: \"false\"\n",
+			wantReason: "annotation sidecar.istio.io/inject is already set to \"false\"",
+		},
+		{
+			name:       "ignored",
+			in:         "spec:\n  template:\n    metadata:\n      annotations:\n        alpha.istio.io/sidecar: ignore\n",
+			wantReason: "annotation alpha.istio.io/sidecar is already set to \"ignore\"",
+		},
+		{
+			name:       "no template",
+			in:         "spec:\n  runLatest: {}\n",
+			wantReason: "Knative Service does not have spec.template",
+		},
+	}
+	for _, c := range cases {
+		updated, reason, err := annotateKnativeService([]byte(c.in))
+		if err != nil {
+			t.Errorf("%s: annotateKnativeService() failed: %v", c.name, err)
+			continue
+		}
+		if reason != c.wantReason {
+			t.Errorf("%s: got reason %q, want %q", c.name, reason, c.wantReason)
+		}
+		if added := strings.Contains(string(updated), "sidecar.istio.io/inject: \"true\""); added != c.wantAdded {
+			t.Errorf("%s: got %s, want annotation added %t", c.name, updated, c.wantAdded)
+		}
+		if !c.wantAdded && string(updated) != c.in {
+			t.Errorf("%s: got %s, want the input unchanged", c.name, updated)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"sort"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// LimitMode selects how injection treats proxy resources that violate
+// the LimitRanges of a namespace.
+type LimitMode string
+
+const (
+	// LimitValidate fails the injection of the resource.
+	LimitValidate LimitMode = "validate"
+	// LimitClamp adjusts the proxy resources to the nearest values the
+	// LimitRanges allow.
+	LimitClamp LimitMode = "clamp"
+)
+
+// LimitPolicy holds the LimitRanges the proxy resources must comply
+// with, so that the API server admits the injected pods.
+type LimitPolicy struct {
+	Mode LimitMode
+	// LimitRanges by namespace.
+	LimitRanges map[string][]v1.LimitRange
+	// DefaultNamespace is used for resources that do not set one.
+	DefaultNamespace string
+}
+
+// limitNames returns the sorted resource names constrained by a
+// LimitRange item.
+func limitNames(item v1.LimitRangeItem) []string {
+	seen := make(map[v1.ResourceName]bool)
+	for _, list := range []v1.ResourceList{item.Min, item.Max, item.Default, item.DefaultRequest, item.MaxLimitRequestRatio} {
+		for name := range list {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copyResources(list v1.ResourceList) v1.ResourceList {
+	out := make(v1.ResourceList, len(list))
+	for name, q := range list {
+		out[name] = q
+	}
+	return out
+}
+
+// Apply checks the proxy resources of a pod in the namespace against
+// the container LimitRanges, including the limits and requests the API
+// server would default. In clamp mode the returned resources are
+// adjusted to comply; in validate mode every violation is returned.
+func (l *LimitPolicy) Apply(namespace string, r v1.ResourceRequirements) (v1.ResourceRequirements, error) {
+	if namespace == "" {
+		namespace = l.DefaultNamespace
+	}
+	out := v1.ResourceRequirements{
+		Requests: copyResources(r.Requests),
+		Limits:   copyResources(r.Limits),
+	}
+	var errs error
+	for _, limitRange := range l.LimitRanges[namespace] {
+		for _, item := range limitRange.Spec.Limits {
+			if item.Type != v1.LimitTypeContainer {
+				continue
+			}
+			for _, name := range limitNames(item) {
+				violations := applyLimitItem(item, v1.ResourceName(name), &out)
+				for _, violation := range violations {
+					errs = multierror.Append(errs, fmt.Errorf("LimitRange %s/%s: proxy %s %s",
+						namespace, limitRange.Name, name, violation))
+				}
+			}
+		}
+	}
+	if errs != nil && l.Mode != LimitClamp {
+		return r, errs
+	}
+	if len(out.Requests) == 0 {
+		out.Requests = nil
+	}
+	if len(out.Limits) == 0 {
+		out.Limits = nil
+	}
+	return out, nil
+}
+
+// applyLimitItem clamps one resource of the requirements to a
+// LimitRange item and describes the violations it corrected.
+func applyLimitItem(item v1.LimitRangeItem, name v1.ResourceName, r *v1.ResourceRequirements) []string {
+	var violations []string
+	// The API server defaults the limit to the default or maximum of
+	// the range, and the request to the default request or the limit.
+	limit, hasLimit := r.Limits[name]
+	if !hasLimit {
+		if limit, hasLimit = item.Default[name]; !hasLimit {
+			limit, hasLimit = item.Max[name]
+		}
+	}
+	request, hasRequest := r.Requests[name]
+	if !hasRequest {
+		if request, hasRequest = item.DefaultRequest[name]; !hasRequest {
+			request, hasRequest = limit, hasLimit
+		}
+	}
+
+	if min, ok := item.Min[name]; ok {
+		if hasRequest && request.Cmp(min) < 0 {
+			violations = append(violations, fmt.Sprintf("request %s is below the minimum %s", request.String(), min.String()))
+			request = min
+			r.Requests[name] = request
+		}
+		if hasLimit && limit.Cmp(min) < 0 {
+			violations = append(violations, fmt.Sprintf("limit %s is below the minimum %s", limit.String(), min.String()))
+			limit = min
+			r.Limits[name] = limit
+		}
+	}
+	if max, ok := item.Max[name]; ok {
+		if limit.Cmp(max) > 0 {
+			violations = append(violations, fmt.Sprintf("limit %s is above the maximum %s", limit.String(), max.String()))
+			limit = max
+			r.Limits[name] = limit
+		}
+		if request.Cmp(max) > 0 {
+			violations = append(violations, fmt.Sprintf("request %s is above the maximum %s", request.String(), max.String()))
+			request = max
+			r.Requests[name] = request
+		}
+	}
+	if hasLimit && hasRequest && request.Cmp(limit) > 0 {
+		violations = append(violations, fmt.Sprintf("request %s is above the limit %s", request.String(), limit.String()))
+		request = limit
+		r.Requests[name] = request
+	}
+	if ratio, ok := item.MaxLimitRequestRatio[name]; ok && hasLimit && hasRequest && !request.IsZero() {
+		// limit / request <= ratio, computed in milli units.
+		if limit.MilliValue()*1000 > ratio.MilliValue()*request.MilliValue() {
+			violations = append(violations, fmt.Sprintf("limit %s is more than %s times the request %s",
+				limit.String(), ratio.String(), request.String()))
+			milli := (limit.MilliValue()*1000 + ratio.MilliValue() - 1) / ratio.MilliValue()
+			r.Requests[name] = *resource.NewMilliQuantity(milli, request.Format)
+		}
+	}
+	return violations
+}
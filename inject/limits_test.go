@@ -0,0 +1,110 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestLimitPolicyApply(t *testing.T) {
+	limitRange := v1.LimitRange{}
+	limitRange.Name = "containers"
+	limitRange.Spec.Limits = []v1.LimitRangeItem{{
+		Type: v1.LimitTypePod,
+		Max:  parseResources(map[v1.ResourceName]string{"cpu": "1m"}),
+	}, {
+		Type:                 v1.LimitTypeContainer,
+		Min:                  parseResources(map[v1.ResourceName]string{"cpu": "50m"}),
+		Max:                  parseResources(map[v1.ResourceName]string{"memory": "256Mi"}),
+		Default:              parseResources(map[v1.ResourceName]string{"cpu": "200m"}),
+		MaxLimitRequestRatio: parseResources(map[v1.ResourceName]string{"cpu": "2"}),
+	}}
+	cases := []struct {
+		name     string
+		in       v1.ResourceRequirements
+		requests map[v1.ResourceName]string
+		limits   map[v1.ResourceName]string
+		errors   []string
+	}{
+		{
+			name:     "compliant",
+			in:       v1.ResourceRequirements{Requests: parseResources(map[v1.ResourceName]string{"cpu": "100m", "memory": "128Mi"})},
+			requests: map[v1.ResourceName]string{"cpu": "100m", "memory": "128Mi"},
+		},
+		{
+			name:     "below minimum and ratio",
+			in:       v1.ResourceRequirements{Requests: parseResources(map[v1.ResourceName]string{"cpu": "10m"})},
+			requests: map[v1.ResourceName]string{"cpu": "100m"},
+			errors: []string{
+				"LimitRange web/containers: proxy cpu request 10m is below the minimum 50m",
+				"LimitRange web/containers: proxy cpu limit 200m is more than 2 times the request 50m",
+			},
+		},
+		{
+			name: "above maximum and default limit",
+			in: v1.ResourceRequirements{
+				Requests: parseResources(map[v1.ResourceName]string{"cpu": "500m", "memory": "512Mi"}),
+			},
+			requests: map[v1.ResourceName]string{"cpu": "200m", "memory": "256Mi"},
+			errors: []string{
+				"LimitRange web/containers: proxy cpu request 500m is above the limit 200m",
+				"LimitRange web/containers: proxy memory request 512Mi is above the maximum 256Mi",
+			},
+		},
+		{
+			name: "limit above maximum",
+			in: v1.ResourceRequirements{
+				Requests: parseResources(map[v1.ResourceName]string{"cpu": "100m"}),
+				Limits:   parseResources(map[v1.ResourceName]string{"cpu": "100m", "memory": "1Gi"}),
+			},
+			limits: map[v1.ResourceName]string{"memory": "256Mi"},
+			errors: []string{"LimitRange web/containers: proxy memory limit 1Gi is above the maximum 256Mi"},
+		},
+	}
+	for _, c := range cases {
+		for _, mode := range []LimitMode{LimitValidate, LimitClamp} {
+			l := &LimitPolicy{Mode: mode, LimitRanges: map[string][]v1.LimitRange{"web": {limitRange}}, DefaultNamespace: "web"}
+			got, err := l.Apply("", c.in)
+			if mode == LimitValidate {
+				for _, want := range c.errors {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("%s: Apply() => got error %v, want %q", c.name, err, want)
+					}
+				}
+				if len(c.errors) == 0 && err != nil {
+					t.Errorf("%s: Apply() returned an error: %v", c.name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: Apply() in clamp mode returned an error: %v", c.name, err)
+				continue
+			}
+			for name, want := range c.requests {
+				if q := got.Requests[name]; q.String() != want {
+					t.Errorf("%s: Apply() => got %s request %s, want %s", c.name, name, q.String(), want)
+				}
+			}
+			for name, want := range c.limits {
+				if q := got.Limits[name]; q.String() != want {
+					t.Errorf("%s: Apply() => got %s limit %s, want %s", c.name, name, q.String(), want)
+				}
+			}
+		}
+	}
+}
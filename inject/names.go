@@ -0,0 +1,77 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"regexp"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// proxyContainerNameAnnotationKey records the name of the injected proxy
+// container if it is not the default, so that it can be found after
+// injection.
+const proxyContainerNameAnnotationKey = "sidecar.wharfie.io/proxyContainerName"
+
+// containerNamePattern matches the DNS labels accepted as container
+// names by the API server.
+var containerNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidateContainerName checks that a container name is accepted by
+// the API server.
+func ValidateContainerName(name string) error {
+	if len(name) > 63 || !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, expected a DNS label of at most 63 characters", name)
+	}
+	return nil
+}
+
+// proxyName returns the name of the proxy container of the parameters.
+func (p *Params) proxyName() string {
+	if p.ProxyContainerName == "" {
+		return proxyContainerName
+	}
+	return p.ProxyContainerName
+}
+
+// injectedProxyName returns the name of the proxy container of an
+// injected pod template.
+func injectedProxyName(t *v1.PodTemplateSpec) string {
+	if name := t.Annotations[proxyContainerNameAnnotationKey]; name != "" {
+		return name
+	}
+	return proxyContainerName
+}
+
+// checkContainerName rejects pod templates that already have a
+// container, an init container, or an init container of the annotation
+// of the name of an injected container, which would end up duplicated.
+func checkContainerName(t *v1.PodTemplateSpec, annotations []interface{}, name string) error {
+	for _, containers := range [][]v1.Container{t.Spec.Containers, t.Spec.InitContainers} {
+		for _, c := range containers {
+			if c.Name == name {
+				return fmt.Errorf("the pod already has a container named %s, which conflicts with the injected container", name)
+			}
+		}
+	}
+	for _, item := range annotations {
+		if c, ok := item.(map[string]interface{}); ok && c["name"] == name {
+			return fmt.Errorf("the pod already has an init container named %s in annotation %s, "+
+				"which conflicts with the injected container", name, initContainersAnnotationKey)
+		}
+	}
+	return nil
+}
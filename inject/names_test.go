@@ -0,0 +1,134 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestValidateContainerName(t *testing.T) {
+	for _, name := range []string{"proxy", "mesh-proxy", "p2"} {
+		if err := ValidateContainerName(name); err != nil {
+			t.Errorf("ValidateContainerName(%q) => got error %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Proxy", "mesh_proxy", "-proxy", strings.Repeat("p", 64)} {
+		if err := ValidateContainerName(name); err == nil {
+			t.Errorf("ValidateContainerName(%q) => got no error", name)
+		}
+	}
+}
+
+func TestContainerNameConflicts(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	newParams := func() *Params {
+		return &Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag),
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Mesh:            &mesh,
+		}
+	}
+	cases := []struct {
+		name           string
+		containers     []string
+		initContainers []string
+		annotation     string
+		proxyName      string
+		wantErr        string
+	}{
+		{name: "proxy container", containers: []string{"proxy"}, wantErr: "container named proxy"},
+		{name: "proxy init container", containers: []string{"app"}, initContainers: []string{"proxy"}, wantErr: "container named proxy"},
+		{name: "init container", containers: []string{"app"}, initContainers: []string{"init"}, wantErr: "container named init"},
+		{
+			name:       "init container of the annotation",
+			containers: []string{"app"},
+			annotation: `[{"name":"init","image":"busybox"}]`,
+			wantErr:    "init container named init",
+		},
+		{name: "renamed proxy", containers: []string{"proxy"}, proxyName: "mesh-proxy"},
+		{name: "renamed proxy conflict", containers: []string{"app", "mesh-proxy"}, proxyName: "mesh-proxy", wantErr: "container named mesh-proxy"},
+	}
+	for _, c := range cases {
+		p := newParams()
+		p.ProxyContainerName = c.proxyName
+		template := &v1.PodTemplateSpec{}
+		for _, name := range c.containers {
+			template.Spec.Containers = append(template.Spec.Containers, v1.Container{Name: name, Image: name})
+		}
+		for _, name := range c.initContainers {
+			template.Spec.InitContainers = append(template.Spec.InitContainers, v1.Container{Name: name, Image: name})
+		}
+		if c.annotation != "" {
+			template.Annotations = map[string]string{initContainersAnnotationKey: c.annotation}
+		}
+		err := IntoPodTemplateSpec(p, "default", template)
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: IntoPodTemplateSpec() returned an error: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: IntoPodTemplateSpec() => got error %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestRenamedProxy(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{
+		InitImage:          InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:         ProxyImageName(unitTestHub, unitTestTag),
+		SidecarProxyUID:    DefaultSidecarProxyUID,
+		Mesh:               &mesh,
+		ProxyContainerName: "mesh-proxy",
+		SidecarMode:        SidecarNative,
+	}
+	template := &v1.PodTemplateSpec{}
+	template.Spec.Containers = []v1.Container{{Name: "proxy", Image: "nginx"}}
+	if err := IntoPodTemplateSpec(p, "default", template); err != nil {
+		t.Fatal(err)
+	}
+	if got := injectedProxyName(template); got != "mesh-proxy" {
+		t.Errorf("injectedProxyName() => got %q, want mesh-proxy", got)
+	}
+
+	spec := map[string]interface{}{"containers": []interface{}{
+		map[string]interface{}{"name": "proxy"},
+		map[string]interface{}{"name": "mesh-proxy"},
+	}}
+	if err := PatchNativeSidecar(p, template, spec); err != nil {
+		t.Fatal(err)
+	}
+	if initContainers := spec["initContainers"].([]interface{}); initContainers[0].(map[string]interface{})["name"] != "mesh-proxy" {
+		t.Errorf("PatchNativeSidecar() => got init containers %v, want the renamed proxy", initContainers)
+	}
+
+	if err := FromPodTemplateSpec(template); err != nil {
+		t.Fatal(err)
+	}
+	if containers := template.Spec.Containers; len(containers) != 1 || containers[0].Image != "nginx" {
+		t.Errorf("FromPodTemplateSpec() => got containers %v, want the proxy of the application", containers)
+	}
+	if _, ok := template.Annotations[proxyContainerNameAnnotationKey]; ok {
+		t.Errorf("FromPodTemplateSpec() kept annotation %s", proxyContainerNameAnnotationKey)
+	}
+}
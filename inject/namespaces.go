@@ -0,0 +1,121 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NamespacePolicy restricts injection to the namespaces that opted in
+// to the mesh. A namespace is injected if it is not excluded, is
+// included when an include list is set, and its labels match the
+// selector when one is set.
+type NamespacePolicy struct {
+	// Include lists the namespaces to inject, all if empty.
+	Include []string `json:"include,omitempty"`
+	// Exclude lists the namespaces never injected, e.g. kube-system.
+	Exclude []string `json:"exclude,omitempty"`
+	// Selector matches the labels of the namespace, e.g.
+	// istio-injection=enabled.
+	Selector string `json:"selector,omitempty"`
+	// Labels holds the labels of the namespaces of the manifests
+	// injected offline, which are not read from a cluster.
+	Labels map[string]map[string]string `json:"labels,omitempty"`
+	// Lookup, if set, reads the labels of a namespace instead of
+	// Labels, e.g. from the cluster.
+	Lookup func(namespace string) (map[string]string, error) `json:"-"`
+	// DefaultNamespace is the namespace of resources without one.
+	DefaultNamespace string `json:"-"`
+}
+
+// LoadNamespacePolicy reads a namespace policy in YAML.
+func LoadNamespacePolicy(in io.Reader) (*NamespacePolicy, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var n NamespacePolicy
+	if err = yaml.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if _, err = labels.Parse(n.Selector); err != nil {
+		return nil, fmt.Errorf("invalid namespace selector %q: %v", n.Selector, err)
+	}
+	return &n, nil
+}
+
+// Skip returns why the policy leaves a namespace with the given labels
+// without the proxy, or an empty string if it is injected.
+func (n *NamespacePolicy) Skip(namespace string, nsLabels map[string]string) (string, error) {
+	if n == nil {
+		return "", nil
+	}
+	if contains(n.Exclude, namespace) {
+		return fmt.Sprintf("namespace %s is excluded by the namespace policy", namespace), nil
+	}
+	if len(n.Include) > 0 && !contains(n.Include, namespace) {
+		return fmt.Sprintf("namespace %s is not included by the namespace policy", namespace), nil
+	}
+	if n.Selector != "" {
+		selector, err := labels.Parse(n.Selector)
+		if err != nil {
+			return "", fmt.Errorf("invalid namespace selector %q: %v", n.Selector, err)
+		}
+		if !selector.Matches(labels.Set(nsLabels)) {
+			return fmt.Sprintf("namespace %s does not match the namespace selector %s", namespace, n.Selector), nil
+		}
+	}
+	return "", nil
+}
+
+// NamespaceSkip returns why the namespace policy of the parameters
+// leaves a namespace without the proxy, or an empty string if it is
+// injected. The labels of the namespace are only looked up if the
+// policy has a selector.
+func NamespaceSkip(p *Params, namespace string) (string, error) {
+	n := p.Namespaces
+	if n == nil {
+		return "", nil
+	}
+	if namespace == "" {
+		namespace = n.DefaultNamespace
+	}
+	var nsLabels map[string]string
+	if n.Selector != "" {
+		if n.Lookup != nil {
+			var err error
+			if nsLabels, err = n.Lookup(namespace); err != nil {
+				return "", fmt.Errorf("cannot read the labels of namespace %s: %v", namespace, err)
+			}
+		} else {
+			nsLabels = n.Labels[namespace]
+		}
+	}
+	return n.Skip(namespace, nsLabels)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNamespacePolicy(t *testing.T) {
+	in := `
+include: [web, api, batch]
+exclude: [batch]
+selector: istio-injection=enabled
+labels:
+  web:
+    istio-injection: enabled
+`
+	n, err := LoadNamespacePolicy(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := map[string]string{"istio-injection": "enabled"}
+	cases := []struct {
+		namespace string
+		labels    map[string]string
+		want      string
+	}{
+		{namespace: "web", labels: enabled},
+		{namespace: "api", labels: enabled},
+		{namespace: "api", want: "does not match the namespace selector"},
+		{namespace: "batch", labels: enabled, want: "is excluded"},
+		{namespace: "default", labels: enabled, want: "is not included"},
+	}
+	for _, c := range cases {
+		got, err := n.Skip(c.namespace, c.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, c.want) || (got == "") != (c.want == "") {
+			t.Errorf("Skip(%s, %v) => got %q, want %q", c.namespace, c.labels, got, c.want)
+		}
+	}
+
+	p := &Params{Namespaces: n}
+	for namespace, want := range map[string]bool{"web": true, "api": false} {
+		if reason, err := NamespaceSkip(p, namespace); err != nil || (reason == "") != want {
+			t.Errorf("NamespaceSkip(%s) => got %q, %v, want injection %v", namespace, reason, err, want)
+		}
+	}
+	n.Lookup = func(namespace string) (map[string]string, error) {
+		if namespace == "api" {
+			return enabled, nil
+		}
+		return nil, errors.New("not found")
+	}
+	if reason, err := NamespaceSkip(p, "api"); err != nil || reason != "" {
+		t.Errorf("NamespaceSkip(api) => got %q, %v, want the labels looked up", reason, err)
+	}
+	if _, err := NamespaceSkip(p, "web"); err == nil {
+		t.Error("NamespaceSkip(web) => got no error from the lookup")
+	}
+	if reason, err := NamespaceSkip(&Params{}, "web"); err != nil || reason != "" {
+		t.Errorf("NamespaceSkip() without a policy => got %q, %v", reason, err)
+	}
+}
+
+func TestLoadNamespacePolicyErrors(t *testing.T) {
+	for _, in := range []string{"selector: 'a in (b'", "include: web"} {
+		if _, err := LoadNamespacePolicy(strings.NewReader(in)); err == nil {
+			t.Errorf("LoadNamespacePolicy(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// SidecarMode selects how the proxy is added to the pods.
+type SidecarMode string
+
+const (
+	// SidecarContainer adds the proxy as a regular container.
+	SidecarContainer SidecarMode = "container"
+	// SidecarNative adds the proxy as an init container with
+	// restartPolicy Always, a native sidecar of clusters with the
+	// SidecarContainers feature. The kubelet starts native sidecars
+	// before the application containers, stops them after, and does
+	// not wait for them to complete Jobs.
+	SidecarNative SidecarMode = "native"
+	// SidecarAuto selects native sidecars if the cluster supports them,
+	// and must be resolved before injection, see SupportsNativeSidecars.
+	SidecarAuto SidecarMode = "auto"
+)
+
+// nativeSidecarAnnotationKey overrides whether the proxy of the pods is
+// a native sidecar.
+const nativeSidecarAnnotationKey = "sidecar.wharfie.io/nativeSidecar"
+
+// nativeSidecarMinor is the first minor version of kubernetes 1 that
+// enables the SidecarContainers feature by default.
+const nativeSidecarMinor = 29
+
+// ParseSidecarMode parses a sidecar mode, SidecarContainer if empty.
+func ParseSidecarMode(value string) (SidecarMode, error) {
+	switch mode := SidecarMode(value); mode {
+	case "":
+		return SidecarContainer, nil
+	case SidecarContainer, SidecarNative, SidecarAuto:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown sidecar mode %q, expected %s, %s, or %s",
+			value, SidecarContainer, SidecarNative, SidecarAuto)
+	}
+}
+
+// SupportsNativeSidecars reports whether a kubernetes server version
+// enables native sidecars by default. Providers may suffix the minor
+// version, e.g. 29+.
+func SupportsNativeSidecars(major, minor string) bool {
+	majorVersion, err := strconv.Atoi(major)
+	if err != nil {
+		return false
+	}
+	minorVersion, err := strconv.Atoi(strings.TrimRight(minor, "+"))
+	if err != nil {
+		return false
+	}
+	return majorVersion > 1 || majorVersion == 1 && minorVersion >= nativeSidecarMinor
+}
+
+// nativeSidecar returns whether the proxy of the pods of the template
+// is a native sidecar, as set by the parameters or overridden by an
+// annotation.
+func nativeSidecar(p *Params, t *v1.PodTemplateSpec) (bool, error) {
+	native := p.SidecarMode == SidecarNative
+	if value, ok := t.Annotations[nativeSidecarAnnotationKey]; ok {
+		var err error
+		if native, err = strconv.ParseBool(value); err != nil {
+			return false, fmt.Errorf("annotation %s must be true or false, got %q", nativeSidecarAnnotationKey, value)
+		}
+	}
+	return native, nil
+}
+
+// PatchNativeSidecar moves the proxy of an injected pod spec, marshaled
+// from the pod template, to the init containers as a native sidecar if
+// the template selects it. The kubernetes types used for injection
+// predate the restart policy of containers.
+func PatchNativeSidecar(p *Params, t *v1.PodTemplateSpec, spec map[string]interface{}) error {
+	native, err := nativeSidecar(p, t)
+	if err != nil || !native {
+		return err
+	}
+	return moveToInitContainers(injectedProxyName(t))(spec)
+}
+
+// moveToInitContainers moves a container of the pod spec after the
+// init containers, with restartPolicy Always so that it keeps running
+// along the application.
+func moveToInitContainers(name string) PodSpecPatch {
+	return func(spec map[string]interface{}) error {
+		list, _ := spec["containers"].([]interface{})
+		var kept []interface{}
+		var sidecar map[string]interface{}
+		for _, item := range list {
+			if container, ok := item.(map[string]interface{}); ok && fmt.Sprint(container["name"]) == name {
+				sidecar = container
+				continue
+			}
+			kept = append(kept, item)
+		}
+		if sidecar == nil {
+			return fmt.Errorf("cannot find injected container %s in the pod template", name)
+		}
+		sidecar["restartPolicy"] = "Always"
+		initContainers, _ := spec["initContainers"].([]interface{})
+		spec["initContainers"] = append(initContainers, sidecar)
+		spec["containers"] = kept
+		return nil
+	}
+}
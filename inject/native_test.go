@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseSidecarMode(t *testing.T) {
+	for value, want := range map[string]SidecarMode{
+		"":          SidecarContainer,
+		"container": SidecarContainer,
+		"native":    SidecarNative,
+		"auto":      SidecarAuto,
+	} {
+		if got, err := ParseSidecarMode(value); err != nil || got != want {
+			t.Errorf("ParseSidecarMode(%q) => got %q, %v, want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseSidecarMode("sidecar"); err == nil {
+		t.Error("ParseSidecarMode() accepted an unknown mode")
+	}
+}
+
+func TestSupportsNativeSidecars(t *testing.T) {
+	cases := []struct {
+		major, minor string
+		want         bool
+	}{
+		{"1", "28", false},
+		{"1", "29", true},
+		{"1", "30+", true},
+		{"2", "0", true},
+		{"1", "", false},
+		{"", "29", false},
+	}
+	for _, c := range cases {
+		if got := SupportsNativeSidecars(c.major, c.minor); got != c.want {
+			t.Errorf("SupportsNativeSidecars(%q, %q) => got %t, want %t", c.major, c.minor, got, c.want)
+		}
+	}
+}
+
+func TestPatchNativeSidecar(t *testing.T) {
+	p := &Params{SidecarMode: SidecarNative}
+	newSpec := func() map[string]interface{} {
+		return map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "hello"},
+				map[string]interface{}{"name": proxyContainerName},
+			},
+			"initContainers": []interface{}{map[string]interface{}{"name": "migrate"}},
+		}
+	}
+	spec := newSpec()
+	if err := PatchNativeSidecar(p, &v1.PodTemplateSpec{}, spec); err != nil {
+		t.Fatal(err)
+	}
+	containers, initContainers := spec["containers"].([]interface{}), spec["initContainers"].([]interface{})
+	if len(containers) != 1 || len(initContainers) != 2 {
+		t.Fatalf("PatchNativeSidecar() => got %v", spec)
+	}
+	if sidecar := initContainers[1].(map[string]interface{}); sidecar["name"] != proxyContainerName || sidecar["restartPolicy"] != "Always" {
+		t.Errorf("PatchNativeSidecar() => got last init container %v, want the proxy restarted always", sidecar)
+	}
+
+	template := &v1.PodTemplateSpec{}
+	template.Annotations = map[string]string{nativeSidecarAnnotationKey: "false"}
+	spec = newSpec()
+	if err := PatchNativeSidecar(p, template, spec); err != nil || len(spec["containers"].([]interface{})) != 2 {
+		t.Errorf("PatchNativeSidecar() of a pod opted out => got %v, %v", spec, err)
+	}
+
+	if err := PatchNativeSidecar(p, &v1.PodTemplateSpec{}, map[string]interface{}{}); err == nil {
+		t.Error("PatchNativeSidecar() accepted a pod spec without the proxy")
+	}
+}
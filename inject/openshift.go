@@ -0,0 +1,200 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// uidRangeAnnotationKey is the annotation of OpenShift projects with the
+// range of UIDs the restricted security context constraints admit, as
+// <first>/<size>.
+const uidRangeAnnotationKey = "openshift.io/sa.scc.uid-range"
+
+// OpenShiftPolicy adapts injection to the security context constraints
+// (SCC) of OpenShift, which only admit the UIDs of the range of every
+// project instead of the fixed UID of the proxy.
+type OpenShiftPolicy struct {
+	// UIDRanges holds the UID ranges of the namespaces of the manifests
+	// injected offline, which are not read from a cluster.
+	UIDRanges map[string]string `json:"uidRanges,omitempty"`
+	// Lookup, if set, reads the annotations of a namespace instead of
+	// UIDRanges, e.g. from the cluster.
+	Lookup func(namespace string) (map[string]string, error) `json:"-"`
+	// DefaultNamespace is the namespace of resources without one.
+	DefaultNamespace string `json:"-"`
+}
+
+// ParseUIDRange parses the UID range of a project, <first>/<size>.
+func ParseUIDRange(value string) (first, size int64, err error) {
+	parts := strings.Split(value, "/")
+	if len(parts) == 2 {
+		first, err = strconv.ParseInt(parts[0], 10, 64)
+		if err == nil {
+			size, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+	}
+	if len(parts) != 2 || err != nil || first < 0 || size < 1 {
+		return 0, 0, fmt.Errorf("invalid UID range %q, expected <first>/<size>", value)
+	}
+	return first, size, nil
+}
+
+// proxyUID returns the UID of the proxy in a namespace: the second UID
+// of its range. The restricted SCC runs the containers without a UID
+// as the first one, and the traffic of the UID of the proxy is not
+// redirected, so the proxy must not share it with the application.
+func (o *OpenShiftPolicy) proxyUID(namespace string) (int64, error) {
+	if namespace == "" {
+		namespace = o.DefaultNamespace
+	}
+	value, ok := o.UIDRanges[namespace]
+	if o.Lookup != nil {
+		annotations, err := o.Lookup(namespace)
+		if err != nil {
+			return 0, fmt.Errorf("cannot read the annotations of namespace %s: %v", namespace, err)
+		}
+		value, ok = annotations[uidRangeAnnotationKey]
+	}
+	if !ok {
+		return 0, fmt.Errorf("namespace %s has no UID range, expected annotation %s of an OpenShift project",
+			namespace, uidRangeAnnotationKey)
+	}
+	first, size, err := ParseUIDRange(value)
+	if err != nil {
+		return 0, fmt.Errorf("namespace %s: %v", namespace, err)
+	}
+	if size < 2 {
+		return 0, fmt.Errorf("the UID range %s of namespace %s leaves no UID for the proxy", value, namespace)
+	}
+	return first + 1, nil
+}
+
+// openShiftParams returns the parameters of the pods of a namespace with
+// the UID of the proxy of the policy, if any.
+func openShiftParams(p *Params, namespace string) (*Params, error) {
+	if p.OpenShift == nil {
+		return p, nil
+	}
+	uid, err := p.OpenShift.proxyUID(namespace)
+	if err != nil {
+		return nil, err
+	}
+	current := *p
+	current.SidecarProxyUID = uid
+	return &current, nil
+}
+
+// securityContextConstraints is the subset of the
+// security.openshift.io/v1 SecurityContextConstraints written by
+// WriteOpenShiftSCC.
+type securityContextConstraints struct {
+	metav1.TypeMeta          `json:",inline"`
+	Metadata                 metav1.ObjectMeta `json:"metadata"`
+	AllowPrivilegedContainer bool              `json:"allowPrivilegedContainer"`
+	AllowPrivilegeEscalation bool              `json:"allowPrivilegeEscalation"`
+	AllowHostDirVolumePlugin bool              `json:"allowHostDirVolumePlugin"`
+	AllowHostIPC             bool              `json:"allowHostIPC"`
+	AllowHostNetwork         bool              `json:"allowHostNetwork"`
+	AllowHostPID             bool              `json:"allowHostPID"`
+	AllowHostPorts           bool              `json:"allowHostPorts"`
+	AllowedCapabilities      []string          `json:"allowedCapabilities"`
+	RequiredDropCapabilities []string          `json:"requiredDropCapabilities,omitempty"`
+	RunAsUser                sccStrategy       `json:"runAsUser"`
+	SELinuxContext           sccStrategy       `json:"seLinuxContext"`
+	FSGroup                  sccStrategy       `json:"fsGroup"`
+	SupplementalGroups       sccStrategy       `json:"supplementalGroups"`
+	Volumes                  []string          `json:"volumes"`
+	Users                    []string          `json:"users"`
+	Groups                   []string          `json:"groups"`
+}
+
+// sccStrategy is the strategy of a field of the security context
+// constraints.
+type sccStrategy struct {
+	Type string `json:"type"`
+}
+
+// WriteOpenShiftSCC writes the SecurityContextConstraints that admit
+// the injected pods, whose init container runs as root with NET_ADMIN
+// and NET_RAW to redirect traffic, a ClusterRole that uses them, and a
+// RoleBinding of the ClusterRole to the service accounts of every
+// namespace, as a YAML stream.
+func WriteOpenShiftSCC(name string, namespaces []string, out io.Writer) error {
+	if name == "" {
+		return fmt.Errorf("the security context constraints need a name")
+	}
+	resources := []interface{}{
+		&securityContextConstraints{
+			TypeMeta: metav1.TypeMeta{APIVersion: "security.openshift.io/v1", Kind: "SecurityContextConstraints"},
+			Metadata: metav1.ObjectMeta{Name: name},
+			// As the restricted SCC, but for the capabilities and the UID
+			// of the init container.
+			AllowedCapabilities:      []string{"NET_ADMIN", "NET_RAW"},
+			RequiredDropCapabilities: []string{"KILL", "MKNOD", "SETUID", "SETGID"},
+			RunAsUser:                sccStrategy{Type: "RunAsAny"},
+			SELinuxContext:           sccStrategy{Type: "MustRunAs"},
+			FSGroup:                  sccStrategy{Type: "MustRunAs"},
+			SupplementalGroups:       sccStrategy{Type: "RunAsAny"},
+			Volumes:                  []string{"configMap", "downwardAPI", "emptyDir", "persistentVolumeClaim", "projected", "secret"},
+			Users:                    []string{},
+			Groups:                   []string{},
+		},
+		&rbac.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Rules: []rbac.PolicyRule{{
+				APIGroups:     []string{"security.openshift.io"},
+				Resources:     []string{"securitycontextconstraints"},
+				ResourceNames: []string{name},
+				Verbs:         []string{"use"},
+			}},
+		},
+	}
+	for _, namespace := range namespaces {
+		resources = append(resources, &rbac.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Subjects: []rbac.Subject{{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Group",
+				Name:     "system:serviceaccounts:" + namespace,
+			}},
+			RoleRef: rbac.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: name},
+		})
+	}
+	for i, resource := range resources {
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err = fmt.Fprint(out, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err = out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/util"
+)
+
+func TestParseUIDRange(t *testing.T) {
+	if first, size, err := ParseUIDRange("1000620000/10000"); err != nil || first != 1000620000 || size != 10000 {
+		t.Errorf("ParseUIDRange() => got %d, %d, %v, want 1000620000, 10000", first, size, err)
+	}
+	for _, value := range []string{"", "1000620000", "1000620000-1000629999", "a/10000", "1000620000/0"} {
+		if _, _, err := ParseUIDRange(value); err == nil {
+			t.Errorf("ParseUIDRange(%q) => got no error", value)
+		}
+	}
+}
+
+func TestOpenShiftProxyUID(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{
+		InitImage:       InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:      ProxyImageName(unitTestHub, unitTestTag),
+		SidecarProxyUID: DefaultSidecarProxyUID,
+		Mesh:            &mesh,
+		OpenShift: &OpenShiftPolicy{
+			UIDRanges:        map[string]string{"web": "1000620000/10000", "tiny": "1000630000/1"},
+			DefaultNamespace: "web",
+		},
+	}
+	template := &v1.PodTemplateSpec{}
+	template.Spec.Containers = []v1.Container{{Name: "hello", Image: "hello"}}
+	if err := IntoPodTemplateSpec(p, "", template); err != nil {
+		t.Fatal(err)
+	}
+	if got := template.Annotations[initContainersAnnotationKey]; !strings.Contains(got, `"-u","1000620001"`) {
+		t.Errorf("IntoPodTemplateSpec() => got init containers %s, want the traffic of UID 1000620001 excluded", got)
+	}
+	sidecar := template.Spec.Containers[1].SecurityContext
+	if sidecar == nil || sidecar.RunAsUser == nil || *sidecar.RunAsUser != 1000620001 {
+		t.Errorf("IntoPodTemplateSpec() => got proxy security context %v, want UID 1000620001", sidecar)
+	}
+
+	for namespace, want := range map[string]string{"tiny": "leaves no UID", "default": "has no UID range"} {
+		template := &v1.PodTemplateSpec{}
+		template.Spec.Containers = []v1.Container{{Name: "hello", Image: "hello"}}
+		if err := IntoPodTemplateSpec(p, namespace, template); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("IntoPodTemplateSpec(%s) => got error %v, want %q", namespace, err, want)
+		}
+	}
+
+	p.OpenShift.Lookup = func(string) (map[string]string, error) { return nil, errors.New("forbidden") }
+	template = &v1.PodTemplateSpec{}
+	template.Spec.Containers = []v1.Container{{Name: "hello", Image: "hello"}}
+	if err := IntoPodTemplateSpec(p, "web", template); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("IntoPodTemplateSpec() => got error %v, want the lookup error", err)
+	}
+}
+
+func TestWriteOpenShiftSCC(t *testing.T) {
+	var got bytes.Buffer
+	if err := WriteOpenShiftSCC("wharfie-proxy", []string{"web", "api"}, &got); err != nil {
+		t.Fatalf("WriteOpenShiftSCC() returned an error: %v", err)
+	}
+	util.CompareContent(got.Bytes(), "testdata/openshift-scc.yaml", t)
+}
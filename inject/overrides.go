@@ -0,0 +1,163 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// overrideParams returns the parameters of a pod template with the
+// overrides of its annotations, so that app teams can tune the
+// injection of a workload without changing the parameters of every
+// other workload. The parameters are not modified.
+func overrideParams(p *Params, t *v1.PodTemplateSpec) (*Params, error) {
+	current := *p
+	for _, override := range []struct {
+		key  string
+		dest *string
+	}{
+		{proxyImageAnnotationKey, &current.ProxyImage},
+		{initImageAnnotationKey, &current.InitImage},
+		{includeIPRangesAnnotationKey, &current.IncludeIPRanges},
+	} {
+		if value, ok := t.Annotations[override.key]; ok {
+			if value == "" {
+				return nil, fmt.Errorf("annotation %s must not be empty", override.key)
+			}
+			*override.dest = value
+		}
+	}
+	for _, key := range []string{proxyImageAnnotationKey, initImageAnnotationKey} {
+		if value, ok := t.Annotations[key]; ok {
+			if err := ValidateImage(value); err != nil {
+				return nil, fmt.Errorf("annotation %s: %v", key, err)
+			}
+		}
+	}
+	if value, ok := t.Annotations[includeIPRangesAnnotationKey]; ok {
+		if err := validateIPRanges(value); err != nil {
+			return nil, fmt.Errorf("annotation %s: %v", includeIPRangesAnnotationKey, err)
+		}
+	}
+	if value, ok := t.Annotations[verbosityAnnotationKey]; ok {
+		verbosity, err := strconv.Atoi(value)
+		if err != nil || verbosity < 0 {
+			return nil, fmt.Errorf("annotation %s must be a non-negative integer, got %q", verbosityAnnotationKey, value)
+		}
+		current.Verbosity = verbosity
+	}
+	if value, ok := t.Annotations[terminationDrainDurationAnnotationKey]; ok {
+		drain, err := time.ParseDuration(value)
+		if err != nil || drain < 0 {
+			return nil, fmt.Errorf("annotation %s must be a non-negative duration, got %q", terminationDrainDurationAnnotationKey, value)
+		}
+		current.TerminationDrainDuration = drain
+	}
+	return &current, nil
+}
+
+// validateIPRanges checks a comma separated list of IP ranges in CIDR
+// form, or * for all ranges, so that the init container does not fail
+// only when the pod starts. Spaces after the commas are allowed, the
+// list is normalized before it is passed on.
+func validateIPRanges(list string) error {
+	if list == "*" {
+		return nil
+	}
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if _, _, err := net.ParseCIDR(cidr); err == nil {
+			continue
+		}
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			return fmt.Errorf("invalid IP range %q in %q: missing the prefix length, e.g. %s/%d", cidr, list, cidr, bits)
+		}
+		return fmt.Errorf("invalid IP range %q in %q: expected an address and prefix length, e.g. 10.0.0.0/8", cidr, list)
+	}
+	return nil
+}
+
+// normalizeList removes the spaces around the entries of a comma
+// separated list, e.g. "10.0.0.0/8, 172.16.0.0/12".
+func normalizeList(list string) string {
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		entries[i] = strings.TrimSpace(entry)
+	}
+	return strings.Join(entries, ",")
+}
+
+// overrideResources returns the resources of the proxy with the
+// requests and limits set by the annotations of the pod template, which
+// take precedence over proportional sizing.
+func overrideResources(t *v1.PodTemplateSpec, resources v1.ResourceRequirements) (v1.ResourceRequirements, error) {
+	overridden := false
+	for _, override := range []struct {
+		key    string
+		name   v1.ResourceName
+		limits bool
+	}{
+		{proxyCPUAnnotationKey, v1.ResourceCPU, false},
+		{proxyMemoryAnnotationKey, v1.ResourceMemory, false},
+		{proxyCPULimitAnnotationKey, v1.ResourceCPU, true},
+		{proxyMemoryLimitAnnotationKey, v1.ResourceMemory, true},
+	} {
+		value, ok := t.Annotations[override.key]
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return resources, fmt.Errorf("annotation %s must be a quantity, got %q", override.key, value)
+		}
+		// The resources may be shared with the parameters.
+		if override.limits {
+			resources.Limits = copyResources(resources.Limits)
+			resources.Limits[override.name] = q
+		} else {
+			resources.Requests = copyResources(resources.Requests)
+			resources.Requests[override.name] = q
+		}
+		overridden = true
+	}
+	if overridden {
+		if err := CheckResources(resources); err != nil {
+			return resources, err
+		}
+	}
+	return resources, nil
+}
+
+// CheckResources checks that the requests of the proxy resources do not
+// exceed their limits, which the API server would reject.
+func CheckResources(resources v1.ResourceRequirements) error {
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("proxy %s request %s exceeds its limit %s", name, request.String(), limit.String())
+		}
+	}
+	return nil
+}
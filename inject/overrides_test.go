@@ -0,0 +1,113 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestOverrideParamsErrors(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{ProxyImage: ProxyImageName(unitTestHub, unitTestTag), Mesh: &mesh}
+	for key, value := range map[string]string{
+		proxyImageAnnotationKey:      "",
+		includeIPRangesAnnotationKey: "10.0.0.0",
+		verbosityAnnotationKey:       "-1",
+		initImageAnnotationKey:       "registry.example.com/Init:latest",
+	} {
+		tmpl := &v1.PodTemplateSpec{}
+		tmpl.Annotations = map[string]string{key: value}
+		if _, err := overrideParams(p, tmpl); err == nil {
+			t.Errorf("overrideParams(%s: %q) succeeded, want an error", key, value)
+		}
+	}
+
+	tmpl := &v1.PodTemplateSpec{}
+	tmpl.Annotations = map[string]string{proxyImageAnnotationKey: "proxy:canary", includeIPRangesAnnotationKey: "*"}
+	got, err := overrideParams(p, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ProxyImage != "proxy:canary" || got.IncludeIPRanges != "*" || p.ProxyImage != ProxyImageName(unitTestHub, unitTestTag) {
+		t.Errorf("overrideParams() => got %+v, want the overrides on a copy", got)
+	}
+}
+
+func TestOverrideResources(t *testing.T) {
+	shared := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("128Mi"),
+	}
+	tmpl := &v1.PodTemplateSpec{}
+	tmpl.Annotations = map[string]string{proxyMemoryAnnotationKey: "1Gi"}
+	got, err := overrideResources(tmpl, v1.ResourceRequirements{Requests: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu, memory := got.Requests.Cpu().String(), got.Requests.Memory().String(); cpu != "100m" || memory != "1Gi" {
+		t.Errorf("overrideResources() => got cpu %s, memory %s, want 100m and 1Gi", cpu, memory)
+	}
+	if memory := shared[v1.ResourceMemory]; memory.String() != "128Mi" {
+		t.Errorf("overrideResources() modified the shared requests: %v", shared)
+	}
+
+	tmpl.Annotations = map[string]string{proxyCPULimitAnnotationKey: "500m", proxyMemoryLimitAnnotationKey: "1Gi"}
+	if got, err = overrideResources(tmpl, v1.ResourceRequirements{Requests: shared}); err != nil {
+		t.Fatal(err)
+	}
+	if cpu, memory := got.Limits.Cpu().String(), got.Limits.Memory().String(); cpu != "500m" || memory != "1Gi" {
+		t.Errorf("overrideResources() => got cpu limit %s, memory limit %s, want 500m and 1Gi", cpu, memory)
+	}
+
+	tmpl.Annotations[proxyCPULimitAnnotationKey] = "50m"
+	if _, err = overrideResources(tmpl, v1.ResourceRequirements{Requests: shared}); err == nil {
+		t.Error("overrideResources() accepted a CPU limit below the request")
+	}
+
+	tmpl.Annotations = map[string]string{proxyCPUAnnotationKey: "lots"}
+	if _, err = overrideResources(tmpl, v1.ResourceRequirements{}); err == nil {
+		t.Error("overrideResources() accepted an invalid quantity")
+	}
+}
+
+func TestValidateIPRanges(t *testing.T) {
+	cases := []struct {
+		list    string
+		wantErr string
+	}{
+		{list: "*"},
+		{list: "10.0.0.0/8,172.16.0.0/12"},
+		{list: "fd00::/8"},
+		{list: "10.0.0.0/8,10.0.0.1", wantErr: `invalid IP range "10.0.0.1" in "10.0.0.0/8,10.0.0.1": missing the prefix length, e.g. 10.0.0.1/32`},
+		{list: "fd00::1", wantErr: "e.g. fd00::1/128"},
+		{list: "10.0.0.0/33", wantErr: `invalid IP range "10.0.0.0/33"`},
+		{list: "10.0.0.0/8, 172.16.0.0/12"},
+		{list: "10.0.0.0/8,", wantErr: `invalid IP range ""`},
+	}
+	for _, c := range cases {
+		err := validateIPRanges(c.list)
+		if c.wantErr == "" && err != nil {
+			t.Errorf("validateIPRanges(%q) => got error %v", c.list, err)
+		} else if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("validateIPRanges(%q) => got error %v, want %q", c.list, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"github.com/ghodss/yaml"
+)
+
+// PodSpecPatch modifies the pod spec of a marshaled resource, to set
+// fields that are newer than the kubernetes types used for injection.
+type PodSpecPatch func(spec map[string]interface{}) error
+
+// patchPodSpec applies the patches to the pod template spec of a
+// marshaled resource.
+func patchPodSpec(doc []byte, patches ...PodSpecPatch) ([]byte, error) {
+	if len(patches) == 0 {
+		return doc, nil
+	}
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal(doc, &obj); err != nil {
+		return nil, err
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	// CronJobs nest the pod template in a job template.
+	if jobTemplate, ok := spec["jobTemplate"].(map[string]interface{}); ok {
+		spec, _ = jobTemplate["spec"].(map[string]interface{})
+	}
+	template, _ := spec["template"].(map[string]interface{})
+	podSpec, ok := template["spec"].(map[string]interface{})
+	if !ok {
+		podSpec = make(map[string]interface{})
+		if template != nil {
+			template["spec"] = podSpec
+		}
+	}
+	for _, patch := range patches {
+		if err := patch(podSpec); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.Marshal(obj)
+}
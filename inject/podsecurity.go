@@ -0,0 +1,200 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// PodSecurityLevel is a level of the pod security standards enforced by
+// the PodSecurity admission of the API server, see
+// https://kubernetes.io/docs/concepts/security/pod-security-standards.
+type PodSecurityLevel string
+
+const (
+	// PodSecurityPrivileged is unrestricted.
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+	// PodSecurityBaseline prevents known privilege escalations: no
+	// privileged containers, added capabilities outside of a default
+	// set, hostPath volumes, or unconfined AppArmor profiles.
+	PodSecurityBaseline PodSecurityLevel = "baseline"
+	// PodSecurityRestricted additionally requires non-root containers
+	// that drop all capabilities, disallow privilege escalation, and
+	// set a seccomp profile, with a limited set of volume types.
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+)
+
+// baselineCapabilities are the capabilities the baseline level allows
+// containers to add.
+var baselineCapabilities = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+	"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// restrictedVolumeSources are the volume sources the restricted level
+// allows.
+var restrictedVolumeSources = []string{
+	"configMap", "csi", "downwardAPI", "emptyDir", "ephemeral", "persistentVolumeClaim", "projected", "secret",
+}
+
+// ParsePodSecurityLevel parses a pod security level, privileged if
+// empty.
+func ParsePodSecurityLevel(value string) (PodSecurityLevel, error) {
+	switch level := PodSecurityLevel(value); level {
+	case "":
+		return PodSecurityPrivileged, nil
+	case PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown pod security level %q, expected %s, %s, or %s",
+			value, PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted)
+	}
+}
+
+// containerSecurity holds the fields of the security context of an
+// injected container that the pod security standards check, including
+// the fields newer than the kubernetes types.
+type containerSecurity struct {
+	Privileged               *bool  `json:"privileged,omitempty"`
+	RunAsNonRoot             *bool  `json:"runAsNonRoot,omitempty"`
+	RunAsUser                *int64 `json:"runAsUser,omitempty"`
+	AllowPrivilegeEscalation *bool  `json:"allowPrivilegeEscalation,omitempty"`
+	Capabilities             *struct {
+		Add  []string `json:"add,omitempty"`
+		Drop []string `json:"drop,omitempty"`
+	} `json:"capabilities,omitempty"`
+	SeccompProfile *SeccompProfile `json:"seccompProfile,omitempty"`
+}
+
+// adaptPodSecurity returns parameters adapted to the pod security level
+// where injection can comply without the operator: traffic is
+// redirected by the CNI plugin instead of the init container, which
+// needs NET_ADMIN and runs as root, core dumps are not enabled by a
+// privileged container unless the pod asks for them, AppArmor is left
+// to the nodes instead of unconfined, and the restricted level gets the
+// default seccomp profile of the runtime.
+func adaptPodSecurity(p *Params, t *v1.PodTemplateSpec) *Params {
+	if !p.AdaptPodSecurity || p.PodSecurityLevel == "" || p.PodSecurityLevel == PodSecurityPrivileged {
+		return p
+	}
+	current := *p
+	if !current.InterceptionMode.annotated() {
+		current.InterceptionMode = InterceptionCNI
+	}
+	if _, ok := t.Annotations[enableCoreDumpAnnotationKey]; !ok && current.CoreDump.initContainer() != nil {
+		current.EnableCoreDump = false
+	}
+	if current.AppArmorProfile == AppArmorUnconfined {
+		current.AppArmorProfile = ""
+	}
+	if current.PodSecurityLevel == PodSecurityRestricted && current.SeccompProfile == nil {
+		current.SeccompProfile = &SeccompProfile{Type: SeccompRuntimeDefault}
+	}
+	return &current
+}
+
+// auditPodSecurity checks the injected init containers, in the form
+// written to the init containers annotation, the proxy, and the
+// injected volumes against the pod security level of the parameters,
+// so that injection fails with an explanation instead of the API
+// server rejecting the pods.
+func auditPodSecurity(p *Params, t *v1.PodTemplateSpec, initContainers []map[string]interface{},
+	sidecar *v1.Container, volumes []v1.Volume) error {
+	level := p.PodSecurityLevel
+	if level == "" || level == PodSecurityPrivileged {
+		return nil
+	}
+	var violations []string
+	for _, c := range initContainers {
+		var sc containerSecurity
+		if err := convert(c["securityContext"], &sc); err != nil {
+			return err
+		}
+		violations = append(violations, sc.violations(level, t, fmt.Sprintf("init container %v", c["name"]))...)
+	}
+	var sc containerSecurity
+	if err := convert(sidecar.SecurityContext, &sc); err != nil {
+		return err
+	}
+	// Both are set when the resource is written out.
+	disallowed := false
+	sc.AllowPrivilegeEscalation, sc.SeccompProfile = &disallowed, p.SeccompProfile
+	violations = append(violations, sc.violations(level, t, "proxy container "+sidecar.Name)...)
+
+	if p.AppArmorProfile == AppArmorUnconfined {
+		violations = append(violations, "the injected containers run with the unconfined AppArmor profile")
+	}
+	for _, v := range volumes {
+		var source map[string]interface{}
+		if err := convert(v.VolumeSource, &source); err != nil {
+			return err
+		}
+		for kind := range source {
+			if kind == "hostPath" || level == PodSecurityRestricted && !contains(restrictedVolumeSources, kind) {
+				violations = append(violations, fmt.Sprintf("volume %s is a %s volume", v.Name, kind))
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("the injected proxy violates the %s pod security level: %s", level, strings.Join(violations, "; "))
+	if !p.AdaptPodSecurity {
+		err = fmt.Errorf("%v; enable adaptPodSecurity to adapt the injection where possible", err)
+	}
+	return err
+}
+
+// violations returns the violations of the pod security level by the
+// security context of a container of the pod template.
+func (sc *containerSecurity) violations(level PodSecurityLevel, t *v1.PodTemplateSpec, container string) []string {
+	var violations []string
+	if sc.Privileged != nil && *sc.Privileged {
+		violations = append(violations, container+" is privileged")
+	}
+	var add, drop []string
+	if sc.Capabilities != nil {
+		add, drop = sc.Capabilities.Add, sc.Capabilities.Drop
+	}
+	for _, capability := range add {
+		if level == PodSecurityRestricted && capability != "NET_BIND_SERVICE" ||
+			!contains(baselineCapabilities, capability) {
+			violations = append(violations, fmt.Sprintf("%s adds capability %s", container, capability))
+		}
+	}
+	if level != PodSecurityRestricted {
+		return violations
+	}
+	if !contains(drop, "ALL") {
+		violations = append(violations, container+" does not drop all capabilities")
+	}
+	if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+		violations = append(violations, container+" allows privilege escalation")
+	}
+	nonRoot := sc.RunAsNonRoot
+	if nonRoot == nil && t.Spec.SecurityContext != nil {
+		nonRoot = t.Spec.SecurityContext.RunAsNonRoot
+	}
+	if nonRoot == nil || !*nonRoot || sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+		violations = append(violations, container+" may run as root")
+	}
+	if sc.SeccompProfile == nil {
+		violations = append(violations, container+" does not set a seccomp profile")
+	}
+	return violations
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+func TestParsePodSecurityLevel(t *testing.T) {
+	if level, err := ParsePodSecurityLevel(""); err != nil || level != PodSecurityPrivileged {
+		t.Errorf("ParsePodSecurityLevel(\"\") => got %q, %v, want %s", level, err, PodSecurityPrivileged)
+	}
+	if _, err := ParsePodSecurityLevel("strict"); err == nil {
+		t.Error("ParsePodSecurityLevel(strict) => got no error")
+	}
+}
+
+func TestPodSecurity(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	mutualTLS := proxy.DefaultMeshConfig()
+	mutualTLS.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	cases := []struct {
+		name        string
+		level       PodSecurityLevel
+		adapt       bool
+		coreDump    bool
+		annotations map[string]string
+		sdsPath     string
+		wantErr     []string
+	}{
+		{name: "privileged", level: PodSecurityPrivileged, coreDump: true},
+		{name: "baseline", level: PodSecurityBaseline, coreDump: true, wantErr: []string{
+			"init container init adds capability NET_ADMIN", "init container enable-core-dump is privileged", "adaptPodSecurity",
+		}},
+		{name: "baseline adapted", level: PodSecurityBaseline, adapt: true, coreDump: true},
+		{name: "restricted", level: PodSecurityRestricted, wantErr: []string{
+			"init container init may run as root", "proxy container proxy does not set a seccomp profile",
+		}},
+		{name: "restricted adapted", level: PodSecurityRestricted, adapt: true},
+		{
+			name:        "restricted adapted with core dumps",
+			level:       PodSecurityRestricted,
+			adapt:       true,
+			annotations: map[string]string{enableCoreDumpAnnotationKey: "true"},
+			wantErr:     []string{"init container enable-core-dump is privileged"},
+		},
+		{name: "SDS", level: PodSecurityBaseline, adapt: true, sdsPath: "/var/run/sds", wantErr: []string{"volume sds-uds-path is a hostPath volume"}},
+	}
+	for _, c := range cases {
+		p := &Params{
+			Mesh:             &mesh,
+			InitImage:        InitImageName(unitTestHub, unitTestTag),
+			ProxyImage:       ProxyImageName(unitTestHub, unitTestTag),
+			SidecarProxyUID:  DefaultSidecarProxyUID,
+			EnableCoreDump:   c.coreDump,
+			SDSPath:          c.sdsPath,
+			PodSecurityLevel: c.level,
+			AdaptPodSecurity: c.adapt,
+		}
+		if c.sdsPath != "" {
+			p.Mesh = &mutualTLS
+		}
+		template := &v1.PodTemplateSpec{}
+		template.Annotations = c.annotations
+		template.Spec.Containers = []v1.Container{{Name: "hello", Image: "hello"}}
+		err := IntoPodTemplateSpec(p, "default", template)
+		if len(c.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("%s: IntoPodTemplateSpec() returned an error: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: IntoPodTemplateSpec() => got no error, want %v", c.name, c.wantErr)
+			continue
+		}
+		for _, want := range c.wantErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: IntoPodTemplateSpec() => got error %v, want it to contain %q", c.name, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// InjectionPolicy determines whether workloads are injected by default.
+type InjectionPolicy string
+
+const (
+	// InjectionPolicyEnabled injects every workload unless it opts out
+	// with the inject annotation set to false.
+	InjectionPolicyEnabled InjectionPolicy = "enabled"
+	// InjectionPolicyDisabled only injects the workloads that opt in
+	// with the inject annotation set to true.
+	InjectionPolicyDisabled InjectionPolicy = "disabled"
+)
+
+// ParseInjectionPolicy parses an injection policy, enabled if empty.
+func ParseInjectionPolicy(value string) (InjectionPolicy, error) {
+	switch policy := InjectionPolicy(value); policy {
+	case "":
+		return InjectionPolicyEnabled, nil
+	case InjectionPolicyEnabled, InjectionPolicyDisabled:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown injection policy %q, expected %s or %s",
+			value, InjectionPolicyEnabled, InjectionPolicyDisabled)
+	}
+}
+
+// PolicySkip returns why the injection policy and the inject annotation
+// of a pod template leave it without the proxy, or an empty string if
+// the template is to be injected. The annotation takes precedence over
+// the policy.
+func PolicySkip(p *Params, t *v1.PodTemplateSpec) (string, error) {
+	if value, ok := t.Annotations[injectAnnotationKey]; ok {
+		inject, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("annotation %s must be true or false, got %q", injectAnnotationKey, value)
+		}
+		if !inject {
+			return fmt.Sprintf("annotation opt-out: %s is %q", injectAnnotationKey, value), nil
+		}
+		return "", nil
+	}
+	if p.Policy == InjectionPolicyDisabled {
+		return fmt.Sprintf("the injection policy is %s and annotation %s is not set", p.Policy, injectAnnotationKey), nil
+	}
+	return "", nil
+}
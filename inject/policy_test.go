@@ -0,0 +1,77 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestInjectionPolicy(t *testing.T) {
+	cases := []struct {
+		policy     InjectionPolicy
+		annotation string
+		want       bool
+	}{
+		{policy: "", want: true},
+		{policy: InjectionPolicyEnabled, want: true},
+		{policy: InjectionPolicyEnabled, annotation: "false", want: false},
+		{policy: InjectionPolicyEnabled, annotation: "true", want: true},
+		{policy: InjectionPolicyDisabled, want: false},
+		{policy: InjectionPolicyDisabled, annotation: "true", want: true},
+		{policy: InjectionPolicyDisabled, annotation: "false", want: false},
+	}
+	for _, c := range cases {
+		mesh := proxy.DefaultMeshConfig()
+		p := &Params{
+			InitImage:  InitImageName(unitTestHub, unitTestTag),
+			ProxyImage: ProxyImageName(unitTestHub, unitTestTag),
+			Mesh:       &mesh,
+			Policy:     c.policy,
+		}
+		tmpl := &v1.PodTemplateSpec{}
+		tmpl.Spec.Containers = []v1.Container{{Name: "app", Image: "app"}}
+		if c.annotation != "" {
+			tmpl.Annotations = map[string]string{injectAnnotationKey: c.annotation}
+		}
+		reason, err := PolicySkip(p, tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := reason == ""; got != c.want {
+			t.Errorf("PolicySkip(%q, %q) => got %q, want injection %v", c.policy, c.annotation, reason, c.want)
+		}
+		if err = IntoPodTemplateSpec(p, "default", tmpl); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(tmpl.Spec.Containers) == 2; got != c.want {
+			t.Errorf("IntoPodTemplateSpec(%q, %q) => got injected %v, want %v", c.policy, c.annotation, got, c.want)
+		}
+	}
+}
+
+func TestInjectionPolicyErrors(t *testing.T) {
+	if _, err := ParseInjectionPolicy("sometimes"); err == nil {
+		t.Error("ParseInjectionPolicy() accepted an unknown policy")
+	}
+	tmpl := &v1.PodTemplateSpec{}
+	tmpl.Annotations = map[string]string{injectAnnotationKey: "maybe"}
+	if _, err := PolicySkip(&Params{}, tmpl); err == nil {
+		t.Error("PolicySkip() accepted an invalid annotation")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// Default names of the container ports of the proxy.
+const (
+	defaultProxyPortName  = "proxy"
+	defaultAdminPortName  = "proxy-admin"
+	defaultStatsPortName  = "http-envoy-prom"
+	defaultHealthPortName = "http-health"
+)
+
+// ProxyPorts configures the container ports declared on the proxy, so
+// that Services, network policies, and monitoring can reference them
+// by name. The listener and admin ports are always declared with the
+// numbers of the mesh configuration, the stats and health ports only
+// if their numbers are set. Empty names are the defaults.
+type ProxyPorts struct {
+	// ProxyName and AdminName name the listener and admin ports, proxy
+	// and proxy-admin by default.
+	ProxyName string `json:"proxyName,omitempty"`
+	AdminName string `json:"adminName,omitempty"`
+	// StatsName and StatsPort declare the port of the Prometheus stats
+	// of the proxy, named http-envoy-prom by default.
+	StatsName string `json:"statsName,omitempty"`
+	StatsPort int32  `json:"statsPort,omitempty"`
+	// HealthName and HealthPort declare the port of the health checks
+	// of the proxy, named http-health by default.
+	HealthName string `json:"healthName,omitempty"`
+	HealthPort int32  `json:"healthPort,omitempty"`
+}
+
+// containerPorts returns the ports declared on the proxy for the mesh
+// configuration.
+func (pp *ProxyPorts) containerPorts(mesh *proxyconfig.ProxyMeshConfig) []v1.ContainerPort {
+	if pp == nil {
+		pp = &ProxyPorts{}
+	}
+	port := func(name, defaultName string, number int32) v1.ContainerPort {
+		if name == "" {
+			name = defaultName
+		}
+		return v1.ContainerPort{Name: name, ContainerPort: number, Protocol: v1.ProtocolTCP}
+	}
+	ports := []v1.ContainerPort{
+		port(pp.ProxyName, defaultProxyPortName, mesh.ProxyListenPort),
+		port(pp.AdminName, defaultAdminPortName, mesh.ProxyAdminPort),
+	}
+	if pp.StatsPort != 0 {
+		ports = append(ports, port(pp.StatsName, defaultStatsPortName, pp.StatsPort))
+	}
+	if pp.HealthPort != 0 {
+		ports = append(ports, port(pp.HealthName, defaultHealthPortName, pp.HealthPort))
+	}
+	return ports
+}
+
+// Validate checks that the names are valid and distinct port names and
+// that the stats and health ports are valid and distinct port numbers.
+func (pp *ProxyPorts) Validate() error {
+	if pp == nil {
+		return nil
+	}
+	for _, port := range []struct {
+		kind   string
+		number int32
+	}{
+		{"stats", pp.StatsPort},
+		{"health", pp.HealthPort},
+	} {
+		if port.number == 0 {
+			continue
+		}
+		if errs := validation.IsValidPortNum(int(port.number)); len(errs) > 0 {
+			return fmt.Errorf("invalid proxy %s port %d: %s", port.kind, port.number, strings.Join(errs, ", "))
+		}
+	}
+	if pp.StatsPort != 0 && pp.StatsPort == pp.HealthPort {
+		return fmt.Errorf("the proxy stats and health ports are both %d", pp.StatsPort)
+	}
+	// The mesh ports are checked with names only, their numbers are
+	// not known yet.
+	seen := make(map[string]bool)
+	for _, port := range pp.containerPorts(&proxyconfig.ProxyMeshConfig{}) {
+		if errs := validation.IsValidPortName(port.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid proxy port name %q: %s", port.Name, strings.Join(errs, ", "))
+		}
+		if seen[port.Name] {
+			return fmt.Errorf("proxy port name %q is used twice", port.Name)
+		}
+		seen[port.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// Injection marshals resources through the kubernetes types, which
+// loses the comments, the order of the fields, and the formatting of
+// the input. preserveFormatting instead applies the changes of
+// injection to the lines of the input YAML, so that untouched content
+// is kept byte for byte. It understands the block style of YAML that
+// kubernetes manifests use. Changes inside flow collections replace the
+// closest enclosing block field, and documents that cannot be edited,
+// e.g. with anchors or directives, keep the marshaled output.
+
+// errUneditable reports YAML that preserveFormatting cannot edit.
+var errUneditable = errors.New("YAML cannot be edited in place")
+
+// yamlLine is a significant line of a YAML document: not blank and not
+// only a comment.
+type yamlLine struct {
+	n      int // index in the document
+	indent int
+	text   string // content after the indentation
+}
+
+// yamlEntry is a field of a mapping or an item of a sequence.
+type yamlEntry struct {
+	key        string
+	start, end int // lines of the entry, end excluded
+	indent     int // column of the key or the dash
+	value      *yamlNode
+}
+
+// yamlNode is a block mapping or sequence. Other values are inline.
+type yamlNode struct {
+	sequence bool
+	indent   int
+	end      int // line after the last entry
+	entries  []yamlEntry
+}
+
+// preserveFormatting returns the input document with the changes of
+// the marshaled output applied to its lines, or the output if the
+// input cannot be edited in place.
+func preserveFormatting(raw, updated []byte) []byte {
+	if hasAnchors(raw) {
+		return updated
+	}
+	edited, err := editYAML(raw, updated)
+	if err != nil {
+		return updated
+	}
+	return edited
+}
+
+func editYAML(raw, updated []byte) ([]byte, error) {
+	before, err := decodePruned(raw)
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodePruned(updated)
+	if err != nil {
+		return nil, err
+	}
+	text := string(raw)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1]
+	for _, op := range diffJSON("", before, after) {
+		if lines, err = applyOperation(lines, op, after); err != nil {
+			return nil, err
+		}
+	}
+	edited := []byte(strings.Join(lines, ""))
+
+	// The edited document must hold every field of the marshaled
+	// output, and may only keep the fields that the types dropped.
+	check, err := decodePruned(edited)
+	if err != nil {
+		return nil, err
+	}
+	if ops := diffJSON("", check, after); len(ops) > 0 {
+		return nil, fmt.Errorf("edited YAML differs at %s", ops[0].Path)
+	}
+	return edited, nil
+}
+
+// decodePruned decodes a YAML document as JSON without null fields.
+func decodePruned(doc []byte) (interface{}, error) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	prune(value)
+	return value, nil
+}
+
+// applyOperation applies an operation of diffJSON to the lines of a
+// document. Operations that do not resolve to block fields replace the
+// closest enclosing block field with its value in the output.
+func applyOperation(lines []string, op PatchOperation, after interface{}) ([]string, error) {
+	root, err := parseYAML(lines)
+	if err != nil {
+		return nil, err
+	}
+	tokens := strings.Split(op.Path, "/")[1:]
+	for i := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(tokens[i], "~1", "/", -1), "~0", "~", -1)
+	}
+
+	// Walk down the block nodes to the parent of the changed value.
+	node := root
+	var container *yamlNode
+	var entry *yamlEntry
+	depth := 0
+	for ; depth < len(tokens)-1; depth++ {
+		next := node.find(tokens[depth])
+		if next == nil {
+			break
+		}
+		container, entry = node, next
+		if next.value == nil {
+			// The value is inline, e.g. a flow collection, and is
+			// replaced as a whole.
+			depth++
+			break
+		}
+		node = next.value
+	}
+	if depth == len(tokens)-1 && (entry == nil || entry.value == node) {
+		last := tokens[depth]
+		if existing := node.find(last); existing != nil {
+			return replaceEntry(lines, existing, last, node.sequence, op.Value), nil
+		}
+		if op.Op == "add" && node.sequence && last == "-" {
+			return insertLines(lines, node.end, renderItem(node.indent, op.Value)), nil
+		}
+		if op.Op == "add" && !node.sequence {
+			return insertLines(lines, node.end, renderField(node.indent, last, op.Value)), nil
+		}
+	}
+	if entry == nil {
+		return nil, errUneditable
+	}
+	value, ok := lookupJSON(after, tokens[:depth])
+	if !ok {
+		return nil, errUneditable
+	}
+	return replaceEntry(lines, entry, tokens[depth-1], container.sequence, value), nil
+}
+
+// find returns the field with the key, or the item at the index.
+func (n *yamlNode) find(token string) *yamlEntry {
+	if n.sequence {
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(n.entries) {
+			return nil
+		}
+		return &n.entries[i]
+	}
+	for i := range n.entries {
+		if n.entries[i].key == token {
+			return &n.entries[i]
+		}
+	}
+	return nil
+}
+
+func lookupJSON(value interface{}, tokens []string) (interface{}, bool) {
+	for _, token := range tokens {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[token]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func replaceEntry(lines []string, entry *yamlEntry, key string, item bool, value interface{}) []string {
+	var rendered []string
+	if item {
+		rendered = renderItem(entry.indent, value)
+	} else {
+		rendered = renderField(entry.indent, key, value)
+	}
+	result := append([]string{}, lines[:entry.start]...)
+	result = append(result, rendered...)
+	return append(result, lines[entry.end:]...)
+}
+
+func insertLines(lines []string, at int, inserted []string) []string {
+	result := append([]string{}, lines[:at]...)
+	result = append(result, inserted...)
+	return append(result, lines[at:]...)
+}
+
+// renderField renders a mapping field at the indentation.
+func renderField(indent int, key string, value interface{}) []string {
+	return renderYAML(indent, map[string]interface{}{key: value})
+}
+
+// renderItem renders a sequence item at the indentation.
+func renderItem(indent int, value interface{}) []string {
+	return renderYAML(indent, []interface{}{value})
+}
+
+func renderYAML(indent int, value interface{}) []string {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		// Decoded JSON values always marshal.
+		panic(err)
+	}
+	prefix := strings.Repeat(" ", indent)
+	lines := strings.SplitAfter(string(data), "\n")
+	lines = lines[:len(lines)-1]
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return lines
+}
+
+// parseYAML parses the block structure of a document, which must be a
+// mapping.
+func parseYAML(lines []string) (*yamlNode, error) {
+	var significant []yamlLine
+	for n, line := range lines {
+		content := strings.TrimLeft(line, " ")
+		trimmed := strings.TrimSpace(content)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if len(significant) == 0 && strings.TrimRight(line, "\r\n") == "---" {
+			// The start of the first document.
+			continue
+		}
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") ||
+			strings.HasPrefix(line, "%") || strings.HasPrefix(content, "\t") {
+			return nil, errUneditable
+		}
+		significant = append(significant, yamlLine{
+			n:      n,
+			indent: len(line) - len(content),
+			text:   strings.TrimRight(content, "\r\n"),
+		})
+	}
+	if len(significant) == 0 || significant[0].indent != 0 {
+		return nil, errUneditable
+	}
+	root, err := parseNode(significant)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || root.sequence {
+		return nil, errUneditable
+	}
+	return root, nil
+}
+
+// parseNode parses the block mapping or sequence of the lines, which
+// start at its indentation, or returns nil for an inline value.
+func parseNode(lines []yamlLine) (*yamlNode, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	if isDash(lines[0].text) {
+		return parseSequence(lines)
+	}
+	if _, _, ok := splitKey(lines[0].text); ok {
+		return parseMapping(lines)
+	}
+	// A multi-line scalar or flow collection.
+	return nil, nil
+}
+
+func parseMapping(lines []yamlLine) (*yamlNode, error) {
+	node := &yamlNode{indent: lines[0].indent}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if line.indent != node.indent {
+			return nil, errUneditable
+		}
+		key, rest, ok := splitKey(line.text)
+		if !ok {
+			return nil, errUneditable
+		}
+		j := i + 1
+		for j < len(lines) && (lines[j].indent > node.indent ||
+			(lines[j].indent == node.indent && isDash(lines[j].text) && isEmptyValue(rest))) {
+			j++
+		}
+		entry := yamlEntry{key: key, start: line.n, end: lines[j-1].n + 1, indent: node.indent}
+		if isEmptyValue(rest) {
+			value, err := parseNode(lines[i+1 : j])
+			if err != nil {
+				return nil, err
+			}
+			entry.value = value
+		} else if strings.HasPrefix(rest, "&") || strings.HasPrefix(rest, "*") || strings.HasPrefix(rest, "!") {
+			return nil, errUneditable
+		}
+		node.entries = append(node.entries, entry)
+		node.end = entry.end
+		i = j
+	}
+	return node, nil
+}
+
+func parseSequence(lines []yamlLine) (*yamlNode, error) {
+	node := &yamlNode{sequence: true, indent: lines[0].indent}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if line.indent != node.indent || !isDash(line.text) {
+			return nil, errUneditable
+		}
+		j := i + 1
+		for j < len(lines) && lines[j].indent > node.indent {
+			j++
+		}
+		entry := yamlEntry{start: line.n, end: lines[j-1].n + 1, indent: node.indent}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var content []yamlLine
+		if isEmptyValue(rest) {
+			content = lines[i+1 : j]
+		} else if _, _, ok := splitKey(rest); ok || isDash(rest) {
+			// The item starts on the line of its dash.
+			first := yamlLine{n: line.n, indent: node.indent + len(line.text) - len(rest), text: rest}
+			content = append([]yamlLine{first}, lines[i+1:j]...)
+		} else if strings.HasPrefix(rest, "&") || strings.HasPrefix(rest, "*") || strings.HasPrefix(rest, "!") {
+			return nil, errUneditable
+		}
+		value, err := parseNode(content)
+		if err != nil {
+			return nil, err
+		}
+		entry.value = value
+		node.entries = append(node.entries, entry)
+		node.end = entry.end
+		i = j
+	}
+	return node, nil
+}
+
+func isDash(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// isEmptyValue reports whether the value after a key or a dash is on
+// the following lines.
+func isEmptyValue(rest string) bool {
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// splitKey splits a mapping field into its key and the rest of the
+// line after the colon.
+func splitKey(text string) (string, string, bool) {
+	var key, rest string
+	switch {
+	case strings.HasPrefix(text, `"`):
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		unquoted, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		key, rest = unquoted, text[end+1:]
+	case strings.HasPrefix(text, "'"):
+		end := strings.Index(strings.Replace(text[1:], "''", "xx", -1), "'")
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest = strings.Replace(text[1:end+1], "''", "'", -1), text[end+2:]
+	case strings.HasPrefix(text, "{"), strings.HasPrefix(text, "["), strings.HasPrefix(text, "?"):
+		return "", "", false
+	default:
+		colon := strings.Index(text, ": ")
+		if colon < 0 {
+			if !strings.HasSuffix(text, ":") {
+				return "", "", false
+			}
+			colon = len(text) - 1
+		}
+		if strings.Contains(text[:colon], " #") {
+			return "", "", false
+		}
+		key, rest = strings.TrimRight(text[:colon], " "), text[colon:]
+	}
+	if !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest[1:]), true
+}
+
+func closingQuote(text string) int {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import "testing"
+
+func TestPreserveFormatting(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		updated string
+		want    string
+	}{
+		{
+			name: "comments and order",
+			raw: `# The frontend.
+kind: Deployment
+metadata:
+  name: frontend # public
+spec:
+  template:
+    metadata:
+      labels:
+        app: frontend
+    spec:
+      containers:
+        - name: nginx
+          image: "nginx:1.0"
+`,
+			updated: `kind: Deployment
+metadata:
+  creationTimestamp: null
+  name: frontend
+spec:
+  template:
+    metadata:
+      annotations:
+        injected: "true"
+      labels:
+        app: frontend
+    spec:
+      containers:
+      - image: nginx:1.0
+        name: nginx
+      - image: proxy
+        name: proxy
+`,
+			want: `# The frontend.
+kind: Deployment
+metadata:
+  name: frontend # public
+spec:
+  template:
+    metadata:
+      labels:
+        app: frontend
+      annotations:
+        injected: "true"
+    spec:
+      containers:
+        - name: nginx
+          image: "nginx:1.0"
+        - image: proxy
+          name: proxy
+`,
+		},
+		{
+			name: "flow collection",
+			raw: `kind: Pod
+spec: {containers: [{name: app}]}
+`,
+			updated: `kind: Pod
+spec:
+  containers:
+  - name: app
+  - name: proxy
+`,
+			want: `kind: Pod
+spec:
+  containers:
+  - name: app
+  - name: proxy
+`,
+		},
+		{
+			name: "changed scalar",
+			raw: `kind: Pod
+metadata:
+  annotations:
+    version: "1" # old
+  name: app
+`,
+			updated: `kind: Pod
+metadata:
+  annotations:
+    version: "2"
+  name: app
+`,
+			want: `kind: Pod
+metadata:
+  annotations:
+    version: "2"
+  name: app
+`,
+		},
+		{
+			name: "anchors",
+			raw: `kind: Pod
+metadata:
+  labels: &labels
+    app: hello
+spec:
+  selector: *labels
+`,
+			updated: `kind: Pod
+metadata:
+  annotations:
+    injected: "true"
+  labels:
+    app: hello
+spec:
+  selector:
+    app: hello
+`,
+			want: `kind: Pod
+metadata:
+  annotations:
+    injected: "true"
+  labels:
+    app: hello
+spec:
+  selector:
+    app: hello
+`,
+		},
+	}
+	for _, c := range cases {
+		if got := string(preserveFormatting([]byte(c.raw), []byte(c.updated))); got != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	cases := []struct {
+		text, key, rest string
+		ok              bool
+	}{
+		{text: "name: hello", key: "name", rest: "hello", ok: true},
+		{text: "spec:", key: "spec", ok: true},
+		{text: `"a: b": c # d`, key: "a: b", rest: "c # d", ok: true},
+		{text: "'it''s': x", key: "it's", rest: "x", ok: true},
+		{text: "image: docker.io/proxy:1.0", key: "image", rest: "docker.io/proxy:1.0", ok: true},
+		{text: "http://example.com", ok: false},
+		{text: "{a: b}", ok: false},
+	}
+	for _, c := range cases {
+		key, rest, ok := splitKey(c.text)
+		if key != c.key || rest != c.rest || ok != c.ok {
+			t.Errorf("splitKey(%q) => got %q, %q, %v, want %q, %q, %v", c.text, key, rest, ok, c.key, c.rest, c.ok)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Profile is a named preset of injection parameters for an
+// environment. Every field overrides the matching Params field.
+type Profile struct {
+	Description string
+	// DebugImage selects the proxy image with debug tooling.
+	DebugImage              bool
+	Verbosity               int
+	EnableCoreDump          bool
+	ProxyResources          v1.ResourceRequirements
+	HardenedSecurityContext bool
+	// InterceptionMode, CoreDump, and PodSecurityLevel are only set if
+	// not empty.
+	InterceptionMode InterceptionMode
+	CoreDump         *CoreDumpPolicy
+	PodSecurityLevel PodSecurityLevel
+}
+
+// Profiles are the built-in environment presets.
+var Profiles = map[string]Profile{
+	"dev": {
+		Description:    "debug image, verbose logging, and core dumps",
+		DebugImage:     true,
+		Verbosity:      4,
+		EnableCoreDump: true,
+	},
+	"staging": {
+		Description: "debug image with default logging and resource requests",
+		DebugImage:  true,
+		Verbosity:   DefaultVerbosity,
+		ProxyResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	},
+	"prod": {
+		Description: "release image, minimal logging, resource limits, and a hardened security context",
+		ProxyResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Limits: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("512Mi"),
+			},
+		},
+		HardenedSecurityContext: true,
+	},
+	// Autopilot rejects privileged containers, added capabilities
+	// outside of the baseline level, e.g. NET_ADMIN, and most hostPath
+	// volumes, and sets the limits of containers to their requests.
+	"autopilot": {
+		Description: "GKE Autopilot: release image, CNI interception, core dumps without a privileged container, " +
+			"equal resource requests and limits, and the baseline pod security level",
+		ProxyResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Limits: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+		InterceptionMode: InterceptionCNI,
+		CoreDump:         &CoreDumpPolicy{NodeConfigured: true},
+		PodSecurityLevel: PodSecurityBaseline,
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles in order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	if got, want := ProfileNames(), []string{"autopilot", "dev", "prod", "staging"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProfileNames() => got %v, want %v", got, want)
+	}
+	for name, profile := range Profiles {
+		if profile.Description == "" {
+			t.Errorf("profile %s has no description", name)
+		}
+		resources := profile.ProxyResources
+		for resource, limit := range resources.Limits {
+			if request, ok := resources.Requests[resource]; ok && limit.Cmp(request) < 0 {
+				t.Errorf("profile %s limits %s to %s below its request %s", name, resource, limit.String(), request.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// unspecifiedNamespace labels resources that do not set a namespace.
+const unspecifiedNamespace = "(unspecified)"
+
+type namespaceView struct {
+	Name      string
+	Resources []ResourceReport
+}
+
+// overheadView formats the CPU and memory requests of an Overhead.
+type overheadView struct {
+	Name          string
+	CPU           string
+	Memory        string
+	PerNodeCPU    string
+	PerNodeMemory string
+}
+
+func quantity(list v1.ResourceList, name v1.ResourceName) string {
+	q, ok := list[name]
+	if !ok {
+		return "0"
+	}
+	return q.String()
+}
+
+func newOverheadView(name string, o Overhead) overheadView {
+	return overheadView{
+		Name:          name,
+		CPU:           quantity(o.Requests, v1.ResourceCPU),
+		Memory:        quantity(o.Requests, v1.ResourceMemory),
+		PerNodeCPU:    quantity(o.PerNode, v1.ResourceCPU),
+		PerNodeMemory: quantity(o.PerNode, v1.ResourceMemory),
+	}
+}
+
+type reportView struct {
+	Total      int
+	Injected   int
+	Skipped    int
+	Warnings   int
+	Namespaces []namespaceView
+	// Overhead has a row for every namespace with injected resources
+	// followed by the total.
+	Overhead []overheadView
+}
+
+func newReportView(r *Report) reportView {
+	view := reportView{Total: len(r.Resources)}
+	view.Injected, view.Skipped = r.Summary()
+
+	byNamespace := make(map[string][]ResourceReport)
+	for _, resource := range r.Resources {
+		ns := resource.Namespace
+		if ns == "" {
+			ns = unspecifiedNamespace
+		}
+		byNamespace[ns] = append(byNamespace[ns], resource)
+		view.Warnings += len(resource.Warnings)
+	}
+	names := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	for _, ns := range names {
+		view.Namespaces = append(view.Namespaces, namespaceView{Name: ns, Resources: byNamespace[ns]})
+	}
+
+	overhead, total := r.Overhead()
+	if len(overhead) > 0 {
+		names = names[:0]
+		for ns := range overhead {
+			names = append(names, ns)
+		}
+		sort.Strings(names)
+		for _, ns := range names {
+			label := ns
+			if label == "" {
+				label = unspecifiedNamespace
+			}
+			view.Overhead = append(view.Overhead, newOverheadView(label, overhead[ns]))
+		}
+		view.Overhead = append(view.Overhead, newOverheadView("Total", total))
+	}
+	return view
+}
+
+var reportFuncs = map[string]interface{}{
+	"join": func(names []string) string { return strings.Join(names, ", ") },
+	// cell escapes the characters that would break a Markdown table.
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	},
+}
+
+var markdownReport = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(
+	`# Injection report
+
+{{.Total}} resources: {{.Injected}} injected, {{.Skipped}} skipped, {{.Warnings}} warnings.
+{{range .Namespaces}}
+## Namespace {{.Name}}
+
+| Kind | Name | Status | Containers | Init containers | Volumes |
+| --- | --- | --- | --- | --- | --- |
+{{range .Resources}}| {{cell .Kind}} | {{cell .Name}} | {{if .Injected}}injected{{else}}skipped: {{cell .Reason}}{{end}} | {{cell (join .Containers)}} | {{cell (join .InitContainers)}} | {{cell (join .Volumes)}} |
+{{end}}{{range $r := .Resources}}{{range .Warnings}}
+> **Warning:** {{$r.Kind}} {{$r.Name}}: {{.}}
+{{end}}{{end}}{{end}}{{if .Overhead}}
+## Resource overhead
+
+Requests added by the injected containers, multiplied by the replicas
+of every resource. Daemon sets add their requests on every node.
+
+| Namespace | CPU | Memory | CPU per node | Memory per node |
+| --- | --- | --- | --- | --- |
+{{range .Overhead}}| {{cell .Name}} | {{.CPU}} | {{.Memory}} | {{.PerNodeCPU}} | {{.PerNodeMemory}} |
+{{end}}{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Injection report</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+tr.warning { background: #fff3cd; }
+p.warning { color: #856404; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Injection report</h1>
+<p>{{.Total}} resources: {{.Injected}} injected, {{.Skipped}} skipped, {{.Warnings}} warnings.</p>
+{{range .Namespaces}}<h2>Namespace {{.Name}}</h2>
+<table>
+<tr><th>Kind</th><th>Name</th><th>Status</th><th>Containers</th><th>Init containers</th><th>Volumes</th></tr>
+{{range .Resources}}<tr{{if .Warnings}} class="warning"{{end}}><td>{{.Kind}}</td><td>{{.Name}}</td><td>{{if .Injected}}injected{{else}}skipped: {{.Reason}}{{end}}</td><td>{{join .Containers}}</td><td>{{join .InitContainers}}</td><td>{{join .Volumes}}</td></tr>
+{{end}}</table>
+{{range $r := .Resources}}{{range .Warnings}}<p class="warning">Warning: {{$r.Kind}} {{$r.Name}}: {{.}}</p>
+{{end}}{{end}}{{end}}{{if .Overhead}}<h2>Resource overhead</h2>
+<p>Requests added by the injected containers, multiplied by the replicas of every resource. Daemon sets add their requests on every node.</p>
+<table>
+<tr><th>Namespace</th><th>CPU</th><th>Memory</th><th>CPU per node</th><th>Memory per node</th></tr>
+{{range .Overhead}}<tr><td>{{.Name}}</td><td>{{.CPU}}</td><td>{{.Memory}}</td><td>{{.PerNodeCPU}}</td><td>{{.PerNodeMemory}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteMarkdown renders the report as a Markdown summary with a table
+// of resources per namespace.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	return markdownReport.Execute(w, newReportView(r))
+}
+
+// WriteHTML renders the report as a standalone HTML page with a table
+// of resources per namespace.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, newReportView(r))
+}
+
+// WriteJSON renders the report as an indented JSON object for tools,
+// e.g. CI checks of injection coverage. Every resource lists the names
+// of the elements added by injection, or the reason it was skipped.
+func (r *Report) WriteJSON(w io.Writer) error {
+	injected, skipped := r.Summary()
+	resources := r.Resources
+	if resources == nil {
+		resources = []ResourceReport{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Injected  int              `json:"injected"`
+		Skipped   int              `json:"skipped"`
+		Resources []ResourceReport `json:"resources"`
+	}{injected, skipped, resources}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
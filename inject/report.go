@@ -0,0 +1,211 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Report describes the outcome of injecting a stream of kubernetes
+// resources.
+type Report struct {
+	Resources []ResourceReport `json:"resources"`
+}
+
+// ResourceReport describes the outcome of injecting a single
+// kubernetes resource.
+type ResourceReport struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Injected  bool   `json:"injected"`
+	// Reason explains why a resource was not injected.
+	Reason string `json:"reason,omitempty"`
+	// Names of the pod template elements added by injection.
+	Containers     []string `json:"containers,omitempty"`
+	InitContainers []string `json:"initContainers,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+	Annotations    []string `json:"annotations,omitempty"`
+	// Replicas is the number of pods created from the template. Daemon
+	// sets run one pod on every node instead and set PerNode.
+	Replicas int32 `json:"replicas,omitempty"`
+	PerNode  bool  `json:"perNode,omitempty"`
+	// Requests are the compute resources requested by the added
+	// containers of every pod.
+	Requests v1.ResourceList `json:"requests,omitempty"`
+	// Warnings point out likely problems with the resource that did
+	// not prevent injection.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// templateSummary records the names of the pod template elements
+// that injection may add to.
+type templateSummary struct {
+	containers     []string
+	initContainers []string
+	volumes        []string
+	annotations    []string
+}
+
+func summarizeTemplate(t *v1.PodTemplateSpec) templateSummary {
+	var s templateSummary
+	for _, c := range t.Spec.Containers {
+		s.containers = append(s.containers, c.Name)
+	}
+	for _, c := range t.Spec.InitContainers {
+		s.initContainers = append(s.initContainers, c.Name)
+	}
+	if value, ok := t.Annotations[initContainersAnnotationKey]; ok {
+		var containers []struct {
+			Name string `json:"name"`
+		}
+		// Malformed values are reported by injection itself.
+		if err := json.Unmarshal([]byte(value), &containers); err == nil {
+			for _, c := range containers {
+				s.initContainers = append(s.initContainers, c.Name)
+			}
+		}
+	}
+	for _, v := range t.Spec.Volumes {
+		s.volumes = append(s.volumes, v.Name)
+	}
+	for key := range t.Annotations {
+		s.annotations = append(s.annotations, key)
+	}
+	sort.Strings(s.annotations)
+	return s
+}
+
+// added returns the names in after that are not in before, keeping
+// their order.
+func added(before, after []string) []string {
+	seen := make(map[string]bool, len(before))
+	for _, name := range before {
+		seen[name] = true
+	}
+	var out []string
+	for _, name := range after {
+		if !seen[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// compare fills in the report with the differences between the
+// template summary taken before injection and the injected template.
+func (r *ResourceReport) compare(p *Params, before templateSummary, t *v1.PodTemplateSpec) {
+	after := summarizeTemplate(t)
+	r.Containers = added(before.containers, after.containers)
+	r.InitContainers = added(before.initContainers, after.initContainers)
+	r.Volumes = added(before.volumes, after.volumes)
+	r.Annotations = added(before.annotations, after.annotations)
+	r.Injected = len(r.Containers) > 0
+	if r.Injected {
+		r.Warnings = append(r.Warnings, secretInjectorWarnings(t)...)
+		if drain, err := proxyDrainDuration(p); err == nil && terminationGracePeriod(t) < drain {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("termination grace period %v is shorter than the proxy drain duration %v",
+				terminationGracePeriod(t), drain))
+		}
+	}
+	for _, c := range t.Spec.Containers {
+		for _, name := range r.Containers {
+			if c.Name == name {
+				r.Requests = addResources(r.Requests, c.Resources.Requests, 1)
+			}
+		}
+	}
+
+	if !r.Injected {
+		status := t.Annotations[istioSidecarAnnotationSidecarKey]
+		if reason, _ := PolicySkip(p, t); status == "" && reason != "" {
+			r.Reason = reason
+			return
+		}
+		r.Reason = fmt.Sprintf("annotation %s is already set to %q", istioSidecarAnnotationSidecarKey, status)
+		if version := t.Annotations[istioSidecarAnnotationVersionKey]; status == istioSidecarAnnotationSidecarValue &&
+			version != p.Version {
+			r.Warnings = append(r.Warnings,
+				fmt.Sprintf("sidecar was injected by version %q, current version is %q", version, p.Version))
+		}
+	}
+}
+
+// Summary returns the number of resources that were injected and
+// skipped.
+func (r *Report) Summary() (injected, skipped int) {
+	for _, resource := range r.Resources {
+		if resource.Injected {
+			injected++
+		} else {
+			skipped++
+		}
+	}
+	return
+}
+
+// Overhead totals the compute resources requested by injected
+// containers.
+type Overhead struct {
+	// Requests are added once for every replica.
+	Requests v1.ResourceList `json:"requests,omitempty"`
+	// PerNode requests are added on every node that runs the injected
+	// daemon sets.
+	PerNode v1.ResourceList `json:"perNode,omitempty"`
+}
+
+func (o *Overhead) add(r ResourceReport) {
+	if r.PerNode {
+		o.PerNode = addResources(o.PerNode, r.Requests, 1)
+	} else {
+		o.Requests = addResources(o.Requests, r.Requests, int64(r.Replicas))
+	}
+}
+
+// Overhead returns the resources requested by the injected containers
+// of every namespace and across all namespaces. Resources that do not
+// set a namespace are totalled under the empty namespace.
+func (r *Report) Overhead() (byNamespace map[string]Overhead, total Overhead) {
+	byNamespace = make(map[string]Overhead)
+	for _, resource := range r.Resources {
+		if !resource.Injected {
+			continue
+		}
+		o := byNamespace[resource.Namespace]
+		o.add(resource)
+		byNamespace[resource.Namespace] = o
+		total.add(resource)
+	}
+	return
+}
+
+// addResources adds count times every quantity of src to dst and
+// returns dst, which is allocated if needed.
+func addResources(dst, src v1.ResourceList, count int64) v1.ResourceList {
+	for name, q := range src {
+		if dst == nil {
+			dst = make(v1.ResourceList)
+		}
+		sum := dst[name]
+		sum.Add(*resource.NewMilliQuantity(q.MilliValue()*count, q.Format))
+		dst[name] = sum
+	}
+	return dst
+}
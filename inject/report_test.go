@@ -0,0 +1,219 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/util"
+)
+
+func injectWithReport(t *testing.T, in string) *Report {
+	mesh := proxy.DefaultMeshConfig()
+	params := Params{
+		InitImage:       InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:      ProxyImageName(unitTestHub, unitTestTag),
+		Verbosity:       DefaultVerbosity,
+		SidecarProxyUID: DefaultSidecarProxyUID,
+		Version:         "12345678",
+		Mesh:            &mesh,
+		ProxyResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	}
+	file, err := os.Open(in)
+	if err != nil {
+		t.Fatalf("Failed to open %q: %v", in, err)
+	}
+	defer func() { _ = file.Close() }()
+	report, err := IntoResourceFileWithReport(&params, file, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("IntoResourceFileWithReport(%v) returned an error: %v", in, err)
+	}
+	return report
+}
+
+func TestIntoResourceFileWithReport(t *testing.T) {
+	report := injectWithReport(t, "testdata/report.yaml")
+	proxyRequests := map[v1.ResourceName]string{
+		v1.ResourceCPU:    "100m",
+		v1.ResourceMemory: "128Mi",
+	}
+	want := []ResourceReport{{
+		Kind:           "Deployment",
+		Name:           "frontend",
+		Namespace:      "web",
+		Injected:       true,
+		Containers:     []string{"proxy"},
+		InitContainers: []string{"init"},
+		Annotations: []string{
+			"alpha.istio.io/sidecar",
+			"alpha.istio.io/version",
+			"pod.beta.kubernetes.io/init-containers",
+		},
+		Replicas: 2,
+	}, {
+		Kind:      "Service",
+		Name:      "frontend",
+		Namespace: "web",
+		Reason:    `kind "Service" does not have a pod template`,
+	}, {
+		Kind:      "Deployment",
+		Name:      "backend",
+		Namespace: "api",
+		Reason:    `annotation alpha.istio.io/sidecar is already set to "injected"`,
+		Warnings:  []string{`sidecar was injected by version "00000000", current version is "12345678"`},
+	}, {
+		Kind:   "ConfigMap",
+		Name:   "settings",
+		Reason: `kind "ConfigMap" does not have a pod template`,
+	}, {
+		Kind:           "DaemonSet",
+		Name:           "collector",
+		Namespace:      "web",
+		Injected:       true,
+		Containers:     []string{"proxy"},
+		InitContainers: []string{"init"},
+		Annotations: []string{
+			"alpha.istio.io/sidecar",
+			"alpha.istio.io/version",
+			"pod.beta.kubernetes.io/init-containers",
+		},
+		PerNode: true,
+	}}
+	for i, resource := range report.Resources {
+		if !resource.Injected {
+			continue
+		}
+		// Quantities cache their formatting, so compare them as strings.
+		requests := make(map[v1.ResourceName]string)
+		for name, q := range resource.Requests {
+			requests[name] = q.String()
+		}
+		if !reflect.DeepEqual(requests, proxyRequests) {
+			t.Errorf("%s %s requests => got %v, want %v", resource.Kind, resource.Name, requests, proxyRequests)
+		}
+		report.Resources[i].Requests = nil
+	}
+	if !reflect.DeepEqual(report.Resources, want) {
+		t.Errorf("IntoResourceFileWithReport() => got\n%#v\nwant\n%#v", report.Resources, want)
+	}
+}
+
+func TestIntoResourceFileWithReportAnchors(t *testing.T) {
+	report := injectWithReport(t, "testdata/anchors.yaml")
+	var warnings []string
+	for _, resource := range report.Resources {
+		warnings = append(warnings, resource.Warnings...)
+	}
+	want := []string{"YAML anchors and aliases were expanded in the injected output"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("IntoResourceFileWithReport() warnings => got %q, want %q", warnings, want)
+	}
+}
+
+func TestReportOverhead(t *testing.T) {
+	report := &Report{Resources: []ResourceReport{{
+		Kind:      "Deployment",
+		Namespace: "web",
+		Injected:  true,
+		Replicas:  3,
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("100m"),
+			v1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}, {
+		Kind:      "Job",
+		Namespace: "batch",
+		Injected:  true,
+		Replicas:  2,
+		Requests: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse("250m"),
+		},
+	}, {
+		Kind:      "DaemonSet",
+		Namespace: "web",
+		Injected:  true,
+		PerNode:   true,
+		Requests: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse("50m"),
+		},
+	}, {
+		Kind:      "Deployment",
+		Namespace: "web",
+		Replicas:  10,
+		Requests: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse("1"),
+		},
+	}}}
+
+	byNamespace, total := report.Overhead()
+	cases := []struct {
+		name     string
+		overhead Overhead
+		cpu      string
+		memory   string
+		nodeCPU  string
+	}{
+		{"web", byNamespace["web"], "300m", "384Mi", "50m"},
+		{"batch", byNamespace["batch"], "500m", "0", "0"},
+		{"total", total, "800m", "384Mi", "50m"},
+	}
+	for _, c := range cases {
+		cpu := quantity(c.overhead.Requests, v1.ResourceCPU)
+		memory := quantity(c.overhead.Requests, v1.ResourceMemory)
+		nodeCPU := quantity(c.overhead.PerNode, v1.ResourceCPU)
+		if cpu != c.cpu || memory != c.memory || nodeCPU != c.nodeCPU {
+			t.Errorf("%s overhead => got cpu %s, memory %s, per node cpu %s, want %s, %s, %s",
+				c.name, cpu, memory, nodeCPU, c.cpu, c.memory, c.nodeCPU)
+		}
+	}
+	if len(byNamespace) != 2 {
+		t.Errorf("Overhead() => got %d namespaces, want 2", len(byNamespace))
+	}
+}
+
+func TestReportRender(t *testing.T) {
+	report := injectWithReport(t, "testdata/report.yaml")
+
+	var markdown bytes.Buffer
+	if err := report.WriteMarkdown(&markdown); err != nil {
+		t.Fatalf("WriteMarkdown() returned an error: %v", err)
+	}
+	util.CompareContent(markdown.Bytes(), "testdata/report.md", t)
+
+	var html bytes.Buffer
+	if err := report.WriteHTML(&html); err != nil {
+		t.Fatalf("WriteHTML() returned an error: %v", err)
+	}
+	util.CompareContent(html.Bytes(), "testdata/report.html", t)
+
+	var data bytes.Buffer
+	if err := report.WriteJSON(&data); err != nil {
+		t.Fatalf("WriteJSON() returned an error: %v", err)
+	}
+	util.CompareContent(data.Bytes(), "testdata/report.json", t)
+}
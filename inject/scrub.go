@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/ghodss/yaml"
+)
+
+// noiseFields are the fields that marshaling the kubernetes types adds
+// to every resource with an empty value, e.g. "strategy: {}".
+var noiseFields = map[string]bool{
+	"creationTimestamp": true,
+	"resources":         true,
+	"status":            true,
+	"strategy":          true,
+}
+
+// scrubOutput removes the null fields and the empty noise fields that
+// the marshaled output of a document gained over the input, so that
+// injection does not add them to committed manifests. The output is
+// returned unchanged if either document does not decode.
+func scrubOutput(raw, updated []byte) []byte {
+	var before interface{}
+	if err := yaml.Unmarshal(raw, &before); err != nil {
+		return updated
+	}
+	data, err := yaml.YAMLToJSON(updated)
+	if err != nil {
+		return updated
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var after interface{}
+	if err = decoder.Decode(&after); err != nil {
+		return updated
+	}
+	scrub(before, after)
+	scrubbed, err := yaml.Marshal(after)
+	if err != nil {
+		return updated
+	}
+	return scrubbed
+}
+
+// scrub removes the fields of after that are null or empty noise
+// fields, unless before has them at the same path.
+func scrub(before, after interface{}) {
+	switch v := after.(type) {
+	case map[string]interface{}:
+		original, _ := before.(map[string]interface{})
+		for key, field := range v {
+			previous, ok := original[key]
+			if !ok && (field == nil || noiseFields[key] && isEmptyObject(field)) {
+				delete(v, key)
+				continue
+			}
+			scrub(previous, field)
+		}
+	case []interface{}:
+		original, _ := before.([]interface{})
+		for i, item := range v {
+			var previous interface{}
+			if i < len(original) {
+				previous = original[i]
+			}
+			scrub(previous, item)
+		}
+	}
+}
+
+func isEmptyObject(value interface{}) bool {
+	object, ok := value.(map[string]interface{})
+	return ok && len(object) == 0
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import "testing"
+
+func TestScrubOutput(t *testing.T) {
+	raw := `kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    spec:
+      containers:
+      - name: hello
+        resources: {}
+      volumes:
+      - name: cache
+        emptyDir: {}
+`
+	updated := `kind: Deployment
+metadata:
+  creationTimestamp: null
+  name: hello
+spec:
+  strategy: {}
+  template:
+    metadata:
+      creationTimestamp: null
+    spec:
+      containers:
+      - name: hello
+        resources: {}
+      - name: proxy
+        resources: {}
+      volumes:
+      - emptyDir: {}
+        name: cache
+      - emptyDir: {}
+        name: istio-envoy
+status: {}
+`
+	want := `kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    metadata: {}
+    spec:
+      containers:
+      - name: hello
+        resources: {}
+      - name: proxy
+      volumes:
+      - emptyDir: {}
+        name: cache
+      - emptyDir: {}
+        name: istio-envoy
+`
+	if got := string(scrubOutput([]byte(raw), []byte(updated))); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
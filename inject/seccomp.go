@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// SeccompRuntimeDefault selects the default seccomp profile of the
+	// container runtime.
+	SeccompRuntimeDefault = "RuntimeDefault"
+	// SeccompLocalhost selects a seccomp profile installed on the nodes,
+	// relative to the seccomp directory of the kubelet.
+	SeccompLocalhost = "Localhost"
+)
+
+// seccompLocalhostPrefix prefixes the localhost profiles of the
+// command line, as in the deprecated seccomp annotations.
+const seccompLocalhostPrefix = "localhost/"
+
+// SeccompProfile is the seccomp profile of the injected init and proxy
+// containers, in the format of the seccompProfile field of their
+// security context.
+type SeccompProfile struct {
+	// Type is RuntimeDefault or Localhost.
+	Type string `json:"type"`
+	// LocalhostProfile is the path of a Localhost profile relative to
+	// the seccomp directory of the kubelet.
+	LocalhostProfile string `json:"localhostProfile,omitempty"`
+}
+
+// ParseSeccompProfile parses RuntimeDefault or localhost/<profile>, or
+// returns nil if empty.
+func ParseSeccompProfile(value string) (*SeccompProfile, error) {
+	var s *SeccompProfile
+	switch {
+	case value == "":
+		return nil, nil
+	case value == SeccompRuntimeDefault:
+		s = &SeccompProfile{Type: SeccompRuntimeDefault}
+	case strings.HasPrefix(value, seccompLocalhostPrefix):
+		s = &SeccompProfile{Type: SeccompLocalhost, LocalhostProfile: strings.TrimPrefix(value, seccompLocalhostPrefix)}
+	default:
+		return nil, fmt.Errorf("invalid seccomp profile %q, expected %s or %s<profile>",
+			value, SeccompRuntimeDefault, seccompLocalhostPrefix)
+	}
+	return s, s.Validate()
+}
+
+// Validate checks that the profile is RuntimeDefault, or Localhost with
+// a relative profile path.
+func (s *SeccompProfile) Validate() error {
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case SeccompRuntimeDefault:
+		if s.LocalhostProfile != "" {
+			return fmt.Errorf("seccomp profile %s does not take a localhost profile", s.Type)
+		}
+	case SeccompLocalhost:
+		if s.LocalhostProfile == "" || strings.HasPrefix(s.LocalhostProfile, "/") {
+			return fmt.Errorf("seccomp profile %s requires a relative localhost profile, got %q", s.Type, s.LocalhostProfile)
+		}
+	default:
+		return fmt.Errorf("unknown seccomp profile type %q, expected %s or %s", s.Type, SeccompRuntimeDefault, SeccompLocalhost)
+	}
+	return nil
+}
+
+// apply sets the profile in the security context of a marshaled
+// container. The field is newer than the kubernetes types used for
+// injection.
+func (s *SeccompProfile) apply(container map[string]interface{}) {
+	securityContext, ok := container["securityContext"].(map[string]interface{})
+	if !ok {
+		securityContext = make(map[string]interface{})
+		container["securityContext"] = securityContext
+	}
+	profile := map[string]interface{}{"type": s.Type}
+	if s.LocalhostProfile != "" {
+		profile["localhostProfile"] = s.LocalhostProfile
+	}
+	securityContext["seccompProfile"] = profile
+}
+
+// seccompProfile returns a patch that sets the profile on the named
+// container of the pod spec.
+func seccompProfile(s *SeccompProfile, name string) PodSpecPatch {
+	return func(spec map[string]interface{}) error {
+		list, _ := spec["containers"].([]interface{})
+		for _, item := range list {
+			if container, ok := item.(map[string]interface{}); ok && fmt.Sprint(container["name"]) == name {
+				s.apply(container)
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot find injected container %s in the pod template", name)
+	}
+}
+
+// PatchSeccompProfile sets the seccomp profile of the parameters, if
+// any, or the profile adapted to the pod security level, on the proxy
+// of an injected pod spec marshaled from the pod template. It must be
+// applied before PatchNativeSidecar.
+func PatchSeccompProfile(p *Params, t *v1.PodTemplateSpec, spec map[string]interface{}) error {
+	profile := adaptPodSecurity(p, t).SeccompProfile
+	if profile == nil {
+		return nil
+	}
+	return seccompProfile(profile, injectedProxyName(t))(spec)
+}
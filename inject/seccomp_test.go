@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSeccompProfile(t *testing.T) {
+	cases := []struct {
+		in   string
+		want *SeccompProfile
+	}{
+		{in: ""},
+		{in: "RuntimeDefault", want: &SeccompProfile{Type: SeccompRuntimeDefault}},
+		{in: "localhost/profiles/proxy.json", want: &SeccompProfile{Type: SeccompLocalhost, LocalhostProfile: "profiles/proxy.json"}},
+	}
+	for _, c := range cases {
+		got, err := ParseSeccompProfile(c.in)
+		if err != nil {
+			t.Errorf("ParseSeccompProfile(%q) returned an error: %v", c.in, err)
+			continue
+		}
+		if (got == nil) != (c.want == nil) || got != nil && *got != *c.want {
+			t.Errorf("ParseSeccompProfile(%q) => got %v, want %v", c.in, got, c.want)
+		}
+	}
+	for _, in := range []string{"Unconfined", "runtime/default", "localhost/", "localhost//etc/proxy.json"} {
+		if _, err := ParseSeccompProfile(in); err == nil {
+			t.Errorf("ParseSeccompProfile(%q) => got no error", in)
+		}
+	}
+	if err := (&SeccompProfile{Type: SeccompRuntimeDefault, LocalhostProfile: "proxy.json"}).Validate(); err == nil {
+		t.Error("Validate() => got no error for a RuntimeDefault profile with a localhost profile")
+	}
+}
+
+func TestSeccompProfilePatch(t *testing.T) {
+	profile := &SeccompProfile{Type: SeccompRuntimeDefault}
+	got, err := patchPodSpec([]byte(securityDeployment), seccompProfile(profile, "proxy"))
+	if err != nil {
+		t.Fatalf("seccompProfile() returned an error: %v", err)
+	}
+	want := `        securityContext:
+          runAsUser: 1337
+          seccompProfile:
+            type: RuntimeDefault
+`
+	if !strings.Contains(string(got), want) || strings.Count(string(got), "seccompProfile") != 1 {
+		t.Errorf("seccompProfile() => got\n%s\nwant only the proxy to contain\n%s", got, want)
+	}
+
+	if _, err = patchPodSpec([]byte(securityDeployment), seccompProfile(profile, "missing")); err == nil {
+		t.Error("seccompProfile() succeeded for a missing container")
+	}
+}
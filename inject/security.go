@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// proxyConfigVolumeName is the name of the volume of the Envoy
+	// configuration written by the proxy agent.
+	proxyConfigVolumeName = "istio-envoy"
+	// proxyConfigDirectory is where the proxy agent writes the Envoy
+	// configuration.
+	proxyConfigDirectory = "/etc/istio/proxy"
+	// proxyTmpVolumeName is the name of the volume of the temporary
+	// files of the proxy.
+	proxyTmpVolumeName = "istio-tmp"
+)
+
+// disallowPrivilegeEscalation returns a patch that sets
+// allowPrivilegeEscalation: false on the named containers. The field
+// is newer than the kubernetes types used for injection, so it cannot
+// be set on the containers themselves.
+func disallowPrivilegeEscalation(containers []string) PodSpecPatch {
+	return func(spec map[string]interface{}) error {
+		list, _ := spec["containers"].([]interface{})
+		names := make(map[string]bool)
+		for _, name := range containers {
+			names[name] = true
+		}
+		found := 0
+		for _, item := range list {
+			container, ok := item.(map[string]interface{})
+			if !ok || !names[fmt.Sprint(container["name"])] {
+				continue
+			}
+			securityContext, ok := container["securityContext"].(map[string]interface{})
+			if !ok {
+				securityContext = make(map[string]interface{})
+				container["securityContext"] = securityContext
+			}
+			securityContext["allowPrivilegeEscalation"] = false
+			found++
+		}
+		if found != len(names) {
+			return fmt.Errorf("cannot find injected containers %v in the pod template", containers)
+		}
+		return nil
+	}
+}
+
+// readOnlyRootFilesystem makes the root filesystem of the proxy
+// read-only, with emptyDir volumes for the paths the proxy writes to:
+// the Envoy configuration and /tmp, unless already mounted.
+func readOnlyRootFilesystem(t *v1.PodTemplateSpec, c *v1.Container) {
+	if c.SecurityContext == nil {
+		c.SecurityContext = &v1.SecurityContext{}
+	}
+	readOnly := true
+	c.SecurityContext.ReadOnlyRootFilesystem = &readOnly
+	for _, writable := range []struct{ name, path string }{
+		{proxyConfigVolumeName, proxyConfigDirectory},
+		{proxyTmpVolumeName, "/tmp"},
+	} {
+		mounted := false
+		for _, m := range c.VolumeMounts {
+			mounted = mounted || m.MountPath == writable.path
+		}
+		if mounted {
+			continue
+		}
+		t.Spec.Volumes = append(t.Spec.Volumes, v1.Volume{
+			Name:         writable.name,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{Name: writable.name, MountPath: writable.path})
+	}
+}
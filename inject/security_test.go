@@ -0,0 +1,80 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const securityDeployment = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    spec:
+      containers:
+      - name: hello
+        image: hello
+      - name: proxy
+        image: proxy
+        securityContext:
+          runAsUser: 1337
+`
+
+func TestDisallowPrivilegeEscalation(t *testing.T) {
+	got, err := patchPodSpec([]byte(securityDeployment), disallowPrivilegeEscalation([]string{"proxy"}))
+	if err != nil {
+		t.Fatalf("disallowPrivilegeEscalation() returned an error: %v", err)
+	}
+	want := `      - image: proxy
+        name: proxy
+        securityContext:
+          allowPrivilegeEscalation: false
+          runAsUser: 1337
+`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("disallowPrivilegeEscalation() => got\n%s\nwant it to contain\n%s", got, want)
+	}
+	if strings.Count(string(got), "allowPrivilegeEscalation") != 1 {
+		t.Errorf("disallowPrivilegeEscalation() modified other containers:\n%s", got)
+	}
+
+	if _, err = patchPodSpec([]byte(securityDeployment), disallowPrivilegeEscalation([]string{"missing"})); err == nil {
+		t.Error("disallowPrivilegeEscalation() succeeded for a missing container")
+	}
+}
+
+func TestReadOnlyRootFilesystem(t *testing.T) {
+	template := &v1.PodTemplateSpec{}
+	sidecar := v1.Container{
+		Name:         "proxy",
+		VolumeMounts: []v1.VolumeMount{{Name: coreDumpVolumeName, MountPath: "/tmp"}},
+	}
+	readOnlyRootFilesystem(template, &sidecar)
+	if sc := sidecar.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("readOnlyRootFilesystem() => got security context %v, want a read-only root filesystem", sc)
+	}
+	if len(template.Spec.Volumes) != 1 || template.Spec.Volumes[0].Name != proxyConfigVolumeName ||
+		template.Spec.Volumes[0].EmptyDir == nil {
+		t.Errorf("readOnlyRootFilesystem() => got volumes %v, want only the proxy configuration emptyDir", template.Spec.Volumes)
+	}
+	if len(sidecar.VolumeMounts) != 2 || sidecar.VolumeMounts[1].MountPath != proxyConfigDirectory {
+		t.Errorf("readOnlyRootFilesystem() => got mounts %v, want the proxy configuration after the core dumps", sidecar.VolumeMounts)
+	}
+}
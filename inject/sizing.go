@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// memoryGranularity is the unit computed memory quantities are rounded
+// up to.
+const memoryGranularity = 1 << 20
+
+// SizingPolicy sizes the proxy relative to the application containers
+// of every pod instead of giving all proxies the same resources.
+type SizingPolicy struct {
+	// Fraction of the summed requests and limits of the application
+	// containers given to the proxy.
+	Fraction float64
+	// Min and Max bound every computed quantity. Min also applies when
+	// no application container sets the resource.
+	Min v1.ResourceList
+	Max v1.ResourceList
+}
+
+// scale returns the fraction of q, rounded to millicores for CPU and
+// up to whole mebibytes for memory.
+func (s *SizingPolicy) scale(name v1.ResourceName, q resource.Quantity) resource.Quantity {
+	if name == v1.ResourceMemory {
+		units := int64(float64(q.Value())*s.Fraction+memoryGranularity-1) / memoryGranularity
+		return *resource.NewQuantity(units*memoryGranularity, resource.BinarySI)
+	}
+	return *resource.NewMilliQuantity(int64(float64(q.MilliValue())*s.Fraction), q.Format)
+}
+
+// size computes the proxy resource list from the summed application
+// resource list. Only CPU and memory are scaled, the proxy has no use
+// for a fraction of extended resources such as GPUs. Other resources
+// and those missing from the sum fall back to the resources of
+// fallback and, if floorMissing is set, to Min.
+func (s *SizingPolicy) size(sum, fallback v1.ResourceList, floorMissing bool) v1.ResourceList {
+	out := make(v1.ResourceList)
+	for name, q := range fallback {
+		out[name] = q
+	}
+	for name, q := range sum {
+		if name == v1.ResourceCPU || name == v1.ResourceMemory {
+			out[name] = s.scale(name, q)
+		}
+	}
+	for name, min := range s.Min {
+		if q, ok := out[name]; (!ok && floorMissing) || (ok && q.Cmp(min) < 0) {
+			out[name] = min
+		}
+	}
+	for name, max := range s.Max {
+		if q, ok := out[name]; ok && q.Cmp(max) > 0 {
+			out[name] = max
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Resources returns the proxy resources for the application
+// containers. Resources the policy cannot size are taken from
+// defaults. Limits are never lower than the requests.
+func (s *SizingPolicy) Resources(containers []v1.Container, defaults v1.ResourceRequirements) v1.ResourceRequirements {
+	requests := make(v1.ResourceList)
+	limits := make(v1.ResourceList)
+	for _, c := range containers {
+		for name, q := range c.Resources.Requests {
+			sum := requests[name]
+			sum.Add(q)
+			requests[name] = sum
+		}
+		for name, q := range c.Resources.Limits {
+			sum := limits[name]
+			sum.Add(q)
+			limits[name] = sum
+		}
+	}
+
+	out := v1.ResourceRequirements{
+		Requests: s.size(requests, defaults.Requests, true),
+		// Only limit the proxy where the application is limited.
+		Limits: s.size(limits, defaults.Limits, false),
+	}
+	for name, limit := range out.Limits {
+		if request, ok := out.Requests[name]; ok && limit.Cmp(request) < 0 {
+			out.Limits[name] = request
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func parseResources(values map[v1.ResourceName]string) v1.ResourceList {
+	if values == nil {
+		return nil
+	}
+	list := make(v1.ResourceList)
+	for name, value := range values {
+		list[name] = resource.MustParse(value)
+	}
+	return list
+}
+
+func formatResources(list v1.ResourceList) map[v1.ResourceName]string {
+	if list == nil {
+		return nil
+	}
+	values := make(map[v1.ResourceName]string)
+	for name, q := range list {
+		values[name] = q.String()
+	}
+	return values
+}
+
+func TestSizingPolicyResources(t *testing.T) {
+	policy := &SizingPolicy{
+		Fraction: 0.1,
+		Min:      parseResources(map[v1.ResourceName]string{"cpu": "10m", "memory": "32Mi"}),
+		Max:      parseResources(map[v1.ResourceName]string{"cpu": "500m", "memory": "256Mi"}),
+	}
+	container := func(requests, limits map[v1.ResourceName]string) v1.Container {
+		return v1.Container{Resources: v1.ResourceRequirements{
+			Requests: parseResources(requests),
+			Limits:   parseResources(limits),
+		}}
+	}
+
+	cases := []struct {
+		name         string
+		containers   []v1.Container
+		defaults     v1.ResourceRequirements
+		wantRequests map[v1.ResourceName]string
+		wantLimits   map[v1.ResourceName]string
+	}{{
+		name: "proportional",
+		containers: []v1.Container{
+			container(map[v1.ResourceName]string{"cpu": "1", "memory": "1Gi"}, nil),
+			container(map[v1.ResourceName]string{"cpu": "500m", "memory": "512Mi"}, nil),
+		},
+		wantRequests: map[v1.ResourceName]string{"cpu": "150m", "memory": "154Mi"},
+	}, {
+		name: "floors",
+		containers: []v1.Container{
+			container(map[v1.ResourceName]string{"cpu": "20m"}, map[v1.ResourceName]string{"cpu": "50m"}),
+		},
+		wantRequests: map[v1.ResourceName]string{"cpu": "10m", "memory": "32Mi"},
+		wantLimits:   map[v1.ResourceName]string{"cpu": "10m"},
+	}, {
+		name: "ceilings",
+		containers: []v1.Container{
+			container(map[v1.ResourceName]string{"cpu": "16", "memory": "64Gi"},
+				map[v1.ResourceName]string{"cpu": "32", "memory": "64Gi"}),
+		},
+		wantRequests: map[v1.ResourceName]string{"cpu": "500m", "memory": "256Mi"},
+		wantLimits:   map[v1.ResourceName]string{"cpu": "500m", "memory": "256Mi"},
+	}, {
+		name:       "defaults",
+		containers: []v1.Container{{}},
+		defaults: v1.ResourceRequirements{
+			Requests: parseResources(map[v1.ResourceName]string{"cpu": "100m"}),
+			Limits:   parseResources(map[v1.ResourceName]string{"memory": "128Mi"}),
+		},
+		wantRequests: map[v1.ResourceName]string{"cpu": "100m", "memory": "32Mi"},
+		wantLimits:   map[v1.ResourceName]string{"memory": "128Mi"},
+	}, {
+		name: "extended resources",
+		containers: []v1.Container{
+			container(map[v1.ResourceName]string{"cpu": "1", "nvidia.com/gpu": "1"},
+				map[v1.ResourceName]string{"nvidia.com/gpu": "1"}),
+		},
+		defaults: v1.ResourceRequirements{
+			Requests: parseResources(map[v1.ResourceName]string{"example.com/foo": "1"}),
+		},
+		wantRequests: map[v1.ResourceName]string{"cpu": "100m", "memory": "32Mi", "example.com/foo": "1"},
+	}}
+	for _, c := range cases {
+		got := policy.Resources(c.containers, c.defaults)
+		if requests := formatResources(got.Requests); !reflect.DeepEqual(requests, c.wantRequests) {
+			t.Errorf("%s: requests => got %v, want %v", c.name, requests, c.wantRequests)
+		}
+		if limits := formatResources(got.Limits); !reflect.DeepEqual(limits, c.wantLimits) {
+			t.Errorf("%s: limits => got %v, want %v", c.name, limits, c.wantLimits)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// SnapshotSuffix is appended to the base name of an input file to
+// name its recorded snapshot.
+const SnapshotSuffix = ".injected"
+
+// SnapshotPath returns the path of the snapshot recorded in dir for
+// the given input file.
+func SnapshotPath(dir, input string) string {
+	return filepath.Join(dir, filepath.Base(input)+SnapshotSuffix)
+}
+
+// uniqueSnapshots fails if two inputs have the same snapshot path, e.g.
+// files of the same name in different directories, whose snapshots
+// would overwrite each other.
+func uniqueSnapshots(inputs []string, dir string) error {
+	seen := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		path := SnapshotPath(dir, input)
+		if other, ok := seen[path]; ok {
+			return fmt.Errorf("inputs %s and %s have the same snapshot %s, rename one of them", other, input, path)
+		}
+		seen[path] = input
+	}
+	return nil
+}
+
+// SnapshotMismatchError reports a recorded snapshot that no longer
+// matches the injected output of its input file.
+type SnapshotMismatchError struct {
+	// Path is the location of the recorded snapshot.
+	Path string
+	// Diff is a unified diff from the snapshot to the current output.
+	Diff string
+}
+
+func (e *SnapshotMismatchError) Error() string {
+	return fmt.Sprintf("snapshot %s does not match:\n%s", e.Path, e.Diff)
+}
+
+func injectFile(p *Params, input string) ([]byte, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = in.Close() }()
+	var out bytes.Buffer
+	if err = IntoResourceFile(p, in, &out); err != nil {
+		return nil, fmt.Errorf("%s: %v", input, err)
+	}
+	return out.Bytes(), nil
+}
+
+// forEach calls fn for every input using at most concurrency
+// goroutines, one if concurrency is not positive. The errors of all
+// inputs are returned in input order.
+func forEach(inputs []string, concurrency int, fn func(input string) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(inputs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(inputs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = fn(inputs[i])
+			}
+		}()
+	}
+	for i := range inputs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var result error
+	for _, err := range errs {
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// RecordSnapshots injects every input file and records the result
+// into dir, overwriting any previously recorded snapshot. Up to
+// concurrency files are processed at once. Snapshots are named after
+// the base names of the inputs, which must be unique.
+func RecordSnapshots(p *Params, inputs []string, dir string, concurrency int) error {
+	if err := uniqueSnapshots(inputs, dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return forEach(inputs, concurrency, func(input string) error {
+		got, err := injectFile(p, input)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(SnapshotPath(dir, input), got, 0644)
+	})
+}
+
+// VerifySnapshots injects every input file and compares the result
+// against the snapshot recorded in dir. Every snapshot that no longer
+// matches is reported as a *SnapshotMismatchError within the returned
+// error. Up to concurrency files are processed at once.
+func VerifySnapshots(p *Params, inputs []string, dir string, concurrency int) error {
+	if err := uniqueSnapshots(inputs, dir); err != nil {
+		return err
+	}
+	return forEach(inputs, concurrency, func(input string) error {
+		got, err := injectFile(p, input)
+		if err != nil {
+			return err
+		}
+		path := SnapshotPath(dir, input)
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(got, want) {
+			return nil
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(want)),
+			B:        difflib.SplitLines(string(got)),
+			FromFile: path,
+			ToFile:   input,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		return &SnapshotMismatchError{Path: path, Diff: diff}
+	})
+}
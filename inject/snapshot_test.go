@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	mesh := proxy.DefaultMeshConfig()
+	params := Params{
+		InitImage:       InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:      ProxyImageName(unitTestHub, unitTestTag),
+		Verbosity:       DefaultVerbosity,
+		SidecarProxyUID: DefaultSidecarProxyUID,
+		Version:         "12345678",
+		Mesh:            &mesh,
+	}
+	inputs := []string{"testdata/hello.yaml", "testdata/frontend.yaml"}
+
+	if err = RecordSnapshots(&params, inputs, dir, 2); err != nil {
+		t.Fatalf("RecordSnapshots() returned an error: %v", err)
+	}
+	if err = VerifySnapshots(&params, inputs, dir, 2); err != nil {
+		t.Fatalf("VerifySnapshots() returned an error on fresh snapshots: %v", err)
+	}
+
+	params.Version = "87654321"
+	err = VerifySnapshots(&params, inputs, dir, 2)
+	if err == nil {
+		t.Fatal("VerifySnapshots() succeeded after the output changed")
+	}
+	for _, want := range []string{"hello.yaml.injected", "+        alpha.istio.io/version: \"87654321\""} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("VerifySnapshots() error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestSnapshotsSameBaseName(t *testing.T) {
+	inputs := []string{"a/hello.yaml", "b/hello.yaml"}
+	for name, fn := range map[string]func(*Params, []string, string, int) error{
+		"RecordSnapshots": RecordSnapshots,
+		"VerifySnapshots": VerifySnapshots,
+	} {
+		if err := fn(&Params{}, inputs, "snapshots", 1); err == nil || !strings.Contains(err.Error(), "same snapshot") {
+			t.Errorf("%s() => got %v, want an error for inputs of the same name", name, err)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	inputs := []string{"a", "b", "c", "d", "e", "f"}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	err := forEach(inputs, 3, func(input string) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if input == "b" || input == "e" {
+			return fmt.Errorf("failed %s", input)
+		}
+		return nil
+	})
+	if peak > 3 {
+		t.Errorf("forEach() ran %d inputs at once, want at most 3", peak)
+	}
+	merr, ok := err.(*multierror.Error)
+	if !ok || len(merr.Errors) != 2 || merr.Errors[0].Error() != "failed b" || merr.Errors[1].Error() != "failed e" {
+		t.Errorf("forEach() => got error %v, want the failures of b and e in order", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// WriteResourceFiles writes every kubernetes resource of a YAML file,
+// e.g. the output of IntoResourceFile, to its own file named
+// kind_name.yaml, or kind_namespace_name.yaml if its namespace is set,
+// in the directory, for repositories that keep a file per resource. The items of Lists are written separately. It returns
+// the paths of the written files.
+func WriteResourceFiles(in io.Reader, dir string) ([]string, error) {
+	var names []string
+	files := make(map[string][]byte)
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(data, []byte("null")) {
+			continue
+		}
+		var meta resourceMeta
+		if err = json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+		docs := [][]byte{raw}
+		if meta.APIVersion == "v1" && meta.Kind == "List" {
+			if docs, err = listItems(data); err != nil {
+				return nil, err
+			}
+		}
+		for _, doc := range docs {
+			name, err := resourceFileName(doc)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := files[name]; ok {
+				return nil, fmt.Errorf("more than one resource would be written to %s", name)
+			}
+			files[name] = doc
+			names = append(names, name)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, files[name], 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// listItems returns the items of a List, decoded as JSON, as YAML
+// documents.
+func listItems(data []byte) ([][]byte, error) {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	docs := make([][]byte, 0, len(list.Items))
+	for _, item := range list.Items {
+		doc, err := yaml.JSONToYAML(item)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// resourceFileName returns the file name of a resource: its kind, its
+// namespace if set, and its name in lower case.
+func resourceFileName(doc []byte) (string, error) {
+	var meta resourceMeta
+	if err := yaml.Unmarshal(doc, &meta); err != nil {
+		return "", err
+	}
+	if meta.Kind == "" || meta.Metadata.Name == "" {
+		return "", fmt.Errorf("resource without a kind and a name cannot be written to its own file:\n%s", doc)
+	}
+	name := meta.Kind + "_" + meta.Metadata.Name
+	if meta.Metadata.Namespace != "" {
+		name = meta.Kind + "_" + meta.Metadata.Namespace + "_" + meta.Metadata.Name
+	}
+	name = strings.ToLower(name)
+	return strings.Replace(name, "/", "-", -1) + ".yaml", nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteResourceFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	in := `apiVersion: v1
+kind: Service
+metadata:
+  name: frontend # public
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: settings
+- apiVersion: extensions/v1beta1
+  kind: Deployment
+  metadata:
+    name: frontend
+---
+`
+	paths, err := WriteResourceFiles(strings.NewReader(in), filepath.Join(dir, "manifests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"service_frontend.yaml", "configmap_settings.yaml", "deployment_frontend.yaml"}
+	if len(paths) != len(want) {
+		t.Fatalf("got files %v, want %v", paths, want)
+	}
+	for i, path := range paths {
+		if filepath.Base(path) != want[i] {
+			t.Errorf("got file %s, want %s", path, want[i])
+		}
+	}
+
+	// Documents are written verbatim.
+	service, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(service), "name: frontend # public") {
+		t.Errorf("got %s, want the document verbatim", service)
+	}
+
+	duplicate := "kind: Service\nmetadata:\n  name: a\n---\nkind: Service\nmetadata:\n  name: a\n"
+	if _, err = WriteResourceFiles(strings.NewReader(duplicate), dir); err == nil {
+		t.Error("WriteResourceFiles() succeeded with two resources of the same kind and name")
+	}
+	sameName := "kind: Service\nmetadata:\n  name: a\n  namespace: web\n---\n" +
+		"kind: Service\nmetadata:\n  name: a\n  namespace: db\n"
+	if paths, err = WriteResourceFiles(strings.NewReader(sameName), dir); err != nil {
+		t.Errorf("WriteResourceFiles() of resources of the same name in two namespaces returned an error: %v", err)
+	} else if len(paths) != 2 || filepath.Base(paths[0]) != "service_web_a.yaml" || filepath.Base(paths[1]) != "service_db_a.yaml" {
+		t.Errorf("got files %v, want service_web_a.yaml and service_db_a.yaml", paths)
+	}
+	if _, err = WriteResourceFiles(strings.NewReader("kind: Service\n"), dir); err == nil {
+		t.Error("WriteResourceFiles() succeeded with a resource without a name")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// holdApplicationAnnotationKey overrides whether the application
+// containers of the pods wait for the proxy to start.
+const holdApplicationAnnotationKey = "sidecar.wharfie.io/holdApplicationUntilProxyStarts"
+
+// proxyStartTimeout is how many seconds the postStart hook waits for
+// the proxy before failing, which restarts the proxy container.
+const proxyStartTimeout = 60
+
+// holdApplication returns whether the application containers of the
+// pods of the template wait for the proxy to start, as set by the
+// parameters or overridden by an annotation.
+func holdApplication(p *Params, t *v1.PodTemplateSpec) (bool, error) {
+	hold := p.HoldApplicationUntilProxyStarts
+	if value, ok := t.Annotations[holdApplicationAnnotationKey]; ok {
+		var err error
+		if hold, err = strconv.ParseBool(value); err != nil {
+			return false, fmt.Errorf("annotation %s must be true or false, got %q", holdApplicationAnnotationKey, value)
+		}
+	}
+	return hold, nil
+}
+
+// addStartHook blocks the start of the containers after the proxy
+// until the admin endpoint of the proxy reports ready. The kubelet
+// starts the containers of a pod in order and runs the postStart hook
+// of each container before starting the next one. A hook set by the
+// flavor is kept.
+func addStartHook(p *Params, c *v1.Container) {
+	if c.Lifecycle != nil && c.Lifecycle.PostStart != nil {
+		return
+	}
+	if c.Lifecycle == nil {
+		c.Lifecycle = &v1.Lifecycle{}
+	}
+	wait := fmt.Sprintf("i=0; until curl -fs http://127.0.0.1:%d/ready >/dev/null; do "+
+		"i=$((i+1)); if [ $i -ge %d ]; then exit 1; fi; sleep 1; done",
+		p.Mesh.ProxyAdminPort, proxyStartTimeout)
+	c.Lifecycle.PostStart = &v1.Handler{
+		Exec: &v1.ExecAction{Command: []string{"sh", "-c", wait}},
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestHoldApplication(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	p := &Params{
+		InitImage:                       InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:                      ProxyImageName(unitTestHub, unitTestTag),
+		SidecarProxyUID:                 DefaultSidecarProxyUID,
+		Mesh:                            &mesh,
+		HoldApplicationUntilProxyStarts: true,
+	}
+	template := &v1.PodTemplateSpec{}
+	template.Spec.Containers = []v1.Container{{Name: "app", Image: "app"}}
+	if err := IntoPodTemplateSpec(p, "default", template); err != nil {
+		t.Fatal(err)
+	}
+	containers := template.Spec.Containers
+	if len(containers) != 2 || containers[0].Name != proxyContainerName {
+		t.Fatalf("IntoPodTemplateSpec() => got containers %v, want the proxy first", containers)
+	}
+	lifecycle := containers[0].Lifecycle
+	if lifecycle == nil || lifecycle.PostStart == nil || lifecycle.PostStart.Exec == nil {
+		t.Fatalf("IntoPodTemplateSpec() => got proxy lifecycle %v, want a postStart command", lifecycle)
+	}
+	if command := strings.Join(lifecycle.PostStart.Exec.Command, " "); !strings.Contains(command, "http://127.0.0.1:15000/ready") {
+		t.Errorf("IntoPodTemplateSpec() => got postStart command %q, want it to wait for the admin endpoint", command)
+	}
+
+	template = &v1.PodTemplateSpec{}
+	template.Annotations = map[string]string{holdApplicationAnnotationKey: "false"}
+	template.Spec.Containers = []v1.Container{{Name: "app", Image: "app"}}
+	if err := IntoPodTemplateSpec(p, "default", template); err != nil {
+		t.Fatal(err)
+	}
+	if containers = template.Spec.Containers; containers[0].Name != "app" || containers[1].Lifecycle != nil {
+		t.Errorf("IntoPodTemplateSpec() of a pod opted out => got containers %v", containers)
+	}
+
+	template = &v1.PodTemplateSpec{}
+	template.Annotations = map[string]string{holdApplicationAnnotationKey: "sometimes"}
+	if err := IntoPodTemplateSpec(p, "default", template); err == nil {
+		t.Error("IntoPodTemplateSpec() accepted an invalid annotation")
+	}
+}
+
+func TestAddStartHook(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	own := &v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/wait"}}
+	c := v1.Container{Lifecycle: &v1.Lifecycle{PostStart: own}}
+	addStartHook(&Params{Mesh: &mesh}, &c)
+	if c.Lifecycle.PostStart != own {
+		t.Errorf("addStartHook() replaced the postStart hook of the flavor with %v", c.Lifecycle.PostStart)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/ghodss/yaml"
+)
+
+// mergeByName lists the fields of pod templates whose items strategic
+// merge patches merge by name.
+var mergeByName = map[string]bool{
+	"containers":     true,
+	"initContainers": true,
+	"volumes":        true,
+	"env":            true,
+}
+
+// WriteStrategicMergePatches writes a YAML stream with a strategic
+// merge patch for every resource changed between the original YAML
+// file and its injected output, e.g. for the patchesStrategicMerge of
+// kustomize. Like WriteJSONPatches, the outputs must hold the same
+// documents in the same order, and every patch names its resource.
+func WriteStrategicMergePatches(original, injected io.Reader, out io.Writer) error {
+	before, err := readObjects(original)
+	if err != nil {
+		return err
+	}
+	after, err := readObjects(injected)
+	if err != nil {
+		return err
+	}
+	if len(before) != len(after) {
+		return fmt.Errorf("injected output has %d resources, want %d", len(after), len(before))
+	}
+	for i := range before {
+		patch, _ := mergePatch("", before[i], after[i]).(map[string]interface{})
+		if patch == nil {
+			continue
+		}
+		var meta resourceMeta
+		if err = convert(before[i], &meta); err != nil {
+			return err
+		}
+		metadata, _ := patch["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			patch["metadata"] = metadata
+		}
+		metadata["name"] = meta.Metadata.Name
+		if meta.Metadata.Namespace != "" {
+			metadata["namespace"] = meta.Metadata.Namespace
+		}
+		patch["apiVersion"] = meta.APIVersion
+		patch["kind"] = meta.Kind
+		data, err := yaml.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(out, "%s---\n", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergePatch returns the strategic merge patch that changes a decoded
+// JSON value in a field into another, or nil if nothing changed. As in
+// diffJSON, fields that the kubernetes types drop or add as null or
+// empty objects are left out. Items of the lists merged by name are
+// patched by name, and other changed lists are replaced.
+func mergePatch(field string, before, after interface{}) interface{} {
+	switch after := after.(type) {
+	case map[string]interface{}:
+		before, ok := before.(map[string]interface{})
+		if !ok {
+			break
+		}
+		patch := make(map[string]interface{})
+		for key, value := range after {
+			old, ok := before[key]
+			if !ok {
+				if !isEmptyJSON(value) {
+					patch[key] = value
+				}
+				continue
+			}
+			if change := mergePatch(key, old, value); change != nil {
+				patch[key] = change
+			}
+		}
+		if len(patch) == 0 {
+			return nil
+		}
+		return patch
+	case []interface{}:
+		before, ok := before.([]interface{})
+		if !ok || !mergeByName[field] {
+			break
+		}
+		items := make(map[interface{}]interface{})
+		for _, item := range before {
+			if item, ok := item.(map[string]interface{}); ok {
+				items[item["name"]] = item
+			}
+		}
+		var patch []interface{}
+		for _, item := range after {
+			value, _ := item.(map[string]interface{})
+			old, ok := items[value["name"]]
+			if !ok {
+				patch = append(patch, item)
+				continue
+			}
+			if change, _ := mergePatch("", old, item).(map[string]interface{}); change != nil {
+				change["name"] = value["name"]
+				patch = append(patch, change)
+			}
+		}
+		if len(patch) == 0 {
+			return nil
+		}
+		return patch
+	}
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	return after
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteStrategicMergePatches(t *testing.T) {
+	original, err := os.Open("testdata/hello-multi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = original.Close() }()
+	injected, err := os.Open("testdata/hello-multi.yaml.injected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = injected.Close() }()
+	var out bytes.Buffer
+	if err = WriteStrategicMergePatches(original, injected, &out); err != nil {
+		t.Fatal(err)
+	}
+	util.CompareContent(out.Bytes(), "testdata/hello-multi.patch.yaml", t)
+}
+
+func TestMergePatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		before map[string]interface{}
+		after  map[string]interface{}
+		want   interface{}
+	}{
+		{
+			name:   "unchanged",
+			before: map[string]interface{}{"name": "hello", "dropped": true},
+			after:  map[string]interface{}{"name": "hello", "status": map[string]interface{}{}},
+		},
+		{
+			name: "containers merged by name",
+			before: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "args": []interface{}{"a"}},
+			}},
+			after: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "args": []interface{}{"a"}, "resources": map[string]interface{}{}},
+				map[string]interface{}{"name": "proxy"},
+			}},
+			want: map[string]interface{}{"containers": []interface{}{map[string]interface{}{"name": "proxy"}}},
+		},
+		{
+			name: "changed container",
+			before: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "args": []interface{}{"a"}},
+			}},
+			after: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "args": []interface{}{"a", "b"}},
+			}},
+			want: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "hello", "args": []interface{}{"a", "b"}},
+			}},
+		},
+		{
+			name:   "annotations",
+			before: map[string]interface{}{"annotations": map[string]interface{}{"a": "1"}},
+			after:  map[string]interface{}{"annotations": map[string]interface{}{"a": "1", "b": "2"}},
+			want:   map[string]interface{}{"annotations": map[string]interface{}{"b": "2"}},
+		},
+	}
+	for _, c := range cases {
+		if got := mergePatch("", c.before, c.after); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
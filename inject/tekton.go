@@ -0,0 +1,220 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Tekton runs embed the steps of their tasks rather than a pod
+// template, and create a pod for every task.
+var (
+	taskRunKind     = schema.GroupKind{Group: "tekton.dev", Kind: "TaskRun"}
+	pipelineRunKind = schema.GroupKind{Group: "tekton.dev", Kind: "PipelineRun"}
+)
+
+// tektonWarning points out that Tekton does not order the proxy
+// before the steps.
+const tektonWarning = "Tekton does not wait for the proxy to be ready before running the steps, " +
+	"which should retry requests to the mesh"
+
+func isTektonRun(gk schema.GroupKind) bool {
+	return gk == taskRunKind || gk == pipelineRunKind
+}
+
+// intoTektonRun injects the istio proxy into the pods of a TaskRun or
+// a PipelineRun. Tekton pod templates cannot hold containers, so the
+// proxy is added to the sidecars of every embedded task spec, its
+// volumes to the pod template of the run, and the annotations and
+// labels of the pod template to the run, which Tekton propagates to
+// its pods. Runs that only reference their tasks are skipped since the
+// proxy would have to be added to the tasks themselves.
+func intoTektonRun(p *Params, gv schema.GroupVersion, kind string, raw []byte, entry *ResourceReport) ([]byte, error) {
+	if p.SkipTekton {
+		entry.Reason = "Tekton runs are skipped"
+		return raw, nil
+	}
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	var taskSpecs []map[string]interface{}
+	if kind == taskRunKind.Kind {
+		if taskSpec, ok := spec["taskSpec"].(map[string]interface{}); ok {
+			taskSpecs = append(taskSpecs, taskSpec)
+		}
+	} else {
+		pipelineSpec, _ := spec["pipelineSpec"].(map[string]interface{})
+		for _, field := range []string{"tasks", "finally"} {
+			tasks, _ := pipelineSpec[field].([]interface{})
+			for _, task := range tasks {
+				task, _ := task.(map[string]interface{})
+				if taskSpec, ok := task["taskSpec"].(map[string]interface{}); ok {
+					taskSpecs = append(taskSpecs, taskSpec)
+				}
+			}
+		}
+	}
+	if len(taskSpecs) == 0 {
+		entry.Reason = fmt.Sprintf("%s does not embed a task spec, add the proxy to the sidecars of its tasks", kind)
+		return raw, nil
+	}
+
+	// The v1 PipelineRun moved the pod template of its task runs.
+	podTemplateParent := spec
+	if kind == pipelineRunKind.Kind && gv.Version == "v1" {
+		podTemplateParent = child(spec, "taskRunTemplate")
+	}
+	podTemplate := child(podTemplateParent, "podTemplate")
+	metadata := child(obj, "metadata")
+
+	var run v1.PodTemplateSpec
+	if err := convert(metadata, &run.ObjectMeta); err != nil {
+		return nil, err
+	}
+	if err := convert(podTemplate, &run.Spec); err != nil {
+		return nil, err
+	}
+	if account, ok := podTemplateParent["serviceAccountName"].(string); ok {
+		run.Spec.ServiceAccountName = account
+	}
+
+	// Every task is injected from the run as it was before injection.
+	var base v1.PodTemplateSpec
+	if err := convert(&run, &base); err != nil {
+		return nil, err
+	}
+	for _, taskSpec := range taskSpecs {
+		// The pod of the task runs the steps next to the sidecars.
+		var t v1.PodTemplateSpec
+		if err := convert(&base, &t); err != nil {
+			return nil, err
+		}
+		t.Spec.Containers = nil
+		for _, field := range []string{"steps", "sidecars"} {
+			var containers []v1.Container
+			if err := convert(taskSpec[field], &containers); err != nil {
+				return nil, fmt.Errorf("invalid %s of the task spec: %v", field, err)
+			}
+			t.Spec.Containers = append(t.Spec.Containers, containers...)
+		}
+		before := summarizeTemplate(&t)
+		if err := IntoPodTemplateSpec(p, entry.Namespace, &t); err != nil {
+			return nil, err
+		}
+		entry.compare(p, before, &t)
+		if !entry.Injected {
+			return raw, nil
+		}
+
+		sidecars, _ := taskSpec["sidecars"].([]interface{})
+		added := map[string]interface{}{}
+		for _, c := range t.Spec.Containers {
+			// The proxy may be placed before the steps.
+			if contains(before.containers, c.Name) {
+				continue
+			}
+			var container map[string]interface{}
+			if err := convert(c, &container); err != nil {
+				return nil, err
+			}
+			sidecars = append(sidecars, container)
+		}
+		added["containers"] = sidecars
+		if err := disallowPrivilegeEscalation(entry.Containers)(added); err != nil {
+			return nil, err
+		}
+		if err := PatchSeccompProfile(p, &t, added); err != nil {
+			return nil, err
+		}
+		if err := PatchRunAsGroup(p, &t, added); err != nil {
+			return nil, err
+		}
+		taskSpec["sidecars"] = sidecars
+
+		volumes, _ := podTemplate["volumes"].([]interface{})
+		for _, v := range t.Spec.Volumes[len(before.volumes):] {
+			if hasVolume(run.Spec.Volumes, v.Name) {
+				continue
+			}
+			var volume map[string]interface{}
+			if err := convert(v, &volume); err != nil {
+				return nil, err
+			}
+			volumes = append(volumes, volume)
+			run.Spec.Volumes = append(run.Spec.Volumes, v)
+		}
+		if len(volumes) > 0 {
+			podTemplate["volumes"] = volumes
+		}
+		run.Annotations = t.Annotations
+		run.Labels = t.Labels
+	}
+	entry.Replicas = int32(len(taskSpecs))
+	entry.Warnings = append(entry.Warnings, tektonWarning)
+
+	annotations := map[string]interface{}{}
+	for key, value := range run.Annotations {
+		annotations[key] = value
+	}
+	metadata["annotations"] = annotations
+	if len(run.Labels) > 0 {
+		labels := map[string]interface{}{}
+		for key, value := range run.Labels {
+			labels[key] = value
+		}
+		metadata["labels"] = labels
+	}
+	if len(podTemplate) == 0 {
+		delete(podTemplateParent, "podTemplate")
+	}
+	if len(podTemplateParent) == 0 {
+		delete(spec, "taskRunTemplate")
+	}
+	updated, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if hasAnchors(raw) {
+		entry.Warnings = append(entry.Warnings,
+			"YAML anchors and aliases were expanded in the injected output")
+	}
+	return updated, nil
+}
+
+// child returns the object in the field of a parent object, adding an
+// empty one if needed.
+func child(parent map[string]interface{}, field string) map[string]interface{} {
+	value, ok := parent[field].(map[string]interface{})
+	if !ok {
+		value = map[string]interface{}{}
+		parent[field] = value
+	}
+	return value
+}
+
+func hasVolume(volumes []v1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
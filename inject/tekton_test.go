@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+const tektonPipelineRun = `apiVersion: tekton.dev/v1
+kind: PipelineRun
+metadata:
+  name: release
+spec:
+  taskRunTemplate:
+    serviceAccountName: publisher
+  pipelineSpec:
+    tasks:
+    - name: build
+      taskSpec:
+        steps:
+        - name: build
+          image: golang
+    - name: publish
+      taskSpec:
+        steps:
+        - name: push
+          image: publisher
+---
+apiVersion: tekton.dev/v1beta1
+kind: TaskRun
+metadata:
+  name: lint
+spec:
+  taskRef:
+    name: golangci-lint
+`
+
+func TestIntoTektonRun(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	for _, skip := range []bool{false, true} {
+		p := &Params{
+			InitImage:  InitImageName(unitTestHub, unitTestTag),
+			ProxyImage: ProxyImageName(unitTestHub, unitTestTag),
+			Verbosity:  DefaultVerbosity,
+			Mesh:       &mesh,
+			SkipTekton: skip,
+		}
+		var out bytes.Buffer
+		report, err := IntoResourceFileWithReport(p, strings.NewReader(tektonPipelineRun), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Resources) != 2 {
+			t.Fatalf("got %d resources in the report, want 2", len(report.Resources))
+		}
+		pipelineRun, taskRun := report.Resources[0], report.Resources[1]
+		if skip {
+			if pipelineRun.Injected || pipelineRun.Reason != "Tekton runs are skipped" {
+				t.Errorf("got report %+v, want a skipped run", pipelineRun)
+			}
+			continue
+		}
+		if !pipelineRun.Injected || pipelineRun.Replicas != 2 || len(pipelineRun.Warnings) == 0 {
+			t.Errorf("got report %+v, want two injected pods with a warning", pipelineRun)
+		}
+		if taskRun.Injected || !strings.Contains(taskRun.Reason, "does not embed a task spec") {
+			t.Errorf("got report %+v, want a skipped task run", taskRun)
+		}
+		// The certificate volume of the publisher is added once to the
+		// pod template shared by the tasks.
+		got := out.String()
+		if n := strings.Count(got, "secretName: istio.publisher"); n != 1 {
+			t.Errorf("got %d certificate volumes, want 1:\n%s", n, got)
+		}
+		if !strings.Contains(got, "  podTemplate:\n      volumes:\n      - name: istio-certs") {
+			t.Errorf("got %s, want the volumes in the pod template of the task runs", got)
+		}
+		if n := strings.Count(got, "image: "+ProxyImageName(unitTestHub, unitTestTag)); n != 2 {
+			t.Errorf("got %d proxies, want one for every task:\n%s", n, got)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// manifest is a kubernetes resource decoded for conversion to
+// Terraform, labeled with a unique Terraform resource name.
+type manifest struct {
+	Label  string
+	Object map[string]interface{}
+}
+
+var invalidLabel = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// readManifests decodes the resources of a YAML file. Null fields and
+// the status, which the API server populates, are dropped since the
+// kubernetes_manifest resource rejects them.
+func readManifests(in io.Reader) ([]manifest, error) {
+	var manifests []manifest
+	labels := make(map[string]int)
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if err = decoder.Decode(&object); err != nil {
+			return nil, err
+		}
+		if len(object) == 0 {
+			continue
+		}
+		delete(object, "status")
+		prune(object)
+
+		var meta resourceMeta
+		if err = json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+		var parts []string
+		for _, part := range []string{meta.Kind, meta.Metadata.Namespace, meta.Metadata.Name} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		label := strings.Trim(invalidLabel.ReplaceAllString(strings.ToLower(strings.Join(parts, "_")), "_"), "_")
+		if label == "" || label[0] >= '0' && label[0] <= '9' {
+			label = "resource_" + label
+		}
+		// Labels must be unique in a Terraform module.
+		if labels[label]++; labels[label] > 1 {
+			label = fmt.Sprintf("%s_%d", label, labels[label])
+		}
+		manifests = append(manifests, manifest{Label: label, Object: object})
+	}
+	return manifests, nil
+}
+
+// prune removes the null fields of a decoded resource. Empty objects
+// are kept since some are meaningful, e.g. an emptyDir volume.
+func prune(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if field == nil {
+				delete(v, key)
+			} else {
+				prune(field)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			prune(item)
+		}
+	}
+}
+
+// WriteTerraform converts the kubernetes resources of a YAML file, e.g.
+// the output of IntoResourceFile, to Terraform kubernetes_manifest
+// resources.
+func WriteTerraform(in io.Reader, out io.Writer) error {
+	manifests, err := readManifests(in)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for i, m := range manifests {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "resource \"kubernetes_manifest\" %q {\n  manifest = ", m.Label)
+		writeHCL(&buf, m.Object, 1)
+		buf.WriteString("\n}\n")
+	}
+	_, err = out.Write(buf.Bytes())
+	return err
+}
+
+// WriteTFVars converts the kubernetes resources of a YAML file to a
+// Terraform variables file in JSON that maps the resource labels to
+// the manifests, to be used with for_each.
+func WriteTFVars(in io.Reader, out io.Writer) error {
+	manifests, err := readManifests(in)
+	if err != nil {
+		return err
+	}
+	vars := make(map[string]interface{}, len(manifests))
+	for _, m := range manifests {
+		vars[m.Label] = m.Object
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"manifests": vars}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+var hclIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// hclString quotes a string literal, escaping the template sequences
+// Terraform would otherwise interpolate. The JSON escapes of control
+// characters are valid in HCL.
+func hclString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(strings.NewReplacer("${", "$${", "%{", "%%{").Replace(s))
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// writeHCL writes a decoded JSON value as an HCL expression indented
+// by the given depth.
+func writeHCL(buf *bytes.Buffer, value interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteString("{\n")
+		for _, key := range keys {
+			name := key
+			if !hclIdentifier.MatchString(key) {
+				name = hclString(key)
+			}
+			fmt.Fprintf(buf, "%s  %s = ", indent, name)
+			writeHCL(buf, v[key], depth+1)
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for _, item := range v {
+			buf.WriteString(indent + "  ")
+			writeHCL(buf, item, depth+1)
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(indent + "]")
+	case string:
+		buf.WriteString(hclString(v))
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	default:
+		buf.WriteString("null")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteTerraform(t *testing.T) {
+	cases := []struct {
+		write func(*os.File, *bytes.Buffer) error
+		want  string
+	}{
+		{
+			write: func(in *os.File, out *bytes.Buffer) error { return WriteTerraform(in, out) },
+			want:  "testdata/hello-multi.tf",
+		},
+		{
+			write: func(in *os.File, out *bytes.Buffer) error { return WriteTFVars(in, out) },
+			want:  "testdata/hello-multi.tfvars.json",
+		},
+	}
+	for _, c := range cases {
+		in, err := os.Open("testdata/hello-multi.yaml.injected")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = c.write(in, &out)
+		_ = in.Close()
+		if err != nil {
+			t.Fatalf("writing %s returned an error: %v", c.want, err)
+		}
+		util.CompareContent(out.Bytes(), c.want, t)
+	}
+}
+
+func TestWriteTerraformLabels(t *testing.T) {
+	in := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: 1st.config
+  annotations:
+    template: "${name} %{if}"
+data: {}
+spec:
+  value: null
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: 1st.config
+`
+	var out bytes.Buffer
+	if err := WriteTerraform(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`resource "kubernetes_manifest" "configmap_1st_config" {`,
+		`resource "kubernetes_manifest" "configmap_1st_config_2" {`,
+		`template = "$${name} %%{if}"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteTerraform() => got\n%s\nwant %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "value") || !strings.Contains(out.String(), "data = {}") {
+		t.Errorf("WriteTerraform() => got\n%s\nwant null fields dropped and empty objects kept", out.String())
+	}
+}
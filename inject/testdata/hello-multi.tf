@@ -0,0 +1,203 @@
+resource "kubernetes_manifest" "deployment_hello_v1" {
+  manifest = {
+    apiVersion = "extensions/v1beta1"
+    kind = "Deployment"
+    metadata = {
+      name = "hello-v1"
+    }
+    spec = {
+      replicas = 3
+      template = {
+        metadata = {
+          annotations = {
+            "alpha.istio.io/sidecar" = "injected"
+            "alpha.istio.io/version" = "12345678"
+            "pod.beta.kubernetes.io/init-containers" = "[{\"args\":[\"-p\",\"15001\",\"-u\",\"1337\"],\"image\":\"docker.io/istio/init:unittest\",\"imagePullPolicy\":\"Always\",\"name\":\"init\",\"securityContext\":{\"allowPrivilegeEscalation\":false,\"capabilities\":{\"add\":[\"NET_ADMIN\",\"NET_RAW\"],\"drop\":[\"ALL\"]},\"privileged\":false}}]"
+          }
+          labels = {
+            app = "hello"
+            tier = "backend"
+            track = "stable"
+            version = "v1"
+          }
+        }
+        spec = {
+          containers = [
+            {
+              image = "fake.docker.io/google-samples/hello-go-gke:1.0"
+              name = "hello"
+              ports = [
+                {
+                  containerPort = 80
+                  name = "http"
+                },
+              ]
+            },
+            {
+              args = [
+                "proxy",
+                "sidecar",
+                "-v",
+                "2",
+              ]
+              env = [
+                {
+                  name = "POD_NAME"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "metadata.name"
+                    }
+                  }
+                },
+                {
+                  name = "POD_NAMESPACE"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "metadata.namespace"
+                    }
+                  }
+                },
+                {
+                  name = "POD_IP"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "status.podIP"
+                    }
+                  }
+                },
+              ]
+              image = "docker.io/istio/proxy_debug:unittest"
+              imagePullPolicy = "Always"
+              name = "proxy"
+              ports = [
+                {
+                  containerPort = 15001
+                  name = "proxy"
+                  protocol = "TCP"
+                },
+                {
+                  containerPort = 15000
+                  name = "proxy-admin"
+                  protocol = "TCP"
+                },
+              ]
+              securityContext = {
+                allowPrivilegeEscalation = false
+                capabilities = {
+                  drop = [
+                    "ALL",
+                  ]
+                }
+                privileged = false
+                runAsNonRoot = true
+                runAsUser = 1337
+              }
+            },
+          ]
+        }
+      }
+    }
+  }
+}
+
+resource "kubernetes_manifest" "deployment_hello_v2" {
+  manifest = {
+    apiVersion = "extensions/v1beta1"
+    kind = "Deployment"
+    metadata = {
+      name = "hello-v2"
+    }
+    spec = {
+      replicas = 3
+      template = {
+        metadata = {
+          annotations = {
+            "alpha.istio.io/sidecar" = "injected"
+            "alpha.istio.io/version" = "12345678"
+            "pod.beta.kubernetes.io/init-containers" = "[{\"args\":[\"-p\",\"15001\",\"-u\",\"1337\"],\"image\":\"docker.io/istio/init:unittest\",\"imagePullPolicy\":\"Always\",\"name\":\"init\",\"securityContext\":{\"allowPrivilegeEscalation\":false,\"capabilities\":{\"add\":[\"NET_ADMIN\",\"NET_RAW\"],\"drop\":[\"ALL\"]},\"privileged\":false}}]"
+          }
+          labels = {
+            app = "hello"
+            tier = "backend"
+            track = "stable"
+            version = "v2"
+          }
+        }
+        spec = {
+          containers = [
+            {
+              image = "fake.docker.io/google-samples/hello-go-gke:1.0"
+              name = "hello"
+              ports = [
+                {
+                  containerPort = 81
+                  name = "http"
+                },
+              ]
+            },
+            {
+              args = [
+                "proxy",
+                "sidecar",
+                "-v",
+                "2",
+              ]
+              env = [
+                {
+                  name = "POD_NAME"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "metadata.name"
+                    }
+                  }
+                },
+                {
+                  name = "POD_NAMESPACE"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "metadata.namespace"
+                    }
+                  }
+                },
+                {
+                  name = "POD_IP"
+                  valueFrom = {
+                    fieldRef = {
+                      fieldPath = "status.podIP"
+                    }
+                  }
+                },
+              ]
+              image = "docker.io/istio/proxy_debug:unittest"
+              imagePullPolicy = "Always"
+              name = "proxy"
+              ports = [
+                {
+                  containerPort = 15001
+                  name = "proxy"
+                  protocol = "TCP"
+                },
+                {
+                  containerPort = 15000
+                  name = "proxy-admin"
+                  protocol = "TCP"
+                },
+              ]
+              securityContext = {
+                allowPrivilegeEscalation = false
+                capabilities = {
+                  drop = [
+                    "ALL",
+                  ]
+                }
+                privileged = false
+                runAsNonRoot = true
+                runAsUser = 1337
+              }
+            },
+          ]
+        }
+      }
+    }
+  }
+}
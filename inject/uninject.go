@@ -0,0 +1,192 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// injectedAnnotations are the annotations that only injection sets.
+var injectedAnnotations = []string{
+	istioSidecarAnnotationSidecarKey,
+	istioSidecarAnnotationVersionKey,
+	istioIdentityAnnotationKey,
+	interceptionModeAnnotationKey,
+	proxyPortAnnotationKey,
+	proxyUIDAnnotationKey,
+	injectedVolumesAnnotationKey,
+	proxyContainerNameAnnotationKey,
+	injectedRedirectionAnnotationKey,
+}
+
+// FromPodTemplateSpec removes the istio proxy injected into the pod
+// template: the proxy container, its init containers, its volumes, and
+// the annotations of injection. Templates that were not injected are
+// left unchanged. Changes that cannot be told apart from the original
+// template are kept, e.g. a raised termination grace period, the
+// network label, a shared process namespace, image pull secrets, or
+// the fsGroup of the pod.
+func FromPodTemplateSpec(t *v1.PodTemplateSpec) error {
+	if SidecarStatus(t) != istioSidecarAnnotationSidecarValue {
+		return nil
+	}
+
+	proxy := injectedProxyName(t)
+	containers := t.Spec.Containers[:0]
+	for _, c := range t.Spec.Containers {
+		if c.Name != proxy {
+			containers = append(containers, c)
+		}
+	}
+	t.Spec.Containers = containers
+
+	// Native sidecars are init containers.
+	initContainers := t.Spec.InitContainers[:0]
+	for _, c := range t.Spec.InitContainers {
+		if c.Name != proxy {
+			initContainers = append(initContainers, c)
+		}
+	}
+	if len(initContainers) == 0 {
+		initContainers = nil
+	}
+	t.Spec.InitContainers = initContainers
+
+	extra := injectedVolumes(t)
+	volumes := t.Spec.Volumes[:0]
+	for _, v := range t.Spec.Volumes {
+		switch {
+		case v.Name == CertVolumeName, v.Name == sdsVolumeName, v.Name == coreDumpVolumeName,
+			v.Name == proxyConfigVolumeName, v.Name == proxyTmpVolumeName, extra[v.Name]:
+		default:
+			volumes = append(volumes, v)
+		}
+	}
+	if len(volumes) == 0 {
+		volumes = nil
+	}
+	t.Spec.Volumes = volumes
+
+	if value, ok := t.Annotations[initContainersAnnotationKey]; ok {
+		var initContainers []map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &initContainers); err != nil {
+			return fmt.Errorf("invalid annotation %s: %v", initContainersAnnotationKey, err)
+		}
+		kept := make([]map[string]interface{}, 0, len(initContainers))
+		for _, c := range initContainers {
+			if name := c["name"]; name != initContainerName && name != enableCoreDumpContainerName {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.Annotations, initContainersAnnotationKey)
+		} else {
+			data, err := json.Marshal(kept)
+			if err != nil {
+				return err
+			}
+			t.Annotations[initContainersAnnotationKey] = string(data)
+		}
+	}
+
+	// The redirection annotations that override the parameters are
+	// kept, those added from the parameters are removed.
+	if value := t.Annotations[injectedRedirectionAnnotationKey]; value != "" {
+		for _, key := range strings.Split(value, ",") {
+			delete(t.Annotations, key)
+		}
+	}
+	for _, key := range injectedAnnotations {
+		delete(t.Annotations, key)
+	}
+	delete(t.Annotations, appArmorAnnotationKey(proxy))
+	delete(t.Annotations, appArmorAnnotationKey(initContainerName))
+	if len(t.Annotations) == 0 {
+		t.Annotations = nil
+	}
+	return nil
+}
+
+// FromResourceFile removes the istio proxy from the resources of the
+// specified kubernetes YAML file, restoring the manifests before
+// injection. Resources that were not injected are written verbatim.
+func FromResourceFile(in io.Reader, out io.Writer) error {
+	reader := newDocumentReader(in)
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		updated, err := fromResource(raw)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(updated, raw) {
+			updated = scrubOutput(raw, updated)
+		}
+		if _, err = out.Write(updated); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprint(out, "---\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fromResource removes the istio proxy from a single YAML document.
+func fromResource(raw []byte) ([]byte, error) {
+	var meta resourceMeta
+	if err := yaml.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	gv, err := schema.ParseGroupVersion(meta.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	if gv.Group == "" && meta.Kind == "List" {
+		updated, _, err := mapList(raw, false, func(item []byte) ([]byte, []ResourceReport, error) {
+			updated, err := fromResource(item)
+			return updated, nil, err
+		})
+		return updated, err
+	}
+	kind, ok := workloadKinds()[gv.WithKind(meta.Kind).GroupKind()]
+	if !ok {
+		return raw, nil
+	}
+	if err = yaml.Unmarshal(raw, kind.typ); err != nil {
+		return nil, err
+	}
+	t := kind.template(kind.typ)
+	if SidecarStatus(t) != istioSidecarAnnotationSidecarValue {
+		return raw, nil
+	}
+	if err = FromPodTemplateSpec(t); err != nil {
+		return nil, fmt.Errorf("%s %s: %v", meta.Kind, meta.Metadata.Name, err)
+	}
+	return yaml.Marshal(kind.typ)
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/util"
+)
+
+func TestFromResourceFile(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{in: "testdata/hello.yaml.injected", want: "testdata/hello.yaml.uninjected"},
+		{in: "testdata/auth.sds.yaml.injected", want: "testdata/auth.sds.yaml.uninjected"},
+		{in: "testdata/enable-core-dump.yaml.injected", want: "testdata/enable-core-dump.yaml.uninjected"},
+		{in: "testdata/multi-init.yaml.injected", want: "testdata/multi-init.yaml.uninjected"},
+		{in: "testdata/list.yaml.injected", want: "testdata/list.yaml.uninjected"},
+		{in: "testdata/hello-cni.yaml.injected", want: "testdata/hello-cni.yaml.uninjected"},
+		{in: "testdata/hello-volumes.yaml.injected", want: "testdata/hello-volumes.yaml.uninjected"},
+		{in: "testdata/hello-apparmor.yaml.injected", want: "testdata/hello-apparmor.yaml.uninjected"},
+	}
+	for _, c := range cases {
+		in, err := os.Open(c.in)
+		if err != nil {
+			t.Fatalf("Failed to open %q: %v", c.in, err)
+		}
+		var got bytes.Buffer
+		err = FromResourceFile(in, &got)
+		_ = in.Close()
+		if err != nil {
+			t.Fatalf("FromResourceFile(%v) returned an error: %v", c.in, err)
+		}
+		util.CompareContent(got.Bytes(), c.want, t)
+	}
+}
+
+// Removing the proxy restores the pod template before injection.
+func TestFromPodTemplateSpec(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	p := &Params{
+		InitImage:      InitImageName(unitTestHub, unitTestTag),
+		ProxyImage:     ProxyImageName(unitTestHub, unitTestTag),
+		Verbosity:      DefaultVerbosity,
+		Mesh:           &mesh,
+		EnableCoreDump: true,
+		TrustDomain:    "cluster.local",
+		SDSPath:        DefaultSDSPath,
+	}
+	for _, file := range []string{"testdata/hello.yaml", "testdata/multi-init.yaml", "testdata/hello-probes.yaml"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var deployment struct {
+			Spec struct {
+				Template v1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}
+		if err = yaml.Unmarshal(data, &deployment); err != nil {
+			t.Fatal(err)
+		}
+		want := deployment.Spec.Template
+		var got v1.PodTemplateSpec
+		if err = convert(&want, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err = IntoPodTemplateSpec(p, "web", &got); err != nil {
+			t.Fatal(err)
+		}
+		if err = FromPodTemplateSpec(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Spec, want.Spec) {
+			t.Errorf("%s: got pod spec %+v, want %+v", file, got.Spec, want.Spec)
+		}
+		// The remaining init containers are encoded again.
+		gotInit, wantInit := initContainerNames(t, got), initContainerNames(t, want)
+		if !reflect.DeepEqual(gotInit, wantInit) {
+			t.Errorf("%s: got init containers %v, want %v", file, gotInit, wantInit)
+		}
+		delete(got.Annotations, initContainersAnnotationKey)
+		delete(want.Annotations, initContainersAnnotationKey)
+		if len(got.Annotations) != len(want.Annotations) {
+			t.Errorf("%s: got annotations %v, want %v", file, got.Annotations, want.Annotations)
+		}
+	}
+}
+
+func initContainerNames(t *testing.T, template v1.PodTemplateSpec) []string {
+	value, ok := template.Annotations[initContainersAnnotationKey]
+	if !ok {
+		return nil
+	}
+	var containers []v1.Container
+	if err := yaml.Unmarshal([]byte(value), &containers); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// proxyVolumesAnnotationKey adds volumes to the pods alongside the
+	// proxy, as a JSON list of volumes.
+	proxyVolumesAnnotationKey = "sidecar.wharfie.io/proxyVolumes"
+	// proxyVolumeMountsAnnotationKey adds mounts to the proxy, as a
+	// JSON list of volume mounts. The mounts may reference the volumes
+	// of the pod, e.g. to share Unix domain sockets with the
+	// application.
+	proxyVolumeMountsAnnotationKey = "sidecar.wharfie.io/proxyVolumeMounts"
+	// injectedVolumesAnnotationKey records the names of the extra
+	// volumes added by injection, so that they can be removed.
+	injectedVolumesAnnotationKey = "sidecar.wharfie.io/injectedVolumes"
+)
+
+// addProxyVolumes adds the extra volumes of the parameters and the
+// annotations to the pod template, and their mounts to the proxy.
+func addProxyVolumes(p *Params, t *v1.PodTemplateSpec, c *v1.Container) error {
+	volumes := p.ProxyVolumes
+	if value, ok := t.Annotations[proxyVolumesAnnotationKey]; ok {
+		var extra []v1.Volume
+		if err := json.Unmarshal([]byte(value), &extra); err != nil {
+			return fmt.Errorf("annotation %s must be a JSON list of volumes: %v", proxyVolumesAnnotationKey, err)
+		}
+		volumes = append(append([]v1.Volume(nil), volumes...), extra...)
+	}
+	mounts := p.ProxyVolumeMounts
+	if value, ok := t.Annotations[proxyVolumeMountsAnnotationKey]; ok {
+		var extra []v1.VolumeMount
+		if err := json.Unmarshal([]byte(value), &extra); err != nil {
+			return fmt.Errorf("annotation %s must be a JSON list of volume mounts: %v", proxyVolumeMountsAnnotationKey, err)
+		}
+		mounts = append(append([]v1.VolumeMount(nil), mounts...), extra...)
+	}
+
+	names := make(map[string]bool)
+	for _, v := range t.Spec.Volumes {
+		names[v.Name] = true
+	}
+	var added []string
+	for _, v := range volumes {
+		if v.Name == "" {
+			return fmt.Errorf("volume of the proxy is missing a name: %+v", v)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("volume %s of the proxy conflicts with a volume of the pod", v.Name)
+		}
+		names[v.Name] = true
+		added = append(added, v.Name)
+	}
+	for _, m := range mounts {
+		if !names[m.Name] {
+			return fmt.Errorf("volume mount %s of the proxy does not reference a volume of the pod", m.Name)
+		}
+		for _, existing := range c.VolumeMounts {
+			if existing.MountPath == m.MountPath {
+				return fmt.Errorf("volume mount %s of the proxy conflicts with %s at %s", m.Name, existing.Name, m.MountPath)
+			}
+		}
+	}
+
+	t.Spec.Volumes = append(t.Spec.Volumes, volumes...)
+	c.VolumeMounts = append(c.VolumeMounts, mounts...)
+	if len(added) > 0 {
+		t.Annotations[injectedVolumesAnnotationKey] = strings.Join(added, ",")
+	}
+	return nil
+}
+
+// injectedVolumes returns the names of the extra volumes added by
+// injection.
+func injectedVolumes(t *v1.PodTemplateSpec) map[string]bool {
+	names := make(map[string]bool)
+	if value := t.Annotations[injectedVolumesAnnotationKey]; value != "" {
+		for _, name := range strings.Split(value, ",") {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestAddProxyVolumes(t *testing.T) {
+	bundle := v1.Volume{Name: "ca-bundle"}
+	p := &Params{
+		ProxyVolumes:      []v1.Volume{bundle},
+		ProxyVolumeMounts: []v1.VolumeMount{{Name: "ca-bundle", MountPath: "/etc/ssl/corporate"}},
+	}
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		volumes     []v1.Volume
+		wantVolumes int
+		wantMounts  int
+		wantErr     bool
+	}{
+		{name: "parameters", wantVolumes: 1, wantMounts: 2},
+		{
+			name:        "annotations",
+			annotations: map[string]string{proxyVolumeMountsAnnotationKey: `[{"name":"sockets","mountPath":"/var/run/app"}]`},
+			volumes:     []v1.Volume{{Name: "sockets"}},
+			wantVolumes: 2,
+			wantMounts:  3,
+		},
+		{name: "conflicting volume", volumes: []v1.Volume{bundle}, wantErr: true},
+		{
+			name:        "missing volume",
+			annotations: map[string]string{proxyVolumeMountsAnnotationKey: `[{"name":"sockets","mountPath":"/var/run/app"}]`},
+			wantErr:     true,
+		},
+		{
+			name:        "conflicting mount path",
+			annotations: map[string]string{proxyVolumeMountsAnnotationKey: `[{"name":"ca-bundle","mountPath":"/etc/certs"}]`},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{proxyVolumesAnnotationKey: "ca-bundle"},
+			wantErr:     true,
+		},
+	}
+	for _, c := range cases {
+		template := &v1.PodTemplateSpec{}
+		template.Annotations = map[string]string{}
+		for key, value := range c.annotations {
+			template.Annotations[key] = value
+		}
+		template.Spec.Volumes = c.volumes
+		container := v1.Container{VolumeMounts: []v1.VolumeMount{{Name: CertVolumeName, MountPath: "/etc/certs"}}}
+		err := addProxyVolumes(p, template, &container)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: addProxyVolumes() => got error %v, want error %t", c.name, err, c.wantErr)
+			continue
+		}
+		if c.wantErr {
+			continue
+		}
+		if len(template.Spec.Volumes) != c.wantVolumes || len(container.VolumeMounts) != c.wantMounts {
+			t.Errorf("%s: addProxyVolumes() => got volumes %v and mounts %v", c.name, template.Spec.Volumes, container.VolumeMounts)
+		}
+		if got := injectedVolumes(template); len(got) != 1 || !got["ca-bundle"] {
+			t.Errorf("%s: addProxyVolumes() => got injected volumes %v, want ca-bundle", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leader elects a single active replica of a controller. The
+// lock is a lease recorded in an annotation of a ConfigMap, as in the
+// leader election of kubernetes components, since the client predates
+// the coordination Lease API.
+package leader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Annotation holds the lease record on the lock ConfigMap.
+const Annotation = "control-plane.alpha.kubernetes.io/leader"
+
+// Defaults of the lease timing, as in kubernetes components.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// ErrLeaseLost is returned by Run when the leader fails to renew its
+// lease in time. Another replica may already lead, so the caller
+// should exit rather than keep acting.
+var ErrLeaseLost = errors.New("leader lease lost")
+
+// Record is the lease record stored in the annotation.
+type Record struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// Config describes the lock and the timing of the lease.
+type Config struct {
+	Client kubernetes.Interface
+	// Namespace and Name of the lock ConfigMap, created if missing.
+	Namespace string
+	Name      string
+	// Identity of this replica, unique among the candidates, e.g. the
+	// pod name.
+	Identity string
+	// LeaseDuration is how long standby replicas wait after the last
+	// observed renewal before taking over.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing before it
+	// gives up leadership; it must be shorter than LeaseDuration.
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between acquire and renew attempts.
+	RetryPeriod time.Duration
+}
+
+// Elector runs a candidate for leadership.
+type Elector struct {
+	config Config
+	now    func() time.Time
+	// observedRecord and observedTime track the last change of the
+	// record seen by this replica. Expiry is measured on the local
+	// clock to be immune to skew between replicas.
+	observedRecord string
+	observedTime   time.Time
+}
+
+// NewElector validates the configuration of a candidate.
+func NewElector(config Config) (*Elector, error) {
+	if config.Name == "" || config.Identity == "" {
+		return nil, errors.New("leader election requires a lock name and an identity")
+	}
+	if config.LeaseDuration <= config.RenewDeadline {
+		return nil, fmt.Errorf("lease duration %v must be longer than the renew deadline %v",
+			config.LeaseDuration, config.RenewDeadline)
+	}
+	if config.RenewDeadline <= config.RetryPeriod {
+		return nil, fmt.Errorf("renew deadline %v must be longer than the retry period %v",
+			config.RenewDeadline, config.RetryPeriod)
+	}
+	return &Elector{config: config, now: time.Now}, nil
+}
+
+// Run blocks until this replica acquires the lease, then runs lead
+// while renewing it. The stop channel passed to lead is closed when
+// leadership ends. Run returns nil after stop is closed, releasing a
+// held lease so that a standby replica takes over without waiting for
+// it to expire, or ErrLeaseLost.
+func (e *Elector) Run(stop <-chan struct{}, lead func(stop <-chan struct{})) error {
+	if !e.acquire(stop) {
+		return nil
+	}
+	glog.Infof("Acquired leader lease %s/%s as %s", e.config.Namespace, e.config.Name, e.config.Identity)
+	leading := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		lead(leading)
+		close(done)
+	}()
+	err := e.renew(stop)
+	close(leading)
+	<-done
+	if err == nil {
+		e.release()
+	}
+	return err
+}
+
+// acquire retries until the lease is acquired or stop is closed.
+func (e *Elector) acquire(stop <-chan struct{}) bool {
+	ticker := time.NewTicker(e.config.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		if e.tryAcquireOrRenew() {
+			return true
+		}
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew keeps the lease until stop is closed, or fails when the lease
+// could not be renewed within the renew deadline.
+func (e *Elector) renew(stop <-chan struct{}) error {
+	ticker := time.NewTicker(e.config.RetryPeriod)
+	defer ticker.Stop()
+	renewed := e.now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		if e.tryAcquireOrRenew() {
+			renewed = e.now()
+		} else if e.now().Sub(renewed) > e.config.RenewDeadline {
+			glog.Errorf("Failed to renew leader lease %s/%s within %v", e.config.Namespace, e.config.Name, e.config.RenewDeadline)
+			return ErrLeaseLost
+		}
+	}
+}
+
+// tryAcquireOrRenew takes or renews the lease if it is free, expired,
+// or already held by this replica.
+func (e *Elector) tryAcquireOrRenew() bool {
+	now := e.now()
+	record := Record{
+		HolderIdentity:       e.config.Identity,
+		LeaseDurationSeconds: int(e.config.LeaseDuration / time.Second),
+		AcquireTime:          metav1.NewTime(now),
+		RenewTime:            metav1.NewTime(now),
+	}
+	configMaps := e.config.Client.CoreV1().ConfigMaps(e.config.Namespace)
+	lock, err := configMaps.Get(e.config.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lock = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: e.config.Name, Namespace: e.config.Namespace}}
+		if err = setRecord(lock, record); err != nil {
+			return false
+		}
+		if _, err = configMaps.Create(lock); err != nil {
+			glog.V(2).Infof("Cannot create leader lock %s/%s: %v", e.config.Namespace, e.config.Name, err)
+			return false
+		}
+		e.observe(lock.Annotations[Annotation], now)
+		return true
+	} else if err != nil {
+		glog.Warningf("Cannot get leader lock %s/%s: %v", e.config.Namespace, e.config.Name, err)
+		return false
+	}
+
+	raw := lock.Annotations[Annotation]
+	var current Record
+	if raw != "" {
+		if err = json.Unmarshal([]byte(raw), &current); err != nil {
+			glog.Warningf("Invalid leader record on %s/%s: %v", e.config.Namespace, e.config.Name, err)
+		}
+	}
+	if raw != e.observedRecord {
+		e.observe(raw, now)
+	}
+	if current.HolderIdentity != "" && current.HolderIdentity != e.config.Identity &&
+		e.observedTime.Add(e.config.LeaseDuration).After(now) {
+		return false
+	}
+
+	if current.HolderIdentity == e.config.Identity {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	if err = setRecord(lock, record); err != nil {
+		return false
+	}
+	// The update carries the resource version of the lock, so that of
+	// two replicas racing for an expired lease only one succeeds.
+	if _, err = configMaps.Update(lock); err != nil {
+		glog.V(2).Infof("Cannot update leader lock %s/%s: %v", e.config.Namespace, e.config.Name, err)
+		return false
+	}
+	e.observe(lock.Annotations[Annotation], now)
+	return true
+}
+
+// release gives up a held lease by clearing the holder.
+func (e *Elector) release() {
+	configMaps := e.config.Client.CoreV1().ConfigMaps(e.config.Namespace)
+	lock, err := configMaps.Get(e.config.Name, metav1.GetOptions{})
+	if err != nil || lock.Annotations[Annotation] != e.observedRecord {
+		return
+	}
+	var record Record
+	if err = json.Unmarshal([]byte(e.observedRecord), &record); err != nil || record.HolderIdentity != e.config.Identity {
+		return
+	}
+	record.HolderIdentity = ""
+	if err = setRecord(lock, record); err != nil {
+		return
+	}
+	if _, err = configMaps.Update(lock); err != nil {
+		glog.Warningf("Cannot release leader lock %s/%s: %v", e.config.Namespace, e.config.Name, err)
+	}
+}
+
+func (e *Elector) observe(raw string, now time.Time) {
+	e.observedRecord = raw
+	e.observedTime = now
+}
+
+func setRecord(lock *v1.ConfigMap, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if lock.Annotations == nil {
+		lock.Annotations = make(map[string]string)
+	}
+	lock.Annotations[Annotation] = string(data)
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestElector(t *testing.T, config Config, c *clock) *Elector {
+	config.Namespace, config.Name = "istio-system", "wharfie-initializer"
+	config.LeaseDuration, config.RenewDeadline, config.RetryPeriod = 15*time.Second, 10*time.Second, time.Millisecond
+	e, err := NewElector(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = c.now
+	return e
+}
+
+func holder(t *testing.T, e *Elector) Record {
+	lock, err := e.config.Client.CoreV1().ConfigMaps("istio-system").Get("wharfie-initializer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record Record
+	if err = json.Unmarshal([]byte(lock.Annotations[Annotation]), &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestTryAcquireOrRenew(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := &clock{t: time.Unix(1500000000, 0)}
+	a := newTestElector(t, Config{Client: client, Identity: "a"}, c)
+	b := newTestElector(t, Config{Client: client, Identity: "b"}, c)
+
+	if !a.tryAcquireOrRenew() {
+		t.Fatal("a did not acquire a free lease")
+	}
+	if b.tryAcquireOrRenew() {
+		t.Fatal("b acquired the lease held by a")
+	}
+	c.t = c.t.Add(10 * time.Second)
+	if !a.tryAcquireOrRenew() {
+		t.Fatal("a did not renew its lease")
+	}
+	// b observed the renewal and waits for a full lease from then.
+	c.t = c.t.Add(10 * time.Second)
+	if b.tryAcquireOrRenew() {
+		t.Fatal("b acquired the lease before it expired")
+	}
+	c.t = c.t.Add(16 * time.Second)
+	if !b.tryAcquireOrRenew() {
+		t.Fatal("b did not acquire the expired lease")
+	}
+	record := holder(t, b)
+	if record.HolderIdentity != "b" || record.LeaderTransitions != 1 || record.LeaseDurationSeconds != 15 {
+		t.Errorf("lease record => got %+v", record)
+	}
+	if a.tryAcquireOrRenew() {
+		t.Error("a renewed the lease taken over by b")
+	}
+}
+
+func TestRun(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := &clock{t: time.Unix(1500000000, 0)}
+	a := newTestElector(t, Config{Client: client, Identity: "a"}, c)
+
+	stop := make(chan struct{})
+	led := make(chan struct{})
+	errs := make(chan error)
+	go func() {
+		errs <- a.Run(stop, func(leading <-chan struct{}) {
+			close(led)
+			<-leading
+		})
+	}()
+	<-led
+	close(stop)
+	if err := <-errs; err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+	if record := holder(t, a); record.HolderIdentity != "" {
+		t.Errorf("Run() => got holder %q after stopping, want the lease released", record.HolderIdentity)
+	}
+
+	// A standby replica takes over a released lease immediately.
+	b := newTestElector(t, Config{Client: client, Identity: "b"}, c)
+	if !b.tryAcquireOrRenew() {
+		t.Error("b did not acquire the released lease")
+	}
+}
+
+func TestNewElector(t *testing.T) {
+	cases := []Config{
+		{Name: "lock"},
+		{Name: "lock", Identity: "a", LeaseDuration: time.Second, RenewDeadline: time.Second, RetryPeriod: time.Millisecond},
+		{Name: "lock", Identity: "a", LeaseDuration: 2 * time.Second, RenewDeadline: time.Second, RetryPeriod: time.Second},
+	}
+	for _, config := range cases {
+		if _, err := NewElector(config); err == nil {
+			t.Errorf("NewElector(%+v) succeeded", config)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mesh reads the mesh configuration from a kubernetes
+// ConfigMap and caches it for long-running injectors.
+package mesh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/jsonpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// ConfigMapKey is the key of the mesh configuration in the ConfigMap.
+const ConfigMapKey = "mesh"
+
+// Parse returns the mesh configuration in YAML applied over the
+// defaults.
+func Parse(text string) (*proxyconfig.ProxyMeshConfig, error) {
+	data, err := yaml.YAMLToJSON([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	mesh := proxy.DefaultMeshConfig()
+	if err = jsonpb.UnmarshalString(string(data), &mesh); err != nil {
+		return nil, err
+	}
+	return &mesh, nil
+}
+
+// Load reads the mesh configuration from the ConfigMap.
+func Load(client kubernetes.Interface, namespace, name string) (*proxyconfig.ProxyMeshConfig, error) {
+	config, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read mesh config map %s in namespace %s: %v", name, namespace, err)
+	}
+	text, ok := config.Data[ConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("mesh config map %s in namespace %s has no key %q", name, namespace, ConfigMapKey)
+	}
+	mesh, err := Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mesh config map %s in namespace %s: %v", name, namespace, err)
+	}
+	return mesh, nil
+}
+
+// Cache serves the mesh configuration of a ConfigMap without reading
+// it for every injection. The cached configuration is dropped when it
+// is older than the TTL, if positive, or when the ConfigMap changes
+// while the cache is running.
+type Cache struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	mesh    *proxyconfig.ProxyMeshConfig
+	fetched time.Time
+}
+
+// NewCache creates a cache of the mesh configuration in the ConfigMap.
+func NewCache(client kubernetes.Interface, namespace, name string, ttl time.Duration) *Cache {
+	return &Cache{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+// Mesh implements inject.MeshSource.
+func (c *Cache) Mesh() (*proxyconfig.ProxyMeshConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mesh != nil && (c.ttl <= 0 || c.now().Sub(c.fetched) < c.ttl) {
+		return c.mesh, nil
+	}
+	mesh, err := Load(c.client, c.namespace, c.name)
+	if err != nil {
+		return nil, err
+	}
+	c.mesh, c.fetched = mesh, c.now()
+	return mesh, nil
+}
+
+// Invalidate drops the cached configuration.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.mesh = nil
+	c.mu.Unlock()
+}
+
+// Run watches the ConfigMap and invalidates the cache on every change
+// until the stop channel is closed.
+func (c *Cache) Run(stop <-chan struct{}) {
+	watchlist := cache.NewListWatchFromClient(c.client.CoreV1().RESTClient(), "configmaps", c.namespace,
+		fields.OneTermEqualSelector("metadata.name", c.name))
+	invalidate := func(interface{}) {
+		glog.V(2).Infof("Mesh config map %s in namespace %s changed", c.name, c.namespace)
+		c.Invalidate()
+	}
+	_, informer := cache.NewInformer(watchlist, &v1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc:    invalidate,
+		UpdateFunc: func(_, obj interface{}) { invalidate(obj) },
+		DeleteFunc: invalidate,
+	})
+	informer.Run(stop)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+func newConfigMap(data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "istio-system"},
+		Data:       data,
+	}
+}
+
+func TestParse(t *testing.T) {
+	mesh, err := Parse("authPolicy: MUTUAL_TLS\ndiscoveryAddress: pilot.staging:8080\n")
+	if err != nil {
+		t.Fatalf("Parse() returned an error: %v", err)
+	}
+	if mesh.AuthPolicy != proxyconfig.ProxyMeshConfig_MUTUAL_TLS || mesh.DiscoveryAddress != "pilot.staging:8080" {
+		t.Errorf("Parse() => got %+v, want the overrides applied", mesh)
+	}
+	// Fields that are not set keep their defaults.
+	if mesh.ProxyListenPort != 15001 {
+		t.Errorf("Parse() => got proxy listen port %d, want the default", mesh.ProxyListenPort)
+	}
+	if _, err = Parse("proxyListenPort: http"); err == nil {
+		t.Error("Parse() succeeded for an invalid port")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    map[string]string
+		missing bool
+		wantErr bool
+	}{
+		{name: "valid", data: map[string]string{ConfigMapKey: "discoveryAddress: pilot:8080"}},
+		{name: "missing", missing: true, wantErr: true},
+		{name: "no key", data: map[string]string{"other": ""}, wantErr: true},
+		{name: "invalid", data: map[string]string{ConfigMapKey: "proxyListenPort: http"}, wantErr: true},
+	}
+	for _, c := range cases {
+		client := fake.NewSimpleClientset()
+		if !c.missing {
+			client = fake.NewSimpleClientset(newConfigMap(c.data))
+		}
+		mesh, err := Load(client, "istio-system", "istio")
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: Load() => got error %v, want error %t", c.name, err, c.wantErr)
+		}
+		if err == nil && mesh.DiscoveryAddress != "pilot:8080" {
+			t.Errorf("%s: Load() => got discovery address %q", c.name, mesh.DiscoveryAddress)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	client := fake.NewSimpleClientset(newConfigMap(map[string]string{ConfigMapKey: "discoveryAddress: pilot:8080"}))
+	now := time.Unix(0, 0)
+	c := NewCache(client, "istio-system", "istio", time.Minute)
+	c.now = func() time.Time { return now }
+
+	reads := func() int { return len(client.Actions()) }
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		configMap string
+		want      string
+		wantReads int
+	}{
+		{name: "first read", want: "pilot:8080", wantReads: 1},
+		{name: "cached", advance: 30 * time.Second, want: "pilot:8080", wantReads: 1},
+		{name: "expired", advance: 31 * time.Second, configMap: "discoveryAddress: pilot:9090", want: "pilot:9090", wantReads: 3},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.configMap != "" {
+			if _, err := client.CoreV1().ConfigMaps("istio-system").Update(
+				newConfigMap(map[string]string{ConfigMapKey: step.configMap})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mesh, err := c.Mesh()
+		if err != nil {
+			t.Fatalf("%s: Mesh() returned an error: %v", step.name, err)
+		}
+		if mesh.DiscoveryAddress != step.want || reads() != step.wantReads {
+			t.Errorf("%s: Mesh() => got %q after %d reads, want %q after %d",
+				step.name, mesh.DiscoveryAddress, reads(), step.want, step.wantReads)
+		}
+	}
+
+	c.Invalidate()
+	if _, err := c.Mesh(); err != nil {
+		t.Fatal(err)
+	}
+	if reads() != 4 {
+		t.Errorf("Mesh() after Invalidate() => got %d reads, want 4", reads())
+	}
+}
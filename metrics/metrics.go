@@ -0,0 +1,288 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics counts the injection decisions of the webhook and the
+// controllers, and exposes them in the Prometheus text format.
+//
+// The Prometheus client library is not part of the vendored
+// dependencies, so the package implements the few counters and
+// histograms it needs.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPath is the path of the metrics endpoint.
+const DefaultPath = "/metrics"
+
+// Sources of the injection decisions.
+const (
+	SourceWebhook     = "webhook"
+	SourceInitializer = "initializer"
+	SourceRetrofit    = "retrofit"
+)
+
+// Reasons a workload or pod is skipped.
+const (
+	ReasonInjected  = "already-injected"
+	ReasonOptOut    = "opt-out"
+	ReasonImmutable = "immutable"
+	// ReasonPolicy is the injection policy or the inject annotation
+	// of the workload.
+	ReasonPolicy = "policy"
+	// ReasonNamespace is the namespace policy.
+	ReasonNamespace = "namespace"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the patch latency
+// histogram.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+var (
+	// Injected counts the workloads and pods injected with the proxy.
+	Injected = NewCounterVec("wharfie_injections_total",
+		"Workloads and pods injected with the proxy.", "source", "kind", "namespace")
+	// Skipped counts the workloads and pods left without the proxy.
+	Skipped = NewCounterVec("wharfie_injections_skipped_total",
+		"Workloads and pods not injected, by reason.", "source", "kind", "namespace", "reason")
+	// Errors counts the workloads and pods that failed to be injected.
+	Errors = NewCounterVec("wharfie_injection_errors_total",
+		"Workloads and pods that failed to be injected.", "source", "kind", "namespace")
+	// PatchLatency observes the time to inject and patch a workload or
+	// pod.
+	PatchLatency = NewHistogramVec("wharfie_patch_duration_seconds",
+		"Time to inject and patch a workload or pod.", DefaultBuckets, "source", "kind")
+)
+
+// SkipReason returns the reason of a workload skipped for its sidecar
+// annotation status.
+func SkipReason(status string) string {
+	if status == "injected" {
+		return ReasonInjected
+	}
+	return ReasonOptOut
+}
+
+// ObserveSince observes the latency of a patch started at start.
+func ObserveSince(source, kind string, start time.Time) {
+	PatchLatency.Observe(time.Since(start).Seconds(), source, kind)
+}
+
+// collector writes its series in the text format.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// registry holds the collectors exposed by Handler.
+var registry struct {
+	sync.Mutex
+	collectors []collector
+}
+
+func register(c collector) {
+	registry.Lock()
+	registry.collectors = append(registry.collectors, c)
+	registry.Unlock()
+}
+
+// series are the values of a metric by label values.
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// get returns the value of the label values, created with create if
+// missing. The caller holds the lock.
+func (s *series) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", s.name, s.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	value, ok := s.values[key]
+	if !ok {
+		value = create()
+		s.values[key] = value
+	}
+	return value
+}
+
+// keys returns the label values of the series in order.
+func (s *series) keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *series) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+}
+
+// labelPairs formats the label values of a key with extra pairs.
+func (s *series) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	for i, value := range strings.Split(key, "\xff") {
+		if i < len(s.labels) {
+			pairs = append(pairs, s.labels[i]+"="+quote(value))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// quote escapes a label value.
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	series
+}
+
+// NewCounterVec creates and registers a counter with the label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{series{name: name, help: help, kind: "counter", labels: labels, values: make(map[string]interface{})}}
+	register(c)
+	return c
+}
+
+// Inc increments the counter of the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	*c.get(values, func() interface{} { return new(uint64) }).(*uint64)++
+	c.mu.Unlock()
+}
+
+// Value returns the counter of the label values.
+func (c *CounterVec) Value(values ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.values[strings.Join(values, "\xff")]; ok {
+		return *value.(*uint64)
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range c.keys() {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, c.labelPairs(key), *c.values[key].(*uint64))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	series
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates and registers a histogram with the bucket
+// upper bounds, in increasing order, and the label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		series:  series{name: name, help: help, kind: "histogram", labels: labels, values: make(map[string]interface{})},
+		buckets: buckets,
+	}
+	register(h)
+	return h
+}
+
+// Observe adds a value to the histogram of the label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o := h.get(values, func() interface{} { return &histogram{counts: make([]uint64, len(h.buckets))} }).(*histogram)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			o.counts[i]++
+		}
+	}
+	o.sum += value
+	o.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range h.keys() {
+		o := h.values[key].(*histogram)
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), o.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), o.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(o.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), o.count)
+	}
+}
+
+// Write writes the registered metrics in the Prometheus text format.
+func Write(out io.Writer) error {
+	registry.Lock()
+	collectors := registry.collectors
+	registry.Unlock()
+	w := bufio.NewWriter(out)
+	for _, c := range collectors {
+		c.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler returns a handler that serves the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = Write(w)
+	})
+}
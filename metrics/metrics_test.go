@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := &CounterVec{series{name: "test_total", help: "Test counter.", kind: "counter",
+		labels: []string{"kind", "reason"}, values: make(map[string]interface{})}}
+	c.Inc("Pod", "opt-out")
+	c.Inc("Deployment", `say "hi"`)
+	c.Inc("Pod", "opt-out")
+	if got := c.Value("Pod", "opt-out"); got != 2 {
+		t.Errorf("Value() => got %d, want 2", got)
+	}
+	if got := c.Value("Job", "opt-out"); got != 0 {
+		t.Errorf("Value() of a missing series => got %d, want 0", got)
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	c.write(w)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{kind="Deployment",reason="say \"hi\""} 1
+test_total{kind="Pod",reason="opt-out"} 2
+`
+	if buf.String() != want {
+		t.Errorf("write() => got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := &HistogramVec{
+		series: series{name: "test_seconds", help: "Test histogram.", kind: "histogram",
+			labels: []string{"kind"}, values: make(map[string]interface{})},
+		buckets: []float64{0.1, 1},
+	}
+	h.Observe(0.05, "Pod")
+	h.Observe(0.5, "Pod")
+	h.Observe(2, "Pod")
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	h.write(w)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{kind="Pod",le="0.1"} 1
+test_seconds_bucket{kind="Pod",le="1"} 2
+test_seconds_bucket{kind="Pod",le="+Inf"} 3
+test_seconds_sum{kind="Pod"} 2.55
+test_seconds_count{kind="Pod"} 3
+`
+	if buf.String() != want {
+		t.Errorf("write() => got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Injected.Inc(SourceWebhook, "Pod", "default")
+	Skipped.Inc(SourceWebhook, "Pod", "default", SkipReason("ignore"))
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DefaultPath, nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE wharfie_injections_total counter\n",
+		`wharfie_injections_total{source="webhook",kind="Pod",namespace="default"} 1`,
+		`wharfie_injections_skipped_total{source="webhook",kind="Pod",namespace="default",reason="opt-out"} 1`,
+		"# TYPE wharfie_patch_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Handler() => missing %q in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nodeagent generates the node agent DaemonSet and RBAC that
+// proxies injected in SDS identity mode fetch their certificates from.
+package nodeagent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// DefaultName names the DaemonSet, its service account, and its RBAC
+// resources.
+const DefaultName = "istio-nodeagent"
+
+// Options describes the node agent deployment.
+type Options struct {
+	Name      string
+	Namespace string
+	Image     string
+	// SDSPath is the node directory holding the agent socket and must
+	// match the SDSPath used for injection.
+	SDSPath string
+}
+
+// NodeAgentImageName returns the fully qualified image name of the
+// node agent given a docker hub and tag.
+func NodeAgentImageName(hub, tag string) string { return hub + "/node-agent:" + tag }
+
+// DefaultOptions returns the options for a node agent in the namespace
+// serving the default SDS directory.
+func DefaultOptions(namespace, hub, tag string) *Options {
+	return &Options{
+		Name:      DefaultName,
+		Namespace: namespace,
+		Image:     NodeAgentImageName(hub, tag),
+		SDSPath:   inject.DefaultSDSPath,
+	}
+}
+
+// Validate checks that the options describe deployable resources.
+func (o *Options) Validate() error {
+	var errs error
+	if o.Name == "" || o.Namespace == "" {
+		errs = multierror.Append(errs, errors.New("node agent name and namespace are required"))
+	}
+	if o.Image == "" {
+		errs = multierror.Append(errs, errors.New("node agent image is required"))
+	}
+	if o.SDSPath == "" {
+		errs = multierror.Append(errs, errors.New("SDS path is required"))
+	}
+	return errs
+}
+
+func (o *Options) resources() []interface{} {
+	labels := map[string]string{"app": o.Name}
+	serviceAccount := &v1.ServiceAccount{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace, Labels: labels},
+	}
+	// The agent signs certificates for the service accounts of the
+	// pods on its node.
+	role := &rbac.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1beta1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name, Labels: labels},
+		Rules: []rbac.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"pods", "serviceaccounts"},
+			Verbs:     []string{"get", "list", "watch"},
+		}, {
+			APIGroups: []string{"certificates.k8s.io"},
+			Resources: []string{"certificatesigningrequests"},
+			Verbs:     []string{"create", "get", "watch"},
+		}},
+	}
+	binding := &rbac.ClusterRoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1beta1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name, Labels: labels},
+		RoleRef: rbac.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     o.Name,
+		},
+		Subjects: []rbac.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      o.Name,
+			Namespace: o.Namespace,
+		}},
+	}
+	daemonSet := &v1beta1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "extensions/v1beta1", Kind: "DaemonSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace, Labels: labels},
+		Spec: v1beta1.DaemonSetSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+					// The agent must not be injected with a proxy itself.
+					Annotations: map[string]string{"alpha.istio.io/sidecar": "ignore"},
+				},
+				Spec: v1.PodSpec{
+					ServiceAccountName: o.Name,
+					Containers: []v1.Container{{
+						Name:            "nodeagent",
+						Image:           o.Image,
+						Args:            []string{"--sdsUdsPath", inject.SDSSocket(o.SDSPath)},
+						ImagePullPolicy: v1.PullIfNotPresent,
+						VolumeMounts: []v1.VolumeMount{{
+							Name:      "sds-uds-path",
+							MountPath: o.SDSPath,
+						}},
+					}},
+					Volumes: []v1.Volume{{
+						Name: "sds-uds-path",
+						VolumeSource: v1.VolumeSource{
+							HostPath: &v1.HostPathVolumeSource{Path: o.SDSPath},
+						},
+					}},
+				},
+			},
+		},
+	}
+	return []interface{}{serviceAccount, role, binding, daemonSet}
+}
+
+// WriteManifests writes the service account, cluster role and binding,
+// and DaemonSet of the node agent as a YAML stream.
+func WriteManifests(o *Options, out io.Writer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	for _, resource := range o.resources() {
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(out, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
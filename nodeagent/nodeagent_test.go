@@ -0,0 +1,43 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeagent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteManifests(t *testing.T) {
+	var got bytes.Buffer
+	if err := WriteManifests(DefaultOptions("istio-system", "docker.io/istio", "unittest"), &got); err != nil {
+		t.Fatalf("WriteManifests() returned an error: %v", err)
+	}
+	util.CompareContent(got.Bytes(), "testdata/nodeagent.yaml", t)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	err := (&Options{}).Validate()
+	if err == nil {
+		t.Fatal("Validate() succeeded for empty options")
+	}
+	for _, want := range []string{"name and namespace", "image", "SDS path"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error %q does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify reports injection failures to an outbound webhook so
+// that platform teams learn about them before application teams do.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Formats of the webhook payload.
+const (
+	// FormatGeneric posts the Failure as JSON.
+	FormatGeneric = "generic"
+	// FormatSlack posts a Slack incoming webhook message.
+	FormatSlack = "slack"
+)
+
+// DefaultTimeout bounds every call to the webhook.
+const DefaultTimeout = 10 * time.Second
+
+// DefaultQueueSize is the number of failures an asynchronous notifier
+// holds while the webhook is slow.
+const DefaultQueueSize = 100
+
+// Failure identifies a resource that could not be injected.
+type Failure struct {
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Error     string `json:"error"`
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("injection failed for %s %s/%s: %s", f.Resource, f.Namespace, f.Name, f.Error)
+}
+
+// Notifier reports injection failures.
+type Notifier interface {
+	Notify(f Failure) error
+}
+
+// Webhook posts failures to an HTTP endpoint.
+type Webhook struct {
+	URL    string
+	Format string
+	Client *http.Client
+}
+
+// NewWebhook returns a notifier that posts failures to the URL in the
+// given format.
+func NewWebhook(url, format string) (*Webhook, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook URL is required")
+	}
+	switch format {
+	case FormatGeneric, FormatSlack:
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q, want %s or %s", format, FormatGeneric, FormatSlack)
+	}
+	return &Webhook{
+		URL:    url,
+		Format: format,
+		Client: &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(f Failure) error {
+	var payload interface{} = f
+	if w.Format == FormatSlack {
+		payload = map[string]string{"text": f.String()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// queue reports failures to a notifier in the background.
+type queue struct {
+	failures chan Failure
+}
+
+// Async returns a notifier that reports failures to n in the
+// background, so that a slow webhook does not hold up the callers, e.g.
+// the event handlers of a controller. Up to size failures are queued,
+// later ones are dropped with an error. Errors of n are logged.
+func Async(n Notifier, size int) Notifier {
+	q := &queue{failures: make(chan Failure, size)}
+	go func() {
+		for f := range q.failures {
+			if err := n.Notify(f); err != nil {
+				glog.Warningf("Failed to notify %v: %v", f, err)
+			}
+		}
+	}()
+	return q
+}
+
+// Notify implements Notifier.
+func (q *queue) Notify(f Failure) error {
+	select {
+	case q.failures <- f:
+		return nil
+	default:
+		return fmt.Errorf("dropped, %d failures are waiting to be notified", cap(q.failures))
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = string(body)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	failure := Failure{Resource: "deployments", Namespace: "web", Name: "hello", Error: "bad annotation"}
+	cases := []struct {
+		format  string
+		path    string
+		want    string
+		wantErr bool
+	}{{
+		format: FormatGeneric,
+		want:   `{"resource":"deployments","namespace":"web","name":"hello","error":"bad annotation"}`,
+	}, {
+		format: FormatSlack,
+		want:   `{"text":"injection failed for deployments web/hello: bad annotation"}`,
+	}, {
+		format:  FormatGeneric,
+		path:    "/broken",
+		want:    `{"resource":"deployments","namespace":"web","name":"hello","error":"bad annotation"}`,
+		wantErr: true,
+	}}
+	for _, c := range cases {
+		w, err := NewWebhook(server.URL+c.path, c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Notify(failure)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Notify(%s%s) => got error %v, want error %t", c.format, c.path, err, c.wantErr)
+		}
+		if got != c.want {
+			t.Errorf("Notify(%s%s) => posted %s, want %s", c.format, c.path, got, c.want)
+		}
+	}
+}
+
+func TestNewWebhook(t *testing.T) {
+	if _, err := NewWebhook("", FormatGeneric); err == nil {
+		t.Error("NewWebhook() succeeded without a URL")
+	}
+	if _, err := NewWebhook("http://example.com", "email"); err == nil {
+		t.Error("NewWebhook() succeeded with an unknown format")
+	}
+}
+
+// blocked notifies a channel once released.
+type blocked struct {
+	release  chan struct{}
+	notified chan Failure
+}
+
+func (b *blocked) Notify(f Failure) error {
+	<-b.release
+	b.notified <- f
+	return nil
+}
+
+func TestAsync(t *testing.T) {
+	b := &blocked{release: make(chan struct{}), notified: make(chan Failure, 2)}
+	n := Async(b, 1)
+	failure := Failure{Resource: "deployments", Namespace: "web", Name: "hello"}
+	// At most one failure is held by the blocked notifier and one is
+	// queued, later ones are dropped.
+	queued := 0
+	for ; queued <= 2; queued++ {
+		if err := n.Notify(failure); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if queued == 0 || queued > 2 {
+		t.Fatalf("Notify() => queued %d failures, want 1 or 2 before dropping", queued)
+	}
+	close(b.release)
+	for i := 0; i < queued; i++ {
+		select {
+		case got := <-b.notified:
+			if got != failure {
+				t.Errorf("Notify() => notified %v, want %v", got, failure)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Notify() => failure not notified")
+		}
+	}
+}
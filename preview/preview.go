@@ -0,0 +1,181 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preview serves a web page that shows what injection does to
+// a pasted manifest.
+package preview
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// DefaultPath is the path the preview page is served at.
+const DefaultPath = "/preview"
+
+// maxManifestBytes bounds the size of a pasted manifest.
+const maxManifestBytes = 1 << 20
+
+// pageView is rendered by the preview page.
+type pageView struct {
+	Manifest string
+	Injected string
+	Diff     string
+	Error    string
+	Config   string
+	Report   *inject.Report
+}
+
+var page = htmltemplate.Must(htmltemplate.New("preview").Parse(
+	`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Injection preview</title>
+<style>
+textarea { width: 100%; height: 20em; font-family: monospace; }
+pre { background: #f6f8fa; padding: 8px; overflow: auto; }
+p.error, p.warning { color: #856404; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Injection preview</h1>
+<form method="post">
+<p>Paste kubernetes resources to see them with the proxy injected.</p>
+<textarea name="manifest">{{.Manifest}}</textarea>
+<p><input type="submit" value="Inject"></p>
+</form>
+{{if .Error}}<p class="error">Error: {{.Error}}</p>
+{{end}}{{with .Report}}{{range $r := .Resources}}{{if not .Injected}}<p>Skipped {{.Kind}} {{.Name}}: {{.Reason}}</p>
+{{end}}{{range .Warnings}}<p class="warning">Warning: {{$r.Kind}} {{$r.Name}}: {{.}}</p>
+{{end}}{{end}}{{end}}{{if .Diff}}<h2>Diff</h2>
+<pre>{{.Diff}}</pre>
+{{end}}{{if .Injected}}<h2>Injected</h2>
+<pre>{{.Injected}}</pre>
+{{end}}<h2>Effective configuration</h2>
+<pre>{{.Config}}</pre>
+</body>
+</html>
+`))
+
+// effectiveConfig returns the injection parameters and mesh
+// configuration as YAML.
+func effectiveConfig(p *inject.Params) (string, error) {
+	mesh := p.Mesh
+	if p.MeshSource != nil {
+		var err error
+		if mesh, err = p.MeshSource.Mesh(); err != nil {
+			return "", err
+		}
+	}
+	config := struct {
+		InitImage          string               `json:"initImage"`
+		ProxyImage         string               `json:"proxyImage"`
+		Verbosity          int                  `json:"verbosity"`
+		SidecarProxyUID    int64                `json:"sidecarProxyUID"`
+		EnableCoreDump     bool                 `json:"enableCoreDump"`
+		MeshConfigMapName  string               `json:"meshConfigMapName,omitempty"`
+		IncludeIPRanges    string               `json:"includeIPRanges,omitempty"`
+		ExcludeInterfaces  string               `json:"excludeInterfaces,omitempty"`
+		ProxyResources     interface{}          `json:"proxyResources,omitempty"`
+		CertSecretTemplate string               `json:"certSecretTemplate,omitempty"`
+		TrustDomain        string               `json:"trustDomain,omitempty"`
+		SDSPath            string               `json:"sdsPath,omitempty"`
+		Hardened           bool                 `json:"hardenedSecurityContext"`
+		Sizing             *inject.SizingPolicy `json:"sizing,omitempty"`
+		Mesh               interface{}          `json:"mesh"`
+	}{
+		InitImage:          p.InitImage,
+		ProxyImage:         p.ProxyImage,
+		Verbosity:          p.Verbosity,
+		SidecarProxyUID:    p.SidecarProxyUID,
+		EnableCoreDump:     p.EnableCoreDump,
+		MeshConfigMapName:  p.MeshConfigMapName,
+		IncludeIPRanges:    p.IncludeIPRanges,
+		ExcludeInterfaces:  p.ExcludeInterfaces,
+		ProxyResources:     p.ProxyResources,
+		CertSecretTemplate: p.CertSecretTemplate,
+		TrustDomain:        p.TrustDomain,
+		SDSPath:            p.SDSPath,
+		Hardened:           p.HardenedSecurityContext,
+		Sizing:             p.Sizing,
+		Mesh:               mesh,
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// NewHandler returns a handler that renders the preview page on GET
+// and injects the pasted manifest on POST. Nothing is sent to the
+// cluster.
+func NewHandler(p *inject.Params) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var view pageView
+		config, err := effectiveConfig(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		view.Config = config
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			r.Body = http.MaxBytesReader(w, r.Body, maxManifestBytes)
+			if err = r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			view.Manifest = r.PostForm.Get("manifest")
+			var out bytes.Buffer
+			report, err := inject.IntoResourceFileWithReport(p, strings.NewReader(view.Manifest), &out)
+			if err != nil {
+				view.Error = err.Error()
+				break
+			}
+			view.Report = report
+			view.Injected = out.String()
+			view.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(view.Manifest),
+				B:        difflib.SplitLines(view.Injected),
+				FromFile: "manifest",
+				ToFile:   "injected",
+				Context:  3,
+			})
+			if err != nil {
+				view.Error = err.Error()
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err = page.Execute(w, view); err != nil {
+			glog.Warningf("Cannot render preview page: %v", err)
+		}
+	})
+}
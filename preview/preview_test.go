@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preview
+
+import (
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/proxy"
+)
+
+const manifest = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    spec:
+      containers:
+      - name: hello
+        image: hello
+`
+
+func TestHandler(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:       inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:      inject.ProxyImageName("docker.io/istio", "unittest"),
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Mesh:            &mesh,
+	}
+	server := httptest.NewServer(NewHandler(params))
+	defer server.Close()
+
+	cases := []struct {
+		name       string
+		method     string
+		manifest   string
+		wantStatus int
+		want       []string
+	}{{
+		name:       "form",
+		method:     http.MethodGet,
+		wantStatus: http.StatusOK,
+		want:       []string{"<form", "Effective configuration", "proxyImage: docker.io/istio/proxy_debug:unittest"},
+	}, {
+		name:       "inject",
+		method:     http.MethodPost,
+		manifest:   manifest,
+		wantStatus: http.StatusOK,
+		want:       []string{"+++ injected", "+        image: docker.io/istio/proxy_debug:unittest", "Effective configuration"},
+	}, {
+		name:       "invalid manifest",
+		method:     http.MethodPost,
+		manifest:   "kind: [",
+		wantStatus: http.StatusOK,
+		want:       []string{`class="error"`},
+	}, {
+		name:       "method not allowed",
+		method:     http.MethodPut,
+		wantStatus: http.StatusMethodNotAllowed,
+	}}
+	for _, c := range cases {
+		form := url.Values{"manifest": {c.manifest}}
+		req, err := http.NewRequest(c.method, server.URL, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		body := html.UnescapeString(string(data))
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("%s: got status %d, want %d", c.name, resp.StatusCode, c.wantStatus)
+		}
+		for _, want := range c.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: page does not contain %q:\n%s", c.name, want, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retrofit injects the istio proxy into workloads that already
+// run in namespaces selected for injection, e.g. when a namespace is
+// labeled after its workloads were created.
+package retrofit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/pkg/api/v1"
+	apps "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batch "k8s.io/client-go/pkg/apis/batch/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/platform/kube/events"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/notify"
+)
+
+// DefaultNamespaceSelector selects the namespaces whose workloads are
+// injected, as the webhook configuration does.
+const DefaultNamespaceSelector = "istio-injection=enabled"
+
+// kind describes how to watch, inject, and patch one workload type.
+// Immutable kinds cannot change their pod template once created.
+type kind struct {
+	resource  string
+	client    func(client kubernetes.Interface) rest.Interface
+	prototype runtime.Object
+	template  func(obj runtime.Object) *v1.PodTemplateSpec
+	immutable bool
+}
+
+// kind returns the name of the kind, e.g. Deployment.
+func (k kind) kind() string {
+	return reflect.TypeOf(k.prototype).Elem().Name()
+}
+
+var kinds = []kind{
+	{
+		resource:  "deployments",
+		client:    func(client kubernetes.Interface) rest.Interface { return client.ExtensionsV1beta1().RESTClient() },
+		prototype: &v1beta1.Deployment{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*v1beta1.Deployment).Spec.Template
+		},
+	},
+	{
+		resource:  "statefulsets",
+		client:    func(client kubernetes.Interface) rest.Interface { return client.AppsV1beta1().RESTClient() },
+		prototype: &apps.StatefulSet{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*apps.StatefulSet).Spec.Template
+		},
+	},
+	{
+		resource:  "daemonsets",
+		client:    func(client kubernetes.Interface) rest.Interface { return client.ExtensionsV1beta1().RESTClient() },
+		prototype: &v1beta1.DaemonSet{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*v1beta1.DaemonSet).Spec.Template
+		},
+	},
+	{
+		// The pod template of a Job cannot be updated, so uninjected
+		// Jobs are only reported to be re-created.
+		resource:  "jobs",
+		client:    func(client kubernetes.Interface) rest.Interface { return client.BatchV1().RESTClient() },
+		prototype: &batch.Job{},
+		template: func(obj runtime.Object) *v1.PodTemplateSpec {
+			return &obj.(*batch.Job).Spec.Template
+		},
+		immutable: true,
+	},
+}
+
+// Controller watches the workloads of the selected namespaces and
+// injects the proxy into those created without it.
+type Controller struct {
+	client     kubernetes.Interface
+	params     *inject.Params
+	selector   labels.Selector
+	namespaces cache.Store
+	informers  []cache.Controller
+	// recorder, if set, records an event on every workload injected
+	// or skipped.
+	recorder record.EventRecorder
+	// stores hold the workloads of every kind, to retrofit the
+	// workloads of a namespace when it is selected.
+	stores map[string]cache.Store
+	// notifier, if set, is notified of every workload that cannot be
+	// injected or patched.
+	notifier notify.Notifier
+
+	// reported holds the skip or failure last reported for every
+	// workload, with its resource version, so that resyncs do not
+	// report them again.
+	mu       sync.Mutex
+	reported map[string]string
+}
+
+// NewController creates a controller that injects the workloads of
+// the namespaces matching the label selector. The event recorder is
+// optional. Injection and patch failures are reported to the notifier,
+// if not nil, which is called from the event handlers and should not
+// block, see notify.Async.
+func NewController(client kubernetes.Interface, params *inject.Params, selector labels.Selector,
+	resyncPeriod time.Duration, recorder record.EventRecorder, notifier notify.Notifier) *Controller {
+	c := &Controller{
+		client:   client,
+		params:   params,
+		selector: selector,
+		stores:   make(map[string]cache.Store),
+		recorder: recorder,
+		notifier: notifier,
+	}
+
+	namespaces := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "namespaces", "", fields.Everything())
+	var informer cache.Controller
+	c.namespaces, informer = cache.NewInformer(namespaces, &v1.Namespace{}, resyncPeriod, cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.retrofitNamespace(obj.(*v1.Namespace)) },
+		UpdateFunc: func(_, obj interface{}) { c.retrofitNamespace(obj.(*v1.Namespace)) },
+	})
+	c.informers = append(c.informers, informer)
+
+	for _, k := range kinds {
+		k := k
+		watchlist := cache.NewListWatchFromClient(k.client(client), k.resource, "", fields.Everything())
+		handle := func(obj interface{}) {
+			if err := c.retrofit(k, obj.(runtime.Object)); err != nil {
+				glog.Warningf("Failed to retrofit %s: %v", k.resource, err)
+			}
+		}
+		store, informer := cache.NewInformer(watchlist, k.prototype, resyncPeriod, cache.ResourceEventHandlerFuncs{
+			AddFunc:    handle,
+			UpdateFunc: func(_, obj interface{}) { handle(obj) },
+			DeleteFunc: func(obj interface{}) { c.forget(k, obj) },
+		})
+		c.stores[k.resource] = store
+		c.informers = append(c.informers, informer)
+	}
+	return c
+}
+
+// Run watches namespaces and workloads until the stop channel is
+// closed.
+func (c *Controller) Run(stop <-chan struct{}) {
+	for _, informer := range c.informers {
+		go informer.Run(stop)
+	}
+	<-stop
+}
+
+// selected reports whether the workloads of the namespace are
+// injected.
+func (c *Controller) selected(namespace string) bool {
+	obj, ok, err := c.namespaces.GetByKey(namespace)
+	if err != nil || !ok {
+		return false
+	}
+	return c.matches(obj.(*v1.Namespace))
+}
+
+// matches reports whether a namespace is selected by the controller
+// and by the namespace policy of the parameters.
+func (c *Controller) matches(ns *v1.Namespace) bool {
+	if !c.selector.Matches(labels.Set(ns.Labels)) {
+		return false
+	}
+	reason, err := c.params.Namespaces.Skip(ns.Name, ns.Labels)
+	if err != nil {
+		glog.Warningf("Failed to apply the namespace policy to %s: %v", ns.Name, err)
+	}
+	return err == nil && reason == ""
+}
+
+// retrofitNamespace retrofits the known workloads of a namespace, e.g.
+// when it was just labeled for injection.
+func (c *Controller) retrofitNamespace(ns *v1.Namespace) {
+	if !c.matches(ns) {
+		return
+	}
+	for _, k := range kinds {
+		store := c.stores[k.resource]
+		if store == nil {
+			continue
+		}
+		for _, obj := range store.List() {
+			accessor, err := meta.Accessor(obj)
+			if err != nil || accessor.GetNamespace() != ns.Name {
+				continue
+			}
+			if err = c.retrofit(k, obj.(runtime.Object)); err != nil {
+				glog.Warningf("Failed to retrofit %s: %v", k.resource, err)
+			}
+		}
+	}
+}
+
+// retrofit injects the proxy into a workload of a selected namespace
+// whose template does not have the sidecar annotation, and patches it,
+// which rolls out its pods with the proxy. Skips and failures are
+// reported once per resource version of the workload.
+func (c *Controller) retrofit(k kind, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if accessor.GetDeletionTimestamp() != nil || !c.selected(accessor.GetNamespace()) {
+		return nil
+	}
+	namespace := accessor.GetNamespace()
+	if status := inject.SidecarStatus(k.template(obj)); status != "" {
+		// Workloads are resynced periodically, only count those that
+		// opted out, not the ones already injected.
+		if status != "injected" && c.report(k, accessor, status) {
+			metrics.Skipped.Inc(metrics.SourceRetrofit, k.kind(), namespace, metrics.SkipReason(status))
+			c.event(obj, v1.EventTypeNormal, events.ReasonSkipped, events.SkipMessage(status))
+		}
+		return nil
+	}
+	if reason, err := inject.PolicySkip(c.params, k.template(obj)); err != nil {
+		c.fail(k, obj, accessor, err)
+		return fmt.Errorf("%s/%s: %v", namespace, accessor.GetName(), err)
+	} else if reason != "" {
+		if c.report(k, accessor, reason) {
+			metrics.Skipped.Inc(metrics.SourceRetrofit, k.kind(), namespace, metrics.ReasonPolicy)
+			c.event(obj, v1.EventTypeNormal, events.ReasonSkipped, reason)
+		}
+		return nil
+	}
+	if k.immutable {
+		if c.report(k, accessor, metrics.ReasonImmutable) {
+			metrics.Skipped.Inc(metrics.SourceRetrofit, k.kind(), namespace, metrics.ReasonImmutable)
+			c.event(obj, v1.EventTypeWarning, events.ReasonSkipped,
+				"the pod template cannot be updated, re-create the workload to inject the istio proxy")
+			glog.Warningf("%s %s/%s runs without the proxy and must be re-created to be injected",
+				k.resource, namespace, accessor.GetName())
+		}
+		return nil
+	}
+	start := time.Now()
+	injected, err := scheme.Scheme.Copy(obj)
+	if err != nil {
+		return err
+	}
+	if err = inject.IntoPodTemplateSpec(c.params, namespace, k.template(injected)); err != nil {
+		c.fail(k, obj, accessor, err)
+		return fmt.Errorf("%s/%s: %v", namespace, accessor.GetName(), err)
+	}
+	if err = c.patch(k, accessor, k.template(injected)); err != nil {
+		err = fmt.Errorf("cannot patch the injected workload: %v", err)
+		c.fail(k, obj, accessor, err)
+		return fmt.Errorf("%s/%s: %v", namespace, accessor.GetName(), err)
+	}
+	metrics.Injected.Inc(metrics.SourceRetrofit, k.kind(), namespace)
+	metrics.ObserveSince(metrics.SourceRetrofit, k.kind(), start)
+	c.event(injected, v1.EventTypeNormal, events.ReasonInjected, "injected the istio proxy into the pod template")
+	glog.Infof("Retrofitted %s %s/%s with the proxy", k.resource, namespace, accessor.GetName())
+	return nil
+}
+
+// patch patches the pod template of a workload with the injected one.
+// The workload is read as JSON rather than from the cache, and only
+// the fields changed by injection are patched, so that the fields of
+// the pod spec that the kubernetes types do not know are kept. The
+// patch fails if the workload changed since it was cached, it is then
+// retried on the update of the cache.
+func (c *Controller) patch(k kind, accessor metav1.Object, t *v1.PodTemplateSpec) error {
+	client := k.client(c.client)
+	data, err := client.Get().
+		Namespace(accessor.GetNamespace()).
+		Resource(k.resource).
+		Name(accessor.GetName()).
+		Do().
+		Raw()
+	if err != nil {
+		return err
+	}
+	var raw struct {
+		Spec struct {
+			Template json.RawMessage `json:"template"`
+		} `json:"spec"`
+	}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Spec.Template) == 0 {
+		return fmt.Errorf("%s %s/%s has no pod template", k.resource, accessor.GetNamespace(), accessor.GetName())
+	}
+	ops, err := inject.PodTemplateJSONPatch(c.params, "/spec/template", raw.Spec.Template, t)
+	if err != nil {
+		return err
+	}
+	ops = append([]inject.PatchOperation{
+		{Op: "test", Path: "/metadata/resourceVersion", Value: accessor.GetResourceVersion()},
+	}, ops...)
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	return client.Patch(types.JSONPatchType).
+		Namespace(accessor.GetNamespace()).
+		Resource(k.resource).
+		Name(accessor.GetName()).
+		Body(body).
+		Do().
+		Error()
+}
+
+// report reports whether the skip or failure of a workload at its
+// resource version was not reported yet, and records it, so that the
+// same outcome is only counted, recorded, and notified once.
+func (c *Controller) report(k kind, accessor metav1.Object, outcome string) bool {
+	key := k.resource + "/" + accessor.GetNamespace() + "/" + accessor.GetName()
+	value := accessor.GetResourceVersion() + " " + outcome
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reported == nil {
+		c.reported = make(map[string]string)
+	}
+	if c.reported[key] == value {
+		return false
+	}
+	c.reported[key] = value
+	return true
+}
+
+// forget forgets the outcome reported for a deleted workload.
+func (c *Controller) forget(k kind, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.reported, k.resource+"/"+key)
+	c.mu.Unlock()
+}
+
+// fail counts, records, and notifies the failure of a workload, unless
+// it was already reported at the resource version of the workload.
+func (c *Controller) fail(k kind, obj runtime.Object, accessor metav1.Object, err error) {
+	if !c.report(k, accessor, err.Error()) {
+		return
+	}
+	metrics.Errors.Inc(metrics.SourceRetrofit, k.kind(), accessor.GetNamespace())
+	c.notify(k, accessor, err)
+	c.event(obj, v1.EventTypeWarning, events.ReasonFailed, err.Error())
+}
+
+// notify reports a failure of the workload to the notifier, if any.
+func (c *Controller) notify(k kind, accessor metav1.Object, err error) {
+	if c.notifier == nil {
+		return
+	}
+	failure := notify.Failure{
+		Resource:  k.resource,
+		Namespace: accessor.GetNamespace(),
+		Name:      accessor.GetName(),
+		Error:     err.Error(),
+	}
+	if nerr := c.notifier.Notify(failure); nerr != nil {
+		glog.Warningf("Failed to notify %v: %v", failure, nerr)
+	}
+}
+
+// event records an event on a workload if the controller has a
+// recorder.
+func (c *Controller) event(obj runtime.Object, eventtype, reason, message string) {
+	if c.recorder != nil {
+		c.recorder.Event(obj, eventtype, reason, message)
+	}
+}
+
+// ParseSelector parses the namespace label selector of the controller.
+func ParseSelector(selector string) (labels.Selector, error) {
+	parsed, err := metav1.ParseToLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return metav1.LabelSelectorAsSelector(parsed)
+}
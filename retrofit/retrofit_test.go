@@ -0,0 +1,365 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrofit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	apps "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/platform/kube/events"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/notify"
+	"istio.io/pilot/proxy"
+)
+
+func newParams() *inject.Params {
+	mesh := proxy.DefaultMeshConfig()
+	return &inject.Params{
+		InitImage:       inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:      inject.ProxyImageName("docker.io/istio", "unittest"),
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Version:         "12345678",
+		Mesh:            &mesh,
+	}
+}
+
+func newTemplate(annotations map[string]string) v1.PodTemplateSpec {
+	return v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "hello", Image: "hello"}},
+		},
+	}
+}
+
+func newDeployment(namespace, name string, annotations map[string]string) *v1beta1.Deployment {
+	return &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "1"},
+		Spec:       v1beta1.DeploymentSpec{Template: newTemplate(annotations)},
+	}
+}
+
+// server serves workloads as JSON at their paths and records the JSON
+// patches of them, which the fake clientset cannot apply.
+type server struct {
+	*httptest.Server
+	objects map[string][]byte
+	patches map[string][]inject.PatchOperation
+}
+
+// newServer starts a server of the objects, by path, and returns a
+// clientset of it.
+func newServer(t *testing.T, objects map[string]runtime.Object) (kubernetes.Interface, *server) {
+	s := &server{
+		objects: make(map[string][]byte),
+		patches: make(map[string][]inject.PatchOperation),
+	}
+	for path, obj := range objects {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.objects[path] = data
+	}
+	s.Server = httptest.NewServer(s)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.objects[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		var ops []inject.PatchOperation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.patches[r.URL.Path] = ops
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// injected reports whether the workload at the path was patched with
+// the proxy, after the test of its resource version.
+func (s *server) injected(path string) bool {
+	ops := s.patches[path]
+	if len(ops) == 0 || ops[0].Op != "test" || ops[0].Path != "/metadata/resourceVersion" || ops[0].Value != "1" {
+		return false
+	}
+	for _, op := range ops {
+		if c, ok := op.Value.(map[string]interface{}); ok && op.Op == "add" &&
+			strings.HasPrefix(op.Path, "/spec/template/spec/containers/") && c["name"] == "proxy" {
+			return true
+		}
+	}
+	return false
+}
+
+func newController(t *testing.T, client kubernetes.Interface) *Controller {
+	selector, err := ParseSelector(DefaultNamespaceSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, ns := range []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "mesh", Labels: map[string]string{"istio-injection": "enabled"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	} {
+		if err = namespaces.Add(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Controller{
+		client:     client,
+		params:     newParams(),
+		selector:   selector,
+		namespaces: namespaces,
+		stores:     make(map[string]cache.Store),
+		recorder:   record.NewFakeRecorder(10),
+	}
+}
+
+func TestRetrofitDeployment(t *testing.T) {
+	cases := []struct {
+		in           *v1beta1.Deployment
+		wantInjected bool
+		wantEvent    string
+	}{
+		{
+			in:           newDeployment("mesh", "uninjected", nil),
+			wantInjected: true,
+			wantEvent:    "Normal " + events.ReasonInjected,
+		},
+		{
+			in: newDeployment("default", "unlabeled", nil),
+		},
+		{
+			in:        newDeployment("mesh", "ignored", map[string]string{"alpha.istio.io/sidecar": "ignore"}),
+			wantEvent: "Normal " + events.ReasonSkipped + " annotation opt-out",
+		},
+		{
+			in:        newDeployment("mesh", "opted-out", map[string]string{"sidecar.wharfie.io/inject": "false"}),
+			wantEvent: "Normal " + events.ReasonSkipped + " annotation opt-out",
+		},
+	}
+
+	injected := metrics.Injected.Value(metrics.SourceRetrofit, "Deployment", "mesh")
+	skipped := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonOptOut)
+	policy := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonPolicy)
+	for _, c := range cases {
+		path := deploymentPath(c.in)
+		client, s := newServer(t, map[string]runtime.Object{path: c.in})
+		controller := newController(t, client)
+		err := controller.retrofit(kinds[0], c.in)
+		s.Close()
+		if err != nil {
+			t.Errorf("retrofit(%s) returned an error: %v", c.in.Name, err)
+			continue
+		}
+		if got := s.injected(path); got != c.wantInjected {
+			t.Errorf("retrofit(%s) => got patch %+v, want the proxy injected: %v", c.in.Name, s.patches[path], c.wantInjected)
+		}
+		if len(c.in.Spec.Template.Spec.Containers) != 1 {
+			t.Errorf("retrofit(%s) modified the cached object", c.in.Name)
+		}
+		var event string
+		select {
+		case event = <-controller.recorder.(*record.FakeRecorder).Events:
+		default:
+		}
+		if !strings.HasPrefix(event, c.wantEvent) || (event == "") != (c.wantEvent == "") {
+			t.Errorf("retrofit(%s) event => got %q, want %q", c.in.Name, event, c.wantEvent)
+		}
+	}
+	if got := metrics.Injected.Value(metrics.SourceRetrofit, "Deployment", "mesh") - injected; got != 1 {
+		t.Errorf("got %d injections counted, want 1", got)
+	}
+	if got := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonOptOut) - skipped; got != 1 {
+		t.Errorf("got %d opt-outs counted, want 1", got)
+	}
+	if got := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonPolicy) - policy; got != 1 {
+		t.Errorf("got %d policy skips counted, want 1", got)
+	}
+}
+
+func TestRetrofitNamespace(t *testing.T) {
+	deployment := newDeployment("mesh", "hello", nil)
+	statefulSet := &apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "mesh", ResourceVersion: "1"},
+		Spec:       apps.StatefulSetSpec{Template: newTemplate(nil)},
+	}
+	statefulSetPath := "/apis/apps/v1beta1/namespaces/mesh/statefulsets/db"
+	client, s := newServer(t, map[string]runtime.Object{
+		deploymentPath(deployment): deployment,
+		statefulSetPath:            statefulSet,
+	})
+	defer s.Close()
+	controller := newController(t, client)
+	for _, k := range kinds {
+		controller.stores[k.resource] = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	}
+	if err := controller.stores["deployments"].Add(deployment); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.stores["statefulsets"].Add(statefulSet); err != nil {
+		t.Fatal(err)
+	}
+
+	// Workloads are retrofitted once their namespace is labeled.
+	controller.retrofitNamespace(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	if len(s.patches) != 0 {
+		t.Errorf("retrofitNamespace patched %d workloads of an unlabeled namespace", len(s.patches))
+	}
+	mesh, _, _ := controller.namespaces.GetByKey("mesh")
+	controller.retrofitNamespace(mesh.(*v1.Namespace))
+	if !s.injected(deploymentPath(deployment)) {
+		t.Errorf("retrofitNamespace did not inject the deployment")
+	}
+	if !s.injected(statefulSetPath) {
+		t.Errorf("retrofitNamespace did not inject the statefulset")
+	}
+}
+
+func TestRetrofitNamespacePolicy(t *testing.T) {
+	deployment := newDeployment("mesh", "hello", nil)
+	client, s := newServer(t, map[string]runtime.Object{deploymentPath(deployment): deployment})
+	defer s.Close()
+	controller := newController(t, client)
+	controller.params.Namespaces = &inject.NamespacePolicy{Exclude: []string{"mesh"}}
+	if err := controller.retrofit(kinds[0], deployment); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.patches) != 0 {
+		t.Errorf("retrofit() in an excluded namespace => got patches %+v", s.patches)
+	}
+}
+
+func TestRetrofitKeepsUnknownFields(t *testing.T) {
+	deployment := newDeployment("mesh", "hello", nil)
+	path := deploymentPath(deployment)
+	client, s := newServer(t, nil)
+	defer s.Close()
+	s.objects[path] = []byte(`{"metadata":{"name":"hello","namespace":"mesh","resourceVersion":"1"},` +
+		`"spec":{"template":{"metadata":{"labels":{"app":"hello"}},"spec":{"containers":[{"name":"hello",` +
+		`"image":"hello","startupProbe":{"exec":{"command":["true"]}}}],"topologySpreadConstraints":[{"maxSkew":1}]}}}}`)
+	controller := newController(t, client)
+	if err := controller.retrofit(kinds[0], deployment); err != nil {
+		t.Fatal(err)
+	}
+	if !s.injected(path) {
+		t.Fatalf("retrofit() => got patch %+v, want the proxy injected", s.patches[path])
+	}
+	for _, op := range s.patches[path] {
+		if op.Path == "/spec/template/spec" || op.Path == "/spec/template/spec/containers/0" ||
+			op.Path == "/spec/template/spec/topologySpreadConstraints" {
+			t.Errorf("retrofit() => got operation %+v, want the original spec kept", op)
+		}
+	}
+}
+
+// failures records the failures it is notified of.
+type failures []notify.Failure
+
+func (f *failures) Notify(failure notify.Failure) error {
+	*f = append(*f, failure)
+	return nil
+}
+
+func TestRetrofitNotifiesFailures(t *testing.T) {
+	// The deployment is not served, so it cannot be patched.
+	client, s := newServer(t, nil)
+	defer s.Close()
+	controller := newController(t, client)
+	var got failures
+	controller.notifier = &got
+	deployment := newDeployment("mesh", "hello", nil)
+	if err := controller.retrofit(kinds[0], deployment); err == nil {
+		t.Errorf("retrofit() of a missing deployment => got no error")
+	}
+	if len(got) != 1 || got[0].Resource != "deployments" || got[0].Namespace != "mesh" || got[0].Name != "hello" {
+		t.Errorf("retrofit() => notified %+v, want the failure of deployments mesh/hello", got)
+	}
+}
+
+func TestRetrofitReportsOnce(t *testing.T) {
+	client, s := newServer(t, nil)
+	defer s.Close()
+	controller := newController(t, client)
+	var got failures
+	controller.notifier = &got
+	optedOut := newDeployment("mesh", "opted-out", map[string]string{"sidecar.wharfie.io/inject": "false"})
+	missing := newDeployment("mesh", "missing", nil)
+	skipped := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonPolicy)
+	failed := metrics.Errors.Value(metrics.SourceRetrofit, "Deployment", "mesh")
+
+	// Resyncs do not report the same outcome again, updates do.
+	for _, version := range []string{"1", "1", "2"} {
+		optedOut.ResourceVersion, missing.ResourceVersion = version, version
+		if err := controller.retrofit(kinds[0], optedOut); err != nil {
+			t.Fatal(err)
+		}
+		if err := controller.retrofit(kinds[0], missing); err == nil {
+			t.Errorf("retrofit() of a missing deployment => got no error")
+		}
+	}
+	if n := metrics.Skipped.Value(metrics.SourceRetrofit, "Deployment", "mesh", metrics.ReasonPolicy) - skipped; n != 2 {
+		t.Errorf("got %d policy skips counted, want 2", n)
+	}
+	if n := metrics.Errors.Value(metrics.SourceRetrofit, "Deployment", "mesh") - failed; n != 2 {
+		t.Errorf("got %d errors counted, want 2", n)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d failures notified, want 2: %+v", len(got), got)
+	}
+	if n := len(controller.recorder.(*record.FakeRecorder).Events); n != 4 {
+		t.Errorf("got %d events recorded, want 4", n)
+	}
+
+	// Deleted workloads are forgotten.
+	controller.forget(kinds[0], missing)
+	if err := controller.retrofit(kinds[0], missing); err == nil {
+		t.Errorf("retrofit() of a missing deployment => got no error")
+	}
+	if len(got) != 3 {
+		t.Errorf("got %d failures notified after the deletion, want 3", len(got))
+	}
+}
+
+// deploymentPath returns the API path of a deployment.
+func deploymentPath(d *v1beta1.Deployment) string {
+	return "/apis/extensions/v1beta1/namespaces/" + d.Namespace + "/deployments/" + d.Name
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validate checks injected kubernetes resources before they
+// are applied to a cluster.
+package validate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// resource is the subset of a kubernetes resource needed to address
+// it on the API server.
+type resource struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+func (r resource) String() string {
+	return fmt.Sprintf("%s %s", r.Kind, r.Metadata.Name)
+}
+
+// decode splits a YAML stream into JSON documents, skipping empty
+// documents.
+func decode(in io.Reader, handle func(r resource, doc []byte) error) error {
+	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
+	for {
+		raw, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		doc, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return err
+		}
+		var r resource
+		if err = json.Unmarshal(doc, &r); err != nil {
+			return err
+		}
+		if r.Kind == "" {
+			continue
+		}
+		if err = handle(r, doc); err != nil {
+			return err
+		}
+	}
+}
+
+// resourcePath looks up the API server path of the collection that
+// holds resources of the given kind.
+func resourcePath(client discovery.DiscoveryInterface, r resource, namespace string) (string, error) {
+	list, err := client.ServerResourcesForGroupVersion(r.APIVersion)
+	if err != nil {
+		return "", err
+	}
+	prefix := "/apis/" + r.APIVersion
+	if !strings.Contains(r.APIVersion, "/") {
+		prefix = "/api/" + r.APIVersion
+	}
+	for _, api := range list.APIResources {
+		// Skip subresources such as deployments/scale.
+		if api.Kind != r.Kind || strings.Contains(api.Name, "/") {
+			continue
+		}
+		if !api.Namespaced {
+			return prefix + "/" + api.Name, nil
+		}
+		if r.Metadata.Namespace != "" {
+			namespace = r.Metadata.Namespace
+		}
+		return prefix + "/namespaces/" + namespace + "/" + api.Name, nil
+	}
+	return "", fmt.Errorf("kind %s is not served by the cluster in %s", r.Kind, r.APIVersion)
+}
+
+// applyPatchType is the patch type of server-side apply, which the
+// vendored client predates.
+const applyPatchType types.PatchType = "application/apply-patch+yaml"
+
+// fieldManager names the manager of the fields of dry-run applies.
+const fieldManager = "wharfie"
+
+// DryRun submits every resource in the YAML stream to the API server
+// as a server-side dry-run create, so that admission (quota, pod
+// security, schema) is evaluated without persisting anything.
+// Resources that already exist are validated against an update
+// instead, with a dry-run server-side apply. Resources without a
+// namespace are created in the given namespace. The returned error
+// lists every resource the server would reject.
+func DryRun(client kubernetes.Interface, namespace string, in io.Reader) error {
+	var errs error
+	err := decode(in, func(r resource, doc []byte) error {
+		path, err := resourcePath(client.Discovery(), r, namespace)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%v: %v", r, err))
+			return nil
+		}
+		err = client.CoreV1().RESTClient().Post().
+			AbsPath(path).
+			Param("dryRun", "All").
+			SetHeader("Content-Type", "application/json").
+			Body(doc).
+			Do().
+			Error()
+		if errors.IsAlreadyExists(err) {
+			err = client.CoreV1().RESTClient().Patch(applyPatchType).
+				AbsPath(path, r.Metadata.Name).
+				Param("dryRun", "All").
+				Param("fieldManager", fieldManager).
+				Param("force", "true").
+				Body(doc).
+				Do().
+				Error()
+		}
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%v would be rejected: %v", r, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errs
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const dryRunInput = `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: accepted
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: rejected
+  namespace: restricted
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: existing
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: hello
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: unknown
+`
+
+func newDryRunServer(t *testing.T) (*httptest.Server, *[]string) {
+	var submitted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/extensions/v1beta1":
+			_ = json.NewEncoder(w).Encode(&metav1.APIResourceList{
+				GroupVersion: "extensions/v1beta1",
+				APIResources: []metav1.APIResource{
+					{Name: "deployments", Namespaced: true, Kind: "Deployment"},
+					{Name: "deployments/scale", Namespaced: true, Kind: "Scale"},
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1":
+			_ = json.NewEncoder(w).Encode(&metav1.APIResourceList{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "services", Namespaced: true, Kind: "Service"}},
+			})
+		case r.Method == http.MethodPost:
+			if got := r.URL.Query().Get("dryRun"); got != "All" {
+				t.Errorf("POST %s without dryRun=All: got %q", r.URL.Path, got)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			submitted = append(submitted, r.Method+" "+r.URL.Path)
+			if strings.Contains(string(body), `"existing"`) {
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(&metav1.Status{
+					TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+					Status:   metav1.StatusFailure,
+					Message:  "deployments \"existing\" already exists",
+					Reason:   metav1.StatusReasonAlreadyExists,
+					Code:     http.StatusConflict,
+				})
+				return
+			}
+			if strings.Contains(string(body), `"rejected"`) {
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(&metav1.Status{
+					TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+					Status:   metav1.StatusFailure,
+					Message:  "exceeded quota",
+					Reason:   metav1.StatusReasonForbidden,
+					Code:     http.StatusForbidden,
+				})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		case r.Method == http.MethodPatch:
+			query := r.URL.Query()
+			if r.Header.Get("Content-Type") != string(applyPatchType) || query.Get("dryRun") != "All" ||
+				query.Get("fieldManager") != fieldManager {
+				t.Errorf("PATCH %s is not a dry-run apply: %s %v", r.URL.Path, r.Header.Get("Content-Type"), query)
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			submitted = append(submitted, r.Method+" "+r.URL.Path)
+			_, _ = w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, &submitted
+}
+
+func TestDryRun(t *testing.T) {
+	server, submitted := newDryRunServer(t)
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DryRun(client, "default", strings.NewReader(dryRunInput))
+	if err == nil {
+		t.Fatal("DryRun() succeeded, want rejections")
+	}
+	for _, want := range []string{"Deployment rejected would be rejected: exceeded quota", "Widget unknown"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("DryRun() error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "accepted") || strings.Contains(err.Error(), "existing") ||
+		strings.Contains(err.Error(), "Service") {
+		t.Errorf("DryRun() error %q reports accepted resources", err)
+	}
+
+	wantSubmitted := []string{
+		"POST /apis/extensions/v1beta1/namespaces/default/deployments",
+		"POST /apis/extensions/v1beta1/namespaces/restricted/deployments",
+		"POST /apis/extensions/v1beta1/namespaces/default/deployments",
+		"PATCH /apis/extensions/v1beta1/namespaces/default/deployments/existing",
+		"POST /api/v1/namespaces/default/services",
+	}
+	if strings.Join(*submitted, ",") != strings.Join(wantSubmitted, ",") {
+		t.Errorf("DryRun() submitted %v, want %v", *submitted, wantSubmitted)
+	}
+}
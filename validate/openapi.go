@@ -0,0 +1,234 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+const definitionRefPrefix = "#/definitions/"
+
+type groupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// definition is the subset of an OpenAPI schema object used by the
+// kubernetes API definitions.
+type definition struct {
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Ref                  string                 `json:"$ref"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*definition `json:"properties"`
+	Items                *definition            `json:"items"`
+	AdditionalProperties *definition            `json:"additionalProperties"`
+	GroupVersionKinds    []groupVersionKind     `json:"x-kubernetes-group-version-kind"`
+}
+
+// Schema validates kubernetes resources offline against the
+// definitions of an OpenAPI (swagger 2.0) document, such as the one
+// served by the API server at /swagger.json.
+type Schema struct {
+	definitions map[string]*definition
+	kinds       map[groupVersionKind]*definition
+}
+
+// LoadSchema reads an OpenAPI document. Only definitions annotated
+// with x-kubernetes-group-version-kind can be validated as top-level
+// resources.
+func LoadSchema(in io.Reader) (*Schema, error) {
+	var doc struct {
+		Definitions map[string]*definition `json:"definitions"`
+	}
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot parse OpenAPI schema: %v", err)
+	}
+	s := &Schema{
+		definitions: doc.Definitions,
+		kinds:       make(map[groupVersionKind]*definition),
+	}
+	for _, def := range doc.Definitions {
+		for _, gvk := range def.GroupVersionKinds {
+			s.kinds[gvk] = def
+		}
+	}
+	return s, nil
+}
+
+// Validate checks every resource in the YAML stream against the
+// schema. The returned error lists every violation with the path of
+// the offending field.
+func (s *Schema) Validate(in io.Reader) error {
+	var errs error
+	err := decode(in, func(r resource, doc []byte) error {
+		gvk := groupVersionKind{Version: r.APIVersion, Kind: r.Kind}
+		if i := strings.Index(r.APIVersion, "/"); i >= 0 {
+			gvk.Group, gvk.Version = r.APIVersion[:i], r.APIVersion[i+1:]
+		}
+		def, ok := s.kinds[gvk]
+		if !ok {
+			errs = multierror.Append(errs, fmt.Errorf("%v: schema does not define %s %s", r, r.APIVersion, r.Kind))
+			return nil
+		}
+		var value interface{}
+		if err := json.Unmarshal(doc, &value); err != nil {
+			return err
+		}
+		for _, violation := range s.validate(def, value, "") {
+			errs = multierror.Append(errs, fmt.Errorf("%v: %s", r, violation))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errs
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "resource"
+	}
+	return path
+}
+
+// validate returns the violations of value against def, identified by
+// their path from the resource root.
+func (s *Schema) validate(def *definition, value interface{}, path string) []string {
+	// kubernetes treats explicit nulls as absent fields.
+	if value == nil {
+		return nil
+	}
+	if def.Ref != "" {
+		name := strings.TrimPrefix(def.Ref, definitionRefPrefix)
+		// Quantities are serialized as either strings or numbers.
+		if strings.HasSuffix(name, ".Quantity") {
+			switch value.(type) {
+			case string, float64:
+				return nil
+			}
+			return []string{fmt.Sprintf("%s: expected a quantity, got %s", describe(path), jsonType(value))}
+		}
+		ref, ok := s.definitions[name]
+		if !ok {
+			return []string{fmt.Sprintf("%s: schema does not define %s", describe(path), name)}
+		}
+		return s.validate(ref, value, path)
+	}
+
+	switch def.Type {
+	case "", "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			if def.Type == "" {
+				return nil
+			}
+			return []string{fmt.Sprintf("%s: expected an object, got %s", describe(path), jsonType(value))}
+		}
+		return s.validateObject(def, obj, path)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array, got %s", describe(path), jsonType(value))}
+		}
+		var out []string
+		if def.Items != nil {
+			for i, item := range items {
+				out = append(out, s.validate(def.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return out
+	case "string":
+		if _, ok := value.(string); ok {
+			return nil
+		}
+		if _, ok := value.(float64); ok && def.Format == "int-or-string" {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: expected a string, got %s", describe(path), jsonType(value))}
+	case "integer":
+		if n, ok := value.(float64); ok && n == float64(int64(n)) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: expected an integer, got %s", describe(path), jsonType(value))}
+	case "number":
+		if _, ok := value.(float64); ok {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: expected a number, got %s", describe(path), jsonType(value))}
+	case "boolean":
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: expected a boolean, got %s", describe(path), jsonType(value))}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(def *definition, obj map[string]interface{}, path string) []string {
+	var out []string
+	for _, field := range def.Required {
+		if obj[field] == nil {
+			out = append(out, fmt.Sprintf("%s: missing required field %q", describe(path), field))
+		}
+	}
+
+	fields := make([]string, 0, len(obj))
+	for field := range obj {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if prop, ok := def.Properties[field]; ok {
+			out = append(out, s.validate(prop, obj[field], joinPath(path, field))...)
+		} else if def.AdditionalProperties != nil {
+			out = append(out, s.validate(def.AdditionalProperties, obj[field], joinPath(path, field))...)
+		} else if def.Properties != nil {
+			out = append(out, fmt.Sprintf("%s: unknown field", joinPath(path, field)))
+		}
+	}
+	return out
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", value)
+}
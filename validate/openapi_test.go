@@ -0,0 +1,116 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const validDeployment = `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  creationTimestamp: null
+  name: hello
+spec:
+  replicas: 7
+  strategy: {}
+  template:
+    metadata:
+      annotations:
+        alpha.istio.io/sidecar: injected
+      creationTimestamp: null
+    spec:
+      containers:
+      - image: fake.docker.io/google-samples/hello-go-gke:1.0
+        name: hello
+        ports:
+        - containerPort: 80
+          name: http
+        resources:
+          limits:
+            cpu: 1
+          requests:
+            memory: 128Mi
+      - args:
+        - proxy
+        - sidecar
+        image: docker.io/istio/proxy_debug:unittest
+        name: proxy
+status: {}
+`
+
+const invalidDeployment = `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: broken
+spec:
+  replicas: "7"
+  template:
+    spec:
+      containers:
+      - image: docker.io/istio/proxy_debug:unittest
+        ports:
+        - name: http
+        resources:
+          requests:
+            memory: [128Mi]
+        unknownField: true
+---
+apiVersion: v1
+kind: Widget
+metadata:
+  name: unknown
+`
+
+func loadTestSchema(t *testing.T) *Schema {
+	file, err := os.Open("testdata/swagger.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	schema, err := LoadSchema(file)
+	if err != nil {
+		t.Fatalf("LoadSchema() returned an error: %v", err)
+	}
+	return schema
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := loadTestSchema(t)
+	if err := schema.Validate(strings.NewReader(validDeployment)); err != nil {
+		t.Errorf("Validate() returned an error for a valid resource: %v", err)
+	}
+
+	err := schema.Validate(strings.NewReader(invalidDeployment))
+	if err == nil {
+		t.Fatal("Validate() succeeded for an invalid resource")
+	}
+	for _, want := range []string{
+		"Deployment broken: spec.replicas: expected an integer, got a string",
+		`Deployment broken: spec.template.spec.containers[0]: missing required field "name"`,
+		`Deployment broken: spec.template.spec.containers[0].ports[0]: missing required field "containerPort"`,
+		"Deployment broken: spec.template.spec.containers[0].resources.requests.memory: expected a quantity, got an array",
+		"Deployment broken: spec.template.spec.containers[0].unknownField: unknown field",
+		"Widget unknown: schema does not define v1 Widget",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error %q does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// certSecret is a certificate secret mounted by an injected pod
+// template.
+type certSecret struct {
+	owner          resource
+	namespace      string
+	name           string
+	serviceAccount string
+}
+
+// CertSecrets returns a warning for every injected pod template that
+// mounts a certificate secret that does not exist in the cluster and
+// will not be created, either by a resource in the YAML stream or by
+// the Istio CA for an existing service account. Pods of such templates
+// are stuck in ContainerCreating. Resources without a namespace are
+// looked up in the given namespace.
+func CertSecrets(client kubernetes.Interface, namespace string, in io.Reader) ([]string, error) {
+	// Secrets and service accounts that will be created with the stream.
+	created := make(map[string]bool)
+	var secrets []certSecret
+	err := decode(in, func(r resource, doc []byte) error {
+		ns := r.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		switch r.Kind {
+		case "Secret", "ServiceAccount":
+			created[r.Kind+"/"+ns+"/"+r.Metadata.Name] = true
+			return nil
+		}
+		var obj struct {
+			Spec struct {
+				Template *v1.PodTemplateSpec `json:"template"`
+				// CronJobs nest the pod template in a job template.
+				JobTemplate struct {
+					Spec struct {
+						Template *v1.PodTemplateSpec `json:"template"`
+					} `json:"spec"`
+				} `json:"jobTemplate"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(doc, &obj); err != nil {
+			return err
+		}
+		t := obj.Spec.Template
+		if t == nil {
+			t = obj.Spec.JobTemplate.Spec.Template
+		}
+		if t == nil {
+			return nil
+		}
+		for _, volume := range t.Spec.Volumes {
+			if volume.Name != inject.CertVolumeName || volume.Secret == nil {
+				continue
+			}
+			sa := t.Spec.ServiceAccountName
+			if sa == "" {
+				sa = "default"
+			}
+			secrets = append(secrets, certSecret{owner: r, namespace: ns, name: volume.Secret.SecretName, serviceAccount: sa})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, s := range secrets {
+		if created["Secret/"+s.namespace+"/"+s.name] || created["ServiceAccount/"+s.namespace+"/"+s.serviceAccount] {
+			continue
+		}
+		_, err := client.CoreV1().Secrets(s.namespace).Get(s.name, metav1.GetOptions{})
+		if err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot get secret %s in namespace %s: %v", s.name, s.namespace, err)
+		}
+		// The Istio CA creates the secret of every service account.
+		_, err = client.CoreV1().ServiceAccounts(s.namespace).Get(s.serviceAccount, metav1.GetOptions{})
+		if err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot get service account %s in namespace %s: %v", s.serviceAccount, s.namespace, err)
+		}
+		warnings = append(warnings, fmt.Sprintf("%v: secret %s and service account %s do not exist in namespace %s",
+			s.owner, s.name, s.serviceAccount, s.namespace))
+	}
+	return warnings, nil
+}
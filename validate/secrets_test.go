@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const certDeployment = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: hello
+  namespace: %s
+spec:
+  template:
+    spec:
+      serviceAccountName: hello
+      containers:
+      - name: hello
+        image: hello
+      volumes:
+      - name: istio-certs
+        secret:
+          secretName: istio.hello
+`
+
+func TestCertSecrets(t *testing.T) {
+	deployment := func(namespace string) string {
+		return fmt.Sprintf(certDeployment, namespace)
+	}
+	cases := []struct {
+		name    string
+		in      string
+		objects []runtime.Object
+		want    []string
+	}{{
+		name: "secret exists",
+		in:   deployment("web"),
+		objects: []runtime.Object{
+			&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "istio.hello", Namespace: "web"}},
+		},
+	}, {
+		name: "service account exists",
+		in:   deployment("web"),
+		objects: []runtime.Object{
+			&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "web"}},
+		},
+	}, {
+		name: "service account in stream",
+		in: deployment("") + `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: hello
+`,
+	}, {
+		name: "missing",
+		in:   deployment(""),
+		objects: []runtime.Object{
+			&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "istio.hello", Namespace: "web"}},
+		},
+		want: []string{"Deployment hello: secret istio.hello and service account hello do not exist in namespace default"},
+	}, {
+		name: "no certificates",
+		in: `apiVersion: v1
+kind: Service
+metadata:
+  name: hello
+`,
+	}}
+	for _, c := range cases {
+		client := fake.NewSimpleClientset(c.objects...)
+		got, err := CertSecrets(client, "default", strings.NewReader(c.in))
+		if err != nil {
+			t.Fatalf("%s: CertSecrets() returned an error: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: CertSecrets() => got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	certificates "k8s.io/client-go/pkg/apis/certificates/v1beta1"
+)
+
+// Defaults of the certificate signing request timing.
+const (
+	DefaultApprovalTimeout = 5 * time.Minute
+	DefaultPollInterval    = 2 * time.Second
+	DefaultRetryPeriod     = time.Minute
+)
+
+// Keypair holds the serving certificate of the webhook. It can be
+// replaced while the server runs, e.g. on rotation, and is used through
+// the GetCertificate hook of the TLS configuration.
+type Keypair struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// GetCertificate returns the current certificate.
+func (k *Keypair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.cert == nil {
+		return nil, errors.New("no serving certificate")
+	}
+	return k.cert, nil
+}
+
+// Set replaces the certificate served to new connections.
+func (k *Keypair) Set(cert *tls.Certificate) {
+	k.mu.Lock()
+	k.cert = cert
+	k.mu.Unlock()
+}
+
+// CSRConfig describes the certificate signing requests of the webhook
+// serving certificate.
+type CSRConfig struct {
+	Client kubernetes.Interface
+	// Name of the CertificateSigningRequest, replaced on every request
+	// and deleted once signed. Every replica of the webhook needs its
+	// own name, e.g. with its pod name.
+	Name string
+	// Service and Namespace of the webhook service. The certificate is
+	// issued for the DNS names of the service.
+	Service   string
+	Namespace string
+	// ApprovalTimeout bounds the wait for the request to be approved
+	// and signed, polling every PollInterval.
+	ApprovalTimeout time.Duration
+	PollInterval    time.Duration
+	// RetryPeriod is the interval between failed renewals.
+	RetryPeriod time.Duration
+}
+
+// dnsNames returns the DNS names of the webhook service.
+func (c *CSRConfig) dnsNames() []string {
+	return []string{
+		c.Service,
+		c.Service + "." + c.Namespace,
+		c.Service + "." + c.Namespace + ".svc",
+	}
+}
+
+// RequestCertificate generates a private key and requests a serving
+// certificate for it through the CertificateSigningRequest API. It
+// waits for an approver, e.g. an operator running kubectl certificate
+// approve, to approve the request and for the cluster to sign it.
+func RequestCertificate(c CSRConfig) (*tls.Certificate, error) {
+	if c.ApprovalTimeout == 0 {
+		c.ApprovalTimeout = DefaultApprovalTimeout
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = DefaultPollInterval
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	names := c.dnsNames()
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[len(names)-1]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	csrs := c.Client.CertificatesV1beta1().CertificateSigningRequests()
+	if err = csrs.Delete(c.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if _, err = csrs.Create(&certificates.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: c.Name},
+		Spec: certificates.CertificateSigningRequestSpec{
+			Request: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+			Usages: []certificates.KeyUsage{
+				certificates.UsageDigitalSignature,
+				certificates.UsageKeyEncipherment,
+				certificates.UsageServerAuth,
+			},
+		},
+	}); err != nil {
+		return nil, err
+	}
+	glog.Infof("Waiting for certificate signing request %s to be approved", c.Name)
+
+	deadline := time.Now().Add(c.ApprovalTimeout)
+	for {
+		csr, err := csrs.Get(c.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, condition := range csr.Status.Conditions {
+			if condition.Type == certificates.CertificateDenied {
+				return nil, fmt.Errorf("certificate signing request %s was denied: %s %s",
+					c.Name, condition.Reason, condition.Message)
+			}
+		}
+		if len(csr.Status.Certificate) > 0 {
+			// The certificate is not needed by anyone else.
+			if err = csrs.Delete(c.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				glog.Warningf("Failed to delete certificate signing request %s: %v", c.Name, err)
+			}
+			return keypair(csr.Status.Certificate, key)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("certificate signing request %s was not signed within %v", c.Name, c.ApprovalTimeout)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// keypair pairs a signed PEM certificate chain with its private key.
+func keypair(certPEM []byte, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// renewalTime returns when a certificate is renewed: after two thirds
+// of its validity, leaving time to approve the next request.
+func renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3)
+}
+
+// RotateCertificate requests a new certificate for the keypair before
+// its certificate expires, until stop is closed. Failed requests are
+// retried every RetryPeriod while the current certificate is served.
+func RotateCertificate(c CSRConfig, k *Keypair, stop <-chan struct{}) {
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = DefaultRetryPeriod
+	}
+	var wait time.Duration
+	if current, err := k.GetCertificate(nil); err == nil && current.Leaf != nil {
+		wait = renewalTime(current.Leaf).Sub(time.Now())
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		cert, err := RequestCertificate(c)
+		if err != nil {
+			glog.Warningf("Failed to renew the serving certificate: %v", err)
+			wait = c.RetryPeriod
+			continue
+		}
+		k.Set(cert)
+		glog.Infof("Renewed the serving certificate, valid until %v", cert.Leaf.NotAfter)
+		wait = renewalTime(cert.Leaf).Sub(time.Now())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	certificates "k8s.io/client-go/pkg/apis/certificates/v1beta1"
+)
+
+// signer signs or denies the certificate signing request of the
+// webhook once it is created, as an approver and the cluster signer
+// would.
+func signer(t *testing.T, client kubernetes.Interface, name string, deny bool) {
+	csrs := client.CertificatesV1beta1().CertificateSigningRequests()
+	for i := 0; i < 100; i++ {
+		csr, err := csrs.Get(name, metav1.GetOptions{})
+		if err != nil || len(csr.Status.Certificate) > 0 {
+			// Not created yet, or the previous request.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if deny {
+			csr.Status.Conditions = []certificates.CertificateSigningRequestCondition{{
+				Type: certificates.CertificateDenied, Reason: "NotOurs",
+			}}
+		} else {
+			block, _ := pem.Decode(csr.Spec.Request)
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			now := time.Now()
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: request.Subject.CommonName},
+				DNSNames:     request.DNSNames,
+				NotBefore:    now,
+				NotAfter:     now.Add(time.Hour),
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, template, request.PublicKey, key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		if _, err = csrs.UpdateStatus(csr); err != nil {
+			t.Error(err)
+		}
+		return
+	}
+	t.Errorf("certificate signing request %s was not created", name)
+}
+
+func TestRequestCertificate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := CSRConfig{
+		Client:          client,
+		Name:            "wharfie-webhook",
+		Service:         "wharfie",
+		Namespace:       "istio-system",
+		ApprovalTimeout: 5 * time.Second,
+		PollInterval:    10 * time.Millisecond,
+	}
+	go signer(t, client, config.Name, false)
+	cert, err := RequestCertificate(config)
+	if err != nil {
+		t.Fatalf("RequestCertificate() returned an error: %v", err)
+	}
+	if got := strings.Join(cert.Leaf.DNSNames, ","); got != "wharfie,wharfie.istio-system,wharfie.istio-system.svc" {
+		t.Errorf("got DNS names %s", got)
+	}
+	if want := cert.Leaf.NotBefore.Add(40 * time.Minute); !renewalTime(cert.Leaf).Equal(want) {
+		t.Errorf("got renewal time %v, want %v", renewalTime(cert.Leaf), want)
+	}
+	if _, err = client.CertificatesV1beta1().CertificateSigningRequests().Get(config.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("RequestCertificate() kept the signed request %s", config.Name)
+	}
+
+	var keypair Keypair
+	if _, err = keypair.GetCertificate(nil); err == nil {
+		t.Error("GetCertificate() succeeded without a certificate")
+	}
+	keypair.Set(cert)
+	if got, err := keypair.GetCertificate(nil); err != nil || got != cert {
+		t.Errorf("GetCertificate() => got %v, %v, want the certificate", got, err)
+	}
+
+	// A new request replaces the previous one.
+	go signer(t, client, config.Name, true)
+	if _, err = RequestCertificate(config); err == nil || !strings.Contains(err.Error(), "denied: NotOurs") {
+		t.Errorf("RequestCertificate() => got %v, want a denied request", err)
+	}
+
+	config.Name = "unapproved"
+	config.ApprovalTimeout = 50 * time.Millisecond
+	if _, err = RequestCertificate(config); err == nil || !strings.Contains(err.Error(), "was not signed") {
+		t.Errorf("RequestCertificate() => got %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook runs proxy injection as a kubernetes mutating
+// admission webhook and generates the configuration to install it.
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Failure policies for calls to the webhook.
+const (
+	FailurePolicyIgnore = "Ignore"
+	FailurePolicyFail   = "Fail"
+)
+
+// Reinvocation policies for the webhook.
+const (
+	ReinvocationPolicyNever    = "Never"
+	ReinvocationPolicyIfNeeded = "IfNeeded"
+)
+
+// Defaults for the generated webhook configuration.
+const (
+	DefaultName           = "sidecar-injector.istio.io"
+	DefaultServiceName    = "istio-sidecar-injector"
+	DefaultPath           = "/inject"
+	DefaultTimeoutSeconds = int32(10)
+)
+
+// Defaults for the generated validating webhook configuration.
+const (
+	DefaultValidatorName = "sidecar-validator.istio.io"
+	DefaultValidatePath  = "/validate"
+)
+
+// RequireInjectionLabel opts a namespace into validation when set to
+// "true". It is separate from the istio-injection label, so that
+// namespaces are injected before uninjected workloads are rejected.
+const RequireInjectionLabel = "wharfie.io/require-injection"
+
+// ConfigOptions describes the MutatingWebhookConfiguration that
+// registers the injector with the API server. The failure policy,
+// timeout, and selectors determine which pods are affected when the
+// injector is unavailable.
+type ConfigOptions struct {
+	// Name of the configuration and of its single webhook.
+	Name string
+	// Service and path the API server calls.
+	ServiceName      string
+	ServiceNamespace string
+	Path             string
+	// CABundle is the PEM encoded CA that signed the serving
+	// certificate of the webhook.
+	CABundle []byte
+	// FailurePolicy is FailurePolicyIgnore or FailurePolicyFail.
+	FailurePolicy string
+	// ReinvocationPolicy is ReinvocationPolicyNever or
+	// ReinvocationPolicyIfNeeded.
+	ReinvocationPolicy string
+	// TimeoutSeconds bounds every call, between 1 and 30 seconds.
+	TimeoutSeconds int32
+	// NamespaceSelector and ObjectSelector restrict the pods sent to
+	// the webhook, all pods if nil.
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+}
+
+// DefaultConfigOptions returns options that fail open and send every
+// pod in namespaces labeled istio-injection=enabled to the injector.
+func DefaultConfigOptions(namespace string) *ConfigOptions {
+	return &ConfigOptions{
+		Name:               DefaultName,
+		ServiceName:        DefaultServiceName,
+		ServiceNamespace:   namespace,
+		Path:               DefaultPath,
+		FailurePolicy:      FailurePolicyIgnore,
+		ReinvocationPolicy: ReinvocationPolicyNever,
+		TimeoutSeconds:     DefaultTimeoutSeconds,
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"istio-injection": "enabled"},
+		},
+	}
+}
+
+// DefaultValidatingConfigOptions returns options that fail open and
+// send every workload in namespaces labeled
+// wharfie.io/require-injection=true to the validator.
+func DefaultValidatingConfigOptions(namespace string) *ConfigOptions {
+	o := DefaultConfigOptions(namespace)
+	o.Name = DefaultValidatorName
+	o.Path = DefaultValidatePath
+	o.NamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{RequireInjectionLabel: "true"},
+	}
+	return o
+}
+
+// Validate checks that the options describe a configuration the API
+// server accepts.
+func (o *ConfigOptions) Validate() error {
+	var errs error
+	if o.Name == "" {
+		errs = multierror.Append(errs, errors.New("webhook name is required"))
+	}
+	if o.ServiceName == "" || o.ServiceNamespace == "" {
+		errs = multierror.Append(errs, errors.New("webhook service name and namespace are required"))
+	}
+	switch o.FailurePolicy {
+	case FailurePolicyIgnore, FailurePolicyFail:
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("failure policy %q is not one of %s, %s",
+			o.FailurePolicy, FailurePolicyIgnore, FailurePolicyFail))
+	}
+	switch o.ReinvocationPolicy {
+	case ReinvocationPolicyNever, ReinvocationPolicyIfNeeded:
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("reinvocation policy %q is not one of %s, %s",
+			o.ReinvocationPolicy, ReinvocationPolicyNever, ReinvocationPolicyIfNeeded))
+	}
+	if o.TimeoutSeconds < 1 || o.TimeoutSeconds > 30 {
+		errs = multierror.Append(errs, fmt.Errorf("timeout %ds is not between 1 and 30 seconds", o.TimeoutSeconds))
+	}
+	return errs
+}
+
+// The admissionregistration.k8s.io/v1 types are not part of the
+// vendored client library, so the subset needed for the generated
+// configuration is declared here.
+
+type mutatingWebhookConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	Webhooks        []mutatingWebhook `json:"webhooks"`
+}
+
+type mutatingWebhook struct {
+	Name                    string                `json:"name"`
+	ClientConfig            webhookClientConfig   `json:"clientConfig"`
+	Rules                   []ruleWithOperations  `json:"rules"`
+	FailurePolicy           string                `json:"failurePolicy"`
+	ReinvocationPolicy      string                `json:"reinvocationPolicy"`
+	TimeoutSeconds          int32                 `json:"timeoutSeconds"`
+	NamespaceSelector       *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector          *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	SideEffects             string                `json:"sideEffects"`
+	AdmissionReviewVersions []string              `json:"admissionReviewVersions"`
+}
+
+type validatingWebhookConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta   `json:"metadata"`
+	Webhooks        []validatingWebhook `json:"webhooks"`
+}
+
+type validatingWebhook struct {
+	Name                    string                `json:"name"`
+	ClientConfig            webhookClientConfig   `json:"clientConfig"`
+	Rules                   []ruleWithOperations  `json:"rules"`
+	FailurePolicy           string                `json:"failurePolicy"`
+	TimeoutSeconds          int32                 `json:"timeoutSeconds"`
+	NamespaceSelector       *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector          *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	SideEffects             string                `json:"sideEffects"`
+	AdmissionReviewVersions []string              `json:"admissionReviewVersions"`
+}
+
+type webhookClientConfig struct {
+	Service  serviceReference `json:"service"`
+	CABundle []byte           `json:"caBundle,omitempty"`
+}
+
+type serviceReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+}
+
+type ruleWithOperations struct {
+	Operations  []string `json:"operations"`
+	APIGroups   []string `json:"apiGroups"`
+	APIVersions []string `json:"apiVersions"`
+	Resources   []string `json:"resources"`
+}
+
+// WriteConfig writes the MutatingWebhookConfiguration described by
+// the options as YAML.
+func WriteConfig(o *ConfigOptions, out io.Writer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	config := mutatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/v1",
+			Kind:       "MutatingWebhookConfiguration",
+		},
+		Metadata: metav1.ObjectMeta{Name: o.Name},
+		Webhooks: []mutatingWebhook{{
+			Name: o.Name,
+			ClientConfig: webhookClientConfig{
+				Service: serviceReference{
+					Namespace: o.ServiceNamespace,
+					Name:      o.ServiceName,
+					Path:      o.Path,
+				},
+				CABundle: o.CABundle,
+			},
+			Rules: []ruleWithOperations{{
+				Operations:  []string{"CREATE"},
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			}},
+			FailurePolicy:           o.FailurePolicy,
+			ReinvocationPolicy:      o.ReinvocationPolicy,
+			TimeoutSeconds:          o.TimeoutSeconds,
+			NamespaceSelector:       o.NamespaceSelector,
+			ObjectSelector:          o.ObjectSelector,
+			SideEffects:             "None",
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+		}},
+	}
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// WriteValidatingConfig writes the ValidatingWebhookConfiguration
+// described by the options as YAML. The webhook validates the
+// workloads the injector supports when they are created or updated,
+// so that uninjected workloads are reported to whoever applies them.
+// The reinvocation policy does not apply to validation.
+func WriteValidatingConfig(o *ConfigOptions, out io.Writer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	operations := []string{"CREATE", "UPDATE"}
+	config := validatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admissionregistration.k8s.io/v1",
+			Kind:       "ValidatingWebhookConfiguration",
+		},
+		Metadata: metav1.ObjectMeta{Name: o.Name},
+		Webhooks: []validatingWebhook{{
+			Name: o.Name,
+			ClientConfig: webhookClientConfig{
+				Service: serviceReference{
+					Namespace: o.ServiceNamespace,
+					Name:      o.ServiceName,
+					Path:      o.Path,
+				},
+				CABundle: o.CABundle,
+			},
+			Rules: []ruleWithOperations{{
+				Operations:  operations,
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"replicationcontrollers"},
+			}, {
+				Operations:  operations,
+				APIGroups:   []string{"apps", "extensions"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"deployments", "daemonsets", "replicasets", "statefulsets"},
+			}, {
+				Operations:  operations,
+				APIGroups:   []string{"batch"},
+				APIVersions: []string{"*"},
+				Resources:   []string{"jobs", "cronjobs"},
+			}},
+			FailurePolicy:           o.FailurePolicy,
+			TimeoutSeconds:          o.TimeoutSeconds,
+			NamespaceSelector:       o.NamespaceSelector,
+			ObjectSelector:          o.ObjectSelector,
+			SideEffects:             "None",
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+		}},
+	}
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteConfig(t *testing.T) {
+	cases := []struct {
+		options func(o *ConfigOptions)
+		want    string
+	}{
+		{
+			options: func(o *ConfigOptions) {},
+			want:    "testdata/webhook-config.yaml",
+		},
+		{
+			options: func(o *ConfigOptions) {
+				o.CABundle = []byte("fake-ca")
+				o.FailurePolicy = FailurePolicyFail
+				o.ReinvocationPolicy = ReinvocationPolicyIfNeeded
+				o.TimeoutSeconds = 5
+				o.NamespaceSelector = &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      "istio-injection",
+						Operator: metav1.LabelSelectorOpNotIn,
+						Values:   []string{"disabled"},
+					}},
+				}
+				o.ObjectSelector = &metav1.LabelSelector{
+					MatchLabels: map[string]string{"mesh": "true"},
+				}
+			},
+			want: "testdata/webhook-config-custom.yaml",
+		},
+	}
+	for _, c := range cases {
+		options := DefaultConfigOptions("istio-system")
+		c.options(options)
+		var got bytes.Buffer
+		if err := WriteConfig(options, &got); err != nil {
+			t.Fatalf("WriteConfig(%v) returned an error: %v", c.want, err)
+		}
+		util.CompareContent(got.Bytes(), c.want, t)
+	}
+}
+
+func TestWriteValidatingConfig(t *testing.T) {
+	var got bytes.Buffer
+	if err := WriteValidatingConfig(DefaultValidatingConfigOptions("istio-system"), &got); err != nil {
+		t.Fatalf("WriteValidatingConfig() returned an error: %v", err)
+	}
+	util.CompareContent(got.Bytes(), "testdata/validating-webhook-config.yaml", t)
+}
+
+func TestConfigOptionsValidate(t *testing.T) {
+	options := DefaultConfigOptions("")
+	options.FailurePolicy = "Sometimes"
+	options.ReinvocationPolicy = "Always"
+	options.TimeoutSeconds = 60
+	err := options.Validate()
+	if err == nil {
+		t.Fatal("Validate() succeeded for invalid options")
+	}
+	for _, want := range []string{
+		"service name and namespace are required",
+		`failure policy "Sometimes"`,
+		`reinvocation policy "Always"`,
+		"timeout 60s",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error %q does not contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	var health Health
+	status := func(handler http.Handler, path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+	for _, c := range []struct {
+		ready     bool
+		wantReady int
+	}{
+		{ready: false, wantReady: http.StatusServiceUnavailable},
+		{ready: true, wantReady: http.StatusOK},
+		{ready: false, wantReady: http.StatusServiceUnavailable},
+	} {
+		health.SetReady(c.ready)
+		if got := status(health.ReadinessHandler(), DefaultReadyPath); got != c.wantReady {
+			t.Errorf("ready %t: got readiness status %d, want %d", c.ready, got, c.wantReady)
+		}
+		if got := status(health.LivenessHandler(), DefaultHealthPath); got != http.StatusOK {
+			t.Errorf("ready %t: got liveness status %d, want %d", c.ready, got, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/platform/kube/events"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/notify"
+)
+
+// eventReference returns the object to record the events of an
+// admitted pod on: its controller, e.g. the ReplicaSet of a Deployment,
+// since the pod does not exist yet and is usually not named, or the pod
+// itself if it has no controller.
+func eventReference(namespace string, pod *v1.Pod) *v1.ObjectReference {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return &v1.ObjectReference{
+				APIVersion: owner.APIVersion,
+				Kind:       owner.Kind,
+				Namespace:  namespace,
+				Name:       owner.Name,
+				UID:        owner.UID,
+			}
+		}
+	}
+	if pod.Name == "" {
+		return nil
+	}
+	return &v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: pod.Name}
+}
+
+// failed returns the response to a pod that cannot be injected. The
+// failure policy of the webhook configuration only covers calls that
+// fail, so under FailurePolicyFail the pod is rejected here, and
+// otherwise admitted unchanged with a warning.
+func failed(resp *admissionResponse, failurePolicy, message string) *admissionResponse {
+	if failurePolicy != FailurePolicyFail {
+		resp.Warnings = []string{message}
+		return resp
+	}
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+	return resp
+}
+
+// mutate returns the response to an admission request for a pod,
+// patching in the proxy, and records an event on the controller of
+// the pod if the recorder is set. Pods that cannot be injected are
+// handled as the failure policy says, see failed, and reported to the
+// notifier, if not nil.
+func mutate(p *inject.Params, recorder record.EventRecorder, notifier notify.Notifier, failurePolicy string,
+	req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind != "Pod" || len(req.Object) == 0 {
+		return resp
+	}
+	start := time.Now()
+	var pod v1.Pod
+	if err := json.Unmarshal(req.Object, &pod); err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		return failed(resp, failurePolicy, fmt.Sprintf("cannot decode pod: %v", err))
+	}
+	fail := func(err error) *admissionResponse {
+		if notifier != nil {
+			name := pod.Name
+			if name == "" {
+				name = pod.GenerateName
+			}
+			failure := notify.Failure{Resource: "pods", Namespace: req.Namespace, Name: name, Error: err.Error()}
+			if nerr := notifier.Notify(failure); nerr != nil {
+				glog.Warningf("Failed to notify %v: %v", failure, nerr)
+			}
+		}
+		return failed(resp, failurePolicy, fmt.Sprintf("the istio proxy was not injected: %v", err))
+	}
+	// Pods of namespaces outside of the mesh are not worth an event.
+	if reason, err := inject.NamespaceSkip(p, req.Namespace); err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		return fail(err)
+	} else if reason != "" {
+		metrics.Skipped.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace, metrics.ReasonNamespace)
+		return resp
+	}
+	event := func(eventtype, reason, message string) {
+		if ref := eventReference(req.Namespace, &pod); recorder != nil && ref != nil {
+			recorder.Event(ref, eventtype, reason, message)
+		}
+	}
+	t := v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+	if status := inject.SidecarStatus(&t); status != "" {
+		metrics.Skipped.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace, metrics.SkipReason(status))
+		event(v1.EventTypeNormal, events.ReasonSkipped, events.SkipMessage(status))
+		return resp
+	}
+	if reason, err := inject.PolicySkip(p, &t); err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		event(v1.EventTypeWarning, events.ReasonFailed, fmt.Sprintf("the istio proxy was not injected: %v", err))
+		return fail(err)
+	} else if reason != "" {
+		metrics.Skipped.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace, metrics.ReasonPolicy)
+		event(v1.EventTypeNormal, events.ReasonSkipped, reason)
+		return resp
+	}
+	if err := inject.IntoPodTemplateSpec(p, req.Namespace, &t); err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		event(v1.EventTypeWarning, events.ReasonFailed, fmt.Sprintf("the istio proxy was not injected: %v", err))
+		return fail(err)
+	}
+
+	// The spec is only patched where injection changed it, so that the
+	// fields that the kubernetes types do not know are kept.
+	ops, err := inject.PodTemplateJSONPatch(p, "", req.Object, &t)
+	if err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		event(v1.EventTypeWarning, events.ReasonFailed, fmt.Sprintf("the istio proxy was not injected: %v", err))
+		return fail(err)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		metrics.Errors.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+		return fail(err)
+	}
+	resp.Patch = patch
+	resp.PatchType = "JSONPatch"
+	metrics.Injected.Inc(metrics.SourceWebhook, req.Kind.Kind, req.Namespace)
+	metrics.ObserveSince(metrics.SourceWebhook, req.Kind.Kind, start)
+	event(v1.EventTypeNormal, events.ReasonInjected, "injected the istio proxy into a pod")
+	return resp
+}
+
+// NewInjector returns a mutating admission webhook handler that
+// injects the proxy into the pods selected by the webhook
+// configuration, see WriteConfig. If the recorder is set, it records
+// an event on the controller of every pod injected or skipped. Pods
+// that cannot be injected are rejected under FailurePolicyFail, which
+// should match the failure policy of the webhook configuration, and
+// reported to the notifier, if set, which should not block, see
+// notify.Async.
+func NewInjector(p *inject.Params, recorder record.EventRecorder, notifier notify.Notifier,
+	failurePolicy string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveReview(w, r, func(req *admissionRequest) *admissionResponse {
+			resp := mutate(p, recorder, notifier, failurePolicy, req)
+			if resp.Patch != nil {
+				glog.V(2).Infof("Injected pod %s/%s", req.Namespace, req.Name)
+			}
+			return resp
+		})
+	})
+}
@@ -0,0 +1,286 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/platform/kube/metrics"
+	"istio.io/pilot/platform/kube/notify"
+	"istio.io/pilot/proxy"
+)
+
+func TestInjector(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:       inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:      inject.ProxyImageName("docker.io/istio", "unittest"),
+		Verbosity:       inject.DefaultVerbosity,
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Version:         "12345678",
+		Mesh:            &mesh,
+	}
+	cases := []struct {
+		name      string
+		review    string
+		wantPatch bool
+		wantEvent string
+	}{
+		{name: "pod", review: newReview("Pod", uninjectedPod), wantPatch: true, wantEvent: "Normal SidecarInjected"},
+		{name: "injected pod", review: newReview("Pod",
+			`{"metadata":{"annotations":{"alpha.istio.io/sidecar":"injected"}},"spec":{"containers":[{"name":"hello"}]}}`)},
+		{name: "opted out pod", review: newReview("Pod", `{"metadata":{"annotations":{"alpha.istio.io/sidecar":"ignore"},`+
+			`"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"hello-1234","uid":"5678","controller":true}]},`+
+			`"spec":{"containers":[{"name":"hello"}]}}`), wantEvent: "Normal SidecarInjectionSkipped annotation opt-out"},
+		{name: "policy opt-out pod", review: newReview("Pod", `{"metadata":{"annotations":{"sidecar.wharfie.io/inject":"false"},`+
+			`"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"hello-1234","uid":"5678","controller":true}]},`+
+			`"spec":{"containers":[{"name":"hello"}]}}`), wantEvent: "Normal SidecarInjectionSkipped annotation opt-out"},
+		{name: "other kind", review: newReview("Deployment", uninjected)},
+	}
+	injected := metrics.Injected.Value(metrics.SourceWebhook, "Pod", "web")
+	skipped := metrics.Skipped.Value(metrics.SourceWebhook, "Pod", "web", metrics.ReasonInjected)
+	for _, c := range cases {
+		events := record.NewFakeRecorder(10)
+		recorder := httptest.NewRecorder()
+		NewInjector(params, events, nil, FailurePolicyIgnore).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, DefaultPath, strings.NewReader(c.review)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", c.name, recorder.Code, recorder.Body)
+		}
+		var out admissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !out.Response.Allowed || out.Response.UID != "1234" {
+			t.Errorf("%s: got response %+v, want an allowed response to the request", c.name, out.Response)
+		}
+		var event string
+		select {
+		case event = <-events.Events:
+		default:
+		}
+		if !strings.HasPrefix(event, c.wantEvent) || (event == "") != (c.wantEvent == "") {
+			t.Errorf("%s: got event %q, want %q", c.name, event, c.wantEvent)
+		}
+		if (out.Response.Patch != nil) != c.wantPatch {
+			t.Errorf("%s: got patch %s, want a patch: %v", c.name, out.Response.Patch, c.wantPatch)
+			continue
+		}
+		if !c.wantPatch {
+			continue
+		}
+		var ops []inject.PatchOperation
+		if err := json.Unmarshal(out.Response.Patch, &ops); err != nil {
+			t.Fatal(err)
+		}
+		patch := string(out.Response.Patch)
+		if out.Response.PatchType != "JSONPatch" || len(ops) < 2 || ops[0].Path != "/metadata/annotations" ||
+			!strings.Contains(patch, `"name":"proxy"`) || !strings.Contains(patch, `"alpha.istio.io/sidecar":"injected"`) {
+			t.Errorf("%s: got patch %s of type %s, want the injected annotations and spec", c.name, patch, out.Response.PatchType)
+		}
+		for _, op := range ops {
+			if op.Path == "/spec" {
+				t.Errorf("%s: got patch %s, want the spec patched where it changed", c.name, patch)
+			}
+		}
+	}
+	if got := metrics.Injected.Value(metrics.SourceWebhook, "Pod", "web") - injected; got != 1 {
+		t.Errorf("got %d injections counted, want 1", got)
+	}
+	if got := metrics.Skipped.Value(metrics.SourceWebhook, "Pod", "web", metrics.ReasonInjected) - skipped; got != 1 {
+		t.Errorf("got %d skips counted, want 1", got)
+	}
+}
+
+func TestInjectorNamespacePolicy(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:  inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+		Namespaces: &inject.NamespacePolicy{Exclude: []string{"web"}},
+	}
+	skipped := metrics.Skipped.Value(metrics.SourceWebhook, "Pod", "web", metrics.ReasonNamespace)
+	events := record.NewFakeRecorder(10)
+	var req admissionReview
+	if err := json.Unmarshal([]byte(newReview("Pod", uninjectedPod)), &req); err != nil {
+		t.Fatal(err)
+	}
+	resp := mutate(params, events, nil, FailurePolicyIgnore, req.Request)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("mutate() in an excluded namespace => got %+v, want an allowed response without a patch", resp)
+	}
+	if len(events.Events) != 0 {
+		t.Errorf("mutate() in an excluded namespace recorded %d events", len(events.Events))
+	}
+	if got := metrics.Skipped.Value(metrics.SourceWebhook, "Pod", "web", metrics.ReasonNamespace) - skipped; got != 1 {
+		t.Errorf("got %d namespace skips counted, want 1", got)
+	}
+}
+
+// failures records the failures it is notified of.
+type failures []notify.Failure
+
+func (f *failures) Notify(failure notify.Failure) error {
+	*f = append(*f, failure)
+	return nil
+}
+
+func TestInjectorNotifiesFailures(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:  inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+	}
+	var req admissionReview
+	pod := `{"metadata":{"generateName":"hello-","annotations":{"sidecar.wharfie.io/inject":"maybe"}},` +
+		`"spec":{"containers":[{"name":"hello"}]}}`
+	if err := json.Unmarshal([]byte(newReview("Pod", pod)), &req); err != nil {
+		t.Fatal(err)
+	}
+	var got failures
+	if resp := mutate(params, nil, &got, FailurePolicyIgnore, req.Request); !resp.Allowed || resp.Patch != nil {
+		t.Errorf("mutate() => got %+v, want an allowed response without a patch", resp)
+	}
+	if len(got) != 1 || got[0].Resource != "pods" || got[0].Namespace != "web" || got[0].Name != "hello-" ||
+		!strings.Contains(got[0].Error, "maybe") {
+		t.Errorf("mutate() => notified %+v, want the failure of pod web/hello-", got)
+	}
+
+	// Pods that are injected or skipped are not failures.
+	got = nil
+	if err := json.Unmarshal([]byte(newReview("Pod", uninjectedPod)), &req); err != nil {
+		t.Fatal(err)
+	}
+	mutate(params, nil, &got, FailurePolicyIgnore, req.Request)
+	if len(got) != 0 {
+		t.Errorf("mutate() => notified %+v, want no failures", got)
+	}
+}
+
+func TestInjectorFailurePolicy(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:  inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+	}
+	invalid := `{"metadata":{"annotations":{"sidecar.wharfie.io/excludeIPRanges":"10.0.0.0"}},` +
+		`"spec":{"containers":[{"name":"hello"}]}}`
+	cases := []struct {
+		policy      string
+		pod         string
+		wantAllowed bool
+	}{
+		{policy: FailurePolicyIgnore, pod: invalid, wantAllowed: true},
+		{policy: FailurePolicyFail, pod: invalid},
+		{policy: FailurePolicyFail, pod: uninjectedPod, wantAllowed: true},
+	}
+	for _, c := range cases {
+		var req admissionReview
+		if err := json.Unmarshal([]byte(newReview("Pod", c.pod)), &req); err != nil {
+			t.Fatal(err)
+		}
+		resp := mutate(params, nil, nil, c.policy, req.Request)
+		if resp.Allowed != c.wantAllowed {
+			t.Errorf("mutate() under %s => got %+v, want allowed: %v", c.policy, resp, c.wantAllowed)
+		}
+		if !resp.Allowed && (resp.Result == nil || !strings.Contains(resp.Result.Message, "10.0.0.0")) {
+			t.Errorf("mutate() under %s => got status %+v, want the injection error", c.policy, resp.Result)
+		}
+	}
+}
+
+func TestInjectorNativeSidecar(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:   inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage:  inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:        &mesh,
+		SidecarMode: inject.SidecarNative,
+		// The profile is patched before the proxy moves.
+		SeccompProfile: &inject.SeccompProfile{Type: inject.SeccompRuntimeDefault},
+	}
+	var req admissionReview
+	if err := json.Unmarshal([]byte(newReview("Pod", uninjectedPod)), &req); err != nil {
+		t.Fatal(err)
+	}
+	resp := mutate(params, nil, nil, FailurePolicyIgnore, req.Request)
+	var ops []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+		t.Fatalf("mutate() => got patch %s: %v", resp.Patch, err)
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(op.Path, "/spec/containers") {
+			t.Errorf("mutate() => got patch %s, want the containers unchanged", resp.Patch)
+		}
+		if op.Path != "/spec/initContainers" {
+			continue
+		}
+		var initContainers []map[string]interface{}
+		if err := json.Unmarshal(op.Value, &initContainers); err != nil || op.Op != "add" || len(initContainers) != 1 ||
+			initContainers[0]["name"] != "proxy" || initContainers[0]["restartPolicy"] != "Always" {
+			t.Errorf("mutate() => got patch %s, want the proxy as a native sidecar", resp.Patch)
+		} else if sc, _ := initContainers[0]["securityContext"].(map[string]interface{}); sc["seccompProfile"] == nil {
+			t.Errorf("mutate() => got patch %s, want the seccomp profile on the proxy", resp.Patch)
+		}
+		return
+	}
+	t.Errorf("mutate() => got patch %s, want the init containers", resp.Patch)
+}
+
+func TestInjectorKeepsUnknownFields(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	params := &inject.Params{
+		InitImage:  inject.InitImageName("docker.io/istio", "unittest"),
+		ProxyImage: inject.ProxyImageName("docker.io/istio", "unittest"),
+		Mesh:       &mesh,
+	}
+	pod := `{"metadata":{"name":"hello"},"spec":{"containers":[{"name":"hello","image":"hello",` +
+		`"startupProbe":{"exec":{"command":["true"]}}}],"topologySpreadConstraints":[{"maxSkew":1}],` +
+		`"terminationGracePeriodSeconds":30}}`
+	var req admissionReview
+	if err := json.Unmarshal([]byte(newReview("Pod", pod)), &req); err != nil {
+		t.Fatal(err)
+	}
+	resp := mutate(params, nil, nil, FailurePolicyIgnore, req.Request)
+	var ops []inject.PatchOperation
+	if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+		t.Fatalf("mutate() => got patch %s: %v", resp.Patch, err)
+	}
+	var proxy bool
+	for _, op := range ops {
+		if op.Op != "add" || op.Path == "/spec" || op.Path == "/spec/containers/0" {
+			t.Errorf("mutate() => got operation %+v, want the original spec kept", op)
+		}
+		if op.Path == "/spec/containers/-" {
+			proxy = true
+		}
+	}
+	if !proxy {
+		t.Errorf("mutate() => got patch %s, want the proxy appended", resp.Patch)
+	}
+}
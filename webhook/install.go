@@ -0,0 +1,217 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/api/v1"
+	apps "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	rbac "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// Defaults for the generated installation of the injector.
+const (
+	DefaultServerPort = 8443
+	DefaultTLSSecret  = "istio-sidecar-injector-certs"
+	DefaultReplicas   = int32(1)
+)
+
+// InstallOptions describes the resources that run the injector in the
+// cluster: a Deployment of the server behind the Service of the webhook
+// configuration, with its ServiceAccount and RBAC.
+type InstallOptions struct {
+	// Config describes the webhook configuration. Its service name and
+	// namespace also name the other resources.
+	Config *ConfigOptions
+	// Image of the wharfie server.
+	Image    string
+	Replicas int32
+	// TLSSecret is the kubernetes.io/tls Secret with the serving
+	// certificate, e.g. issued by cert-manager.
+	TLSSecret string
+	// Args are added to the arguments of the server, e.g. the injection
+	// flags.
+	Args []string
+}
+
+// DefaultInstallOptions returns options that run a replica of the image
+// with the default webhook configuration.
+func DefaultInstallOptions(namespace, image string) *InstallOptions {
+	return &InstallOptions{
+		Config:    DefaultConfigOptions(namespace),
+		Image:     image,
+		Replicas:  DefaultReplicas,
+		TLSSecret: DefaultTLSSecret,
+	}
+}
+
+// Validate checks the options and the webhook configuration.
+func (o *InstallOptions) Validate() error {
+	errs := o.Config.Validate()
+	if o.Image == "" {
+		errs = multierror.Append(errs, errors.New("injector image is required"))
+	}
+	if o.TLSSecret == "" {
+		errs = multierror.Append(errs, errors.New("serving certificate secret is required"))
+	}
+	if o.Replicas < 1 {
+		errs = multierror.Append(errs, fmt.Errorf("%d replicas is not positive", o.Replicas))
+	}
+	return errs
+}
+
+// WriteInstall writes the ServiceAccount, Role, RoleBinding,
+// ClusterRole, ClusterRoleBinding, Service, Deployment, and
+// MutatingWebhookConfiguration of the injector as a YAML stream.
+func WriteInstall(o *InstallOptions, out io.Writer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	name, namespace := o.Config.ServiceName, o.Config.ServiceNamespace
+	meta := metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": name}}
+	selector := map[string]string{"app": name}
+
+	args := []string{
+		"server",
+		"--port", strconv.Itoa(DefaultServerPort),
+		"--namespace", namespace,
+		"--tlsSecret", o.TLSSecret,
+		"--failurePolicy", o.Config.FailurePolicy,
+		"--recordEvents",
+	}
+	args = append(args, o.Args...)
+	trueValue := true
+	replicas := o.Replicas
+	probe := func(path string) *v1.Probe {
+		return &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+			Path:   path,
+			Port:   intstr.FromInt(DefaultServerPort),
+			Scheme: v1.URISchemeHTTPS,
+		}}}
+	}
+
+	resources := []interface{}{
+		&v1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: meta,
+		},
+		// The server reads the mesh configuration and watches the
+		// secret of its serving certificate.
+		&rbac.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: meta,
+			Rules: []rbac.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "secrets"},
+				Verbs:     []string{"get", "list", "watch"},
+			}},
+		},
+		&rbac.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: meta,
+			Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: name, Namespace: namespace}},
+			RoleRef:    rbac.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: name},
+		},
+		// Events are recorded on the controllers of the injected pods in
+		// every namespace, whose labels a namespace policy may select.
+		&rbac.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: meta.Labels},
+			Rules: []rbac.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"events"},
+					Verbs:     []string{"create", "patch", "update"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"namespaces"},
+					Verbs:     []string{"get"},
+				},
+			},
+		},
+		&rbac.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: meta.Labels},
+			Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: name, Namespace: namespace}},
+			RoleRef:    rbac.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: name},
+		},
+		&v1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: meta,
+			Spec: v1.ServiceSpec{
+				Selector: selector,
+				Ports: []v1.ServicePort{{
+					Name:       "https",
+					Port:       443,
+					TargetPort: intstr.FromInt(DefaultServerPort),
+				}},
+			},
+		},
+		&apps.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: meta,
+			Spec: apps.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: selector},
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: selector,
+						// The injector does not inject itself.
+						Annotations: map[string]string{"alpha.istio.io/sidecar": "ignore"},
+					},
+					Spec: v1.PodSpec{
+						ServiceAccountName: name,
+						Containers: []v1.Container{{
+							Name:  "injector",
+							Image: o.Image,
+							Args:  args,
+							Ports: []v1.ContainerPort{{Name: "https", ContainerPort: DefaultServerPort}},
+							// Reviews are only routed to ready replicas, which
+							// drain on shutdown, see the server command.
+							LivenessProbe:  probe(DefaultHealthPath),
+							ReadinessProbe: probe(DefaultReadyPath),
+							SecurityContext: &v1.SecurityContext{
+								RunAsNonRoot:           &trueValue,
+								ReadOnlyRootFilesystem: &trueValue,
+							},
+						}},
+					},
+				},
+			},
+		},
+	}
+	for _, resource := range resources {
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(data); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprint(out, "---\n"); err != nil {
+			return err
+		}
+	}
+	return WriteConfig(o.Config, out)
+}
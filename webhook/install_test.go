@@ -0,0 +1,49 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestWriteInstall(t *testing.T) {
+	options := DefaultInstallOptions("istio-system", "docker.io/istio/wharfie:0.1")
+	options.Config.FailurePolicy = FailurePolicyFail
+	options.Args = []string{"--hub", "docker.io/istio", "--tag", "0.1"}
+	var got bytes.Buffer
+	if err := WriteInstall(options, &got); err != nil {
+		t.Fatalf("WriteInstall() returned an error: %v", err)
+	}
+	util.CompareContent(got.Bytes(), "testdata/install.yaml", t)
+}
+
+func TestInstallOptionsValidate(t *testing.T) {
+	options := DefaultInstallOptions("istio-system", "")
+	options.Replicas = 0
+	options.Config.FailurePolicy = "Sometimes"
+	err := options.Validate()
+	if err == nil {
+		t.Fatal("Validate() succeeded with invalid options")
+	}
+	for _, want := range []string{"image is required", "0 replicas", "failure policy"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() => got %v, want an error about %q", err, want)
+		}
+	}
+}
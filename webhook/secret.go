@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Keys of the certificate and the private key in a kubernetes.io/tls
+// Secret, as issued by cert-manager.
+const (
+	SecretCertKey = "tls.crt"
+	SecretKeyKey  = "tls.key"
+)
+
+// secretKeypair parses the keypair of a TLS Secret.
+func secretKeypair(secret *v1.Secret) (*tls.Certificate, error) {
+	certPEM, key := secret.Data[SecretCertKey], secret.Data[SecretKeyKey]
+	if len(certPEM) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("secret %s/%s does not have both %s and %s",
+			secret.Namespace, secret.Name, SecretCertKey, SecretKeyKey)
+	}
+	cert, err := tls.X509KeyPair(certPEM, key)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// LoadSecret loads the keypair of a TLS Secret, e.g. one that
+// cert-manager issues for a Certificate resource.
+func LoadSecret(client kubernetes.Interface, namespace, name string) (*tls.Certificate, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretKeypair(secret)
+}
+
+// WatchSecret reloads the keypair from a TLS Secret whenever it changes,
+// e.g. when cert-manager renews the certificate, until the stop channel
+// is closed. Connections are served without interruption: new ones get
+// the renewed certificate. Invalid or deleted secrets keep the current
+// certificate.
+func WatchSecret(client kubernetes.Interface, namespace, name string, k *Keypair, stop <-chan struct{}) {
+	watchlist := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "secrets", namespace,
+		fields.OneTermEqualSelector("metadata.name", name))
+	_, informer := cache.NewInformer(watchlist, &v1.Secret{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { reloadSecret(k, obj) },
+		UpdateFunc: func(_, obj interface{}) { reloadSecret(k, obj) },
+		DeleteFunc: func(interface{}) {
+			glog.Warningf("Secret %s/%s of the serving certificate was deleted, keeping the current certificate",
+				namespace, name)
+		},
+	})
+	informer.Run(stop)
+}
+
+// reloadSecret replaces the keypair with the one of an updated Secret.
+func reloadSecret(k *Keypair, obj interface{}) {
+	secret, ok := obj.(*v1.Secret)
+	if !ok {
+		return
+	}
+	cert, err := secretKeypair(secret)
+	if err != nil {
+		glog.Warningf("Keeping the current serving certificate: %v", err)
+		return
+	}
+	if current, err := k.GetCertificate(nil); err == nil && current.Leaf != nil && current.Leaf.Equal(cert.Leaf) {
+		return
+	}
+	k.Set(cert)
+	glog.Infof("Reloaded the serving certificate from secret %s/%s, valid until %v",
+		secret.Namespace, secret.Name, cert.Leaf.NotAfter)
+}
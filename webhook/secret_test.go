@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+func tlsSecret(t *testing.T, host string) *v1.Secret {
+	certPEM, key, err := cert.GenerateSelfSignedCertKey(host, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "wharfie-tls", Namespace: "istio-system"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{SecretCertKey: certPEM, SecretKeyKey: key},
+	}
+}
+
+func TestSecretKeypair(t *testing.T) {
+	client := fake.NewSimpleClientset(tlsSecret(t, "wharfie.istio-system.svc"))
+	loaded, err := LoadSecret(client, "istio-system", "wharfie-tls")
+	if err != nil {
+		t.Fatalf("LoadSecret() returned an error: %v", err)
+	}
+	if err = loaded.Leaf.VerifyHostname("wharfie.istio-system.svc"); err != nil {
+		t.Error(err)
+	}
+	if _, err = LoadSecret(client, "istio-system", "missing"); err == nil {
+		t.Error("LoadSecret() succeeded with a missing secret")
+	}
+
+	var keypair Keypair
+	keypair.Set(loaded)
+
+	// A renewed certificate replaces the current one.
+	reloadSecret(&keypair, tlsSecret(t, "renewed"))
+	current, _ := keypair.GetCertificate(nil)
+	if err = current.Leaf.VerifyHostname("renewed"); err != nil {
+		t.Errorf("got %v after renewal", err)
+	}
+
+	// An incomplete secret, e.g. while it is being issued, keeps the
+	// current certificate.
+	incomplete := tlsSecret(t, "incomplete")
+	delete(incomplete.Data, SecretKeyKey)
+	reloadSecret(&keypair, incomplete)
+	if got, _ := keypair.GetCertificate(nil); got != current {
+		t.Errorf("got certificate for %s after an incomplete secret, want the current one", got.Leaf.Subject.CommonName)
+	}
+}
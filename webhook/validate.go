@@ -0,0 +1,203 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+)
+
+// Validation modes for workloads created without the proxy.
+const (
+	ValidationReject = "reject"
+	ValidationWarn   = "warn"
+)
+
+// maxReviewBytes bounds the size of an admission review.
+const maxReviewBytes = 4 << 20
+
+// AdmissionReview versions served by the webhooks. Both have the same
+// request and response fields, so only the version of the review is
+// negotiated.
+const (
+	admissionV1      = "admission.k8s.io/v1"
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+)
+
+// The admission.k8s.io/v1 types are not part of the vendored client
+// library either.
+
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *admissionRequest  `json:"request,omitempty"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       types.UID               `json:"uid"`
+	Kind      metav1.GroupVersionKind `json:"kind"`
+	Namespace string                  `json:"namespace,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Operation string                  `json:"operation"`
+	Object    json.RawMessage         `json:"object,omitempty"`
+}
+
+type admissionResponse struct {
+	UID       types.UID      `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType string         `json:"patchType,omitempty"`
+	Warnings  []string       `json:"warnings,omitempty"`
+}
+
+// podTemplate returns the pod template of a workload or pod, or nil
+// for other kinds.
+func podTemplate(kind string, object []byte) (*v1.PodTemplateSpec, error) {
+	switch kind {
+	case "Pod":
+		var pod v1.Pod
+		if err := json.Unmarshal(object, &pod); err != nil {
+			return nil, err
+		}
+		return &v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, nil
+	case "Deployment", "DaemonSet", "ReplicaSet", "StatefulSet", "ReplicationController", "Job":
+		var workload struct {
+			Spec struct {
+				Template *v1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(object, &workload); err != nil {
+			return nil, err
+		}
+		return workload.Spec.Template, nil
+	case "CronJob":
+		var cronJob struct {
+			Spec struct {
+				JobTemplate struct {
+					Spec struct {
+						Template *v1.PodTemplateSpec `json:"template"`
+					} `json:"spec"`
+				} `json:"jobTemplate"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(object, &cronJob); err != nil {
+			return nil, err
+		}
+		return cronJob.Spec.JobTemplate.Spec.Template, nil
+	}
+	return nil, nil
+}
+
+// review returns the response to an admission request for a namespace
+// that requires injection.
+func review(mode string, req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	if len(req.Object) == 0 {
+		return resp
+	}
+	t, err := podTemplate(req.Kind.Kind, req.Object)
+	if err != nil {
+		resp.Warnings = []string{fmt.Sprintf("cannot decode %s: %v", req.Kind.Kind, err)}
+		return resp
+	}
+	if t == nil || inject.SidecarStatus(t) != "" {
+		return resp
+	}
+	message := fmt.Sprintf("%s %s/%s was not injected with the istio proxy, which is required in namespace %s",
+		req.Kind.Kind, req.Namespace, req.Name, req.Namespace)
+	if mode == ValidationWarn {
+		resp.Warnings = []string{message}
+		return resp
+	}
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+	}
+	return resp
+}
+
+// NewValidator returns a validating admission webhook handler for
+// namespaces that require injection, as selected by the webhook
+// configuration. Workloads and pods whose template was not injected or
+// explicitly excluded from injection are rejected, or only warned
+// about in ValidationWarn mode.
+func NewValidator(mode string) (http.Handler, error) {
+	switch mode {
+	case ValidationReject, ValidationWarn:
+	default:
+		return nil, fmt.Errorf("unknown validation mode %q, want %s or %s", mode, ValidationReject, ValidationWarn)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveReview(w, r, func(req *admissionRequest) *admissionResponse {
+			resp := review(mode, req)
+			if !resp.Allowed {
+				glog.V(2).Infof("Rejected %s %s/%s", req.Kind.Kind, req.Namespace, req.Name)
+			}
+			return resp
+		})
+	}), nil
+}
+
+// serveReview decodes the admission review of a request and writes the
+// review with the response to its request, in the version of the
+// request.
+func serveReview(w http.ResponseWriter, r *http.Request, respond func(*admissionRequest) *admissionResponse) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxReviewBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var in admissionReview
+	if err = json.Unmarshal(body, &in); err != nil || in.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	switch in.APIVersion {
+	case admissionV1, admissionV1beta1:
+	case "":
+		// Early v1beta1 clients omit the type of the review.
+		in.APIVersion = admissionV1beta1
+	default:
+		http.Error(w, fmt.Sprintf("unsupported admission review version %q, want %s or %s",
+			in.APIVersion, admissionV1, admissionV1beta1), http.StatusBadRequest)
+		return
+	}
+	out := admissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: in.APIVersion, Kind: "AdmissionReview"},
+		Response: respond(in.Request),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&out); err != nil {
+		glog.Warningf("Cannot write admission review: %v", err)
+	}
+}
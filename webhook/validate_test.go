@@ -0,0 +1,154 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newReview(kind, object string) string {
+	return `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1234",` +
+		`"kind":{"group":"apps","version":"v1","kind":"` + kind + `"},"namespace":"web","name":"hello",` +
+		`"operation":"CREATE","object":` + object + `}}`
+}
+
+const (
+	uninjected = `{"spec":{"template":{"spec":{"containers":[{"name":"hello"}]}}}}`
+	injected   = `{"spec":{"template":{"metadata":{"annotations":{"alpha.istio.io/sidecar":"injected"}},` +
+		`"spec":{"containers":[{"name":"hello"},{"name":"proxy"}]}}}}`
+	ignored = `{"spec":{"template":{"metadata":{"annotations":{"alpha.istio.io/sidecar":"ignore"}},` +
+		`"spec":{"containers":[{"name":"hello"}]}}}}`
+	uninjectedPod     = `{"metadata":{"name":"hello"},"spec":{"containers":[{"name":"hello"}]}}`
+	uninjectedCronJob = `{"spec":{"jobTemplate":{"spec":{"template":{"spec":{"containers":[{"name":"hello"}]}}}}}}`
+	injectedCronJob   = `{"spec":{"jobTemplate":{"spec":{"template":{"metadata":{"annotations":{"alpha.istio.io/sidecar":"injected"}},` +
+		`"spec":{"containers":[{"name":"hello"},{"name":"proxy"}]}}}}}}`
+)
+
+func TestValidator(t *testing.T) {
+	cases := []struct {
+		name        string
+		mode        string
+		review      string
+		wantAllowed bool
+		wantWarning bool
+	}{
+		{name: "uninjected", mode: ValidationReject, review: newReview("Deployment", uninjected)},
+		{name: "uninjected pod", mode: ValidationReject, review: newReview("Pod", uninjectedPod)},
+		{name: "injected", mode: ValidationReject, review: newReview("Deployment", injected), wantAllowed: true},
+		{name: "ignored", mode: ValidationReject, review: newReview("DaemonSet", ignored), wantAllowed: true},
+		{name: "uninjected cron job", mode: ValidationReject, review: newReview("CronJob", uninjectedCronJob)},
+		{name: "injected cron job", mode: ValidationReject, review: newReview("CronJob", injectedCronJob), wantAllowed: true},
+		{name: "other kind", mode: ValidationReject, review: newReview("Service", `{"spec":{}}`), wantAllowed: true},
+		{name: "no object", mode: ValidationReject, review: newReview("Deployment", "null"), wantAllowed: true},
+		{name: "warn", mode: ValidationWarn, review: newReview("Job", uninjected), wantAllowed: true, wantWarning: true},
+	}
+	for _, c := range cases {
+		handler, err := NewValidator(c.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, DefaultValidatePath, strings.NewReader(c.review)))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", c.name, recorder.Code, recorder.Body)
+			continue
+		}
+		var out admissionReview
+		if err = json.Unmarshal(recorder.Body.Bytes(), &out); err != nil || out.Response == nil {
+			t.Errorf("%s: invalid response %s: %v", c.name, recorder.Body, err)
+			continue
+		}
+		if out.Response.UID != "1234" || out.Kind != "AdmissionReview" {
+			t.Errorf("%s: got response %s for another review", c.name, recorder.Body)
+		}
+		if out.Response.Allowed != c.wantAllowed || (len(out.Response.Warnings) > 0) != c.wantWarning {
+			t.Errorf("%s: got response %s, want allowed %t and warning %t", c.name, recorder.Body, c.wantAllowed, c.wantWarning)
+		}
+		if !c.wantAllowed && (out.Response.Result == nil || !strings.Contains(out.Response.Result.Message, "required in namespace web")) {
+			t.Errorf("%s: got response %s without a reason", c.name, recorder.Body)
+		}
+	}
+}
+
+func TestReviewVersions(t *testing.T) {
+	handler, err := NewValidator(ValidationReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := newReview("Deployment", injected)
+	for _, c := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "v1", body: review, want: "admission.k8s.io/v1"},
+		{
+			name: "v1beta1",
+			body: strings.Replace(review, "admission.k8s.io/v1", "admission.k8s.io/v1beta1", 1),
+			want: "admission.k8s.io/v1beta1",
+		},
+		{
+			name: "untyped",
+			body: strings.Replace(review, `"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview",`, "", 1),
+			want: "admission.k8s.io/v1beta1",
+		},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, DefaultValidatePath, strings.NewReader(c.body)))
+		var out admissionReview
+		if err = json.Unmarshal(recorder.Body.Bytes(), &out); err != nil || out.Response == nil {
+			t.Errorf("%s: invalid response %s: %v", c.name, recorder.Body, err)
+			continue
+		}
+		if out.APIVersion != c.want || out.Kind != "AdmissionReview" {
+			t.Errorf("%s: got review %s/%s, want %s/AdmissionReview", c.name, out.APIVersion, out.Kind, c.want)
+		}
+	}
+}
+
+func TestValidatorInvalidRequests(t *testing.T) {
+	if _, err := NewValidator("audit"); err == nil {
+		t.Error("NewValidator() succeeded for an unknown mode")
+	}
+	handler, err := NewValidator(ValidationReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{method: http.MethodGet, want: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, body: "{", want: http.StatusBadRequest},
+		{method: http.MethodPost, body: "{}", want: http.StatusBadRequest},
+		{
+			method: http.MethodPost,
+			body:   strings.Replace(newReview("Deployment", injected), "admission.k8s.io/v1", "admission.k8s.io/v2", 1),
+			want:   http.StatusBadRequest,
+		},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(c.method, DefaultValidatePath, bytes.NewBufferString(c.body)))
+		if recorder.Code != c.want {
+			t.Errorf("%s %q: got status %d, want %d", c.method, c.body, recorder.Code, c.want)
+		}
+	}
+}